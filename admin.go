@@ -0,0 +1,67 @@
+package busetabot
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// AdminStatus is the status reported by the admin status endpoint.
+type AdminStatus struct {
+	Breaker  *BreakerStats  `json:"breaker,omitempty"`
+	Cache    *CacheStats    `json:"cache,omitempty"`
+	BusStops *BusStopsStats `json:"bus_stops,omitempty"`
+}
+
+// AdminHandler serves the bot's operational status as JSON. It exposes internal counters and should only be reachable
+// by administrators.
+type AdminHandler struct {
+	Breaker  *CircuitBreaker
+	Cache    *CachingETAService
+	BusStops *InMemoryBusStopRepository
+}
+
+func (h AdminHandler) status() AdminStatus {
+	var status AdminStatus
+	if h.Breaker != nil {
+		stats := h.Breaker.Stats()
+		status.Breaker = &stats
+	}
+	if h.Cache != nil {
+		stats := h.Cache.Stats()
+		status.Cache = &stats
+	}
+	if h.BusStops != nil {
+		stats := h.BusStops.Stats()
+		status.BusStops = &stats
+	}
+	return status
+}
+
+func (h AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.status())
+}
+
+// ReloadBusStopsHandler reloads bus stops from their file when it receives a POST request and responds with the bus
+// stops which were added, removed and changed as JSON. It should only be reachable by administrators.
+type ReloadBusStopsHandler struct {
+	BusStops *InMemoryBusStopRepository
+}
+
+func (h ReloadBusStopsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	diff, err := h.BusStops.Reload()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(diff)
+}
@@ -0,0 +1,57 @@
+package busetabot
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminHandler(t *testing.T) {
+	breaker := NewCircuitBreaker(DefaultBreakerThreshold, DefaultBreakerCooldown)
+	cache := NewCachingETAService(breaker.Wrap(mockDatamall{}), time.Minute)
+	_, _ = cache.GetBusArrival("96049", "")
+	_, _ = cache.GetBusArrival("96049", "")
+	handler := AdminHandler{
+		Breaker: breaker,
+		Cache:   cache,
+	}
+
+	t.Run("reports breaker and cache stats", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/status", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		var status AdminStatus
+		err := json.NewDecoder(w.Body).Decode(&status)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, BreakerClosed, status.Breaker.State)
+		assert.Equal(t, uint64(1), status.Breaker.Requests)
+		assert.Equal(t, &CacheStats{Hits: 1, Misses: 1}, status.Cache)
+	})
+	t.Run("only allows GET requests", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/admin/status", nil))
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	})
+}
+
+func TestReloadBusStopsHandler(t *testing.T) {
+	t.Run("reports errors", func(t *testing.T) {
+		handler := ReloadBusStopsHandler{BusStops: NewInMemoryBusStopRepository(nil, nil)}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/admin/reload-bus-stops", nil))
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+	})
+	t.Run("only allows POST requests", func(t *testing.T) {
+		handler := ReloadBusStopsHandler{BusStops: NewInMemoryBusStopRepository(nil, nil)}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/reload-bus-stops", nil))
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	})
+}
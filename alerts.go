@@ -0,0 +1,221 @@
+package busetabot
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/yi-jiayu/bus-eta-bot/v4/telegram"
+)
+
+// Alert limits and defaults
+const (
+	DefaultAlertMinutes    = 5
+	MaxAlertMinutes        = 30
+	MaxAlertsPerUser       = 3
+	AlertDuration          = 2 * time.Hour
+	DefaultAlertCheckEvery = 30 * time.Second
+)
+
+var (
+	errAlertUsage = errors.New("invalid alert arguments")
+)
+
+// Alert is a request to be notified when the next bus for a service is within a number of minutes of a bus stop.
+type Alert struct {
+	ID          int64
+	UserID      int
+	ChatID      int64
+	BusStopCode string
+	ServiceNo   string
+	Minutes     int
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
+
+// AlertRepository stores alerts until they are triggered, cancelled or expire.
+type AlertRepository interface {
+	AddAlert(ctx context.Context, alert Alert) (ID int64, err error)
+	GetAlerts(ctx context.Context) ([]Alert, error)
+	GetUserAlerts(ctx context.Context, userID int) ([]Alert, error)
+	DeleteAlert(ctx context.Context, ID int64) error
+}
+
+// ParseAlertArgs extracts a bus stop code, a service number and an optional number of minutes from the arguments to
+// the /alert command, such as "96049 96 5".
+func ParseAlertArgs(args string) (code, serviceNo string, minutes int, err error) {
+	fields := strings.Fields(args)
+	if len(fields) < 2 || len(fields) > 3 || !busStopRegex.MatchString(fields[0]) {
+		return "", "", 0, errAlertUsage
+	}
+	code, serviceNo, minutes = fields[0], strings.ToUpper(fields[1]), DefaultAlertMinutes
+	if len(fields) == 3 {
+		minutes, err = strconv.Atoi(fields[2])
+		if err != nil || minutes < 1 || minutes > MaxAlertMinutes {
+			return "", "", 0, errAlertUsage
+		}
+	}
+	return code, serviceNo, minutes, nil
+}
+
+// describeBusStop returns a bus stop's description and code, or just its code if it could not be found.
+func describeBusStop(busStops BusStopGetter, code string) string {
+	if busStops != nil {
+		if stop := busStops.Get(code); stop != nil && stop.Description != "" {
+			return fmt.Sprintf("%s (%s)", stop.Description, code)
+		}
+	}
+	return code
+}
+
+// addAlert saves a new alert for a user unless they already have the maximum number of alerts, returning a message
+// to reply to the user with.
+func addAlert(ctx context.Context, bot *BusEtaBot, alert Alert) (telegram.SendMessageRequest, error) {
+	reply := telegram.SendMessageRequest{
+		ChatID: alert.ChatID,
+	}
+	existing, err := bot.Alerts.GetUserAlerts(ctx, alert.UserID)
+	if err != nil {
+		return reply, errors.Wrap(err, "error getting user alerts")
+	}
+	if len(existing) >= MaxAlertsPerUser {
+		reply.Text = fmt.Sprintf("Oops, you can only have up to %d alerts at a time. Send /alert to see and cancel your alerts.", MaxAlertsPerUser)
+		return reply, nil
+	}
+	now := bot.NowFunc()
+	alert.CreatedAt = now
+	alert.ExpiresAt = now.Add(AlertDuration)
+	alert.ID, err = bot.Alerts.AddAlert(ctx, alert)
+	if err != nil {
+		return reply, errors.Wrap(err, "error adding alert")
+	}
+	reply.Text = fmt.Sprintf("Alright, I'll let you know when bus %s is %d min away from %s.", alert.ServiceNo, alert.Minutes, describeBusStop(bot.BusStops, alert.BusStopCode))
+	reply.ReplyMarkup = newAlertsMarkup([]Alert{alert})
+	return reply, nil
+}
+
+func newCancelAlertButton(alert Alert) telegram.InlineKeyboardButton {
+	return telegram.InlineKeyboardButton{
+		Text:         fmt.Sprintf("Cancel bus %s at %s", alert.ServiceNo, alert.BusStopCode),
+		CallbackData: fmt.Sprintf(`{"t":"alert_cancel","a":"%d"}`, alert.ID),
+	}
+}
+
+func newAlertsMarkup(alerts []Alert) telegram.InlineKeyboardMarkup {
+	var keyboard [][]telegram.InlineKeyboardButton
+	for _, alert := range alerts {
+		keyboard = append(keyboard, []telegram.InlineKeyboardButton{newCancelAlertButton(alert)})
+	}
+	return telegram.InlineKeyboardMarkup{
+		InlineKeyboard: keyboard,
+	}
+}
+
+// AlertScheduler periodically checks alerts and notifies users when their bus is close enough.
+type AlertScheduler struct {
+	Bot      *BusEtaBot
+	Interval time.Duration
+}
+
+// Run checks alerts every Interval until ctx is cancelled.
+func (s *AlertScheduler) Run(ctx context.Context) {
+	interval := s.Interval
+	if interval <= 0 {
+		interval = DefaultAlertCheckEvery
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.CheckAlerts(ctx)
+		}
+	}
+}
+
+// CheckAlerts notifies users whose bus is within their alert's threshold and removes alerts which have been triggered
+// or have expired. Bus arrivals for each bus stop are only fetched once no matter how many alerts there are for it.
+func (s *AlertScheduler) CheckAlerts(ctx context.Context) {
+	bot := s.Bot
+	alerts, err := bot.Alerts.GetAlerts(ctx)
+	if err != nil {
+		bot.logger().Errorf(ctx, "error getting alerts: %+v", err)
+		return
+	}
+	now := bot.NowFunc()
+	byBusStop := make(map[string][]Alert)
+	for _, alert := range alerts {
+		if now.After(alert.ExpiresAt) {
+			s.expire(ctx, alert)
+			continue
+		}
+		byBusStop[alert.BusStopCode] = append(byBusStop[alert.BusStopCode], alert)
+	}
+	for code, alerts := range byBusStop {
+		arrival, err := bot.Datamall.GetBusArrival(code, "")
+		if err != nil {
+			// don't trigger alerts based on stale or missing arrivals
+			continue
+		}
+		for _, alert := range alerts {
+			for _, service := range arrival.Services {
+				if service.ServiceNo != alert.ServiceNo || service.NextBus.EstimatedArrival.IsZero() {
+					continue
+				}
+				minutes := int(service.NextBus.EstimatedArrival.Sub(now).Minutes())
+				if minutes <= alert.Minutes {
+					s.trigger(ctx, alert, minutes)
+				}
+				break
+			}
+		}
+	}
+}
+
+func (s *AlertScheduler) trigger(ctx context.Context, alert Alert, minutes int) {
+	bot := s.Bot
+	var when string
+	if minutes <= 0 {
+		when = "now"
+	} else {
+		when = fmt.Sprintf("in %d min", minutes)
+	}
+	req := telegram.SendMessageRequest{
+		ChatID:      alert.ChatID,
+		Text:        fmt.Sprintf("🔔 Bus %s is arriving at %s %s.", alert.ServiceNo, describeBusStop(bot.BusStops, alert.BusStopCode), when),
+		ReplyMarkup: NewETAMessageReplyMarkup(alert.BusStopCode, []string{alert.ServiceNo}, bot.etaMessageOptions()),
+	}
+	err := bot.TelegramService.Do(req)
+	if err != nil {
+		// leave the alert in place so that it is retried until it expires
+		bot.logger().Errorf(ctx, "error sending alert %d: %+v", alert.ID, err)
+		return
+	}
+	err = bot.Alerts.DeleteAlert(ctx, alert.ID)
+	if err != nil {
+		bot.logger().Errorf(ctx, "error deleting triggered alert %d: %+v", alert.ID, err)
+	}
+}
+
+func (s *AlertScheduler) expire(ctx context.Context, alert Alert) {
+	bot := s.Bot
+	err := bot.Alerts.DeleteAlert(ctx, alert.ID)
+	if err != nil {
+		bot.logger().Errorf(ctx, "error deleting expired alert %d: %+v", alert.ID, err)
+		return
+	}
+	req := telegram.SendMessageRequest{
+		ChatID: alert.ChatID,
+		Text:   fmt.Sprintf("Your alert for bus %s at %s has expired.", alert.ServiceNo, describeBusStop(bot.BusStops, alert.BusStopCode)),
+	}
+	err = bot.TelegramService.Do(req)
+	if err != nil {
+		bot.logger().Errorf(ctx, "error sending alert expiry for alert %d: %+v", alert.ID, err)
+	}
+}
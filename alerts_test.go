@@ -0,0 +1,393 @@
+package busetabot
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kr/pretty"
+	"github.com/stretchr/testify/assert"
+	"github.com/yi-jiayu/datamall/v3"
+
+	"github.com/yi-jiayu/bus-eta-bot/v4/telegram"
+)
+
+type mockAlertRepository struct {
+	Alerts []Alert
+	nextID int64
+}
+
+func (r *mockAlertRepository) AddAlert(ctx context.Context, alert Alert) (ID int64, err error) {
+	r.nextID++
+	alert.ID = r.nextID
+	r.Alerts = append(r.Alerts, alert)
+	return alert.ID, nil
+}
+
+func (r *mockAlertRepository) GetAlerts(ctx context.Context) ([]Alert, error) {
+	return append([]Alert(nil), r.Alerts...), nil
+}
+
+func (r *mockAlertRepository) GetUserAlerts(ctx context.Context, userID int) (alerts []Alert, err error) {
+	for _, alert := range r.Alerts {
+		if alert.UserID == userID {
+			alerts = append(alerts, alert)
+		}
+	}
+	return alerts, nil
+}
+
+func (r *mockAlertRepository) DeleteAlert(ctx context.Context, ID int64) error {
+	for i, alert := range r.Alerts {
+		if alert.ID == ID {
+			r.Alerts = append(r.Alerts[:i], r.Alerts[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+type busStopETAService struct {
+	Arrivals map[string]datamall.BusArrival
+	Error    error
+	Calls    map[string]int
+}
+
+func (s *busStopETAService) GetBusArrival(code string, serviceNo string) (datamall.BusArrival, error) {
+	if s.Calls == nil {
+		s.Calls = make(map[string]int)
+	}
+	s.Calls[code]++
+	return s.Arrivals[code], s.Error
+}
+
+func TestParseAlertArgs(t *testing.T) {
+	testCases := []struct {
+		Args      string
+		Code      string
+		ServiceNo string
+		Minutes   int
+		Err       error
+	}{
+		{Args: "96049 96 10", Code: "96049", ServiceNo: "96", Minutes: 10},
+		{Args: "96049 96", Code: "96049", ServiceNo: "96", Minutes: DefaultAlertMinutes},
+		{Args: "96049 5a", Code: "96049", ServiceNo: "5A", Minutes: DefaultAlertMinutes},
+		{Args: "96049", Err: errAlertUsage},
+		{Args: "9604 96", Err: errAlertUsage},
+		{Args: "96049 96 0", Err: errAlertUsage},
+		{Args: "96049 96 31", Err: errAlertUsage},
+		{Args: "96049 96 five", Err: errAlertUsage},
+		{Args: "96049 96 5 extra", Err: errAlertUsage},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Args, func(t *testing.T) {
+			code, serviceNo, minutes, err := ParseAlertArgs(tc.Args)
+			assert.Equal(t, tc.Err, err)
+			assert.Equal(t, tc.Code, code)
+			assert.Equal(t, tc.ServiceNo, serviceNo)
+			assert.Equal(t, tc.Minutes, minutes)
+		})
+	}
+}
+
+func TestAlertScheduler_CheckAlerts(t *testing.T) {
+	now := time.Date(2019, 1, 1, 8, 0, 0, 0, sgt)
+	alerts := &mockAlertRepository{
+		Alerts: []Alert{
+			{ID: 1, UserID: 1, ChatID: 1, BusStopCode: "96049", ServiceNo: "2", Minutes: 5, ExpiresAt: now.Add(time.Hour)},
+			{ID: 2, UserID: 2, ChatID: 2, BusStopCode: "96049", ServiceNo: "24", Minutes: 5, ExpiresAt: now.Add(time.Hour)},
+			{ID: 3, UserID: 3, ChatID: 3, BusStopCode: "81111", ServiceNo: "2", Minutes: 5, ExpiresAt: now.Add(-time.Minute)},
+		},
+	}
+	service := &busStopETAService{
+		Arrivals: map[string]datamall.BusArrival{
+			"96049": {
+				BusStopCode: "96049",
+				Services: []datamall.Service{
+					{ServiceNo: "2", NextBus: datamall.ArrivingBus{EstimatedArrival: now.Add(4 * time.Minute)}},
+					{ServiceNo: "24", NextBus: datamall.ArrivingBus{EstimatedArrival: now.Add(12 * time.Minute)}},
+				},
+			},
+		},
+	}
+	tg := &mockTelegramService{}
+	bot := &BusEtaBot{
+		Datamall:        service,
+		Alerts:          alerts,
+		TelegramService: tg,
+		NowFunc: func() time.Time {
+			return now
+		},
+	}
+	scheduler := AlertScheduler{Bot: bot}
+
+	scheduler.CheckAlerts(context.Background())
+
+	expected := []telegram.Request{
+		telegram.SendMessageRequest{
+			ChatID: 3,
+			Text:   "Your alert for bus 2 at 81111 has expired.",
+		},
+		telegram.SendMessageRequest{
+			ChatID:      1,
+			Text:        "🔔 Bus 2 is arriving at 96049 in 4 min.",
+			ReplyMarkup: NewETAMessageReplyMarkup("96049", []string{"2"}, ETAMessageOptions{Alerts: true}),
+		},
+	}
+	if !assert.Equal(t, expected, tg.Requests) {
+		pretty.Println(tg.Requests)
+	}
+	assert.Equal(t, map[string]int{"96049": 1}, service.Calls, "arrivals should only be fetched once per bus stop")
+	assert.Equal(t, []Alert{alerts.Alerts[0]}, alerts.Alerts)
+	assert.Equal(t, int64(2), alerts.Alerts[0].ID)
+}
+
+func TestAlertScheduler_CheckAlerts_DoesNotTriggerOnError(t *testing.T) {
+	now := time.Date(2019, 1, 1, 8, 0, 0, 0, sgt)
+	alert := Alert{ID: 1, UserID: 1, ChatID: 1, BusStopCode: "96049", ServiceNo: "2", Minutes: 5, ExpiresAt: now.Add(time.Hour)}
+	alerts := &mockAlertRepository{
+		Alerts: []Alert{alert},
+	}
+	service := &busStopETAService{
+		Arrivals: map[string]datamall.BusArrival{
+			"96049": {
+				Services: []datamall.Service{
+					{ServiceNo: "2", NextBus: datamall.ArrivingBus{EstimatedArrival: now.Add(time.Minute)}},
+				},
+			},
+		},
+		Error: &StaleArrivalError{Err: errors.New("DataMall is down"), FetchedAt: now.Add(-10 * time.Minute)},
+	}
+	tg := &mockTelegramService{}
+	bot := &BusEtaBot{
+		Datamall:        service,
+		Alerts:          alerts,
+		TelegramService: tg,
+		NowFunc: func() time.Time {
+			return now
+		},
+	}
+	scheduler := AlertScheduler{Bot: bot}
+
+	scheduler.CheckAlerts(context.Background())
+
+	assert.Empty(t, tg.Requests)
+	assert.Equal(t, []Alert{alert}, alerts.Alerts)
+}
+
+func TestAlertCmdHandler(t *testing.T) {
+	now := time.Date(2019, 1, 1, 8, 0, 0, 0, sgt)
+	existing := Alert{ID: 1, UserID: 1, ChatID: 1, BusStopCode: "81111", ServiceNo: "2", Minutes: 5}
+	testCases := []struct {
+		Name     string
+		Text     string
+		Alerts   []Alert
+		Expected []Response
+	}{
+		{
+			Name: "creates an alert",
+			Text: "/alert 96049 96 10",
+			Expected: []Response{
+				ok(telegram.SendMessageRequest{
+					ChatID: 1,
+					Text:   "Alright, I'll let you know when bus 96 is 10 min away from Opp Tropicana Condo (96049).",
+					ReplyMarkup: telegram.InlineKeyboardMarkup{
+						InlineKeyboard: [][]telegram.InlineKeyboardButton{
+							{{Text: "Cancel bus 96 at 96049", CallbackData: `{"t":"alert_cancel","a":"1"}`}},
+						},
+					},
+				}),
+			},
+		},
+		{
+			Name:   "lists alerts",
+			Text:   "/alert",
+			Alerts: []Alert{existing},
+			Expected: []Response{
+				ok(telegram.SendMessageRequest{
+					ChatID: 1,
+					Text:   "Here are your alerts. Tap an alert to cancel it.",
+					ReplyMarkup: telegram.InlineKeyboardMarkup{
+						InlineKeyboard: [][]telegram.InlineKeyboardButton{
+							{{Text: "Cancel bus 2 at 81111", CallbackData: `{"t":"alert_cancel","a":"1"}`}},
+						},
+					},
+				}),
+			},
+		},
+		{
+			Name:   "enforces the per-user limit",
+			Text:   "/alert 96049 96",
+			Alerts: []Alert{existing, existing, existing},
+			Expected: []Response{
+				ok(telegram.SendMessageRequest{
+					ChatID: 1,
+					Text:   "Oops, you can only have up to 3 alerts at a time. Send /alert to see and cancel your alerts.",
+				}),
+			},
+		},
+		{
+			Name: "rejects invalid arguments",
+			Text: "/alert 96049 96 60",
+			Expected: []Response{
+				ok(telegram.SendMessageRequest{
+					ChatID:    1,
+					Text:      "Oops, that was not a valid alert. To get an alert when your bus is a few minutes away, send `/alert <bus stop code> <service> [minutes]`, for example `/alert 96049 96 5`. Alerts expire after 2 hours.",
+					ParseMode: "markdown",
+				}),
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			alerts := &mockAlertRepository{
+				Alerts: tc.Alerts,
+			}
+			bot := &BusEtaBot{
+				BusStops: mockBusStopRepository{
+					BusStop: &BusStop{
+						BusStopCode: "96049",
+						Description: "Opp Tropicana Condo",
+					},
+				},
+				Alerts: alerts,
+				NowFunc: func() time.Time {
+					return now
+				},
+			}
+			responses := make(chan Response, ResponseBufferSize)
+
+			AlertCmdHandler(context.Background(), bot, MockMessageWithText(tc.Text), responses)
+
+			actual, err := collectResponsesWithTimeout(responses, 100*time.Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !assert.Equal(t, tc.Expected, actual) {
+				pretty.Println(actual)
+			}
+		})
+	}
+}
+
+func TestAlertCallbackHandler(t *testing.T) {
+	now := time.Date(2019, 1, 1, 8, 0, 0, 0, sgt)
+	bot := &BusEtaBot{
+		BusStops: mockBusStopRepository{
+			BusStop: &BusStop{
+				BusStopCode: "96049",
+				Description: "Opp Tropicana Condo",
+				Services:    []string{"2", "24"},
+			},
+		},
+		Alerts: &mockAlertRepository{},
+		NowFunc: func() time.Time {
+			return now
+		},
+	}
+	testCases := []struct {
+		Name     string
+		Data     string
+		Expected []Response
+	}{
+		{
+			Name: "asks which service to alert for",
+			Data: `{"t":"alert","b":"96049"}`,
+			Expected: []Response{
+				ok(telegram.SendMessageRequest{
+					ChatID: 1,
+					Text:   "Which bus at Opp Tropicana Condo (96049) should I alert you about?",
+					ReplyMarkup: telegram.InlineKeyboardMarkup{
+						InlineKeyboard: [][]telegram.InlineKeyboardButton{
+							{
+								{Text: "2", CallbackData: `{"t":"alert","b":"96049","s":["2"]}`},
+								{Text: "24", CallbackData: `{"t":"alert","b":"96049","s":["24"]}`},
+							},
+						},
+					},
+				}),
+				ok(telegram.AnswerCallbackQueryRequest{CallbackQueryID: "1"}),
+			},
+		},
+		{
+			Name: "creates an alert for a single service",
+			Data: `{"t":"alert","b":"96049","s":["24"]}`,
+			Expected: []Response{
+				ok(telegram.SendMessageRequest{
+					ChatID: 1,
+					Text:   "Alright, I'll let you know when bus 24 is 5 min away from Opp Tropicana Condo (96049).",
+					ReplyMarkup: telegram.InlineKeyboardMarkup{
+						InlineKeyboard: [][]telegram.InlineKeyboardButton{
+							{{Text: "Cancel bus 24 at 96049", CallbackData: `{"t":"alert_cancel","a":"1"}`}},
+						},
+					},
+				}),
+				ok(telegram.AnswerCallbackQueryRequest{CallbackQueryID: "1"}),
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			responses := make(chan Response, ResponseBufferSize)
+
+			AlertCallbackHandler(context.Background(), bot, newCallbackQueryFromMessage(tc.Data), responses)
+
+			actual, err := collectResponsesWithTimeout(responses, 100*time.Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !assert.Equal(t, tc.Expected, actual) {
+				pretty.Println(actual)
+			}
+		})
+	}
+}
+
+func TestCancelAlertCallbackHandler(t *testing.T) {
+	alerts := &mockAlertRepository{
+		Alerts: []Alert{
+			{ID: 1, UserID: 1, BusStopCode: "96049", ServiceNo: "96"},
+			{ID: 2, UserID: 2, BusStopCode: "96049", ServiceNo: "96"},
+		},
+	}
+	bot := &BusEtaBot{
+		Alerts: alerts,
+	}
+	testCases := []struct {
+		Name     string
+		Data     string
+		Expected []Response
+	}{
+		{
+			Name: "cancels the user's own alert",
+			Data: `{"t":"alert_cancel","a":"1"}`,
+			Expected: []Response{
+				ok(telegram.AnswerCallbackQueryRequest{CallbackQueryID: "1", Text: "Alert for bus 96 at 96049 cancelled."}),
+			},
+		},
+		{
+			Name: "does not cancel another user's alert",
+			Data: `{"t":"alert_cancel","a":"2"}`,
+			Expected: []Response{
+				ok(telegram.AnswerCallbackQueryRequest{CallbackQueryID: "1", Text: "That alert has already been triggered or cancelled."}),
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			responses := make(chan Response, ResponseBufferSize)
+
+			CancelAlertCallbackHandler(context.Background(), bot, newCallbackQueryFromMessage(tc.Data), responses)
+
+			actual, err := collectResponsesWithTimeout(responses, 100*time.Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !assert.Equal(t, tc.Expected, actual) {
+				pretty.Println(actual)
+			}
+		})
+	}
+	assert.Equal(t, []Alert{{ID: 2, UserID: 2, BusStopCode: "96049", ServiceNo: "96"}}, alerts.Alerts)
+}
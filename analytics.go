@@ -34,34 +34,61 @@ const (
 
 // Event actions
 const (
-	ActionEtaCommandWithArgs    = "eta_command_with_args"
-	ActionEtaCommandWithoutArgs = "eta_command_without_args"
-	ActionStartCommand          = "start_command"
-	ActionAboutCommand          = "about_command"
-	ActionVersionCommand        = "version_command"
-	ActionHelpCommand           = "help_command"
-	ActionPrivacyCommand        = "privacy_command"
-	ActionFeedbackCommand       = "feedback_command"
-
-	ActionEtaTextMessage       = "eta_text_message"
-	ActionContinuedTextMessage = "continued_text_message"
-	ActionIgnoredTextMessage   = "ignored_text_message"
-	ActionLocationMessage      = "location_message"
-
-	ActionNewInlineQuery       = "new_inline_query"
-	ActionNewNearbyInlineQuery = "new_nearby_inline_query"
-	ActionOffsetInlineQuery    = "offset_inline_query"
-
-	ActionChosenInlineResult       = "chosen_inline_result"
-	ActionChosenNearbyInlineResult = "chosen_nearby_inline_result"
-
-	ActionRefreshCallback         = "refresh_callback"
-	ActionResendCallback          = "resend_callback"
-	ActionEtaCallback             = "eta_callback"
-	ActionEtaDemoCallback         = "eta_demo_callback"
-	ActionEtaFromLocationCallback = "eta_from_location_callback"
-	ActionAddFavouriteCalback     = "add_favourite_callback"
-	ActionRemoveFavouriteCalback  = "remove_favourite_callback"
+	ActionEtaCommandWithArgs      = "eta_command_with_args"
+	ActionEtaCommandWithoutArgs   = "eta_command_without_args"
+	ActionStartCommand            = "start_command"
+	ActionAboutCommand            = "about_command"
+	ActionVersionCommand          = "version_command"
+	ActionHelpCommand             = "help_command"
+	ActionPrivacyCommand          = "privacy_command"
+	ActionFeedbackCommand         = "feedback_command"
+	ActionAlertCommand            = "alert_command"
+	ActionScheduleCommand         = "schedule_command"
+	ActionSchedulesCommand        = "schedules_command"
+	ActionUnscheduleCommand       = "unschedule_command"
+	ActionRouteCommand            = "route_command"
+	ActionPlanCommand             = "plan_command"
+	ActionSettingsCommand         = "settings_command"
+	ActionManageFavouritesCommand = "manage_favourites_command"
+	ActionDashboardCommand        = "dashboard_command"
+
+	ActionEtaTextMessage         = "eta_text_message"
+	ActionContinuedTextMessage   = "continued_text_message"
+	ActionIgnoredTextMessage     = "ignored_text_message"
+	ActionLocationMessage        = "location_message"
+	ActionCompactLocationMessage = "compact_location_message"
+	ActionLiveLocationMessage    = "live_location_message"
+	ActionLiveLocationUpdate     = "live_location_update"
+	ActionRenameFavouriteMessage = "rename_favourite_message"
+
+	ActionNewInlineQuery         = "new_inline_query"
+	ActionNewNearbyInlineQuery   = "new_nearby_inline_query"
+	ActionNewPersonalInlineQuery = "new_personal_inline_query"
+	ActionOffsetInlineQuery      = "offset_inline_query"
+
+	ActionChosenInlineResult          = "chosen_inline_result"
+	ActionChosenNearbyInlineResult    = "chosen_nearby_inline_result"
+	ActionChosenFavouriteInlineResult = "chosen_favourite_inline_result"
+	ActionChosenRecentInlineResult    = "chosen_recent_inline_result"
+
+	ActionRefreshCallback          = "refresh_callback"
+	ActionResendCallback           = "resend_callback"
+	ActionEtaCallback              = "eta_callback"
+	ActionEtaDemoCallback          = "eta_demo_callback"
+	ActionEtaFromLocationCallback  = "eta_from_location_callback"
+	ActionAddFavouriteCalback      = "add_favourite_callback"
+	ActionRemoveFavouriteCalback   = "remove_favourite_callback"
+	ActionAlertCallback            = "alert_callback"
+	ActionCancelAlertCallback      = "cancel_alert_callback"
+	ActionDeleteScheduleCallback   = "delete_schedule_callback"
+	ActionLiveCallback             = "live_callback"
+	ActionStopLiveCallback         = "stop_live_callback"
+	ActionRouteCallback            = "route_callback"
+	ActionNearbyCallback           = "nearby_callback"
+	ActionNearbyModeCallback       = "nearby_mode_callback"
+	ActionSettingsCallback         = "settings_callback"
+	ActionManageFavouritesCallback = "manage_favourites_callback"
+	ActionDashboardCallback        = "dashboard_callback"
 
 	ActionCommandError     = "command_error"
 	ActionMessageError     = "message_error"
@@ -0,0 +1,40 @@
+// Package appengine contains the parts of Bus Eta Bot which depend on the App Engine APIs, so that the busetabot
+// package can be used outside App Engine.
+package appengine
+
+import (
+	"context"
+	"net/http"
+
+	"google.golang.org/appengine"
+	aelog "google.golang.org/appengine/log"
+
+	"github.com/yi-jiayu/bus-eta-bot/v4"
+)
+
+// Logger logs messages using the App Engine logging API. It can only be used with App Engine contexts.
+type Logger struct{}
+
+func (Logger) Infof(ctx context.Context, format string, args ...interface{}) {
+	aelog.Infof(ctx, format, args...)
+}
+
+func (Logger) Warningf(ctx context.Context, format string, args ...interface{}) {
+	aelog.Warningf(ctx, format, args...)
+}
+
+func (Logger) Errorf(ctx context.Context, format string, args ...interface{}) {
+	aelog.Errorf(ctx, format, args...)
+}
+
+// RequestIDProvider uses the App Engine request log ID as the request ID.
+type RequestIDProvider struct{}
+
+func (RequestIDProvider) RequestID(ctx context.Context) string {
+	return appengine.RequestID(ctx)
+}
+
+// NewContext returns an App Engine context for handling the webhook request r.
+func NewContext(r *http.Request) context.Context {
+	return busetabot.NewContext(appengine.NewContext(r), r, Logger{})
+}
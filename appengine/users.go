@@ -0,0 +1,207 @@
+package appengine
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+
+	"github.com/yi-jiayu/bus-eta-bot/v4"
+	"github.com/yi-jiayu/bus-eta-bot/v4/favourite"
+)
+
+// namespace is the Datastore namespace used for the current bot environment.
+var namespace = busetabot.GetBotEnvironment()
+
+const (
+	KindFavourites     = "Favourites"
+	KindUser           = "User"
+	KindPreferences    = "Preferences"
+	KindRecentBusStops = "RecentBusStops"
+)
+
+// RecentBusStops contains the codes of the bus stops a user recently requested etas for, most recent first.
+type RecentBusStops struct {
+	Codes []string
+}
+
+// Favourites contains a user's saved favourites. Favourites contains their queries and Labels contains the label for
+// the query at the same position. Entities saved before labels were added have no Labels, so any missing labels are
+// empty.
+type Favourites struct {
+	Favourites []string
+	Labels     []string
+}
+
+// newFavourites returns the entity for storing favourites in Datastore.
+func newFavourites(favourites []favourite.Favourite) Favourites {
+	var f Favourites
+	for _, fav := range favourites {
+		f.Favourites = append(f.Favourites, fav.Query)
+		f.Labels = append(f.Labels, fav.Label)
+	}
+	return f
+}
+
+// list returns the favourites stored in f.
+func (f Favourites) list() []favourite.Favourite {
+	if len(f.Favourites) == 0 {
+		return nil
+	}
+	favourites := favourite.FromQueries(f.Favourites...)
+	for i := range favourites {
+		if i < len(f.Labels) {
+			favourites[i].Label = f.Labels[i]
+		}
+	}
+	return favourites
+}
+
+type User struct {
+	LastSeenTime time.Time
+	Favourites   []string
+}
+
+type DatastoreUserRepository struct {
+}
+
+func (r *DatastoreUserRepository) UpdateUserLastSeenTime(ctx context.Context, userID int, t time.Time) error {
+	ctx, err := appengine.Namespace(ctx, namespace)
+	if err != nil {
+		return err
+	}
+	k := datastore.NewKey(ctx, KindUser, "", int64(userID), nil)
+	e := &User{
+		LastSeenTime: t,
+	}
+	_, err = datastore.Put(ctx, k, e)
+	if err != nil {
+		return errors.Wrap(err, "error updating user last seen time")
+	}
+	return nil
+}
+
+func (r *DatastoreUserRepository) GetUserFavourites(ctx context.Context, userID int) (favourites []favourite.Favourite, err error) {
+	ctx, err = appengine.Namespace(ctx, namespace)
+	if err != nil {
+		err = errors.Wrap(err, "error setting namespace")
+		return
+	}
+	k := datastore.NewKey(ctx, KindFavourites, "", int64(userID), nil)
+	var f Favourites
+	err = datastore.Get(ctx, k, &f)
+	if err != nil {
+		if err != datastore.ErrNoSuchEntity {
+			err = errors.Wrap(err, "error getting user favourites")
+			return
+		}
+		return nil, nil
+	}
+	favourites = f.list()
+	return
+}
+
+func (r *DatastoreUserRepository) SetUserFavourites(ctx context.Context, userID int, favourites []favourite.Favourite) error {
+	ctx, err := appengine.Namespace(ctx, namespace)
+	if err != nil {
+		return errors.Wrap(err, "error setting namespace")
+	}
+	k := datastore.NewKey(ctx, KindFavourites, "", int64(userID), nil)
+	err = datastore.RunInTransaction(ctx, func(tc context.Context) (err error) {
+		var f Favourites
+		err = datastore.Get(tc, k, &f)
+		if err != nil && err != datastore.ErrNoSuchEntity {
+			return errors.Wrap(err, "error getting user favourites from datastore")
+		}
+		f = newFavourites(favourites)
+		_, err = datastore.Put(tc, k, &f)
+		if err != nil {
+			return errors.Wrap(err, "error putting user favourites into datastore")
+		}
+		return nil
+	}, nil)
+	if err != nil {
+		return errors.Wrap(err, "error updating user favourites in transaction")
+	}
+	return nil
+}
+
+func (r *DatastoreUserRepository) GetUserPreferences(ctx context.Context, userID int) (busetabot.Preferences, error) {
+	ctx, err := appengine.Namespace(ctx, namespace)
+	if err != nil {
+		return busetabot.Preferences{}, errors.Wrap(err, "error setting namespace")
+	}
+	k := datastore.NewKey(ctx, KindPreferences, "", int64(userID), nil)
+	var preferences busetabot.Preferences
+	err = datastore.Get(ctx, k, &preferences)
+	if err != nil {
+		if err != datastore.ErrNoSuchEntity {
+			return busetabot.Preferences{}, errors.Wrap(err, "error getting user preferences")
+		}
+		return busetabot.Preferences{}, nil
+	}
+	return preferences, nil
+}
+
+func (r *DatastoreUserRepository) SetUserPreferences(ctx context.Context, userID int, preferences busetabot.Preferences) error {
+	ctx, err := appengine.Namespace(ctx, namespace)
+	if err != nil {
+		return errors.Wrap(err, "error setting namespace")
+	}
+	k := datastore.NewKey(ctx, KindPreferences, "", int64(userID), nil)
+	_, err = datastore.Put(ctx, k, &preferences)
+	if err != nil {
+		return errors.Wrap(err, "error updating user preferences")
+	}
+	return nil
+}
+
+func (r *DatastoreUserRepository) AddRecentBusStop(ctx context.Context, userID int, code string, t time.Time) error {
+	ctx, err := appengine.Namespace(ctx, namespace)
+	if err != nil {
+		return errors.Wrap(err, "error setting namespace")
+	}
+	k := datastore.NewKey(ctx, KindRecentBusStops, "", int64(userID), nil)
+	err = datastore.RunInTransaction(ctx, func(tc context.Context) (err error) {
+		var recent RecentBusStops
+		err = datastore.Get(tc, k, &recent)
+		if err != nil && err != datastore.ErrNoSuchEntity {
+			return errors.Wrap(err, "error getting recent bus stops from datastore")
+		}
+		codes := []string{code}
+		for _, c := range recent.Codes {
+			if c != code && len(codes) < busetabot.MaxRecentBusStops {
+				codes = append(codes, c)
+			}
+		}
+		recent.Codes = codes
+		_, err = datastore.Put(tc, k, &recent)
+		if err != nil {
+			return errors.Wrap(err, "error putting recent bus stops into datastore")
+		}
+		return nil
+	}, nil)
+	if err != nil {
+		return errors.Wrap(err, "error updating recent bus stops in transaction")
+	}
+	return nil
+}
+
+func (r *DatastoreUserRepository) GetRecentBusStops(ctx context.Context, userID int) ([]string, error) {
+	ctx, err := appengine.Namespace(ctx, namespace)
+	if err != nil {
+		return nil, errors.Wrap(err, "error setting namespace")
+	}
+	k := datastore.NewKey(ctx, KindRecentBusStops, "", int64(userID), nil)
+	var recent RecentBusStops
+	err = datastore.Get(ctx, k, &recent)
+	if err != nil {
+		if err != datastore.ErrNoSuchEntity {
+			return nil, errors.Wrap(err, "error getting recent bus stops")
+		}
+		return nil, nil
+	}
+	return recent.Codes, nil
+}
@@ -1,4 +1,4 @@
-package busetabot
+package appengine
 
 import (
 	"testing"
@@ -8,6 +8,9 @@ import (
 	"google.golang.org/appengine"
 	"google.golang.org/appengine/aetest"
 	"google.golang.org/appengine/datastore"
+
+	"github.com/yi-jiayu/bus-eta-bot/v4/favourite"
+	"github.com/yi-jiayu/bus-eta-bot/v4/usertest"
 )
 
 func TestDatastoreUserRepository_UpdateUserLastSeenTime(t *testing.T) {
@@ -92,7 +95,7 @@ func TestDatastoreUserRepository_GetUserFavourites(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		expected := f.Favourites
+		expected := favourite.FromQueries("96049", "81111")
 		assert.Equal(t, expected, actual)
 	})
 }
@@ -104,7 +107,7 @@ func TestDatastoreUserRepository_SetUserFavourites(t *testing.T) {
 	}
 	defer done()
 	const userID = 1
-	favourites := []string{"96049", "81111"}
+	favourites := []favourite.Favourite{{Query: "96049", Label: "Home"}, {Query: "81111"}}
 	userRepository := new(DatastoreUserRepository)
 	err = userRepository.SetUserFavourites(ctx, userID, favourites)
 	if err != nil {
@@ -120,5 +123,15 @@ func TestDatastoreUserRepository_SetUserFavourites(t *testing.T) {
 	if err != nil && err != datastore.ErrNoSuchEntity {
 		t.Fatal(err)
 	}
-	assert.Equal(t, favourites, f.Favourites)
+	assert.Equal(t, []string{"96049", "81111"}, f.Favourites)
+	assert.Equal(t, []string{"Home", ""}, f.Labels)
+}
+
+func TestDatastoreUserRepository_Conformance(t *testing.T) {
+	ctx, done, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer done()
+	usertest.RunConformance(t, ctx, new(DatastoreUserRepository))
 }
@@ -10,7 +10,6 @@ import (
 	"github.com/pkg/errors"
 	"github.com/yi-jiayu/datamall/v3"
 	"github.com/yi-jiayu/telegram-bot-api"
-	aelog "google.golang.org/appengine/log"
 
 	"github.com/yi-jiayu/bus-eta-bot/v4/telegram"
 )
@@ -25,6 +24,7 @@ var handlers = Handlers{
 	FallbackCommandHandler:    FallbackCommandHandler,
 	TextHandler:               TextHandler,
 	LocationHandler:           LocationHandler,
+	EditedLocationHandler:     EditedLocationHandler,
 	CallbackQueryHandlers:     callbackQueryHandlers,
 	InlineQueryHandler:        InlineQueryHandler,
 	ChosenInlineResultHandler: ChosenInlineResultHandler,
@@ -41,8 +41,8 @@ type BusStopRepository interface {
 
 type UserRepository interface {
 	UpdateUserLastSeenTime(ctx context.Context, userID int, t time.Time) error
-	GetUserFavourites(ctx context.Context, userID int) (favourites []string, err error)
-	SetUserFavourites(ctx context.Context, userID int, favourites []string) error
+	GetUserFavourites(ctx context.Context, userID int) (favourites []Favourite, err error)
+	SetUserFavourites(ctx context.Context, userID int, favourites []Favourite) error
 }
 
 type ETAService interface {
@@ -62,8 +62,17 @@ type BusEtaBot struct {
 	MeasurementProtocol *MeasurementProtocolClient
 	NowFunc             func() time.Time
 	BusStops            BusStopRepository
+	Routes              RouteRepository
 	Users               UserRepository
+	Alerts              AlertRepository
+	Schedules           ScheduleRepository
+	Preferences         PreferenceRepository
+	RecentBusStops      RecentBusStopRepository
+	LiveETAs            *LiveETAManager
+	LiveLocations       *LiveLocationTracker
 	TelegramService     TelegramService
+	Logger              Logger
+	RequestIDs          RequestIDProvider
 }
 
 // Handlers contains all the handlers used by the bot.
@@ -72,6 +81,7 @@ type Handlers struct {
 	FallbackCommandHandler    MessageHandler
 	TextHandler               MessageHandler
 	LocationHandler           MessageHandler
+	EditedLocationHandler     MessageHandler
 	CallbackQueryHandlers     map[string]CallbackQueryHandler
 	InlineQueryHandler        func(ctx context.Context, bot *BusEtaBot, ilq *tgbotapi.InlineQuery) error
 	ChosenInlineResultHandler func(ctx context.Context, bot *BusEtaBot, cir *tgbotapi.ChosenInlineResult) error
@@ -137,6 +147,8 @@ func (bot *BusEtaBot) Dispatch(ctx context.Context, responses <-chan Response) {
 
 // HandleUpdate dispatches an incoming update to the corresponding handler depending on the update type
 func (bot *BusEtaBot) HandleUpdate(ctx context.Context, update *tgbotapi.Update) {
+	ctx = bot.withLogging(ctx, update)
+
 	var wg sync.WaitGroup
 	defer wg.Wait()
 
@@ -147,7 +159,7 @@ func (bot *BusEtaBot) HandleUpdate(ctx context.Context, update *tgbotapi.Update)
 				defer wg.Done()
 				err := bot.Users.UpdateUserLastSeenTime(ctx, message.From.ID, time.Now())
 				if err != nil {
-					aelog.Warningf(ctx, "%+v", err)
+					logWarningf(ctx, "%+v", err)
 				}
 			}()
 		}
@@ -162,7 +174,7 @@ func (bot *BusEtaBot) HandleUpdate(ctx context.Context, update *tgbotapi.Update)
 				defer wg.Done()
 				err := bot.Users.UpdateUserLastSeenTime(ctx, cbq.From.ID, time.Now())
 				if err != nil {
-					aelog.Warningf(ctx, "%+v", err)
+					logWarningf(ctx, "%+v", err)
 				}
 			}()
 		}
@@ -180,7 +192,7 @@ func (bot *BusEtaBot) HandleUpdate(ctx context.Context, update *tgbotapi.Update)
 				defer wg.Done()
 				err := bot.Users.UpdateUserLastSeenTime(ctx, ilq.From.ID, time.Now())
 				if err != nil {
-					aelog.Warningf(ctx, "%+v", err)
+					logWarningf(ctx, "%+v", err)
 				}
 			}()
 		}
@@ -195,6 +207,14 @@ func (bot *BusEtaBot) HandleUpdate(ctx context.Context, update *tgbotapi.Update)
 		bot.handleChosenInlineResult(ctx, cir)
 		return
 	}
+
+	// live locations are sent as messages and then edited as they move
+	if message := update.EditedMessage; message != nil && message.Location != nil {
+		if bot.Handlers.EditedLocationHandler != nil {
+			bot.handleEditedLocation(ctx, message)
+		}
+		return
+	}
 }
 
 func (bot *BusEtaBot) handleMessage(ctx context.Context, message *tgbotapi.Message) {
@@ -203,7 +223,7 @@ func (bot *BusEtaBot) handleMessage(ctx context.Context, message *tgbotapi.Messa
 	// ignore messages longer than a certain length
 	if len(message.Text) > MaxMessageLength {
 		go bot.LogEvent(ctx, message.From, CategoryMessage, ActionIgnoredTextMessage, message.Chat.Type)
-		aelog.Infof(ctx, "ignoring long message")
+		logInfof(ctx, "ignoring long message")
 		return
 	}
 
@@ -278,10 +298,19 @@ func (bot *BusEtaBot) handleInlineQuery(ctx context.Context, ilq *tgbotapi.Inlin
 	}
 }
 
+func (bot *BusEtaBot) handleEditedLocation(ctx context.Context, message *tgbotapi.Message) {
+	setUserContext(ctx, strconv.Itoa(message.From.ID))
+	// live location updates happen in the background, so errors are not shown to the user
+	err := bot.Handlers.EditedLocationHandler(ctx, bot, message)
+	if err != nil {
+		logError(ctx, err)
+	}
+}
+
 func (bot *BusEtaBot) handleChosenInlineResult(ctx context.Context, cir *tgbotapi.ChosenInlineResult) {
 	err := bot.Handlers.ChosenInlineResultHandler(ctx, bot, cir)
 	if err != nil {
-		aelog.Errorf(ctx, "%+v", err)
+		logError(ctx, err)
 	}
 }
 
@@ -290,7 +319,7 @@ func (bot *BusEtaBot) LogEvent(ctx context.Context, user *tgbotapi.User, categor
 	if bot.MeasurementProtocol != nil {
 		_, err := bot.MeasurementProtocol.LogEvent(user.ID, user.LanguageCode, category, action, label)
 		if err != nil {
-			aelog.Errorf(ctx, "error while logging event: %v", err)
+			logErrorf(ctx, "error while logging event: %v", err)
 		}
 	}
 }
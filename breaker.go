@@ -0,0 +1,205 @@
+package busetabot
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/yi-jiayu/datamall/v3"
+)
+
+// Circuit breaker states
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// Default circuit breaker parameters.
+const (
+	DefaultBreakerThreshold = 5
+	DefaultBreakerCooldown  = 30 * time.Second
+)
+
+// DataMallOutageMessage is shown instead of ETAs while the circuit breaker around DataMall is open.
+const DataMallOutageMessage = "LTA DataMall appears to be down at the moment, please try again later"
+
+// statusCodeNetworkError is used in place of a status code when a request to DataMall failed without a response.
+const statusCodeNetworkError = 0
+
+// CircuitOpenError is returned instead of making a request to DataMall while the circuit breaker is open.
+type CircuitOpenError struct {
+	// OpenedAt is when the circuit breaker opened.
+	OpenedAt time.Time
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker open since %s", e.OpenedAt)
+}
+
+// StatusCodeStats contains request counts and latencies for responses with a single status code.
+type StatusCodeStats struct {
+	Count         uint64  `json:"count"`
+	MeanLatencyMS float64 `json:"mean_latency_ms"`
+	MaxLatencyMS  float64 `json:"max_latency_ms"`
+}
+
+// BreakerStats describes the state of a circuit breaker and the requests which have passed through it.
+type BreakerStats struct {
+	State               string                  `json:"state"`
+	OpenedAt            time.Time               `json:"opened_at,omitempty"`
+	ConsecutiveFailures int                     `json:"consecutive_failures"`
+	Requests            uint64                  `json:"requests"`
+	Failures            uint64                  `json:"failures"`
+	Rejected            uint64                  `json:"rejected"`
+	ErrorRate           float64                 `json:"error_rate"`
+	StatusCodes         map[int]StatusCodeStats `json:"status_codes"`
+}
+
+type statusCodeCounter struct {
+	count        uint64
+	totalLatency time.Duration
+	maxLatency   time.Duration
+}
+
+// CircuitBreaker stops requests from being made to DataMall after repeated failures. After threshold consecutive
+// failures it opens and rejects all requests with a *CircuitOpenError. Once cooldown has passed, a single trial request
+// is allowed through: if it succeeds the breaker closes again, otherwise it stays open for another cooldown.
+//
+// A CircuitBreaker also records error rates and latencies for each status code returned by DataMall. Its state is kept
+// separately from the ETAService it protects so that a single breaker can be shared between services created for
+// different requests. It is safe for concurrent use.
+type CircuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	nowFunc   func() time.Time
+
+	mu                  sync.Mutex
+	state               string
+	openedAt            time.Time
+	consecutiveFailures int
+	requests            uint64
+	failures            uint64
+	rejected            uint64
+	statusCodes         map[int]*statusCodeCounter
+}
+
+// NewCircuitBreaker returns a closed CircuitBreaker which opens after threshold consecutive failures and allows a trial
+// request through after cooldown.
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		threshold:   threshold,
+		cooldown:    cooldown,
+		nowFunc:     time.Now,
+		state:       BreakerClosed,
+		statusCodes: make(map[int]*statusCodeCounter),
+	}
+}
+
+// Wrap returns an ETAService which makes requests to service through the circuit breaker.
+func (b *CircuitBreaker) Wrap(service ETAService) ETAService {
+	return circuitBreakerETAService{
+		breaker: b,
+		service: service,
+	}
+}
+
+// allow reports whether a request should be made, moving the breaker from open to half-open once the cooldown has
+// passed.
+func (b *CircuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if b.nowFunc().Sub(b.openedAt) < b.cooldown {
+			b.rejected++
+			return &CircuitOpenError{OpenedAt: b.openedAt}
+		}
+		b.state = BreakerHalfOpen
+		return nil
+	case BreakerHalfOpen:
+		// a trial request is already in flight
+		b.rejected++
+		return &CircuitOpenError{OpenedAt: b.openedAt}
+	}
+	return nil
+}
+
+func (b *CircuitBreaker) record(err error, latency time.Duration) {
+	statusCode := 200
+	if err != nil {
+		statusCode = statusCodeNetworkError
+		if dmErr, ok := err.(*datamall.Error); ok {
+			statusCode = dmErr.StatusCode
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.requests++
+	counter, ok := b.statusCodes[statusCode]
+	if !ok {
+		counter = new(statusCodeCounter)
+		b.statusCodes[statusCode] = counter
+	}
+	counter.count++
+	counter.totalLatency += latency
+	if latency > counter.maxLatency {
+		counter.maxLatency = latency
+	}
+
+	if err == nil {
+		b.consecutiveFailures = 0
+		b.state = BreakerClosed
+		b.openedAt = time.Time{}
+		return
+	}
+	b.failures++
+	b.consecutiveFailures++
+	if b.state == BreakerHalfOpen || b.consecutiveFailures >= b.threshold {
+		b.state = BreakerOpen
+		b.openedAt = b.nowFunc()
+	}
+}
+
+// Stats returns the breaker's current state and counters.
+func (b *CircuitBreaker) Stats() BreakerStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stats := BreakerStats{
+		State:               b.state,
+		OpenedAt:            b.openedAt,
+		ConsecutiveFailures: b.consecutiveFailures,
+		Requests:            b.requests,
+		Failures:            b.failures,
+		Rejected:            b.rejected,
+		StatusCodes:         make(map[int]StatusCodeStats),
+	}
+	if b.requests > 0 {
+		stats.ErrorRate = float64(b.failures) / float64(b.requests)
+	}
+	for statusCode, counter := range b.statusCodes {
+		stats.StatusCodes[statusCode] = StatusCodeStats{
+			Count:         counter.count,
+			MeanLatencyMS: float64(counter.totalLatency) / float64(counter.count) / float64(time.Millisecond),
+			MaxLatencyMS:  float64(counter.maxLatency) / float64(time.Millisecond),
+		}
+	}
+	return stats
+}
+
+type circuitBreakerETAService struct {
+	breaker *CircuitBreaker
+	service ETAService
+}
+
+func (s circuitBreakerETAService) GetBusArrival(busStopCode string, serviceNo string) (datamall.BusArrival, error) {
+	err := s.breaker.allow()
+	if err != nil {
+		return datamall.BusArrival{}, err
+	}
+	start := time.Now()
+	arrival, err := s.service.GetBusArrival(busStopCode, serviceNo)
+	s.breaker.record(err, time.Since(start))
+	return arrival, err
+}
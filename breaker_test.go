@@ -0,0 +1,88 @@
+package busetabot
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yi-jiayu/datamall/v3"
+)
+
+type sequenceETAService struct {
+	errs  []error
+	calls int
+}
+
+func (s *sequenceETAService) GetBusArrival(code string, serviceNo string) (datamall.BusArrival, error) {
+	var err error
+	if s.calls < len(s.errs) {
+		err = s.errs[s.calls]
+	}
+	s.calls++
+	return datamall.BusArrival{BusStopCode: code}, err
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Date(2019, 1, 1, 8, 0, 0, 0, sgt)
+	dmErr := &datamall.Error{StatusCode: 503}
+	upstream := &sequenceETAService{
+		errs: []error{nil, dmErr, dmErr, errors.New("network error"), dmErr},
+	}
+	breaker := NewCircuitBreaker(3, 30*time.Second)
+	breaker.nowFunc = func() time.Time {
+		return now
+	}
+	service := breaker.Wrap(upstream)
+
+	// a success followed by 3 consecutive failures opens the breaker
+	for i := 0; i < 4; i++ {
+		_, err := service.GetBusArrival("96049", "")
+		assert.Equal(t, upstream.errs[i], err)
+	}
+	assert.Equal(t, BreakerOpen, breaker.Stats().State)
+
+	// requests are rejected without reaching datamall while the breaker is open
+	_, err := service.GetBusArrival("96049", "")
+	assert.Equal(t, &CircuitOpenError{OpenedAt: now}, err)
+	assert.Equal(t, 4, upstream.calls)
+
+	// a failed trial request after the cooldown keeps the breaker open
+	now = now.Add(30 * time.Second)
+	_, err = service.GetBusArrival("96049", "")
+	assert.Equal(t, dmErr, err)
+	assert.Equal(t, 5, upstream.calls)
+	assert.Equal(t, BreakerOpen, breaker.Stats().State)
+	_, err = service.GetBusArrival("96049", "")
+	assert.IsType(t, &CircuitOpenError{}, err)
+
+	// a successful trial request closes the breaker
+	now = now.Add(30 * time.Second)
+	arrival, err := service.GetBusArrival("96049", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "96049", arrival.BusStopCode)
+
+	stats := breaker.Stats()
+	assert.Equal(t, BreakerClosed, stats.State)
+	assert.Equal(t, 0, stats.ConsecutiveFailures)
+	assert.Equal(t, uint64(6), stats.Requests)
+	assert.Equal(t, uint64(4), stats.Failures)
+	assert.Equal(t, uint64(2), stats.Rejected)
+	assert.InDelta(t, 4.0/6.0, stats.ErrorRate, 1e-9)
+	assert.Equal(t, uint64(2), stats.StatusCodes[200].Count)
+	assert.Equal(t, uint64(3), stats.StatusCodes[503].Count)
+	assert.Equal(t, uint64(1), stats.StatusCodes[statusCodeNetworkError].Count)
+}
+
+func TestCircuitBreaker_HalfOpenRejectsConcurrentRequests(t *testing.T) {
+	now := time.Date(2019, 1, 1, 8, 0, 0, 0, sgt)
+	breaker := NewCircuitBreaker(1, 30*time.Second)
+	breaker.nowFunc = func() time.Time {
+		return now
+	}
+	breaker.record(&datamall.Error{StatusCode: 503}, 0)
+	now = now.Add(time.Minute)
+	assert.NoError(t, breaker.allow())
+	assert.Equal(t, BreakerHalfOpen, breaker.Stats().State)
+	assert.IsType(t, &CircuitOpenError{}, breaker.allow())
+}
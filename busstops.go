@@ -3,10 +3,13 @@ package busetabot
 import (
 	"context"
 	"encoding/json"
-	"math"
+	"io/ioutil"
 	"os"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/pkg/errors"
 	"go.opencensus.io/trace"
@@ -20,6 +23,9 @@ type BusStop struct {
 	Latitude    float64  `json:"latitude"`
 	Longitude   float64  `json:"longitude"`
 	Services    []string `json:"services"`
+
+	// Timings contains the first and last bus times for each service at this bus stop.
+	Timings map[string]BusTimings `json:"timings,omitempty"`
 }
 
 type NearbyBusStop struct {
@@ -27,162 +33,351 @@ type NearbyBusStop struct {
 	Distance float64
 }
 
-type InMemoryBusStopRepository struct {
+// busStopIndex is an immutable snapshot of the bus stops in an InMemoryBusStopRepository.
+type busStopIndex struct {
 	busStops    []BusStop
 	busStopsMap map[string]*BusStop
+	search      searchIndex
+	grid        spatialGrid
 	synonyms    map[string]string
+	loadedAt    time.Time
+}
+
+func newBusStopIndex(busStops []BusStop, synonyms map[string]string) *busStopIndex {
+	busStopsMap := make(map[string]*BusStop)
+	for i := range busStops {
+		bs := busStops[i]
+		busStopsMap[bs.BusStopCode] = &bs
+	}
+	return &busStopIndex{
+		busStops:    busStops,
+		busStopsMap: busStopsMap,
+		search:      newSearchIndex(busStops),
+		grid:        newSpatialGrid(busStops),
+		synonyms:    synonyms,
+		loadedAt:    time.Now(),
+	}
+}
+
+// InMemoryBusStopRepository is a BusStopRepository which keeps all bus stops in memory. Its bus stops can be replaced
+// while it is in use, and each call sees a consistent snapshot of either the old or new bus stops.
+type InMemoryBusStopRepository struct {
+	index atomic.Value // *busStopIndex
+
+	// path and synonymsPath are where bus stops are reloaded from, if the repository was created from a file.
+	path         string
+	synonymsPath string
+
+	// mu serialises reloads.
+	mu       sync.Mutex
+	modTime  time.Time
+	lastDiff BusStopsDiff
+}
+
+func (r *InMemoryBusStopRepository) snapshot() *busStopIndex {
+	return r.index.Load().(*busStopIndex)
 }
 
 func (r *InMemoryBusStopRepository) Get(ID string) *BusStop {
-	busStop, ok := r.busStopsMap[ID]
+	busStop, ok := r.snapshot().busStopsMap[ID]
 	if ok {
 		return busStop
 	}
 	return nil
 }
 
-// Nearby returns up to limit bus stops which are within a given radius from a point as well as their
-// distance from that point.
+// Nearby returns up to limit bus stops which are within a given radius in metres from a point as well as their
+// distance from that point, sorted by distance.
 func (r *InMemoryBusStopRepository) Nearby(ctx context.Context, lat, lon, radius float64, limit int) (nearby []NearbyBusStop) {
 	if parent, ok := parentSpanFromContext(ctx); ok {
 		_, span := trace.StartSpanWithRemoteParent(ctx, "InMemoryBusStopRepository/Nearby", parent)
 		defer span.End()
 	}
 
-	r2 := radius * radius
-	for _, bs := range r.busStops {
-		d2 := SquaredEuclideanDistanceAtEquator(lat, lon, bs.Latitude, bs.Longitude)
-		if d2 <= r2 {
+	index := r.snapshot()
+	index.grid.candidates(lat, lon, radius, func(i int) {
+		bs := index.busStops[i]
+		d := HaversineDistance(lat, lon, bs.Latitude, bs.Longitude)
+		if d <= radius {
 			nearby = append(nearby, NearbyBusStop{
 				BusStop:  bs,
-				Distance: d2,
+				Distance: d,
 			})
 		}
-	}
+	})
+	// grid cells are visited in no particular order, so break ties by bus stop code
 	sort.Slice(nearby, func(i, j int) bool {
+		if nearby[i].Distance == nearby[j].Distance {
+			return nearby[i].BusStopCode < nearby[j].BusStopCode
+		}
 		return nearby[i].Distance < nearby[j].Distance
 	})
 	if limit <= 0 || limit > len(nearby) {
 		limit = len(nearby)
 	}
-	nearby = nearby[:limit]
-	for i := range nearby {
-		nearby[i].Distance = math.Sqrt(nearby[i].Distance)
-	}
-	return nearby
+	return nearby[:limit]
 }
 
-func lowercaseTokens(tokens []string) (lowercased []string) {
-	lowercased = make([]string, len(tokens))
+// expandSynonyms returns the search terms for tokens. Each term contains a token and, if an alias starts at that
+// token, its synonym as an alternative, so that synonyms only ever add matches. Aliases can be made up of several
+// words, in which case the longest alias starting at each token is used and the rest of its words are kept as terms of
+// their own.
+func expandSynonyms(synonyms map[string]string, tokens []string) [][]string {
+	terms := make([][]string, len(tokens))
 	for i, token := range tokens {
-		lowercased[i] = strings.ToLower(token)
+		terms[i] = []string{token}
 	}
-	return
+	if synonyms == nil {
+		return terms
+	}
+	longest := 1
+	for alias := range synonyms {
+		if n := strings.Count(alias, " ") + 1; n > longest {
+			longest = n
+		}
+	}
+	for i := 0; i < len(tokens); {
+		n := longest
+		if n > len(tokens)-i {
+			n = len(tokens) - i
+		}
+		for ; n > 0; n-- {
+			if synonym, ok := synonyms[strings.Join(tokens[i:i+n], " ")]; ok {
+				terms[i] = append(terms[i], synonym)
+				break
+			}
+		}
+		if n == 0 {
+			n = 1
+		}
+		i += n
+	}
+	return terms
 }
 
-func replaceSynonyms(synonyms map[string]string, tokens []string) []string {
-	if synonyms == nil {
-		return tokens
+// LoadSynonymsFile reads a synonyms JSON file and returns a map from each alias to its synonym. The file contains an
+// object whose keys are words used in bus stop descriptions and road names, such as "opp", and whose values are lists
+// of aliases which users might search for instead, such as ["opposite"]. Aliases can contain several words. Words and
+// aliases must be lowercase, and each alias can only have one synonym.
+func LoadSynonymsFile(path string) (map[string]string, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading synonyms JSON file")
 	}
-	results := make([]string, len(tokens))
-	for i, token := range tokens {
-		if synonym, ok := synonyms[token]; ok {
-			results[i] = synonym
-		} else {
-			results[i] = token
+	var words map[string][]string
+	err = json.Unmarshal(bs, &words)
+	if err != nil {
+		return nil, errors.Wrap(err, "error decoding synonyms JSON file")
+	}
+	synonyms := make(map[string]string)
+	for word, aliases := range words {
+		if word == "" || strings.ToLower(word) != word || len(strings.Fields(word)) != 1 {
+			return nil, errors.Errorf("invalid synonym %q: must be a single lowercase word", word)
+		}
+		for _, alias := range aliases {
+			if alias == "" || strings.ToLower(alias) != alias || strings.Join(strings.Fields(alias), " ") != alias {
+				return nil, errors.Errorf("invalid alias %q for %q: must be lowercase words separated by single spaces", alias, word)
+			}
+			if alias == word {
+				return nil, errors.Errorf("invalid alias %q: cannot be the same as its synonym", alias)
+			}
+			if other, ok := synonyms[alias]; ok && other != word {
+				return nil, errors.Errorf("invalid alias %q: has more than one synonym (%q and %q)", alias, other, word)
+			}
+			synonyms[alias] = word
 		}
 	}
-	return results
+	return synonyms, nil
 }
 
+// Search returns up to limit bus stops matching query, ranked as described in search.go. A query consisting of a
+// single bus stop code only returns that bus stop, and an empty query returns all bus stops.
 func (r *InMemoryBusStopRepository) Search(ctx context.Context, query string, limit int) []BusStop {
 	if parent, ok := parentSpanFromContext(ctx); ok {
 		_, span := trace.StartSpanWithRemoteParent(ctx, "InMemoryBusStopRepository/Search", parent)
 		defer span.End()
 	}
 
+	index := r.snapshot()
 	if query == "" {
-		if limit <= 0 || limit > len(r.busStops) {
-			limit = len(r.busStops)
+		if limit <= 0 || limit > len(index.busStops) {
+			limit = len(index.busStops)
 		}
-		return r.busStops[:limit]
+		return index.busStops[:limit]
 	}
 	tokens := strings.Fields(query)
 	if len(tokens) == 1 && len(tokens[0]) == 5 {
 		code := tokens[0]
-		if busStop, ok := r.busStopsMap[code]; ok {
+		if busStop, ok := index.busStopsMap[code]; ok {
 			return []BusStop{
 				*busStop,
 			}
 		}
 	}
-	tokens = replaceSynonyms(r.synonyms, lowercaseTokens(tokens))
-	var hits []struct {
-		Score int
-		BusStop
-	}
-	for _, busStop := range r.busStops {
-		descTokens := lowercaseTokens(strings.Fields(busStop.Description))
-		roadTokens := lowercaseTokens(strings.Fields(busStop.RoadName))
-		score := 0
-		for _, token := range tokens {
-			for _, descToken := range descTokens {
-				if token == descToken {
-					score += 2
-				}
-			}
-			for _, roadToken := range roadTokens {
-				if token == roadToken {
-					score += 1
-				}
-			}
-		}
-		if score > 0 {
-			hits = append(hits, struct {
-				Score int
-				BusStop
-			}{Score: score, BusStop: busStop})
-		}
-	}
-	sort.Slice(hits, func(i, j int) bool {
-		if hits[i].Score == hits[j].Score {
-			return hits[i].BusStopCode < hits[j].BusStopCode
-		}
-		return hits[i].Score > hits[j].Score
-	})
+	hits := index.search.search(index.busStops, expandSynonyms(index.synonyms, searchTokens(query)))
 	if limit <= 0 || limit > len(hits) {
 		limit = len(hits)
 	}
 	results := make([]BusStop, limit)
 	for i := 0; i < limit; i++ {
-		results[i] = hits[i].BusStop
+		results[i] = index.busStops[hits[i].stop]
 	}
 	return results
 }
 
 func NewInMemoryBusStopRepository(busStops []BusStop, synonyms map[string]string) *InMemoryBusStopRepository {
-	busStopsMap := make(map[string]*BusStop)
-	for i := range busStops {
-		bs := busStops[i]
-		busStopsMap[bs.BusStopCode] = &bs
+	r := new(InMemoryBusStopRepository)
+	r.index.Store(newBusStopIndex(busStops, synonyms))
+	return r
+}
+
+func NewInMemoryBusStopRepositoryFromFile(path, synonymsPath string) (*InMemoryBusStopRepository, error) {
+	busStops, modTime, err := loadBusStopsFile(path)
+	if err != nil {
+		return nil, err
 	}
-	return &InMemoryBusStopRepository{
-		busStops:    busStops,
-		busStopsMap: busStopsMap,
-		synonyms:    synonyms,
+	var synonyms map[string]string
+	if synonymsPath != "" {
+		synonyms, err = LoadSynonymsFile(synonymsPath)
+		if err != nil {
+			return nil, err
+		}
 	}
+	r := NewInMemoryBusStopRepository(busStops, synonyms)
+	r.path = path
+	r.synonymsPath = synonymsPath
+	r.modTime = modTime
+	return r, nil
 }
 
-func NewInMemoryBusStopRepositoryFromFile(path, synonymsPath string) (*InMemoryBusStopRepository, error) {
+func loadBusStopsFile(path string) ([]BusStop, time.Time, error) {
 	busStopsFile, err := os.Open(path)
 	if err != nil {
-		return nil, errors.Wrap(err, "error opening bus stops JSON file")
+		return nil, time.Time{}, errors.Wrap(err, "error opening bus stops JSON file")
 	}
 	defer busStopsFile.Close()
+	info, err := busStopsFile.Stat()
+	if err != nil {
+		return nil, time.Time{}, errors.Wrap(err, "error getting bus stops JSON file info")
+	}
 	var busStops []BusStop
 	err = json.NewDecoder(busStopsFile).Decode(&busStops)
 	if err != nil {
-		return nil, errors.Wrap(err, "error decoding bus stops JSON file")
+		return nil, time.Time{}, errors.Wrap(err, "error decoding bus stops JSON file")
+	}
+	return busStops, info.ModTime(), nil
+}
+
+// BusStopsStats describes the bus stops currently in an InMemoryBusStopRepository and the changes made by the last
+// reload.
+type BusStopsStats struct {
+	Count    int       `json:"count"`
+	LoadedAt time.Time `json:"loaded_at"`
+	Added    int       `json:"added"`
+	Removed  int       `json:"removed"`
+	Changed  int       `json:"changed"`
+}
+
+// Stats returns the number of bus stops and the changes made by the last reload.
+func (r *InMemoryBusStopRepository) Stats() BusStopsStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	index := r.snapshot()
+	return BusStopsStats{
+		Count:    len(index.busStops),
+		LoadedAt: index.loadedAt,
+		Added:    len(r.lastDiff.Added),
+		Removed:  len(r.lastDiff.Removed),
+		Changed:  len(r.lastDiff.Changed),
+	}
+}
+
+// Replace swaps in new bus stops and returns how they differ from the previous ones. Calls which are already in
+// progress keep using the previous bus stops.
+func (r *InMemoryBusStopRepository) Replace(busStops []BusStop) BusStopsDiff {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.replace(busStops, r.snapshot().synonyms)
+}
+
+// replace must be called with r.mu held.
+func (r *InMemoryBusStopRepository) replace(busStops []BusStop, synonyms map[string]string) BusStopsDiff {
+	old := r.snapshot()
+	diff := DiffBusStops(old.busStops, busStops)
+	r.index.Store(newBusStopIndex(busStops, synonyms))
+	r.lastDiff = diff
+	return diff
+}
+
+// Reload reads the bus stops and synonyms files the repository was created from again and swaps in their contents.
+// The current bus stops and synonyms are kept if either file cannot be read or is invalid.
+func (r *InMemoryBusStopRepository) Reload() (BusStopsDiff, error) {
+	if r.path == "" {
+		return BusStopsDiff{}, errors.New("bus stops were not loaded from a file")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.reload()
+}
+
+// reload must be called with r.mu held.
+func (r *InMemoryBusStopRepository) reload() (BusStopsDiff, error) {
+	busStops, modTime, err := loadBusStopsFile(r.path)
+	if err != nil {
+		return BusStopsDiff{}, err
+	}
+	// remember the file even if it is invalid so that it is not reloaded again until it changes
+	r.modTime = modTime
+	err = ValidateBusStops(busStops)
+	if err != nil {
+		return BusStopsDiff{}, err
+	}
+	synonyms := r.snapshot().synonyms
+	if r.synonymsPath != "" {
+		synonyms, err = LoadSynonymsFile(r.synonymsPath)
+		if err != nil {
+			return BusStopsDiff{}, err
+		}
+	}
+	return r.replace(busStops, synonyms), nil
+}
+
+// reloadIfModified reloads bus stops if the bus stops file has been modified since it was last loaded.
+func (r *InMemoryBusStopRepository) reloadIfModified() (diff BusStopsDiff, reloaded bool, err error) {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return BusStopsDiff{}, false, errors.Wrap(err, "error getting bus stops JSON file info")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if info.ModTime().Equal(r.modTime) {
+		return BusStopsDiff{}, false, nil
+	}
+	diff, err = r.reload()
+	return diff, err == nil, err
+}
+
+// Watch reloads bus stops whenever the bus stops file the repository was created from is modified, checking every
+// interval until ctx is done.
+func (r *InMemoryBusStopRepository) Watch(ctx context.Context, interval time.Duration, logger Logger) {
+	if r.path == "" {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			diff, reloaded, err := r.reloadIfModified()
+			if err != nil {
+				logger.Errorf(ctx, "error reloading bus stops: %+v", err)
+				continue
+			}
+			if reloaded {
+				logger.Infof(ctx, "reloaded bus stops: %s", diff)
+			}
+		}
 	}
-	return NewInMemoryBusStopRepository(busStops, nil), nil
 }
@@ -2,7 +2,13 @@ package busetabot
 
 import (
 	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -105,7 +111,7 @@ func TestInMemoryBusStopRepository_Nearby(t *testing.T) {
 						Latitude:    1.383764,
 						Longitude:   103.7583,
 					},
-					Distance: 923.9831005649131},
+					Distance: 922.6774749124571},
 			},
 		},
 		{
@@ -123,7 +129,7 @@ func TestInMemoryBusStopRepository_Nearby(t *testing.T) {
 						Latitude:    1.383764,
 						Longitude:   103.7583,
 					},
-					Distance: 6716.655692096068,
+					Distance: 6729.342747444399,
 				},
 				{
 					BusStop: BusStop{
@@ -132,7 +138,7 @@ func TestInMemoryBusStopRepository_Nearby(t *testing.T) {
 						Description: "Hotel Grand Pacific",
 						Latitude:    1.29684825487647,
 						Longitude:   103.85253591654006},
-					Distance: 7511.381516450915,
+					Distance: 7524.456713691638,
 				},
 			},
 		},
@@ -151,7 +157,7 @@ func TestInMemoryBusStopRepository_Nearby(t *testing.T) {
 						Latitude:    1.383764,
 						Longitude:   103.7583,
 					},
-					Distance: 6716.655692096068,
+					Distance: 6729.342747444399,
 				},
 			},
 		},
@@ -170,7 +176,7 @@ func TestInMemoryBusStopRepository_Nearby(t *testing.T) {
 						Latitude:    1.383764,
 						Longitude:   103.7583,
 					},
-					Distance: 6716.655692096068,
+					Distance: 6729.342747444399,
 				},
 				{
 					BusStop: BusStop{
@@ -179,7 +185,7 @@ func TestInMemoryBusStopRepository_Nearby(t *testing.T) {
 						Description: "Hotel Grand Pacific",
 						Latitude:    1.29684825487647,
 						Longitude:   103.85253591654006},
-					Distance: 7511.381516450915,
+					Distance: 7524.456713691638,
 				},
 			},
 		},
@@ -192,15 +198,137 @@ func TestInMemoryBusStopRepository_Nearby(t *testing.T) {
 	}
 }
 
-func Test_replaceSynonyms(t *testing.T) {
+func Test_expandSynonyms(t *testing.T) {
 	synonyms := map[string]string{
 		"bukit": "bk",
 		"park":  "pk",
 	}
-	tokens := []string{"bukit", "park"}
-	actual := replaceSynonyms(synonyms, tokens)
-	expected := []string{"bk", "pk"}
+	tokens := []string{"bukit", "park", "road"}
+	actual := expandSynonyms(synonyms, tokens)
+	expected := [][]string{{"bukit", "bk"}, {"park", "pk"}, {"road"}}
 	assert.Equal(t, expected, actual)
+
+	t.Run("multi-word aliases", func(t *testing.T) {
+		synonyms := map[string]string{
+			"mrt":         "stn",
+			"mrt station": "stn",
+			"station":     "stn",
+			"opposite":    "opp",
+		}
+		testCases := []struct {
+			Tokens   []string
+			Expected [][]string
+		}{
+			{[]string{"clementi", "mrt", "station"}, [][]string{{"clementi"}, {"mrt", "stn"}, {"station"}}},
+			{[]string{"clementi", "mrt"}, [][]string{{"clementi"}, {"mrt", "stn"}}},
+			{[]string{"opposite", "station"}, [][]string{{"opposite", "opp"}, {"station", "stn"}}},
+			{[]string{"mrt", "mrt", "station"}, [][]string{{"mrt", "stn"}, {"mrt", "stn"}, {"station"}}},
+			{[]string{"station", "mrt"}, [][]string{{"station", "stn"}, {"mrt", "stn"}}},
+		}
+		for _, tc := range testCases {
+			assert.Equal(t, tc.Expected, expandSynonyms(synonyms, tc.Tokens), tc.Tokens)
+		}
+	})
+}
+
+func TestLoadSynonymsFile(t *testing.T) {
+	synonyms, err := LoadSynonymsFile("data/synonyms.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "opp", synonyms["opposite"])
+	assert.Equal(t, "stn", synonyms["mrt station"])
+	assert.Equal(t, "int", synonyms["bus interchange"])
+
+	dir, err := ioutil.TempDir("", "synonyms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	testCases := []struct {
+		Name     string
+		Contents string
+		Error    string
+	}{
+		{
+			Name:     "uppercase synonym",
+			Contents: `{"OPP": ["opposite"]}`,
+			Error:    `invalid synonym "OPP": must be a single lowercase word`,
+		},
+		{
+			Name:     "alias which is the same as its synonym",
+			Contents: `{"opp": ["opp"]}`,
+			Error:    `invalid alias "opp": cannot be the same as its synonym`,
+		},
+		{
+			Name:     "alias with more than one synonym",
+			Contents: `{"stn": ["station"], "ter": ["station"]}`,
+			Error:    `invalid alias "station": has more than one synonym ("stn" and "ter")`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			path := filepath.Join(dir, "synonyms.json")
+			err := ioutil.WriteFile(path, []byte(tc.Contents), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = LoadSynonymsFile(path)
+			assert.EqualError(t, err, tc.Error)
+		})
+	}
+}
+
+func TestInMemoryBusStopRepository_SearchWithDataFiles(t *testing.T) {
+	repo, err := NewInMemoryBusStopRepositoryFromFile("data/bus_stops.json", "data/synonyms.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// each expected bus stop should be one of the first Limit results
+	testCases := []struct {
+		Query    string
+		Limit    int
+		Expected []string
+	}{
+		{"clementi mrt", 2, []string{"17171", "17179"}},
+		{"clementi mrt station", 2, []string{"17171", "17179"}},
+		{"bukit panjang interchange", 2, []string{"45009"}},
+		{"bukit panjang bus interchange", 2, []string{"45009"}},
+		{"tampines int", 1, []string{"75009"}},
+		{"bedok north interchange", 1, []string{"84009"}},
+		{"clementii int", 1, []string{"17009"}},
+		{"9604", 2, []string{"96041", "96049"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Query, func(t *testing.T) {
+			results := repo.Search(context.Background(), tc.Query, tc.Limit)
+			var codes []string
+			for _, bs := range results {
+				codes = append(codes, bs.BusStopCode)
+			}
+			for _, code := range tc.Expected {
+				assert.Contains(t, codes, code)
+			}
+		})
+	}
+}
+
+func TestInMemoryBusStopRepository_SearchWithSynonymsOnlyAddsResults(t *testing.T) {
+	withSynonyms, err := NewInMemoryBusStopRepositoryFromFile("data/bus_stops.json", "data/synonyms.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	withoutSynonyms, err := NewInMemoryBusStopRepositoryFromFile("data/bus_stops.json", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range []string{"woodlands", "lrt", "park", "clementi mrt", "opposite"} {
+		t.Run(query, func(t *testing.T) {
+			with := withSynonyms.Search(context.Background(), query, 0)
+			without := withoutSynonyms.Search(context.Background(), query, 0)
+			assert.True(t, len(with) >= len(without), "%d results with synonyms, %d without", len(with), len(without))
+		})
+	}
 }
 
 func TestInMemoryBusStopRepository_Search(t *testing.T) {
@@ -245,8 +373,7 @@ func TestInMemoryBusStopRepository_Search(t *testing.T) {
 		"bukit": "bk",
 		"park":  "pk",
 	}
-	repo := NewInMemoryBusStopRepository(busStops, nil)
-	repo.synonyms = synonyms
+	repo := NewInMemoryBusStopRepository(busStops, synonyms)
 
 	testCases := []struct {
 		Name     string
@@ -353,6 +480,37 @@ func TestInMemoryBusStopRepository_Search(t *testing.T) {
 				},
 			},
 		},
+		{
+			Name:  "prefixes should match longer words and rank description matches higher",
+			Query: "vict",
+			Expected: []BusStop{
+				busStops[4],
+				busStops[0],
+				busStops[3],
+			},
+		},
+		{
+			Name:  "exact matches should rank higher than prefix matches",
+			Query: "bras basa",
+			Expected: []BusStop{
+				busStops[0],
+			},
+		},
+		{
+			Name:     "misspelt words should match",
+			Query:    "bridgge",
+			Expected: []BusStop{busStops[2]},
+		},
+		{
+			Name:     "partial bus stop codes should match",
+			Query:    "010",
+			Expected: []BusStop{busStops[0], busStops[2]},
+		},
+		{
+			Name:     "short words should not match longer words",
+			Query:    "v",
+			Expected: []BusStop{},
+		},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.Name, func(t *testing.T) {
@@ -361,3 +519,98 @@ func TestInMemoryBusStopRepository_Search(t *testing.T) {
 		})
 	}
 }
+
+func TestInMemoryBusStopRepository_Reload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "busstops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "bus_stops.json")
+	old := []BusStop{
+		{BusStopCode: "01012", Description: "Hotel Grand Pacific", Latitude: 1.29, Longitude: 103.85},
+		{BusStopCode: "01013", Description: "St. Joseph's Ch", Latitude: 1.29, Longitude: 103.85},
+	}
+	err = WriteJSONFile(path, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := NewInMemoryBusStopRepositoryFromFile(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, reloaded, err := repo.reloadIfModified()
+	assert.NoError(t, err)
+	assert.False(t, reloaded, "an unmodified file should not be reloaded")
+
+	before := repo.snapshot()
+	err = WriteJSONFile(path, []BusStop{
+		{BusStopCode: "01012", Description: "Hotel Grand Pacific", Latitude: 1.29, Longitude: 103.85, Services: []string{"2"}},
+		{BusStopCode: "01019", Description: "Bras Basah Cplx", Latitude: 1.29, Longitude: 103.85},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// make sure the modification time changes even on file systems with coarse timestamps
+	later := time.Now().Add(time.Minute)
+	err = os.Chtimes(path, later, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff, reloaded, err := repo.reloadIfModified()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, reloaded)
+	assert.Equal(t, BusStopsDiff{Added: []string{"01019"}, Removed: []string{"01013"}, Changed: []string{"01012"}}, diff)
+	assert.NotNil(t, repo.Get("01019"))
+	assert.Nil(t, repo.Get("01013"))
+	assert.Len(t, before.busStops, 2, "existing snapshots should not be modified")
+	assert.NotNil(t, before.busStopsMap["01013"])
+	stats := repo.Stats()
+	assert.Equal(t, 2, stats.Count)
+	assert.Equal(t, 1, stats.Added)
+	assert.Equal(t, 1, stats.Removed)
+	assert.Equal(t, 1, stats.Changed)
+
+	t.Run("invalid bus stops are not swapped in", func(t *testing.T) {
+		err = WriteJSONFile(path, []BusStop{{BusStopCode: "123"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err := repo.Reload()
+		assert.Error(t, err)
+		assert.NotNil(t, repo.Get("01019"))
+	})
+}
+
+func TestInMemoryBusStopRepository_Replace(t *testing.T) {
+	repo := NewInMemoryBusStopRepository([]BusStop{{BusStopCode: "01012", Description: "Hotel Grand Pacific"}}, nil)
+	_, err := repo.Reload()
+	assert.Error(t, err, "repositories not created from a file cannot be reloaded")
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				results := repo.Search(context.Background(), "", 0)
+				assert.Len(t, results, 1)
+				repo.Nearby(context.Background(), 1.29, 103.85, 100, 0)
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		repo.Replace([]BusStop{{BusStopCode: fmt.Sprintf("%05d", i), Description: "Replaced"}})
+	}
+	close(stop)
+	wg.Wait()
+	assert.NotNil(t, repo.Get("00099"))
+}
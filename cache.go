@@ -0,0 +1,120 @@
+package busetabot
+
+import (
+	"sync"
+	"time"
+
+	"github.com/yi-jiayu/datamall/v3"
+)
+
+// DefaultETACacheTTL is how long bus arrivals are cached for by default.
+const DefaultETACacheTTL = 15 * time.Second
+
+// CacheStats contains counters describing how effective a cache has been.
+type CacheStats struct {
+	// Hits is the number of requests answered from a cached response.
+	Hits uint64 `json:"hits"`
+
+	// Misses is the number of requests which resulted in a request to the underlying service.
+	Misses uint64 `json:"misses"`
+
+	// Coalesced is the number of requests which waited for an identical request already in flight instead of making
+	// their own request to the underlying service.
+	Coalesced uint64 `json:"coalesced"`
+}
+
+type etaCacheEntry struct {
+	// done is closed once the request for this entry completes.
+	done      chan struct{}
+	arrival   datamall.BusArrival
+	err       error
+	fetchedAt time.Time
+}
+
+// CachingETAService is an ETAService which caches the bus arrivals for each bus stop for a short time, so that users
+// asking for the same bus stop within a few seconds of each other do not each cause a request to DataMall.
+// Concurrent requests for the same bus stop share a single upstream request. Errors are not cached.
+// It is safe for concurrent use.
+type CachingETAService struct {
+	service ETAService
+	ttl     time.Duration
+	nowFunc func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*etaCacheEntry
+	stats   CacheStats
+}
+
+// NewCachingETAService returns a CachingETAService which caches bus arrivals from service for ttl.
+func NewCachingETAService(service ETAService, ttl time.Duration) *CachingETAService {
+	return &CachingETAService{
+		service: service,
+		ttl:     ttl,
+		nowFunc: time.Now,
+		entries: make(map[string]*etaCacheEntry),
+	}
+}
+
+func (s *CachingETAService) GetBusArrival(busStopCode string, serviceNo string) (datamall.BusArrival, error) {
+	key := busStopCode + " " + serviceNo
+	now := s.nowFunc()
+
+	s.mu.Lock()
+	entry, ok := s.entries[key]
+	if ok {
+		select {
+		case <-entry.done:
+			if now.Sub(entry.fetchedAt) < s.ttl {
+				s.stats.Hits++
+				s.mu.Unlock()
+				return entry.arrival, nil
+			}
+		default:
+			s.stats.Coalesced++
+			s.mu.Unlock()
+			<-entry.done
+			return entry.arrival, entry.err
+		}
+	}
+	entry = &etaCacheEntry{
+		done: make(chan struct{}),
+	}
+	s.entries[key] = entry
+	s.stats.Misses++
+	s.mu.Unlock()
+
+	entry.arrival, entry.err = s.service.GetBusArrival(busStopCode, serviceNo)
+	entry.fetchedAt = s.nowFunc()
+
+	s.mu.Lock()
+	if entry.err != nil && s.entries[key] == entry {
+		delete(s.entries, key)
+	}
+	close(entry.done)
+	s.mu.Unlock()
+
+	return entry.arrival, entry.err
+}
+
+// Stats returns the cache's hit and miss counters.
+func (s *CachingETAService) Stats() CacheStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+// Purge removes expired entries from the cache.
+func (s *CachingETAService) Purge() {
+	now := s.nowFunc()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, entry := range s.entries {
+		select {
+		case <-entry.done:
+			if now.Sub(entry.fetchedAt) >= s.ttl {
+				delete(s.entries, key)
+			}
+		default:
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package busetabot
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yi-jiayu/datamall/v3"
+)
+
+type countingETAService struct {
+	calls   int64
+	release chan struct{}
+	err     error
+}
+
+func (s *countingETAService) GetBusArrival(code string, serviceNo string) (datamall.BusArrival, error) {
+	atomic.AddInt64(&s.calls, 1)
+	if s.release != nil {
+		<-s.release
+	}
+	if s.err != nil {
+		return datamall.BusArrival{}, s.err
+	}
+	return datamall.BusArrival{BusStopCode: code}, nil
+}
+
+func TestCachingETAService_GetBusArrival(t *testing.T) {
+	now := time.Date(2019, 1, 1, 8, 0, 0, 0, sgt)
+	upstream := &countingETAService{}
+	service := NewCachingETAService(upstream, 15*time.Second)
+	service.nowFunc = func() time.Time {
+		return now
+	}
+
+	arrival, err := service.GetBusArrival("96049", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "96049", arrival.BusStopCode)
+
+	now = now.Add(10 * time.Second)
+	arrival, err = service.GetBusArrival("96049", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "96049", arrival.BusStopCode)
+	assert.Equal(t, int64(1), upstream.calls, "second request within TTL should be answered from the cache")
+
+	_, err = service.GetBusArrival("81111", "")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), upstream.calls, "requests for different bus stops should not share a cache entry")
+
+	now = now.Add(10 * time.Second)
+	_, err = service.GetBusArrival("96049", "")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), upstream.calls, "request after TTL should go to the underlying service")
+
+	assert.Equal(t, CacheStats{Hits: 1, Misses: 3}, service.Stats())
+}
+
+func TestCachingETAService_GetBusArrival_Coalescing(t *testing.T) {
+	upstream := &countingETAService{
+		release: make(chan struct{}),
+	}
+	service := NewCachingETAService(upstream, 15*time.Second)
+
+	const concurrency = 10
+	var wg sync.WaitGroup
+	arrivals := make([]datamall.BusArrival, concurrency)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			arrivals[i], _ = service.GetBusArrival("96049", "")
+		}(i)
+	}
+	// wait for all the requests to start waiting before releasing the upstream request
+	for {
+		stats := service.Stats()
+		if stats.Misses+stats.Coalesced == concurrency {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(upstream.release)
+	wg.Wait()
+
+	assert.Equal(t, int64(1), upstream.calls)
+	for _, arrival := range arrivals {
+		assert.Equal(t, "96049", arrival.BusStopCode)
+	}
+	assert.Equal(t, CacheStats{Misses: 1, Coalesced: concurrency - 1}, service.Stats())
+}
+
+func TestCachingETAService_GetBusArrival_DoesNotCacheErrors(t *testing.T) {
+	upstream := &countingETAService{
+		err: &datamall.Error{StatusCode: 503},
+	}
+	service := NewCachingETAService(upstream, 15*time.Second)
+
+	_, err := service.GetBusArrival("96049", "")
+	assert.Equal(t, upstream.err, err)
+	upstream.err = nil
+	arrival, err := service.GetBusArrival("96049", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "96049", arrival.BusStopCode)
+	assert.Equal(t, int64(2), upstream.calls)
+}
+
+func TestCachingETAService_Purge(t *testing.T) {
+	now := time.Date(2019, 1, 1, 8, 0, 0, 0, sgt)
+	upstream := &countingETAService{}
+	service := NewCachingETAService(upstream, 15*time.Second)
+	service.nowFunc = func() time.Time {
+		return now
+	}
+	_, _ = service.GetBusArrival("96049", "")
+	now = now.Add(10 * time.Second)
+	_, _ = service.GetBusArrival("81111", "")
+	now = now.Add(10 * time.Second)
+	service.Purge()
+	assert.Len(t, service.entries, 1)
+	assert.Contains(t, service.entries, "81111 ")
+}
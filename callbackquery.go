@@ -4,12 +4,12 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
 
 	"github.com/pkg/errors"
 	"github.com/yi-jiayu/telegram-bot-api"
-	"google.golang.org/appengine"
-	"google.golang.org/appengine/log"
 
+	"github.com/yi-jiayu/bus-eta-bot/v4/favourite"
 	"github.com/yi-jiayu/bus-eta-bot/v4/telegram"
 )
 
@@ -21,35 +21,51 @@ var callbackQueryHandlers = map[string]CallbackQueryHandler{
 	"new_eta":  NewEtaHandler,
 	"addf":     ToggleFavouritesHandler,
 	"togf":     ToggleFavouritesHandler,
+
+	"alert":        AlertCallbackHandler,
+	"alert_cancel": CancelAlertCallbackHandler,
+
+	"schedule_delete": DeleteScheduleCallbackHandler,
+
+	"live":      LiveCallbackHandler,
+	"live_stop": StopLiveCallbackHandler,
+
+	"route": RouteCallbackHandler,
+
+	"nearby":      NearbyCallbackHandler,
+	"nearby_mode": NearbyModeCallbackHandler,
+
+	"settings": SettingsCallbackHandler,
+
+	"favm": ManageFavouritesCallbackHandler,
+	"dash": DashboardCallbackHandler,
 }
 
 // CallbackQueryHandler is a handler for callback queries
 type CallbackQueryHandler func(ctx context.Context, bot *BusEtaBot, cbq *tgbotapi.CallbackQuery, responses chan<- Response)
 
-func updateETAMessage(ctx context.Context, bot *BusEtaBot, cbq *tgbotapi.CallbackQuery, req ETARequest, formatter string, responses chan<- Response) {
-	eta := NewETA(ctx, bot.BusStops, bot.Datamall, req)
-	var f Formatter
-	var ok_ bool
-	if f, ok_ = Formatters[formatter]; !ok_ {
-		f = summaryFormatter
+// callbackQueryMessage returns the message a callback query came from.
+func callbackQueryMessage(cbq *tgbotapi.CallbackQuery) liveMessage {
+	if cbq.InlineMessageID != "" {
+		return liveMessage{
+			InlineMessageID: cbq.InlineMessageID,
+		}
+	}
+	return liveMessage{
+		ChatID:    cbq.Message.Chat.ID,
+		MessageID: cbq.Message.MessageID,
 	}
-	text, err := f.Format(eta)
+}
+
+func updateETAMessage(ctx context.Context, bot *BusEtaBot, cbq *tgbotapi.CallbackQuery, req ETARequest, formatter string, responses chan<- Response) {
+	message := callbackQueryMessage(cbq)
+	// refreshing a live message should not replace its Stop button
+	live := bot.LiveETAs != nil && bot.LiveETAs.IsLive(message)
+	editMessageTextRequest, err := newETAMessageEditRequest(ctx, bot, message, req, formatter, live)
 	if err != nil {
 		responses <- notOk(err)
 		return
 	}
-	editMessageTextRequest := telegram.EditMessageTextRequest{
-		Text:      text,
-		ParseMode: "markdown",
-	}
-	if cbq.InlineMessageID != "" {
-		editMessageTextRequest.InlineMessageID = cbq.InlineMessageID
-		editMessageTextRequest.ReplyMarkup = NewETAMessageReplyMarkup(req.Code, req.Services, formatter, true)
-	} else {
-		editMessageTextRequest.ChatID = cbq.Message.Chat.ID
-		editMessageTextRequest.MessageID = cbq.Message.MessageID
-		editMessageTextRequest.ReplyMarkup = NewETAMessageReplyMarkup(req.Code, req.Services, formatter, false)
-	}
 	responses <- ok(editMessageTextRequest)
 	answerCallbackQueryRequest := telegram.AnswerCallbackQueryRequest{
 		CallbackQueryID: cbq.ID,
@@ -59,23 +75,21 @@ func updateETAMessage(ctx context.Context, bot *BusEtaBot, cbq *tgbotapi.Callbac
 }
 
 func sendETAMessage(ctx context.Context, bot *BusEtaBot, cbq *tgbotapi.CallbackQuery, code string, services []string, responses chan<- Response) {
-	eta := NewETA(ctx, bot.BusStops, bot.Datamall, ETARequest{
+	eta, err := bot.etaMessages().Message(ctx, ETARequest{
 		UserID:   cbq.From.ID,
 		Time:     bot.NowFunc(),
 		Code:     code,
 		Services: services,
-	})
-	text, err := summaryFormatter.Format(eta)
+	}, bot.etaMessageOptions())
 	if err != nil {
 		responses <- notOk(err)
 		return
 	}
-	markup := NewETAMessageReplyMarkup(code, services, "", false)
 	sendMessageRequest := telegram.SendMessageRequest{
-		Text:        text,
+		Text:        eta.Text,
 		ChatID:      cbq.Message.Chat.ID,
-		ParseMode:   "markdown",
-		ReplyMarkup: markup,
+		ParseMode:   eta.ParseMode,
+		ReplyMarkup: eta.ReplyMarkup,
 	}
 	responses <- ok(sendMessageRequest)
 	answerCallbackQueryRequest := telegram.AnswerCallbackQueryRequest{
@@ -83,6 +97,7 @@ func sendETAMessage(ctx context.Context, bot *BusEtaBot, cbq *tgbotapi.CallbackQ
 		Text:            "ETAs sent!",
 	}
 	responses <- ok(answerCallbackQueryRequest)
+	bot.rememberBusStop(ctx, cbq.From.ID, code)
 }
 
 // RefreshCallbackHandler handles the callback for the Refresh button on an eta message.
@@ -164,20 +179,12 @@ func NewEtaHandler(ctx context.Context, bot *BusEtaBot, cbq *tgbotapi.CallbackQu
 	close(responses)
 }
 
-func stringInSlice(a string, list []string) (bool, int) {
-	for i, b := range list {
-		if b == a {
-			return true, i
-		}
-	}
-	return false, 0
-}
-
-func newShowFavouritesMarkup(favourites []string) telegram.ReplyKeyboardMarkup {
+// newShowFavouritesMarkup returns a reply keyboard with a button for each favourite, showing its label if it has one.
+func newShowFavouritesMarkup(favourites []Favourite) telegram.ReplyKeyboardMarkup {
 	var keyboard [][]telegram.KeyboardButton
 	for _, fav := range favourites {
 		button := telegram.KeyboardButton{
-			Text: fav,
+			Text: fav.Name(),
 		}
 		row := []telegram.KeyboardButton{button}
 		keyboard = append(keyboard, row)
@@ -206,15 +213,15 @@ func ToggleFavouritesHandler(ctx context.Context, bot *BusEtaBot, cbq *tgbotapi.
 	}
 	var action string
 	// if the entry is already in the favourites, we remove it
-	if exists, pos := stringInSlice(data.Argstr, favourites); exists {
+	if pos := favourite.Index(favourites, data.Argstr); pos >= 0 {
 		// remove item from slice
 		copy(favourites[pos:], favourites[pos+1:])
-		favourites[len(favourites)-1] = ""
+		favourites[len(favourites)-1] = Favourite{}
 		favourites = favourites[:len(favourites)-1]
 
 		action = "removed from"
 	} else {
-		favourites = append(favourites, data.Argstr)
+		favourites = append(favourites, Favourite{Query: data.Argstr})
 		action = "added to"
 	}
 	err = bot.Users.SetUserFavourites(ctx, userID, favourites)
@@ -244,16 +251,331 @@ func ToggleFavouritesHandler(ctx context.Context, bot *BusEtaBot, cbq *tgbotapi.
 	}
 }
 
+// AlertCallbackHandler handles the alert button on eta messages. If the eta message was for a single service, an alert
+// is set up for it straight away, otherwise the user is asked to pick a service first.
+func AlertCallbackHandler(ctx context.Context, bot *BusEtaBot, cbq *tgbotapi.CallbackQuery, responses chan<- Response) {
+	defer close(responses)
+	go bot.LogEvent(ctx, cbq.From, CategoryCallback, ActionAlertCallback, cbq.Message.Chat.Type)
+
+	var data CallbackData
+	err := json.Unmarshal([]byte(cbq.Data), &data)
+	if err != nil {
+		responses <- notOk(errors.Wrap(err, "error unmarshalling callback data"))
+		return
+	}
+	chatID := cbq.Message.Chat.ID
+	var resp telegram.SendMessageRequest
+	switch {
+	case bot.Alerts == nil:
+		resp = telegram.SendMessageRequest{
+			ChatID: chatID,
+			Text:   "Oops, alerts are not available at the moment.",
+		}
+	case len(data.ServiceNos) == 1:
+		resp, err = addAlert(ctx, bot, Alert{
+			UserID:      cbq.From.ID,
+			ChatID:      chatID,
+			BusStopCode: data.BusStopID,
+			ServiceNo:   data.ServiceNos[0],
+			Minutes:     DefaultAlertMinutes,
+		})
+		if err != nil {
+			responses <- notOk(err)
+			return
+		}
+	default:
+		services := data.ServiceNos
+		if len(services) == 0 && bot.BusStops != nil {
+			if stop := bot.BusStops.Get(data.BusStopID); stop != nil {
+				services = stop.Services
+			}
+		}
+		resp = telegram.SendMessageRequest{
+			ChatID:      chatID,
+			Text:        fmt.Sprintf("Which bus at %s should I alert you about?", describeBusStop(bot.BusStops, data.BusStopID)),
+			ReplyMarkup: newAlertServicesMarkup(data.BusStopID, services),
+		}
+		if len(services) == 0 {
+			resp.Text = fmt.Sprintf("Send `/alert %s <service> [minutes]` to get an alert when your bus is a few minutes away.", data.BusStopID)
+			resp.ParseMode = "markdown"
+			resp.ReplyMarkup = nil
+		}
+	}
+	responses <- ok(resp)
+	responses <- ok(telegram.AnswerCallbackQueryRequest{
+		CallbackQueryID: cbq.ID,
+	})
+}
+
+func newAlertServicesMarkup(busStopCode string, services []string) telegram.InlineKeyboardMarkup {
+	var keyboard [][]telegram.InlineKeyboardButton
+	var row []telegram.InlineKeyboardButton
+	for _, service := range services {
+		JSON, _ := json.Marshal(CallbackData{
+			Type:       "alert",
+			BusStopID:  busStopCode,
+			ServiceNos: []string{service},
+		})
+		row = append(row, telegram.InlineKeyboardButton{
+			Text:         service,
+			CallbackData: string(JSON),
+		})
+		if len(row) == 4 {
+			keyboard = append(keyboard, row)
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		keyboard = append(keyboard, row)
+	}
+	return telegram.InlineKeyboardMarkup{
+		InlineKeyboard: keyboard,
+	}
+}
+
+// CancelAlertCallbackHandler handles the cancel buttons on alerts.
+func CancelAlertCallbackHandler(ctx context.Context, bot *BusEtaBot, cbq *tgbotapi.CallbackQuery, responses chan<- Response) {
+	defer close(responses)
+	go bot.LogEvent(ctx, cbq.From, CategoryCallback, ActionCancelAlertCallback, cbq.Message.Chat.Type)
+
+	var data CallbackData
+	err := json.Unmarshal([]byte(cbq.Data), &data)
+	if err != nil {
+		responses <- notOk(errors.Wrap(err, "error unmarshalling callback data"))
+		return
+	}
+	answer := telegram.AnswerCallbackQueryRequest{
+		CallbackQueryID: cbq.ID,
+		Text:            "That alert has already been triggered or cancelled.",
+	}
+	if bot.Alerts == nil {
+		responses <- ok(answer)
+		return
+	}
+	ID, err := strconv.ParseInt(data.Argstr, 10, 64)
+	if err != nil {
+		responses <- notOk(errors.Wrap(err, "invalid alert ID"))
+		return
+	}
+	alerts, err := bot.Alerts.GetUserAlerts(ctx, cbq.From.ID)
+	if err != nil {
+		responses <- notOk(err)
+		return
+	}
+	// users may only cancel their own alerts
+	for _, alert := range alerts {
+		if alert.ID != ID {
+			continue
+		}
+		err = bot.Alerts.DeleteAlert(ctx, ID)
+		if err != nil {
+			responses <- notOk(err)
+			return
+		}
+		answer.Text = fmt.Sprintf("Alert for bus %s at %s cancelled.", alert.ServiceNo, alert.BusStopCode)
+		break
+	}
+	responses <- ok(answer)
+}
+
+// DeleteScheduleCallbackHandler handles the delete buttons on the list of scheduled etas.
+func DeleteScheduleCallbackHandler(ctx context.Context, bot *BusEtaBot, cbq *tgbotapi.CallbackQuery, responses chan<- Response) {
+	defer close(responses)
+	go bot.LogEvent(ctx, cbq.From, CategoryCallback, ActionDeleteScheduleCallback, cbq.Message.Chat.Type)
+
+	var data CallbackData
+	err := json.Unmarshal([]byte(cbq.Data), &data)
+	if err != nil {
+		responses <- notOk(errors.Wrap(err, "error unmarshalling callback data"))
+		return
+	}
+	answer := telegram.AnswerCallbackQueryRequest{
+		CallbackQueryID: cbq.ID,
+		Text:            "That scheduled eta has already been deleted.",
+	}
+	if bot.Schedules == nil {
+		responses <- ok(answer)
+		return
+	}
+	ID, err := strconv.ParseInt(data.Argstr, 10, 64)
+	if err != nil {
+		responses <- notOk(errors.Wrap(err, "invalid schedule ID"))
+		return
+	}
+	schedules, err := bot.Schedules.GetUserSchedules(ctx, cbq.From.ID)
+	if err != nil {
+		responses <- notOk(err)
+		return
+	}
+	// users may only delete their own schedules
+	for i, schedule := range schedules {
+		if schedule.ID != ID {
+			continue
+		}
+		err = bot.Schedules.DeleteSchedule(ctx, ID)
+		if err != nil {
+			responses <- notOk(err)
+			return
+		}
+		schedules = append(schedules[:i], schedules[i+1:]...)
+		answer.Text = fmt.Sprintf("Deleted scheduled etas for %s.", schedule)
+		break
+	}
+	list := newSchedulesMessage(cbq.Message.Chat.ID, schedules)
+	edit := telegram.EditMessageTextRequest{
+		ChatID:    cbq.Message.Chat.ID,
+		MessageID: cbq.Message.MessageID,
+		Text:      list.Text,
+	}
+	if markup, ok := list.ReplyMarkup.(telegram.InlineKeyboardMarkup); ok {
+		edit.ReplyMarkup = markup
+	}
+	responses <- ok(edit)
+	responses <- ok(answer)
+}
+
+// LiveCallbackHandler handles the Live button on eta messages, which keeps the message up to date for a while.
+func LiveCallbackHandler(ctx context.Context, bot *BusEtaBot, cbq *tgbotapi.CallbackQuery, responses chan<- Response) {
+	defer close(responses)
+	go bot.LogEvent(ctx, cbq.From, CategoryCallback, ActionLiveCallback, "")
+
+	var data CallbackData
+	err := json.Unmarshal([]byte(cbq.Data), &data)
+	if err != nil {
+		responses <- notOk(errors.Wrap(err, "error unmarshalling callback data"))
+		return
+	}
+	answer := telegram.AnswerCallbackQueryRequest{
+		CallbackQueryID: cbq.ID,
+	}
+	if bot.LiveETAs == nil {
+		answer.Text = "Oops, live etas are not available at the moment."
+		responses <- ok(answer)
+		return
+	}
+	message := callbackQueryMessage(cbq)
+	owner := message.ChatID
+	if message.InlineMessageID != "" {
+		owner = int64(cbq.From.ID)
+	}
+	req := ETARequest{
+		UserID:   cbq.From.ID,
+		Time:     bot.NowFunc(),
+		Code:     data.BusStopID,
+		Services: data.ServiceNos,
+	}
+	err = bot.LiveETAs.start(ctx, bot, message, owner, req, data.Formatter)
+	switch err {
+	case nil:
+	case errLiveChatLimit:
+		answer.Text = fmt.Sprintf("Oops, there can only be %d live eta messages in a chat at a time.", bot.LiveETAs.MaxPerChat)
+		responses <- ok(answer)
+		return
+	case errLiveLimit:
+		answer.Text = "Oops, too many people are using live etas right now. Please try again later."
+		responses <- ok(answer)
+		return
+	default:
+		responses <- notOk(err)
+		return
+	}
+	edit, err := newETAMessageEditRequest(ctx, bot, message, req, data.Formatter, true)
+	if err != nil {
+		responses <- notOk(err)
+		return
+	}
+	responses <- ok(edit)
+	answer.Text = liveDescription(bot.LiveETAs)
+	responses <- ok(answer)
+}
+
+// StopLiveCallbackHandler handles the Stop button on live eta messages.
+func StopLiveCallbackHandler(ctx context.Context, bot *BusEtaBot, cbq *tgbotapi.CallbackQuery, responses chan<- Response) {
+	defer close(responses)
+	go bot.LogEvent(ctx, cbq.From, CategoryCallback, ActionStopLiveCallback, "")
+
+	var data CallbackData
+	err := json.Unmarshal([]byte(cbq.Data), &data)
+	if err != nil {
+		responses <- notOk(errors.Wrap(err, "error unmarshalling callback data"))
+		return
+	}
+	answer := telegram.AnswerCallbackQueryRequest{
+		CallbackQueryID: cbq.ID,
+		Text:            "Live etas stopped.",
+	}
+	message := callbackQueryMessage(cbq)
+	if bot.LiveETAs != nil && bot.LiveETAs.stop(message) {
+		// the live message will be updated one last time without the Stop button
+		responses <- ok(answer)
+		return
+	}
+	// the message is no longer live, for example because the bot was restarted, so remove the Stop button ourselves
+	req := ETARequest{
+		UserID:   cbq.From.ID,
+		Time:     bot.NowFunc(),
+		Code:     data.BusStopID,
+		Services: data.ServiceNos,
+	}
+	edit, err := newETAMessageEditRequest(ctx, bot, message, req, data.Formatter, false)
+	if err != nil {
+		responses <- notOk(err)
+		return
+	}
+	responses <- ok(edit)
+	responses <- ok(answer)
+}
+
+// RouteCallbackHandler handles the buttons for moving between pages and directions on route messages.
+func RouteCallbackHandler(ctx context.Context, bot *BusEtaBot, cbq *tgbotapi.CallbackQuery, responses chan<- Response) {
+	defer close(responses)
+	go bot.LogEvent(ctx, cbq.From, CategoryCallback, ActionRouteCallback, cbq.Message.Chat.Type)
+
+	var data CallbackData
+	err := json.Unmarshal([]byte(cbq.Data), &data)
+	if err != nil {
+		responses <- notOk(errors.Wrap(err, "error unmarshalling callback data"))
+		return
+	}
+	var direction, page int
+	_, err = fmt.Sscanf(data.Argstr, "%d %d", &direction, &page)
+	if err != nil || len(data.ServiceNos) != 1 {
+		responses <- notOk(errors.Errorf("invalid route callback data: %s", cbq.Data))
+		return
+	}
+	var routes []Route
+	if bot.Routes != nil {
+		routes = bot.Routes.Routes(data.ServiceNos[0])
+	}
+	if len(routes) == 0 {
+		responses <- ok(telegram.AnswerCallbackQueryRequest{
+			CallbackQueryID: cbq.ID,
+			Text:            "Oops, that bus route is no longer available.",
+		})
+		return
+	}
+	text, markup := newRouteMessage(bot.BusStops, routes, direction, page)
+	responses <- ok(telegram.EditMessageTextRequest{
+		ChatID:      cbq.Message.Chat.ID,
+		MessageID:   cbq.Message.MessageID,
+		Text:        text,
+		ReplyMarkup: markup,
+	})
+	responses <- ok(telegram.AnswerCallbackQueryRequest{
+		CallbackQueryID: cbq.ID,
+	})
+}
+
 // callbackErrorHandler is for informing the user about an error while processing a callback query.
 func callbackErrorHandler(ctx context.Context, bot *BusEtaBot, cbq *tgbotapi.CallbackQuery, err error) {
-	log.Errorf(ctx, "%+v", err)
+	logError(ctx, err)
 
-	text := fmt.Sprintf("Oh no! Something went wrong. \n\nRequest ID: `%s`", appengine.RequestID(ctx))
+	text := fmt.Sprintf("Oh no! Something went wrong. \n\nRequest ID: `%s`", requestID(ctx))
 	answer := tgbotapi.NewCallbackWithAlert(cbq.ID, text)
 
 	_, err = bot.Telegram.AnswerCallbackQuery(answer)
 	if err != nil {
 		err := errors.Wrap(err, fmt.Sprintf("%#v", answer))
-		log.Errorf(ctx, "%+v", err)
+		logError(ctx, err)
 	}
 }
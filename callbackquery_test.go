@@ -13,6 +13,7 @@ import (
 	"github.com/yi-jiayu/datamall/v3"
 	"github.com/yi-jiayu/telegram-bot-api"
 
+	"github.com/yi-jiayu/bus-eta-bot/v4/favourite"
 	"github.com/yi-jiayu/bus-eta-bot/v4/mocks"
 	"github.com/yi-jiayu/bus-eta-bot/v4/telegram"
 )
@@ -495,9 +496,9 @@ func TestToggleFavouritesHandler(t *testing.T) {
 	const userID = 1
 	type testCase struct {
 		Name               string
-		Favourites         []string
+		Favourites         []Favourite
 		BusStopCode        string
-		ExpectedFavourites []string
+		ExpectedFavourites []Favourite
 		ExpectedResponses  []Response
 	}
 	testCases := []testCase{
@@ -505,7 +506,7 @@ func TestToggleFavouritesHandler(t *testing.T) {
 			Name:               "when toggling a new favourite",
 			Favourites:         nil,
 			BusStopCode:        "96049",
-			ExpectedFavourites: []string{"96049"},
+			ExpectedFavourites: favourite.FromQueries("96049"),
 			ExpectedResponses: []Response{
 				{
 					Request: telegram.SendMessageRequest{
@@ -529,9 +530,9 @@ func TestToggleFavouritesHandler(t *testing.T) {
 		},
 		{
 			Name:               "when toggling an existing favourite",
-			Favourites:         []string{"96049", "81111"},
+			Favourites:         favourite.FromQueries("96049", "81111"),
 			BusStopCode:        "96049",
-			ExpectedFavourites: []string{"81111"},
+			ExpectedFavourites: favourite.FromQueries("81111"),
 			ExpectedResponses: []Response{
 				{
 					Request: telegram.SendMessageRequest{
@@ -555,9 +556,9 @@ func TestToggleFavouritesHandler(t *testing.T) {
 		},
 		{
 			Name:               "when toggling the only favourite",
-			Favourites:         []string{"96049"},
+			Favourites:         favourite.FromQueries("96049"),
 			BusStopCode:        "96049",
-			ExpectedFavourites: []string{},
+			ExpectedFavourites: []Favourite{},
 			ExpectedResponses: []Response{
 				{
 					Request: telegram.SendMessageRequest{
@@ -572,6 +573,35 @@ func TestToggleFavouritesHandler(t *testing.T) {
 				},
 			},
 		},
+		{
+			Name:               "keeps the labels of other favourites",
+			Favourites:         []Favourite{{Query: "81111", Label: "Home"}},
+			BusStopCode:        "96049",
+			ExpectedFavourites: []Favourite{{Query: "81111", Label: "Home"}, {Query: "96049"}},
+			ExpectedResponses: []Response{
+				{
+					Request: telegram.SendMessageRequest{
+						ChatID:    1,
+						Text:      "ETA query `96049` added to favourites!",
+						ParseMode: "markdown",
+						ReplyMarkup: telegram.ReplyKeyboardMarkup{
+							Keyboard: [][]telegram.KeyboardButton{
+								{
+									{Text: "Home"},
+								},
+								{
+									{Text: "96049"},
+								},
+							},
+							ResizeKeyboard: true,
+						},
+					},
+				},
+				{
+					Request: telegram.AnswerCallbackQueryRequest{CallbackQueryID: "1"},
+				},
+			},
+		},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.Name, func(t *testing.T) {
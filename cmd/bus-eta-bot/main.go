@@ -0,0 +1,173 @@
+// Command bus-eta-bot runs Bus Eta Bot outside App Engine by retrieving updates from the Telegram Bot API with long
+// polling instead of receiving them through a webhook.
+//
+// It must be run from the repository root so that the message templates and bus stop data can be found.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/yi-jiayu/datamall/v3"
+	"github.com/yi-jiayu/telegram-bot-api"
+
+	"github.com/yi-jiayu/bus-eta-bot/v4"
+	"github.com/yi-jiayu/bus-eta-bot/v4/telegram"
+)
+
+func main() {
+	workers := flag.Int("workers", busetabot.DefaultPollWorkers, "number of updates to handle concurrently")
+	timeout := flag.Int("timeout", busetabot.DefaultPollTimeout, "long polling timeout in seconds")
+	busStopsPath := flag.String("bus-stops", "data/bus_stops.json", "path to bus stops JSON file")
+	synonymsPath := flag.String("synonyms", "data/synonyms.json", "path to bus stop search synonyms JSON file, or empty to disable synonyms")
+	reloadEvery := flag.Duration("reload-bus-stops-every", time.Minute, "how often to check the bus stops JSON file for changes, or 0 to disable reloading")
+	busRoutesPath := flag.String("bus-routes", "data/bus_routes.json", "path to bus routes JSON file, which is optional")
+	dbPath := flag.String("db", "bus-eta-bot.sqlite", "path to SQLite database for storing users, alerts and schedules")
+	etaCacheTTL := flag.Duration("eta-cache-ttl", busetabot.DefaultETACacheTTL, "how long to cache bus arrivals for, or 0 to disable caching")
+	adminAddr := flag.String("admin-addr", "", "address to serve the admin status endpoint on, such as localhost:8081")
+	logFormat := flag.String("log-format", "text", "log format, either text or json")
+	flag.Parse()
+
+	var logger busetabot.Logger
+	switch *logFormat {
+	case "text":
+		logger = busetabot.NewStdLogger(log.New(os.Stderr, "", log.LstdFlags))
+	case "json":
+		logger = busetabot.NewJSONLogger(os.Stderr)
+	default:
+		log.Fatalf("unknown log format: %s", *logFormat)
+	}
+
+	token := os.Getenv("TELEGRAM_BOT_TOKEN")
+	if token == "" {
+		log.Fatal("TELEGRAM_BOT_TOKEN not set")
+	}
+
+	busStops, err := busetabot.NewInMemoryBusStopRepositoryFromFile(*busStopsPath, *synonymsPath)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+
+	var routes busetabot.RouteRepository
+	if _, err := os.Stat(*busRoutesPath); err == nil {
+		routes, err = busetabot.NewInMemoryRouteRepositoryFromFile(*busRoutesPath)
+		if err != nil {
+			log.Fatalf("%+v", err)
+		}
+	} else {
+		log.Printf("bus routes not loaded: %v", err)
+	}
+
+	users, err := busetabot.NewSQLiteUserRepository(*dbPath)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	defer users.Close()
+
+	// the long polling request must be allowed to outlive the polling timeout
+	pollingClient := &http.Client{
+		Timeout: time.Duration(*timeout+10) * time.Second,
+	}
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+	}
+
+	tg := &tgbotapi.BotAPI{
+		APIEndpoint: tgbotapi.APIEndpoint,
+		Token:       token,
+		Client:      client,
+	}
+	breaker := busetabot.NewCircuitBreaker(busetabot.DefaultBreakerThreshold, busetabot.DefaultBreakerCooldown)
+	var dm busetabot.ETAService = breaker.Wrap(datamall.NewClient(os.Getenv("DATAMALL_ACCOUNT_KEY"), client))
+	dm = busetabot.NewFallbackETAService(dm, busetabot.NewInMemoryArrivalStore())
+	admin := busetabot.AdminHandler{
+		Breaker:  breaker,
+		BusStops: busStops,
+	}
+	if *etaCacheTTL > 0 {
+		cache := busetabot.NewCachingETAService(dm, *etaCacheTTL)
+		go func() {
+			for range time.Tick(time.Minute) {
+				cache.Purge()
+			}
+		}()
+		dm = cache
+		admin.Cache = cache
+	}
+	if *adminAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/admin/status", admin)
+		mux.Handle("/admin/reload-bus-stops", busetabot.ReloadBusStopsHandler{BusStops: busStops})
+		go func() {
+			log.Printf("serving admin endpoints on %s", *adminAddr)
+			err := http.ListenAndServe(*adminAddr, mux)
+			if err != nil {
+				log.Printf("error serving admin endpoints: %+v", err)
+			}
+		}()
+	}
+	mp := busetabot.NewMeasurementProtocolClientWithClient(os.Getenv("GA_TID"), client)
+	sv := busetabot.NewStreetViewAPI(os.Getenv("GOOGLE_API_KEY"))
+
+	bot := busetabot.NewBot(busetabot.DefaultHandlers(), tg, dm, &sv, &mp)
+	bot.BusStops = busStops
+	bot.Routes = routes
+	bot.Users = users
+	bot.Alerts = users
+	bot.Schedules = users
+	bot.Preferences = users
+	bot.RecentBusStops = users
+	bot.LiveETAs = busetabot.NewLiveETAManager()
+	bot.LiveLocations = busetabot.NewLiveLocationTracker()
+	bot.Logger = logger
+	bot.RequestIDs = busetabot.UpdateIDRequestIDProvider{}
+	telegramService, err := telegram.NewClient(token, client)
+	if err != nil {
+		log.Fatalf("error creating telegram service: %+v", err)
+	}
+	bot.TelegramService = telegramService
+
+	source := &tgbotapi.BotAPI{
+		APIEndpoint: tgbotapi.APIEndpoint,
+		Token:       token,
+		Client:      pollingClient,
+	}
+	poller := busetabot.NewPoller(source, &bot)
+	poller.Workers = *workers
+	poller.Timeout = *timeout
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		s := <-signals
+		log.Printf("received %s, shutting down", s)
+		cancel()
+	}()
+
+	alerts := &busetabot.AlertScheduler{
+		Bot: &bot,
+	}
+	go alerts.Run(ctx)
+	schedules := &busetabot.ScheduleRunner{
+		Bot: &bot,
+	}
+	go schedules.Run(ctx)
+	if *reloadEvery > 0 {
+		go busStops.Watch(ctx, *reloadEvery, logger)
+	}
+
+	log.Printf("polling for updates with %d workers", poller.Workers)
+	err = poller.Run(ctx)
+	if err != nil {
+		log.Printf("%+v", err)
+	}
+	// remove the Stop button from live eta messages which will no longer be updated
+	bot.LiveETAs.StopAll()
+}
@@ -0,0 +1,68 @@
+// Command refresh-data downloads the bus stops and bus routes datasets from LTA DataMall and writes them to the bus
+// stops and bus routes JSON files used by Bus Eta Bot.
+//
+// A summary of bus stops which were added, removed or changed is printed before anything is written. If the new data
+// removes more than a fraction of the existing bus stops, nothing is written unless -force is passed. Pass -endpoint
+// to use a recorded or fake DataMall server instead.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/yi-jiayu/datamall/v3"
+
+	"github.com/yi-jiayu/bus-eta-bot/v4"
+)
+
+func main() {
+	endpoint := flag.String("endpoint", datamall.Endpoint, "DataMall endpoint")
+	busStopsPath := flag.String("bus-stops", "data/bus_stops.json", "path to bus stops JSON file")
+	busRoutesPath := flag.String("bus-routes", "data/bus_routes.json", "path to bus routes JSON file")
+	maxRemoved := flag.Float64("max-removed", 0.1, "largest fraction of existing bus stops which can be removed without -force")
+	force := flag.Bool("force", false, "write the new data even if too many bus stops were removed")
+	dryRun := flag.Bool("dry-run", false, "only print the changes without writing anything")
+	flag.Parse()
+
+	accountKey := os.Getenv("DATAMALL_ACCOUNT_KEY")
+	if accountKey == "" && *endpoint == datamall.Endpoint {
+		log.Fatal("DATAMALL_ACCOUNT_KEY not set")
+	}
+	client := datamall.APIClient{
+		Endpoint:   *endpoint,
+		AccountKey: accountKey,
+		Client:     &http.Client{Timeout: 30 * time.Second},
+	}
+
+	busStops, routes, err := busetabot.RefreshStaticData(client)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	log.Printf("fetched %d bus stops and %d routes", len(busStops), len(routes))
+
+	old, err := busetabot.ReadBusStopsFile(*busStopsPath)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	diff := busetabot.DiffBusStops(old, busStops)
+	log.Printf("bus stops: %s", diff)
+	if len(old) > 0 && float64(len(diff.Removed))/float64(len(old)) > *maxRemoved && !*force {
+		log.Fatalf("refusing to remove %d of %d bus stops without -force", len(diff.Removed), len(old))
+	}
+	if *dryRun {
+		return
+	}
+
+	err = busetabot.WriteJSONFile(*busStopsPath, busStops)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	err = busetabot.WriteJSONFile(*busRoutesPath, routes)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	log.Printf("wrote %s and %s", *busStopsPath, *busRoutesPath)
+}
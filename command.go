@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"regexp"
+	"strconv"
+	"strings"
 
 	"github.com/yi-jiayu/telegram-bot-api"
 
@@ -35,6 +37,14 @@ var commandHandlers = map[string]CommandHandler{
 	"showfavorites":  ShowFavouritesCmdHandler,
 	"hidefavourites": HideFavouritesCmdHandler,
 	"hidefavorites":  HideFavouritesCmdHandler,
+	"alert":          AlertCmdHandler,
+	"schedule":       ScheduleCmdHandler,
+	"schedules":      SchedulesCmdHandler,
+	"unschedule":     UnscheduleCmdHandler,
+	"route":          RouteCmdHandler,
+	"plan":           PlanCmdHandler,
+	"settings":       SettingsCmdHandler,
+	"dashboard":      DashboardCmdHandler,
 }
 
 // CommandHandler is a handler for incoming commands.
@@ -179,27 +189,27 @@ func EtaHandler(ctx context.Context, bot *BusEtaBot, message *tgbotapi.Message,
 			responses <- ok(resp)
 			return
 		}
-		eta := NewETA(ctx, bot.BusStops, bot.Datamall, ETARequest{
+		eta, err := bot.etaMessages().Message(ctx, ETARequest{
 			UserID:   message.From.ID,
 			Time:     bot.NowFunc(),
 			Code:     busStopCode,
 			Services: serviceNos,
-		})
-		text, err := summaryFormatter.Format(eta)
+		}, bot.etaMessageOptions())
 		if err != nil {
 			responses <- notOk(err)
 			return
 		}
 		resp := telegram.SendMessageRequest{
 			ChatID:      chatID,
-			Text:        text,
-			ParseMode:   "markdown",
-			ReplyMarkup: NewETAMessageReplyMarkup(busStopCode, serviceNos, "", false),
+			Text:        eta.Text,
+			ParseMode:   eta.ParseMode,
+			ReplyMarkup: eta.ReplyMarkup,
 		}
 		if !message.Chat.IsPrivate() {
 			resp.ReplyToMessageID = message.MessageID
 		}
 		responses <- ok(resp)
+		bot.rememberBusStop(ctx, message.From.ID, busStopCode)
 		go bot.LogEvent(ctx, message.From, CategoryCommand, ActionEtaCommandWithArgs, message.Chat.Type)
 		return
 	}
@@ -215,10 +225,15 @@ func EtaHandler(ctx context.Context, bot *BusEtaBot, message *tgbotapi.Message,
 	responses <- ok(resp)
 }
 
-// ShowFavouritesCmdHandler will display a reply keyboard for quick access to the user's favourites.
+// ShowFavouritesCmdHandler will display a reply keyboard for quick access to the user's favourites. With the argument
+// "manage", it shows buttons for renaming, reordering and deleting them instead.
 func ShowFavouritesCmdHandler(ctx context.Context, bot *BusEtaBot, message *tgbotapi.Message, responses chan<- Response) {
 	defer close(responses)
 
+	if strings.TrimSpace(message.CommandArguments()) == "manage" {
+		sendManageFavouritesMessage(ctx, bot, message, responses)
+		return
+	}
 	favourites, err := bot.Users.GetUserFavourites(ctx, message.From.ID)
 	if err != nil {
 		responses <- notOk(err)
@@ -226,10 +241,11 @@ func ShowFavouritesCmdHandler(ctx context.Context, bot *BusEtaBot, message *tgbo
 	}
 	resp := telegram.SendMessageRequest{
 		ChatID: message.Chat.ID,
-		Text:   "You haven't set any favourites yet!",
+		Text:   noFavouritesText,
 	}
 	if len(favourites) > 0 {
-		resp.Text = "Favourites keyboard activated!"
+		resp.Text = "Favourites keyboard activated! Send `/favourites manage` to rename, reorder or delete them."
+		resp.ParseMode = "markdown"
 		resp.ReplyMarkup = newShowFavouritesMarkup(favourites)
 	}
 	responses <- ok(resp)
@@ -247,6 +263,297 @@ func HideFavouritesCmdHandler(ctx context.Context, bot *BusEtaBot, message *tgbo
 	close(responses)
 }
 
+// AlertCmdHandler handles the /alert command. With arguments, it sets up an alert for when a bus is a number of minutes
+// away from a bus stop. Without arguments, it lists the user's alerts so that they can be cancelled.
+func AlertCmdHandler(ctx context.Context, bot *BusEtaBot, message *tgbotapi.Message, responses chan<- Response) {
+	defer close(responses)
+	go bot.LogEvent(ctx, message.From, CategoryCommand, ActionAlertCommand, message.Chat.Type)
+
+	chatID := message.Chat.ID
+	if bot.Alerts == nil {
+		responses <- ok(telegram.SendMessageRequest{
+			ChatID: chatID,
+			Text:   "Oops, alerts are not available at the moment.",
+		})
+		return
+	}
+	usage := fmt.Sprintf("To get an alert when your bus is a few minutes away, send `/alert <bus stop code> <service> [minutes]`, for example `/alert 96049 96 %d`. Alerts expire after %d hours.", DefaultAlertMinutes, int(AlertDuration.Hours()))
+	args := message.CommandArguments()
+	if args == "" {
+		alerts, err := bot.Alerts.GetUserAlerts(ctx, message.From.ID)
+		if err != nil {
+			responses <- notOk(err)
+			return
+		}
+		resp := telegram.SendMessageRequest{
+			ChatID:    chatID,
+			Text:      "You don't have any alerts set up.\n\n" + usage,
+			ParseMode: "markdown",
+		}
+		if len(alerts) > 0 {
+			resp.Text = "Here are your alerts. Tap an alert to cancel it."
+			resp.ParseMode = ""
+			resp.ReplyMarkup = newAlertsMarkup(alerts)
+		}
+		responses <- ok(resp)
+		return
+	}
+	code, serviceNo, minutes, err := ParseAlertArgs(args)
+	if err != nil {
+		responses <- ok(telegram.SendMessageRequest{
+			ChatID:    chatID,
+			Text:      "Oops, that was not a valid alert. " + usage,
+			ParseMode: "markdown",
+		})
+		return
+	}
+	resp, err := addAlert(ctx, bot, Alert{
+		UserID:      message.From.ID,
+		ChatID:      chatID,
+		BusStopCode: code,
+		ServiceNo:   serviceNo,
+		Minutes:     minutes,
+	})
+	if err != nil {
+		responses <- notOk(err)
+		return
+	}
+	responses <- ok(resp)
+}
+
+// scheduleUsage explains how to use the /schedule command.
+const scheduleUsage = "To get etas at the same time every day, send `/schedule <bus stop code> [services] <HH:MM> [days]`. " +
+	"For example, `/schedule 96049 2 24 08:10 weekdays` sends etas for buses 2 and 24 at 96049 at 8.10am Singapore time on weekdays. " +
+	"Days can be `weekdays`, `weekends`, `daily` or a list like `mon,wed,fri`."
+
+// ScheduleCmdHandler handles the /schedule command, which sets up etas to be sent at the same time on certain days.
+func ScheduleCmdHandler(ctx context.Context, bot *BusEtaBot, message *tgbotapi.Message, responses chan<- Response) {
+	defer close(responses)
+	go bot.LogEvent(ctx, message.From, CategoryCommand, ActionScheduleCommand, message.Chat.Type)
+
+	chatID := message.Chat.ID
+	if bot.Schedules == nil {
+		responses <- ok(telegram.SendMessageRequest{
+			ChatID: chatID,
+			Text:   "Oops, scheduled etas are not available at the moment.",
+		})
+		return
+	}
+	args := message.CommandArguments()
+	if args == "" {
+		responses <- ok(telegram.SendMessageRequest{
+			ChatID:    chatID,
+			Text:      scheduleUsage + "\n\nSend /schedules to see your scheduled etas.",
+			ParseMode: "markdown",
+		})
+		return
+	}
+	code, services, hour, minute, days, err := ParseScheduleArgs(args)
+	if err != nil {
+		responses <- ok(telegram.SendMessageRequest{
+			ChatID:    chatID,
+			Text:      "Oops, I didn't understand that. " + scheduleUsage,
+			ParseMode: "markdown",
+		})
+		return
+	}
+	existing, err := bot.Schedules.GetUserSchedules(ctx, message.From.ID)
+	if err != nil {
+		responses <- notOk(err)
+		return
+	}
+	if len(existing) >= MaxSchedulesPerUser {
+		responses <- ok(telegram.SendMessageRequest{
+			ChatID: chatID,
+			Text:   fmt.Sprintf("Oops, you can only have up to %d scheduled etas. Send /schedules to see and delete them.", MaxSchedulesPerUser),
+		})
+		return
+	}
+	schedule := Schedule{
+		UserID:      message.From.ID,
+		ChatID:      chatID,
+		BusStopCode: code,
+		ServiceNos:  services,
+		Hour:        hour,
+		Minute:      minute,
+		Days:        days,
+	}
+	_, err = bot.Schedules.AddSchedule(ctx, schedule)
+	if err != nil {
+		responses <- notOk(err)
+		return
+	}
+	responses <- ok(telegram.SendMessageRequest{
+		ChatID: chatID,
+		Text:   fmt.Sprintf("Alright, I'll send you etas for %s.", schedule),
+	})
+}
+
+// SchedulesCmdHandler handles the /schedules command, which lists a user's scheduled etas.
+func SchedulesCmdHandler(ctx context.Context, bot *BusEtaBot, message *tgbotapi.Message, responses chan<- Response) {
+	defer close(responses)
+	go bot.LogEvent(ctx, message.From, CategoryCommand, ActionSchedulesCommand, message.Chat.Type)
+
+	chatID := message.Chat.ID
+	if bot.Schedules == nil {
+		responses <- ok(telegram.SendMessageRequest{
+			ChatID: chatID,
+			Text:   "Oops, scheduled etas are not available at the moment.",
+		})
+		return
+	}
+	schedules, err := bot.Schedules.GetUserSchedules(ctx, message.From.ID)
+	if err != nil {
+		responses <- notOk(err)
+		return
+	}
+	responses <- ok(newSchedulesMessage(chatID, schedules))
+}
+
+// UnscheduleCmdHandler handles the /unschedule command, which deletes a scheduled eta by its position in the list
+// shown by /schedules.
+func UnscheduleCmdHandler(ctx context.Context, bot *BusEtaBot, message *tgbotapi.Message, responses chan<- Response) {
+	defer close(responses)
+	go bot.LogEvent(ctx, message.From, CategoryCommand, ActionUnscheduleCommand, message.Chat.Type)
+
+	chatID := message.Chat.ID
+	if bot.Schedules == nil {
+		responses <- ok(telegram.SendMessageRequest{
+			ChatID: chatID,
+			Text:   "Oops, scheduled etas are not available at the moment.",
+		})
+		return
+	}
+	schedules, err := bot.Schedules.GetUserSchedules(ctx, message.From.ID)
+	if err != nil {
+		responses <- notOk(err)
+		return
+	}
+	n, err := strconv.Atoi(message.CommandArguments())
+	if err != nil || n < 1 || n > len(schedules) {
+		resp := newSchedulesMessage(chatID, schedules)
+		if len(schedules) > 0 {
+			resp.Text = "Send `/unschedule <number>` to delete a scheduled eta, or tap one below.\n\n" + resp.Text
+			resp.ParseMode = "markdown"
+		}
+		responses <- ok(resp)
+		return
+	}
+	schedule := schedules[n-1]
+	err = bot.Schedules.DeleteSchedule(ctx, schedule.ID)
+	if err != nil {
+		responses <- notOk(err)
+		return
+	}
+	responses <- ok(telegram.SendMessageRequest{
+		ChatID: chatID,
+		Text:   fmt.Sprintf("Deleted scheduled etas for %s.", schedule),
+	})
+}
+
+// RouteCmdHandler handles the /route command, which lists the stops along a bus service's route.
+func RouteCmdHandler(ctx context.Context, bot *BusEtaBot, message *tgbotapi.Message, responses chan<- Response) {
+	defer close(responses)
+	go bot.LogEvent(ctx, message.From, CategoryCommand, ActionRouteCommand, message.Chat.Type)
+
+	chatID := message.Chat.ID
+	if bot.Routes == nil {
+		responses <- ok(telegram.SendMessageRequest{
+			ChatID: chatID,
+			Text:   "Oops, bus routes are not available at the moment.",
+		})
+		return
+	}
+	serviceNo := strings.TrimSpace(message.CommandArguments())
+	if serviceNo == "" {
+		responses <- ok(telegram.SendMessageRequest{
+			ChatID:    chatID,
+			Text:      "Send `/route <service>`, for example `/route 96`, to see the stops along a bus route.",
+			ParseMode: "markdown",
+		})
+		return
+	}
+	routes := bot.Routes.Routes(serviceNo)
+	if len(routes) == 0 {
+		responses <- ok(telegram.SendMessageRequest{
+			ChatID: chatID,
+			Text:   fmt.Sprintf("Oops, I couldn't find bus service %s.", serviceNo),
+		})
+		return
+	}
+	text, markup := newRouteMessage(bot.BusStops, routes, routes[0].Direction, 0)
+	responses <- ok(telegram.SendMessageRequest{
+		ChatID:      chatID,
+		Text:        text,
+		ReplyMarkup: markup,
+	})
+}
+
+// PlanCmdHandler handles the /plan command, which suggests buses to take between two bus stops.
+func PlanCmdHandler(ctx context.Context, bot *BusEtaBot, message *tgbotapi.Message, responses chan<- Response) {
+	defer close(responses)
+	go bot.LogEvent(ctx, message.From, CategoryCommand, ActionPlanCommand, message.Chat.Type)
+
+	chatID := message.Chat.ID
+	if bot.Routes == nil {
+		responses <- ok(telegram.SendMessageRequest{
+			ChatID: chatID,
+			Text:   "Oops, journey planning is not available at the moment.",
+		})
+		return
+	}
+	from, to, err := ParsePlanArgs(message.CommandArguments())
+	if err != nil {
+		responses <- ok(telegram.SendMessageRequest{
+			ChatID:    chatID,
+			Text:      "Send `/plan <from> <to>` with two bus stop codes, for example `/plan 96049 17179`, to find buses between them.",
+			ParseMode: "markdown",
+		})
+		return
+	}
+	if from == to {
+		responses <- ok(telegram.SendMessageRequest{
+			ChatID: chatID,
+			Text:   "Oops, you're already there!",
+		})
+		return
+	}
+	for _, code := range []string{from, to} {
+		if bot.BusStops.Get(code) == nil {
+			responses <- ok(telegram.SendMessageRequest{
+				ChatID: chatID,
+				Text:   fmt.Sprintf("Oops, I couldn't find bus stop %s.", code),
+			})
+			return
+		}
+	}
+
+	direct, transfers := PlanJourneys(ctx, bot.BusStops, bot.Routes, from, to)
+	if len(direct) > 0 || len(transfers) > 0 {
+		arrival, err := bot.Datamall.GetBusArrival(from, "")
+		if err != nil {
+			// rank journeys by stop count alone rather than by possibly stale etas
+			bot.logger().Warningf(ctx, "error getting etas for journey plan: %+v", err)
+			arrival.Services = nil
+		}
+		now := bot.NowFunc()
+		rankJourneys(direct, arrival, now)
+		rankJourneys(transfers, arrival, now)
+	}
+	if len(direct) > MaxDirectJourneys {
+		direct = direct[:MaxDirectJourneys]
+	}
+	if len(transfers) > MaxTransferJourneys {
+		transfers = transfers[:MaxTransferJourneys]
+	}
+	text, markup := newPlanMessage(bot.BusStops, from, to, direct, transfers)
+	responses <- ok(telegram.SendMessageRequest{
+		ChatID:      chatID,
+		Text:        text,
+		ReplyMarkup: markup,
+	})
+}
+
 // StreetviewCmdHandler handlers the /streetview command.
 // func StreetviewCmdHandler(ctx context.Context, bot *BusEtaBot, message *tgbotapi.Message) error {
 // 	chatID := message.Chat.ID
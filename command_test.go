@@ -13,6 +13,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/yi-jiayu/telegram-bot-api"
 
+	"github.com/yi-jiayu/bus-eta-bot/v4/favourite"
 	"github.com/yi-jiayu/bus-eta-bot/v4/mocks"
 	"github.com/yi-jiayu/bus-eta-bot/v4/telegram"
 )
@@ -323,17 +324,19 @@ func TestShowFavouritesCmdHandler(t *testing.T) {
 	const userID = 1
 	type testCase struct {
 		Name       string
-		Favourites []string
+		Text       string
+		Favourites []Favourite
 		Expected   []Response
 	}
 	testCases := []testCase{
 		{
 			Name:       "when user has favourites",
-			Favourites: []string{"96049", "81111"},
+			Favourites: favourite.FromQueries("96049", "81111"),
 			Expected: []Response{
 				ok(telegram.SendMessageRequest{
-					ChatID: 1,
-					Text:   "Favourites keyboard activated!",
+					ChatID:    1,
+					Text:      "Favourites keyboard activated! Send `/favourites manage` to rename, reorder or delete them.",
+					ParseMode: "markdown",
 					ReplyMarkup: telegram.ReplyKeyboardMarkup{
 						Keyboard: [][]telegram.KeyboardButton{
 							{{Text: "96049"}},
@@ -344,6 +347,47 @@ func TestShowFavouritesCmdHandler(t *testing.T) {
 				}),
 			},
 		},
+		{
+			Name:       "when user has labelled favourites",
+			Favourites: []Favourite{{Query: "96049", Label: "Home"}, {Query: "81111"}},
+			Expected: []Response{
+				ok(telegram.SendMessageRequest{
+					ChatID:    1,
+					Text:      "Favourites keyboard activated! Send `/favourites manage` to rename, reorder or delete them.",
+					ParseMode: "markdown",
+					ReplyMarkup: telegram.ReplyKeyboardMarkup{
+						Keyboard: [][]telegram.KeyboardButton{
+							{{Text: "Home"}},
+							{{Text: "81111"}},
+						},
+						ResizeKeyboard: true,
+					},
+				}),
+			},
+		},
+		{
+			Name:       "when managing favourites",
+			Text:       "/favourites manage",
+			Favourites: []Favourite{{Query: "96049", Label: "Home"}, {Query: "81111"}},
+			Expected: []Response{
+				ok(telegram.SendMessageRequest{
+					ChatID:      1,
+					Text:        "Tap a favourite to get etas for it, or use the buttons below it to move, rename or delete it.",
+					ReplyMarkup: newManageFavouritesMarkup([]Favourite{{Query: "96049", Label: "Home"}, {Query: "81111"}}),
+				}),
+			},
+		},
+		{
+			Name:       "when managing favourites without any favourites",
+			Text:       "/favourites manage",
+			Favourites: nil,
+			Expected: []Response{
+				ok(telegram.SendMessageRequest{
+					ChatID: 1,
+					Text:   "You haven't set any favourites yet!",
+				}),
+			},
+		},
 		{
 			Name:       "when user has no favourites",
 			Favourites: nil,
@@ -356,7 +400,7 @@ func TestShowFavouritesCmdHandler(t *testing.T) {
 		},
 		{
 			Name:       "when user has empty favourites",
-			Favourites: []string{},
+			Favourites: []Favourite{},
 			Expected: []Response{
 				ok(telegram.SendMessageRequest{
 					ChatID: 1,
@@ -374,9 +418,9 @@ func TestShowFavouritesCmdHandler(t *testing.T) {
 			bot := &BusEtaBot{
 				Users: m,
 			}
-			message := MockMessage()
+			message := MockMessageWithText(tc.Text)
 			responses := make(chan Response, ResponseBufferSize)
-			go ShowFavouritesCmdHandler(context.TODO(), bot, &message, responses)
+			go ShowFavouritesCmdHandler(context.TODO(), bot, message, responses)
 			actual, err := collectResponsesWithTimeout(responses, 5*time.Second)
 			if err != nil {
 				t.Fatal(err)
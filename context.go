@@ -5,24 +5,21 @@ import (
 	"net/http"
 
 	"github.com/getsentry/raven-go"
-	"google.golang.org/appengine"
-	"google.golang.org/appengine/log"
 )
 
 type requestKey struct{}
 type sentryKey struct{}
 
-func NewContext(r *http.Request) (ctx context.Context) {
-	// create an appengine context
-	ctx = appengine.NewContext(r)
-
-	// add the request onto the context too
-	ctx = context.WithValue(ctx, requestKey{}, r)
+// NewContext returns a context derived from parent for handling the webhook request r. Problems setting up the context
+// are logged with logger.
+func NewContext(parent context.Context, r *http.Request, logger Logger) (ctx context.Context) {
+	// add the request onto the context
+	ctx = context.WithValue(parent, requestKey{}, r)
 
 	// include a raven client on the context
 	sentry, err := raven.New("")
 	if err != nil {
-		log.Warningf(ctx, "error creating raven client: %+v", err)
+		logger.Warningf(ctx, "error creating raven client: %+v", err)
 		return
 	}
 	ctx = context.WithValue(ctx, sentryKey{}, sentry)
@@ -38,9 +35,7 @@ func setUserContext(ctx context.Context, ID string) {
 }
 
 func logError(ctx context.Context, err error) {
-	if appengine.IsAppEngine() || appengine.IsDevAppServer() {
-		log.Errorf(ctx, "%+v", err)
-	}
+	logErrorf(ctx, "%+v", err)
 	if sentry, ok := ctx.Value(sentryKey{}).(*raven.Client); ok {
 		sentry.CaptureError(err, nil)
 	}
@@ -0,0 +1,217 @@
+package busetabot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"github.com/yi-jiayu/telegram-bot-api"
+
+	"github.com/yi-jiayu/bus-eta-bot/v4/telegram"
+)
+
+// dashboardNewMessage is the callback argument of a button which sends a new dashboard message instead of updating the
+// message it is on.
+const dashboardNewMessage = "new"
+
+var dashboardTemplate = template.Must(template.New("dashboard.tmpl").
+	Funcs(funcMap).
+	ParseFiles("templates/dashboard.tmpl",
+		"templates/partials/dashboard_stop.tmpl"))
+
+// DashboardStop contains the etas for one of a user's favourites.
+type DashboardStop struct {
+	ETA
+
+	// Label is the label of the favourite, or empty if it has none.
+	Label string
+
+	// ClockTimes is whether to show the time each bus arrives instead of the number of minutes until it arrives.
+	ClockTimes bool
+}
+
+// Dashboard contains the etas for all of a user's favourites, for showing them in one message.
+type Dashboard struct {
+	Now   time.Time
+	Stops []DashboardStop
+
+	// ClockTimes is whether to show the time each bus arrives instead of the number of minutes until it arrives.
+	ClockTimes bool
+
+	// Omitted is the number of favourites left out after Stops because the message would have been too long.
+	Omitted int
+}
+
+// NewDashboard gets the etas for each of favourites concurrently, to be shown the way userID prefers. If the etas for a
+// favourite cannot be retrieved, the error is shown in its place without affecting the other favourites.
+func NewDashboard(ctx context.Context, factory ETAMessageFactory, userID int, favourites []Favourite, now time.Time) Dashboard {
+	stops := make([]DashboardStop, len(favourites))
+	var requests []ETARequest
+	var indexes []int
+	for i, favourite := range favourites {
+		stops[i].Label = favourite.Label
+		code, services, err := InferEtaQuery(favourite.Query)
+		if err != nil {
+			stops[i].BusStop.BusStopCode = favourite.Query
+			stops[i].Now = now
+			stops[i].Error = "Oops, that was not a valid eta query."
+			continue
+		}
+		requests = append(requests, ETARequest{
+			UserID:   userID,
+			Time:     now,
+			Code:     code,
+			Services: services,
+		})
+		indexes = append(indexes, i)
+	}
+	etas, preferences := factory.ETAs(ctx, userID, requests)
+	for i, eta := range etas {
+		stops[indexes[i]].ETA = eta
+	}
+	for i := range stops {
+		stops[i].ClockTimes = preferences.clockTimes()
+	}
+	return Dashboard{
+		Now:        now,
+		Stops:      stops,
+		ClockTimes: preferences.clockTimes(),
+	}
+}
+
+// Format returns the text of a dashboard message. If it would be longer than a message can be, favourites are left out
+// from the end until it fits.
+func (d Dashboard) Format() (string, error) {
+	for {
+		b := new(bytes.Buffer)
+		err := dashboardTemplate.Execute(b, d)
+		if err != nil {
+			return "", errors.Wrap(err, "error formatting dashboard")
+		}
+		if utf8.RuneCount(b.Bytes()) <= telegram.MaxMessageLength || len(d.Stops) == 0 {
+			return b.String(), nil
+		}
+		d.Stops = d.Stops[:len(d.Stops)-1]
+		d.Omitted++
+	}
+}
+
+// newDashboardButton returns a button which shows a dashboard. If newMessage is true, the dashboard is sent as a new
+// message, otherwise the message the button is on is updated.
+func newDashboardButton(text string, newMessage bool) telegram.InlineKeyboardButton {
+	data := CallbackData{
+		Type: "dash",
+	}
+	if newMessage {
+		data.Argstr = dashboardNewMessage
+	}
+	JSON, _ := json.Marshal(data)
+	return telegram.InlineKeyboardButton{
+		Text:         text,
+		CallbackData: string(JSON),
+	}
+}
+
+// newDashboardMarkup returns the reply markup for a dashboard message.
+func newDashboardMarkup() telegram.InlineKeyboardMarkup {
+	return telegram.InlineKeyboardMarkup{
+		InlineKeyboard: [][]telegram.InlineKeyboardButton{
+			{
+				newDashboardButton("Refresh", false),
+			},
+		},
+	}
+}
+
+// newDashboardMessage returns the text and markup of a dashboard message for a user. found is false if the user does
+// not have any favourites.
+func newDashboardMessage(ctx context.Context, bot *BusEtaBot, userID int) (text string, markup telegram.InlineKeyboardMarkup, found bool, err error) {
+	favourites, err := bot.Users.GetUserFavourites(ctx, userID)
+	if err != nil {
+		return "", telegram.InlineKeyboardMarkup{}, false, err
+	}
+	if len(favourites) == 0 {
+		return "", telegram.InlineKeyboardMarkup{}, false, nil
+	}
+	dashboard := NewDashboard(ctx, bot.etaMessages(), userID, favourites, bot.NowFunc())
+	text, err = dashboard.Format()
+	if err != nil {
+		return "", telegram.InlineKeyboardMarkup{}, false, err
+	}
+	return text, newDashboardMarkup(), true, nil
+}
+
+// sendDashboardMessage sends a dashboard message for a user to chatID.
+func sendDashboardMessage(ctx context.Context, bot *BusEtaBot, userID int, chatID int64, responses chan<- Response) {
+	text, markup, found, err := newDashboardMessage(ctx, bot, userID)
+	if err != nil {
+		responses <- notOk(err)
+		return
+	}
+	resp := telegram.SendMessageRequest{
+		ChatID: chatID,
+		Text:   noFavouritesText,
+	}
+	if found {
+		resp.Text = text
+		resp.ParseMode = "markdown"
+		resp.ReplyMarkup = markup
+	}
+	responses <- ok(resp)
+}
+
+// DashboardCmdHandler handles the /dashboard command, which sends the etas for all of a user's favourites in one
+// message.
+func DashboardCmdHandler(ctx context.Context, bot *BusEtaBot, message *tgbotapi.Message, responses chan<- Response) {
+	defer close(responses)
+	go bot.LogEvent(ctx, message.From, CategoryCommand, ActionDashboardCommand, message.Chat.Type)
+
+	sendDashboardMessage(ctx, bot, message.From.ID, message.Chat.ID, responses)
+}
+
+// DashboardCallbackHandler handles the Refresh button on a dashboard message, which updates the message in place, and
+// the Dashboard button on the favourites management message, which sends a new dashboard message.
+func DashboardCallbackHandler(ctx context.Context, bot *BusEtaBot, cbq *tgbotapi.CallbackQuery, responses chan<- Response) {
+	defer close(responses)
+
+	var data CallbackData
+	err := json.Unmarshal([]byte(cbq.Data), &data)
+	if err != nil {
+		responses <- notOk(errors.Wrap(err, "error unmarshalling callback data"))
+		return
+	}
+	go bot.LogEvent(ctx, cbq.From, CategoryCallback, ActionDashboardCallback, data.Argstr)
+
+	if data.Argstr == dashboardNewMessage && cbq.Message != nil {
+		sendDashboardMessage(ctx, bot, cbq.From.ID, cbq.Message.Chat.ID, responses)
+		responses <- ok(telegram.AnswerCallbackQueryRequest{
+			CallbackQueryID: cbq.ID,
+		})
+		return
+	}
+	text, markup, found, err := newDashboardMessage(ctx, bot, cbq.From.ID)
+	if err != nil {
+		responses <- notOk(err)
+		return
+	}
+	if !found {
+		text = noFavouritesText
+	}
+	message := callbackQueryMessage(cbq)
+	responses <- ok(telegram.EditMessageTextRequest{
+		ChatID:          message.ChatID,
+		MessageID:       message.MessageID,
+		InlineMessageID: message.InlineMessageID,
+		Text:            text,
+		ParseMode:       "markdown",
+		ReplyMarkup:     markup,
+	})
+	responses <- ok(telegram.AnswerCallbackQueryRequest{
+		CallbackQueryID: cbq.ID,
+		Text:            "ETAs updated!",
+	})
+}
@@ -0,0 +1,345 @@
+package busetabot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/golang/mock/gomock"
+	"github.com/kr/pretty"
+	"github.com/stretchr/testify/assert"
+	"github.com/yi-jiayu/datamall/v3"
+
+	"github.com/yi-jiayu/bus-eta-bot/v4/mocks"
+	"github.com/yi-jiayu/bus-eta-bot/v4/telegram"
+)
+
+// busStopsByCode is a BusStopGetter which only knows about the bus stops it contains.
+type busStopsByCode map[string]BusStop
+
+func (b busStopsByCode) Get(ID string) *BusStop {
+	stop, ok := b[ID]
+	if !ok {
+		return nil
+	}
+	return &stop
+}
+
+// partialETAService fails to get bus arrivals for the bus stops in Errors.
+type partialETAService struct {
+	Now    time.Time
+	Errors map[string]error
+}
+
+func (s partialETAService) GetBusArrival(code string, serviceNo string) (datamall.BusArrival, error) {
+	if err, ok := s.Errors[code]; ok {
+		return datamall.BusArrival{}, err
+	}
+	return newArrival(s.Now, code), nil
+}
+
+func TestDashboard_Format(t *testing.T) {
+	now := time.Date(2018, 1, 1, 8, 0, 0, 0, sgt)
+	dashboard := Dashboard{
+		Now: now,
+		Stops: []DashboardStop{
+			{
+				ETA: ETA{
+					BusStop: BusStop{BusStopCode: "96049", Description: "Opp Tropicana Condo"},
+					Now:     now,
+					Services: []datamall.Service{
+						{ServiceNo: "24", NextBus: datamall.ArrivingBus{EstimatedArrival: now.Add(5 * time.Minute)}},
+						{ServiceNo: "2"},
+					},
+				},
+				Label: "Home_sweet*home",
+			},
+			{
+				ETA: ETA{
+					BusStop: BusStop{BusStopCode: "96041", Description: "Bef Tropicana Condo"},
+					Now:     now,
+					Error:   "Oops, couldn't get etas.",
+				},
+			},
+			{
+				ETA: ETA{
+					BusStop:   BusStop{BusStopCode: "81111"},
+					Now:       now,
+					StaleAsOf: now.Add(-2 * time.Minute),
+					Services: []datamall.Service{
+						{ServiceNo: "2", NextBus: datamall.ArrivingBus{EstimatedArrival: now.Add(3 * time.Minute)}},
+					},
+				},
+				Label: "Work",
+			},
+			{
+				ETA: ETA{
+					BusStop: BusStop{BusStopCode: "96031", Description: "Opp Changi Village"},
+					Now:     now,
+				},
+			},
+		},
+	}
+	actual, err := dashboard.Format()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "*Favourites*\n\n" +
+		"*Home\\_sweet\\*home*\n" +
+		"Opp Tropicana Condo (96049)\n" +
+		"`2: ?  24: 5`\n\n" +
+		"*Bef Tropicana Condo (96041)*\n" +
+		"Oops, couldn't get etas.\n\n" +
+		"*Work*\n" +
+		"81111\n" +
+		"`2: 3`\n" +
+		"_Stale as of 07:58._\n\n" +
+		"*Opp Changi Village (96031)*\n" +
+		"No ETAs available.\n\n" +
+		"_Minutes until the next bus, as of Mon, 01 Jan 18 08:00 SGT_"
+	assert.Equal(t, expected, actual)
+}
+
+func TestDashboard_FormatClockTimes(t *testing.T) {
+	now := time.Date(2018, 1, 1, 8, 0, 0, 0, sgt)
+	dashboard := Dashboard{
+		Now: now,
+		Stops: []DashboardStop{
+			{
+				ETA: ETA{
+					BusStop: BusStop{BusStopCode: "96049", Description: "Opp Tropicana Condo"},
+					Now:     now,
+					Services: []datamall.Service{
+						{ServiceNo: "24", NextBus: datamall.ArrivingBus{EstimatedArrival: now.Add(5 * time.Minute)}},
+					},
+				},
+				ClockTimes: true,
+			},
+		},
+		ClockTimes: true,
+	}
+	actual, err := dashboard.Format()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "*Favourites*\n\n" +
+		"*Opp Tropicana Condo (96049)*\n" +
+		"`24: 08:05`\n\n" +
+		"_Arrival time of the next bus, as of Mon, 01 Jan 18 08:00 SGT_"
+	assert.Equal(t, expected, actual)
+}
+
+func TestDashboard_FormatTooLong(t *testing.T) {
+	now := time.Date(2018, 1, 1, 8, 0, 0, 0, sgt)
+	stop := DashboardStop{
+		ETA: ETA{
+			BusStop: BusStop{BusStopCode: "96049", Description: strings.Repeat("Opp Tropicana Condo ", 5)},
+			Now:     now,
+			Services: []datamall.Service{
+				{ServiceNo: "24", NextBus: datamall.ArrivingBus{EstimatedArrival: now.Add(5 * time.Minute)}},
+			},
+		},
+	}
+	dashboard := Dashboard{Now: now}
+	for i := 0; i < 100; i++ {
+		dashboard.Stops = append(dashboard.Stops, stop)
+	}
+	actual, err := dashboard.Format()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, utf8.RuneCountInString(actual) <= telegram.MaxMessageLength, "dashboard is %d characters long", utf8.RuneCountInString(actual))
+	shown := strings.Count(actual, "(96049)")
+	assert.True(t, shown > 0)
+	assert.Contains(t, actual, fmt.Sprintf("_…and %d more. Send /favourites to see them all._\n\n", 100-shown))
+	assert.True(t, strings.HasSuffix(actual, "_Minutes until the next bus, as of Mon, 01 Jan 18 08:00 SGT_"))
+}
+
+func TestNewDashboard(t *testing.T) {
+	now := time.Date(2018, 1, 1, 8, 0, 0, 0, sgt)
+	busStops := busStopsByCode{
+		"96049": {BusStopCode: "96049", Description: "Opp Tropicana Condo"},
+		"81111": {BusStopCode: "81111", Description: "Blk 1"},
+	}
+	etaService := partialETAService{
+		Now: now,
+		Errors: map[string]error{
+			"81111": &datamall.Error{StatusCode: 503},
+		},
+	}
+	favourites := []Favourite{
+		{Query: "96049 24", Label: "Home"},
+		{Query: "81111"},
+		{Query: "17179"},
+	}
+	dashboard := NewDashboard(context.Background(), NewETAMessageFactory(busStops, etaService, nil), 1, favourites, now)
+	assert.Equal(t, now, dashboard.Now)
+	if !assert.Len(t, dashboard.Stops, 3) {
+		return
+	}
+
+	home := dashboard.Stops[0]
+	assert.Equal(t, "Home", home.Label)
+	assert.Equal(t, "Opp Tropicana Condo", home.BusStop.Description)
+	assert.Empty(t, home.Error)
+	if assert.Len(t, home.Services, 1) {
+		assert.Equal(t, "24", home.Services[0].ServiceNo)
+	}
+
+	failed := dashboard.Stops[1]
+	assert.Equal(t, "Blk 1", failed.BusStop.Description)
+	assert.Empty(t, failed.Services)
+	assert.Equal(t, "LTA DataMall could be down at the moment (status code 503)", failed.Error)
+
+	unknown := dashboard.Stops[2]
+	assert.Equal(t, "17179", unknown.BusStop.BusStopCode)
+	assert.Empty(t, unknown.Error)
+	assert.Len(t, unknown.Services, 2)
+}
+
+func TestDashboardCmdHandler(t *testing.T) {
+	const userID = 1
+	now := time.Date(2018, 1, 1, 8, 0, 0, 0, sgt)
+	type testCase struct {
+		Name       string
+		Favourites []Favourite
+		Expected   []Response
+	}
+	testCases := []testCase{
+		{
+			Name:       "when user has favourites",
+			Favourites: []Favourite{{Query: "96049 24", Label: "Home"}},
+			Expected: []Response{
+				ok(telegram.SendMessageRequest{
+					ChatID: 1,
+					Text: "*Favourites*\n\n" +
+						"*Home*\n" +
+						"Opp Tropicana Condo (96049)\n" +
+						"`24: 1`\n\n" +
+						"_Minutes until the next bus, as of Mon, 01 Jan 18 08:00 SGT_",
+					ParseMode: "markdown",
+					ReplyMarkup: telegram.InlineKeyboardMarkup{
+						InlineKeyboard: [][]telegram.InlineKeyboardButton{
+							{
+								{Text: "Refresh", CallbackData: `{"t":"dash"}`},
+							},
+						},
+					},
+				}),
+			},
+		},
+		{
+			Name:       "when user has no favourites",
+			Favourites: nil,
+			Expected: []Response{
+				ok(telegram.SendMessageRequest{
+					ChatID: 1,
+					Text:   "You haven't set any favourites yet!",
+				}),
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			m := mocks.NewMockUserRepository(ctrl)
+			m.EXPECT().GetUserFavourites(gomock.Any(), userID).Return(tc.Favourites, nil)
+			bot := &BusEtaBot{
+				Users:    m,
+				BusStops: mockBusStopRepository{BusStop: &BusStop{BusStopCode: "96049", Description: "Opp Tropicana Condo"}},
+				Datamall: mockETAService{BusArrival: newArrival(now, "96049")},
+				NowFunc: func() time.Time {
+					return now
+				},
+			}
+			message := MockMessageWithText("/dashboard")
+			responses := make(chan Response, ResponseBufferSize)
+			go DashboardCmdHandler(context.TODO(), bot, message, responses)
+			actual, err := collectResponsesWithTimeout(responses, 5*time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !assert.Equal(t, tc.Expected, actual) {
+				pretty.Println(actual)
+			}
+		})
+	}
+}
+
+func TestDashboardCallbackHandler(t *testing.T) {
+	const userID = 1
+	now := time.Date(2018, 1, 1, 8, 0, 0, 0, sgt)
+	text := "*Favourites*\n\n" +
+		"*Opp Tropicana Condo (96049)*\n" +
+		"`2: -1  24: 1`\n\n" +
+		"_Minutes until the next bus, as of Mon, 01 Jan 18 08:00 SGT_"
+	markup := newDashboardMarkup()
+	type testCase struct {
+		Name     string
+		Data     string
+		Expected []Response
+	}
+	testCases := []testCase{
+		{
+			Name: "refreshes the dashboard",
+			Data: `{"t":"dash"}`,
+			Expected: []Response{
+				ok(telegram.EditMessageTextRequest{
+					ChatID:      1,
+					MessageID:   1,
+					Text:        text,
+					ParseMode:   "markdown",
+					ReplyMarkup: markup,
+				}),
+				ok(telegram.AnswerCallbackQueryRequest{
+					CallbackQueryID: "1",
+					Text:            "ETAs updated!",
+				}),
+			},
+		},
+		{
+			Name: "sends a new dashboard",
+			Data: `{"t":"dash","a":"new"}`,
+			Expected: []Response{
+				ok(telegram.SendMessageRequest{
+					ChatID:      1,
+					Text:        text,
+					ParseMode:   "markdown",
+					ReplyMarkup: markup,
+				}),
+				ok(telegram.AnswerCallbackQueryRequest{
+					CallbackQueryID: "1",
+				}),
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			m := mocks.NewMockUserRepository(ctrl)
+			m.EXPECT().GetUserFavourites(gomock.Any(), userID).Return([]Favourite{{Query: "96049"}}, nil)
+			bot := &BusEtaBot{
+				Users:    m,
+				BusStops: mockBusStopRepository{BusStop: &BusStop{BusStopCode: "96049", Description: "Opp Tropicana Condo"}},
+				Datamall: mockETAService{BusArrival: newArrival(now, "96049")},
+				NowFunc: func() time.Time {
+					return now
+				},
+			}
+			responses := make(chan Response, ResponseBufferSize)
+			go DashboardCallbackHandler(context.TODO(), bot, newCallbackQueryFromMessage(tc.Data), responses)
+			actual, err := collectResponsesWithTimeout(responses, 5*time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !assert.Equal(t, tc.Expected, actual) {
+				pretty.Println(actual)
+			}
+		})
+	}
+}
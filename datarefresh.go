@@ -0,0 +1,323 @@
+package busetabot
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/yi-jiayu/datamall/v3"
+)
+
+// MaxDatamallPages is the maximum number of pages fetched from a DataMall dataset, to avoid paging forever if the
+// server never returns an empty page.
+const MaxDatamallPages = 1000
+
+// StaticDataSource provides the bus stops and bus routes datasets from DataMall. It is satisfied by
+// datamall.APIClient.
+type StaticDataSource interface {
+	GetBusStops(offset int) (datamall.BusStops, error)
+	GetBusRoutes(offset int) (datamall.BusRoutes, error)
+}
+
+// FetchAllBusStops pages through the DataMall BusStops dataset until an empty page is returned.
+func FetchAllBusStops(src StaticDataSource) ([]datamall.BusStop, error) {
+	var stops []datamall.BusStop
+	for page := 0; page < MaxDatamallPages; page++ {
+		res, err := src.GetBusStops(len(stops))
+		if err != nil {
+			return nil, errors.Wrapf(err, "error fetching bus stops at offset %d", len(stops))
+		}
+		if len(res.Value) == 0 {
+			return stops, nil
+		}
+		stops = append(stops, res.Value...)
+	}
+	return nil, errors.Errorf("bus stops did not end after %d pages", MaxDatamallPages)
+}
+
+// FetchAllBusRoutes pages through the DataMall BusRoutes dataset until an empty page is returned.
+func FetchAllBusRoutes(src StaticDataSource) ([]datamall.BusRoute, error) {
+	var routes []datamall.BusRoute
+	for page := 0; page < MaxDatamallPages; page++ {
+		res, err := src.GetBusRoutes(len(routes))
+		if err != nil {
+			return nil, errors.Wrapf(err, "error fetching bus routes at offset %d", len(routes))
+		}
+		if len(res.Value) == 0 {
+			return routes, nil
+		}
+		routes = append(routes, res.Value...)
+	}
+	return nil, errors.Errorf("bus routes did not end after %d pages", MaxDatamallPages)
+}
+
+// RefreshStaticData fetches the bus stops and bus routes datasets from src and returns validated bus stops and routes
+// in the formats of the bus stops and bus routes JSON files.
+func RefreshStaticData(src StaticDataSource) ([]BusStop, []Route, error) {
+	stops, err := FetchAllBusStops(src)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(stops) == 0 {
+		return nil, nil, errors.New("no bus stops returned")
+	}
+	routes, err := FetchAllBusRoutes(src)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(routes) == 0 {
+		return nil, nil, errors.New("no bus routes returned")
+	}
+	busStops := BuildBusStops(stops, routes)
+	err = ValidateBusStops(busStops)
+	if err != nil {
+		return nil, nil, err
+	}
+	return busStops, BuildRoutes(routes), nil
+}
+
+// sortBusRoutes sorts bus routes by service, direction and stop sequence.
+func sortBusRoutes(routes []datamall.BusRoute) {
+	sort.SliceStable(routes, func(i, j int) bool {
+		a, b := routes[i], routes[j]
+		if a.ServiceNo != b.ServiceNo {
+			return a.ServiceNo < b.ServiceNo
+		}
+		if a.Direction != b.Direction {
+			return a.Direction < b.Direction
+		}
+		return a.StopSequence < b.StopSequence
+	})
+}
+
+func busTimings(r datamall.BusRoute) BusTimings {
+	return BusTimings{
+		WeekdayFirstBus:  r.WeekdayFirstBus,
+		WeekdayLastBus:   r.WeekdayLastBus,
+		SaturdayFirstBus: r.SatFirstBus,
+		SaturdayLastBus:  r.SatLastBus,
+		SundayFirstBus:   r.SunFirstBus,
+		SundayLastBus:    r.SunLastBun,
+	}
+}
+
+// BuildBusStops combines DataMall bus stops and bus routes into bus stops with the services calling at them and their
+// first and last bus timings, sorted by bus stop code. Services which call at a bus stop more than once use the
+// timings from their first visit.
+func BuildBusStops(stops []datamall.BusStop, routes []datamall.BusRoute) []BusStop {
+	routes = append([]datamall.BusRoute(nil), routes...)
+	sortBusRoutes(routes)
+	byCode := make(map[string]*BusStop, len(stops))
+	busStops := make([]BusStop, len(stops))
+	for i, s := range stops {
+		busStops[i] = BusStop{
+			BusStopCode: s.BusStopCode,
+			RoadName:    s.RoadName,
+			Description: s.Description,
+			Latitude:    s.Latitude,
+			Longitude:   s.Longitude,
+			Services:    []string{},
+		}
+	}
+	sort.Slice(busStops, func(i, j int) bool {
+		return busStops[i].BusStopCode < busStops[j].BusStopCode
+	})
+	for i := range busStops {
+		byCode[busStops[i].BusStopCode] = &busStops[i]
+	}
+	for _, r := range routes {
+		stop, ok := byCode[r.BusStopCode]
+		if !ok {
+			continue
+		}
+		if _, ok := stop.Timings[r.ServiceNo]; ok {
+			continue
+		}
+		if stop.Timings == nil {
+			stop.Timings = make(map[string]BusTimings)
+		}
+		stop.Services = append(stop.Services, r.ServiceNo)
+		stop.Timings[r.ServiceNo] = busTimings(r)
+	}
+	return busStops
+}
+
+// BuildRoutes groups DataMall bus routes into the stops served by each service in each direction.
+func BuildRoutes(routes []datamall.BusRoute) []Route {
+	routes = append([]datamall.BusRoute(nil), routes...)
+	sortBusRoutes(routes)
+	var built []Route
+	for _, r := range routes {
+		if n := len(built); n == 0 || built[n-1].ServiceNo != r.ServiceNo || built[n-1].Direction != r.Direction {
+			built = append(built, Route{
+				ServiceNo: r.ServiceNo,
+				Operator:  r.Operator,
+				Direction: r.Direction,
+			})
+		}
+		route := &built[len(built)-1]
+		route.Stops = append(route.Stops, RouteStop{
+			BusTimings:  busTimings(r),
+			BusStopCode: r.BusStopCode,
+			// distances are float32 in DataMall responses, so round them to avoid noise like 9.300000190734863
+			Distance: math.Round(float64(r.Distance)*1000) / 1000,
+		})
+	}
+	return built
+}
+
+// ValidateBusStops checks that bus stops have unique five digit codes, descriptions and plausible locations.
+func ValidateBusStops(stops []BusStop) error {
+	var problems []string
+	seen := make(map[string]bool, len(stops))
+	for _, s := range stops {
+		switch {
+		case !busStopRegex.MatchString(s.BusStopCode) || len(s.BusStopCode) != 5:
+			problems = append(problems, fmt.Sprintf("invalid bus stop code %q", s.BusStopCode))
+		case seen[s.BusStopCode]:
+			problems = append(problems, fmt.Sprintf("duplicate bus stop %s", s.BusStopCode))
+		case s.Description == "":
+			problems = append(problems, fmt.Sprintf("bus stop %s has no description", s.BusStopCode))
+		case s.Latitude < -90 || s.Latitude > 90 || s.Longitude < -180 || s.Longitude > 180 || s.Latitude == 0 && s.Longitude == 0:
+			problems = append(problems, fmt.Sprintf("bus stop %s has invalid location %f, %f", s.BusStopCode, s.Latitude, s.Longitude))
+		}
+		seen[s.BusStopCode] = true
+	}
+	if len(problems) > 0 {
+		return errors.Errorf("invalid bus stops: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// maxDiffCodes is the maximum number of bus stop codes listed for each kind of difference in BusStopsDiff.String.
+const maxDiffCodes = 10
+
+// BusStopsDiff lists the codes of bus stops which were added, removed or changed between two sets of bus stops.
+type BusStopsDiff struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Changed []string `json:"changed"`
+}
+
+// Empty reports whether there were no differences.
+func (d BusStopsDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+func (d BusStopsDiff) String() string {
+	if d.Empty() {
+		return "no changes"
+	}
+	var parts []string
+	for _, section := range []struct {
+		name  string
+		codes []string
+	}{
+		{"added", d.Added},
+		{"removed", d.Removed},
+		{"changed", d.Changed},
+	} {
+		if len(section.codes) == 0 {
+			continue
+		}
+		codes := section.codes
+		if len(codes) > maxDiffCodes {
+			codes = append(codes[:maxDiffCodes:maxDiffCodes], "...")
+		}
+		parts = append(parts, fmt.Sprintf("%d %s (%s)", len(section.codes), section.name, strings.Join(codes, ", ")))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// DiffBusStops compares two sets of bus stops by bus stop code.
+func DiffBusStops(old, new []BusStop) BusStopsDiff {
+	var diff BusStopsDiff
+	oldByCode := make(map[string]BusStop, len(old))
+	for _, s := range old {
+		oldByCode[s.BusStopCode] = s
+	}
+	newByCode := make(map[string]bool, len(new))
+	for _, s := range new {
+		newByCode[s.BusStopCode] = true
+		o, ok := oldByCode[s.BusStopCode]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, s.BusStopCode)
+		case !busStopsEqual(o, s):
+			diff.Changed = append(diff.Changed, s.BusStopCode)
+		}
+	}
+	for _, s := range old {
+		if !newByCode[s.BusStopCode] {
+			diff.Removed = append(diff.Removed, s.BusStopCode)
+		}
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Changed)
+	return diff
+}
+
+// busStopsEqual compares bus stops by their JSON encoding so that nil and empty slices and maps are equal.
+func busStopsEqual(a, b BusStop) bool {
+	if len(a.Services) == 0 {
+		a.Services = nil
+	}
+	if len(b.Services) == 0 {
+		b.Services = nil
+	}
+	x, _ := json.Marshal(a)
+	y, _ := json.Marshal(b)
+	return string(x) == string(y)
+}
+
+// ReadBusStopsFile reads bus stops from a JSON file, returning no bus stops if it does not exist.
+func ReadBusStopsFile(path string) ([]BusStop, error) {
+	bs, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading bus stops JSON file")
+	}
+	var stops []BusStop
+	err = json.Unmarshal(bs, &stops)
+	if err != nil {
+		return nil, errors.Wrap(err, "error decoding bus stops JSON file")
+	}
+	return stops, nil
+}
+
+// WriteJSONFile atomically replaces the file at path with the JSON encoding of v.
+func WriteJSONFile(path string, v interface{}) error {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrap(err, "error encoding JSON")
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return errors.Wrap(err, "error creating temporary file")
+	}
+	defer os.Remove(f.Name())
+	err = f.Chmod(0644)
+	if err == nil {
+		_, err = f.Write(bs)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrap(err, "error writing temporary file")
+	}
+	err = os.Rename(f.Name(), path)
+	if err != nil {
+		return errors.Wrap(err, "error replacing file")
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package busetabot
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/kr/pretty"
+	"github.com/stretchr/testify/assert"
+	"github.com/yi-jiayu/datamall/v3"
+)
+
+// newFakeDatamallServer returns a server which pages through stops and routes like the DataMall BusStops and BusRoutes
+// endpoints, pageSize at a time.
+func newFakeDatamallServer(t *testing.T, stops []datamall.BusStop, routes []datamall.BusRoute, pageSize int) *httptest.Server {
+	page := func(w http.ResponseWriter, r *http.Request, n int, values func(start, end int) interface{}) {
+		if r.Header.Get("AccountKey") != "key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		skip, err := strconv.Atoi(r.URL.Query().Get("$skip"))
+		if err != nil {
+			t.Errorf("invalid $skip: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		start, end := skip, skip+pageSize
+		if start > n {
+			start = n
+		}
+		if end > n {
+			end = n
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"odata.metadata": "http://datamall2.mytransport.sg/ltaodataservice/$metadata",
+			"value":          values(start, end),
+		})
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/BusStops", func(w http.ResponseWriter, r *http.Request) {
+		page(w, r, len(stops), func(start, end int) interface{} { return stops[start:end] })
+	})
+	mux.HandleFunc("/BusRoutes", func(w http.ResponseWriter, r *http.Request) {
+		page(w, r, len(routes), func(start, end int) interface{} { return routes[start:end] })
+	})
+	return httptest.NewServer(mux)
+}
+
+var (
+	datamallStops = []datamall.BusStop{
+		{BusStopCode: "96049", RoadName: "Upp Changi Rd East", Description: "Opp Tropicana Condo", Latitude: 1.34, Longitude: 103.96},
+		{BusStopCode: "01012", RoadName: "Victoria St", Description: "Hotel Grand Pacific", Latitude: 1.29, Longitude: 103.85},
+		{BusStopCode: "96041", RoadName: "Upp Changi Rd East", Description: "Bef Tropicana Condo", Latitude: 1.34, Longitude: 103.96},
+	}
+	datamallRoutes = []datamall.BusRoute{
+		{ServiceNo: "2", Operator: "GAS", Direction: 1, StopSequence: 2, BusStopCode: "96049", Distance: 9.3, WeekdayFirstBus: "0610", WeekdayLastBus: "2355", SatFirstBus: "0610", SatLastBus: "2355", SunFirstBus: "0630", SunLastBun: "2355"},
+		{ServiceNo: "2", Operator: "GAS", Direction: 1, StopSequence: 1, BusStopCode: "01012", Distance: 0, WeekdayFirstBus: "0530", WeekdayLastBus: "2330", SatFirstBus: "0530", SatLastBus: "2330", SunFirstBus: "0600", SunLastBun: "2330"},
+		{ServiceNo: "24", Operator: "SBST", Direction: 1, StopSequence: 1, BusStopCode: "96049", Distance: 0, WeekdayFirstBus: "0545", WeekdayLastBus: "0005", SatFirstBus: "0545", SatLastBus: "0005", SunFirstBus: "0545", SunLastBun: "0005"},
+	}
+)
+
+func TestRefreshStaticData(t *testing.T) {
+	server := newFakeDatamallServer(t, datamallStops, datamallRoutes, 2)
+	defer server.Close()
+	client := datamall.APIClient{Endpoint: server.URL, AccountKey: "key", Client: server.Client()}
+
+	busStops, routes, err := RefreshStaticData(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedBusStops := []BusStop{
+		{
+			BusStopCode: "01012",
+			RoadName:    "Victoria St",
+			Description: "Hotel Grand Pacific",
+			Latitude:    1.29,
+			Longitude:   103.85,
+			Services:    []string{"2"},
+			Timings: map[string]BusTimings{
+				"2": {WeekdayFirstBus: "0530", WeekdayLastBus: "2330", SaturdayFirstBus: "0530", SaturdayLastBus: "2330", SundayFirstBus: "0600", SundayLastBus: "2330"},
+			},
+		},
+		{
+			BusStopCode: "96041",
+			RoadName:    "Upp Changi Rd East",
+			Description: "Bef Tropicana Condo",
+			Latitude:    1.34,
+			Longitude:   103.96,
+			Services:    []string{},
+		},
+		{
+			BusStopCode: "96049",
+			RoadName:    "Upp Changi Rd East",
+			Description: "Opp Tropicana Condo",
+			Latitude:    1.34,
+			Longitude:   103.96,
+			Services:    []string{"2", "24"},
+			Timings: map[string]BusTimings{
+				"2":  {WeekdayFirstBus: "0610", WeekdayLastBus: "2355", SaturdayFirstBus: "0610", SaturdayLastBus: "2355", SundayFirstBus: "0630", SundayLastBus: "2355"},
+				"24": {WeekdayFirstBus: "0545", WeekdayLastBus: "0005", SaturdayFirstBus: "0545", SaturdayLastBus: "0005", SundayFirstBus: "0545", SundayLastBus: "0005"},
+			},
+		},
+	}
+	if !assert.Equal(t, expectedBusStops, busStops) {
+		pretty.Println(busStops)
+	}
+	if assert.Len(t, routes, 2) {
+		assert.Equal(t, "2", routes[0].ServiceNo)
+		assert.Equal(t, "GAS", routes[0].Operator)
+		if assert.Len(t, routes[0].Stops, 2) {
+			assert.Equal(t, "01012", routes[0].Stops[0].BusStopCode)
+			assert.Equal(t, "96049", routes[0].Stops[1].BusStopCode)
+			assert.Equal(t, 9.3, routes[0].Stops[1].Distance)
+		}
+		assert.Equal(t, "24", routes[1].ServiceNo)
+	}
+}
+
+func TestRefreshStaticData_Errors(t *testing.T) {
+	t.Run("when unauthorised", func(t *testing.T) {
+		server := newFakeDatamallServer(t, datamallStops, datamallRoutes, 2)
+		defer server.Close()
+		client := datamall.APIClient{Endpoint: server.URL, AccountKey: "wrong", Client: server.Client()}
+
+		_, _, err := RefreshStaticData(client)
+		assert.Error(t, err)
+	})
+	t.Run("when there are no bus stops", func(t *testing.T) {
+		server := newFakeDatamallServer(t, nil, datamallRoutes, 2)
+		defer server.Close()
+		client := datamall.APIClient{Endpoint: server.URL, AccountKey: "key", Client: server.Client()}
+
+		_, _, err := RefreshStaticData(client)
+		assert.EqualError(t, err, "no bus stops returned")
+	})
+	t.Run("when bus stops are invalid", func(t *testing.T) {
+		stops := append([]datamall.BusStop{{BusStopCode: "96049", Description: "Duplicate", Latitude: 1.34, Longitude: 103.96}}, datamallStops...)
+		server := newFakeDatamallServer(t, stops, datamallRoutes, 2)
+		defer server.Close()
+		client := datamall.APIClient{Endpoint: server.URL, AccountKey: "key", Client: server.Client()}
+
+		_, _, err := RefreshStaticData(client)
+		assert.EqualError(t, err, "invalid bus stops: duplicate bus stop 96049")
+	})
+}
+
+func TestValidateBusStops(t *testing.T) {
+	err := ValidateBusStops([]BusStop{
+		{BusStopCode: "1234", Description: "Short", Latitude: 1, Longitude: 1},
+		{BusStopCode: "12345", Latitude: 1, Longitude: 1},
+		{BusStopCode: "12346", Description: "Nowhere"},
+		{BusStopCode: "12347", Description: "Fine", Latitude: 1, Longitude: 1},
+	})
+	assert.EqualError(t, err, `invalid bus stops: invalid bus stop code "1234"; bus stop 12345 has no description; bus stop 12346 has invalid location 0.000000, 0.000000`)
+}
+
+func TestDiffBusStops(t *testing.T) {
+	old := []BusStop{
+		{BusStopCode: "01012", Description: "Hotel Grand Pacific"},
+		{BusStopCode: "01013", Description: "St. Joseph's Ch", Services: []string{}},
+		{BusStopCode: "01019", Description: "Bras Basah Cplx"},
+	}
+	new := []BusStop{
+		{BusStopCode: "01012", Description: "Hotel Grand Pacific", Services: []string{"2"}},
+		{BusStopCode: "01013", Description: "St. Joseph's Ch"},
+		{BusStopCode: "01029", Description: "Nan Hua Pr Sch"},
+	}
+	diff := DiffBusStops(old, new)
+	assert.Equal(t, BusStopsDiff{
+		Added:   []string{"01029"},
+		Removed: []string{"01019"},
+		Changed: []string{"01012"},
+	}, diff)
+	assert.Equal(t, "1 added (01029), 1 removed (01019), 1 changed (01012)", diff.String())
+	assert.Equal(t, "no changes", DiffBusStops(old, old).String())
+}
+
+func TestWriteJSONFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "refresh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "bus_stops.json")
+
+	stops, err := ReadBusStopsFile(path)
+	assert.NoError(t, err, "a missing file should be treated as having no bus stops")
+	assert.Empty(t, stops)
+
+	expected := []BusStop{{BusStopCode: "96049", Description: "Opp Tropicana Condo", Services: []string{"2"}}}
+	err = WriteJSONFile(path, expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := ReadBusStopsFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, expected, actual)
+	files, _ := ioutil.ReadDir(dir)
+	assert.Len(t, files, 1, "temporary files should be cleaned up")
+}
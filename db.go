@@ -20,8 +20,6 @@ var (
 	errNotFound = errors.New("not found")
 )
 
-var namespace = GetBotEnvironment()
-
 func GetBotEnvironment() string {
 	switch os.Getenv("BOT_ENVIRONMENT") {
 	case stagingEnvironment:
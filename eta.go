@@ -11,7 +11,6 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/yi-jiayu/datamall/v3"
-	"google.golang.org/appengine"
 
 	"github.com/yi-jiayu/bus-eta-bot/v4/telegram"
 )
@@ -29,10 +28,13 @@ type ETA struct {
 	Now      time.Time
 	Services []datamall.Service
 	Error    string
-}
 
-type FormatterFactory interface {
-	GetFormatter(ctx context.Context, userID int) Formatter
+	// ServiceNos are the services which etas were requested for, or nil for all services.
+	ServiceNos []string
+
+	// StaleAsOf is when Services were retrieved if they are the last known bus arrivals shown because DataMall is
+	// down, or the zero time if they are up to date.
+	StaleAsOf time.Time
 }
 
 type BusStopGetter interface {
@@ -55,6 +57,12 @@ type IncomingBuses struct {
 	Types     [3]string
 }
 
+// maxCallbackDataLength is the maximum length in bytes of the callback data of an inline keyboard button.
+const maxCallbackDataLength = 64
+
+// maxConcurrentETARequests is the maximum number of etas retrieved at the same time for one message.
+const maxConcurrentETARequests = 5
+
 // CallbackData represents the data to be included with the Refresh inline keyboard button in eta messages.
 type CallbackData struct {
 	Type       string   `json:"t"`
@@ -78,31 +86,120 @@ type ETARequest struct {
 	Services []string
 }
 
+// ETAMessageOptions controls how an eta message is formatted and which buttons it has.
+type ETAMessageOptions struct {
+	// Formatter is the name of the formatter to use, or empty for the user's preferred formatter.
+	Formatter string
+
+	// Inline is whether the message is an inline message, which cannot have buttons which send new messages.
+	Inline bool
+
+	// Live is whether the message is a live eta message, which has a Stop button in place of the Live button.
+	Live bool
+
+	// Alerts is whether alerts can be set up, in which case messages which are not inline have an alert button.
+	Alerts bool
+
+	// LiveETAs is whether messages can be kept up to date, in which case messages have a Live button.
+	LiveETAs bool
+}
+
+// ETAMessage contains the text and reply markup of an eta message.
+type ETAMessage struct {
+	Text        string
+	ParseMode   string
+	ReplyMarkup telegram.InlineKeyboardMarkup
+}
+
+// ETAMessageFactory renders eta messages for all the places they are sent or updated from, taking each user's
+// preferences into account.
 type ETAMessageFactory struct {
-	busStopGetter    BusStopGetter
-	etaService       ETAService
-	formatterFactory FormatterFactory
+	busStopGetter BusStopGetter
+	etaService    ETAService
+	preferences   PreferenceRepository
 }
 
-func (f ETAMessageFactory) Text(ctx context.Context, request ETARequest) (string, error) {
+// NewETAMessageFactory returns an ETAMessageFactory. preferences may be nil, in which case every user gets the default
+// preferences.
+func NewETAMessageFactory(busStopGetter BusStopGetter, etaService ETAService, preferences PreferenceRepository) ETAMessageFactory {
+	return ETAMessageFactory{
+		busStopGetter: busStopGetter,
+		etaService:    etaService,
+		preferences:   preferences,
+	}
+}
+
+// Message returns the eta message for request. The etas and the user's preferences are retrieved concurrently.
+func (f ETAMessageFactory) Message(ctx context.Context, request ETARequest, options ETAMessageOptions) (ETAMessage, error) {
 	var wg sync.WaitGroup
 	var eta ETA
-	var formatter Formatter
+	var preferences Preferences
 	wg.Add(2)
 	go func() {
 		eta = NewETA(ctx, f.busStopGetter, f.etaService, request)
 		wg.Done()
 	}()
 	go func() {
-		formatter = f.formatterFactory.GetFormatter(ctx, request.UserID)
+		preferences = getUserPreferences(ctx, f.preferences, request.UserID)
 		wg.Done()
 	}()
 	wg.Wait()
-	return formatter.Format(eta)
+
+	formatter := options.Formatter
+	if formatter == "" {
+		formatter = preferences.Formatter
+	}
+	text, err := preferences.formatter(formatter).Format(eta)
+	if err != nil {
+		return ETAMessage{}, errors.Wrap(err, "error formatting etas")
+	}
+	options.Formatter = formatter
+	return ETAMessage{
+		Text:        text,
+		ParseMode:   "markdown",
+		ReplyMarkup: NewETAMessageReplyMarkup(request.Code, request.Services, options),
+	}, nil
+}
+
+// Text returns the text of the eta message for request, formatted with the user's preferred formatter.
+func (f ETAMessageFactory) Text(ctx context.Context, request ETARequest) (string, error) {
+	message, err := f.Message(ctx, request, ETAMessageOptions{})
+	if err != nil {
+		return "", err
+	}
+	return message.Text, nil
+}
+
+// ETAs returns the etas for each of requests, along with the preferences of the user they are for so that they can be
+// shown together in one message the way the user prefers. The etas and the user's preferences are retrieved
+// concurrently, with at most maxConcurrentETARequests etas being retrieved at a time.
+func (f ETAMessageFactory) ETAs(ctx context.Context, userID int, requests []ETARequest) ([]ETA, Preferences) {
+	etas := make([]ETA, len(requests))
+	var preferences Preferences
+	var wg sync.WaitGroup
+	wg.Add(len(requests) + 1)
+	go func() {
+		defer wg.Done()
+		preferences = getUserPreferences(ctx, f.preferences, userID)
+	}()
+	sem := make(chan struct{}, maxConcurrentETARequests)
+	for i := range requests {
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			etas[i] = NewETA(ctx, f.busStopGetter, f.etaService, requests[i])
+		}(i)
+	}
+	wg.Wait()
+	return etas, preferences
 }
 
 func NewETA(ctx context.Context, busStopGetter BusStopGetter, etaService ETAService, request ETARequest) (eta ETA) {
 	eta.Now = request.Time
+	eta.ServiceNos = request.Services
 	stop := busStopGetter.Get(request.Code)
 	if stop != nil {
 		eta.BusStop = *stop
@@ -110,14 +207,18 @@ func NewETA(ctx context.Context, busStopGetter BusStopGetter, etaService ETAServ
 		eta.BusStop.BusStopCode = request.Code
 	}
 	arrival, err := etaService.GetBusArrival(request.Code, "")
-	if err != nil {
-		if err, ok := err.(*datamall.Error); ok {
-			eta.Error = fmt.Sprintf("LTA DataMall could be down at the moment (status code %d)", err.StatusCode)
-			// TODO: record metrics about DataMall disruptions
+	if stale, ok := err.(*StaleArrivalError); ok {
+		arrival = projectArrival(arrival, request.Time)
+		if len(arrival.Services) > 0 {
+			eta.StaleAsOf = stale.FetchedAt
+			eta.Error = etaErrorMessage(ctx, stale.Err)
+			err = nil
 		} else {
-			eta.Error = fmt.Sprintf("An error occurred while fetching ETAs (request ID: %s)", appengine.RequestID(ctx))
-			logError(ctx, err)
+			err = stale.Err
 		}
+	}
+	if err != nil {
+		eta.Error = etaErrorMessage(ctx, err)
 		return
 	}
 	if len(request.Services) == 0 {
@@ -132,6 +233,19 @@ func NewETA(ctx context.Context, busStopGetter BusStopGetter, etaService ETAServ
 	return
 }
 
+// etaErrorMessage returns the message to show users when fetching ETAs failed with err. Metrics about DataMall
+// disruptions are recorded by the CircuitBreaker around the ETAService.
+func etaErrorMessage(ctx context.Context, err error) string {
+	switch err := err.(type) {
+	case *CircuitOpenError:
+		return DataMallOutageMessage
+	case *datamall.Error:
+		return fmt.Sprintf("LTA DataMall could be down at the moment (status code %d)", err.StatusCode)
+	}
+	logError(ctx, err)
+	return fmt.Sprintf("An error occurred while fetching ETAs (request ID: %s)", requestID(ctx))
+}
+
 func (SummaryETAFormatter) Format(etas BusEtas, serviceNos []string) string {
 	showing := 0
 	services := make([][4]string, 0)
@@ -383,14 +497,66 @@ func NewToggleFavouriteButton(busStopCode string, serviceNos []string) telegram.
 	}
 }
 
-func NewETAMessageReplyMarkup(busStopCode string, serviceNos []string, formatter string, inline bool) telegram.InlineKeyboardMarkup {
+func NewAlertButton(busStopCode string, serviceNos []string) telegram.InlineKeyboardButton {
+	data := CallbackData{
+		Type:       "alert",
+		BusStopID:  busStopCode,
+		ServiceNos: serviceNos,
+	}
+	JSON, _ := json.Marshal(data)
+	return telegram.InlineKeyboardButton{
+		Text:         "🔔",
+		CallbackData: string(JSON),
+	}
+}
+
+func NewLiveButton(busStopCode string, serviceNos []string, formatter string) telegram.InlineKeyboardButton {
+	data := CallbackData{
+		Type:       "live",
+		BusStopID:  busStopCode,
+		ServiceNos: serviceNos,
+		Formatter:  formatter,
+	}
+	JSON, _ := json.Marshal(data)
+	return telegram.InlineKeyboardButton{
+		Text:         "Live",
+		CallbackData: string(JSON),
+	}
+}
+
+func NewStopLiveButton(busStopCode string, serviceNos []string, formatter string) telegram.InlineKeyboardButton {
+	data := CallbackData{
+		Type:       "live_stop",
+		BusStopID:  busStopCode,
+		ServiceNos: serviceNos,
+		Formatter:  formatter,
+	}
+	JSON, _ := json.Marshal(data)
+	return telegram.InlineKeyboardButton{
+		Text:         "Stop",
+		CallbackData: string(JSON),
+	}
+}
+
+// NewETAMessageReplyMarkup returns the reply markup for an eta message with the buttons described by options. Live eta
+// messages have a Stop button in place of the Live button.
+func NewETAMessageReplyMarkup(busStopCode string, serviceNos []string, options ETAMessageOptions) telegram.InlineKeyboardMarkup {
+	formatter := options.Formatter
 	row := []telegram.InlineKeyboardButton{
 		NewRefreshButton(busStopCode, serviceNos, formatter),
 	}
-	if !inline {
+	if options.Live {
+		row = append(row, NewStopLiveButton(busStopCode, serviceNos, formatter))
+	} else if options.LiveETAs {
+		row = append(row, NewLiveButton(busStopCode, serviceNos, formatter))
+	}
+	if !options.Inline {
 		row = append(row,
 			NewResendButton(busStopCode, serviceNos, formatter),
 			NewToggleFavouriteButton(busStopCode, serviceNos))
+		if options.Alerts {
+			row = append(row, NewAlertButton(busStopCode, serviceNos))
+		}
 	}
 	keyboard := [][]telegram.InlineKeyboardButton{
 		row,
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"math"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
@@ -26,6 +27,9 @@ func (b mockBusStopRepository) Search(ctx context.Context, query string, limit i
 
 func (b mockBusStopRepository) Nearby(ctx context.Context, lat, lon, radius float64, limit int) (nearby []NearbyBusStop) {
 	for _, bs := range b.NearbyBusStops {
+		if limit > 0 && len(nearby) == limit {
+			break
+		}
 		nearby = append(nearby, NearbyBusStop{
 			BusStop:  bs,
 			Distance: math.Sqrt(SquaredEuclideanDistanceAtEquator(lat, lon, bs.Latitude, bs.Longitude)),
@@ -422,8 +426,7 @@ func TestNewETAMessageReplyMarkup(t *testing.T) {
 	type args struct {
 		busStopCode string
 		serviceNos  []string
-		formatter   string
-		inline      bool
+		options     ETAMessageOptions
 	}
 	tests := []struct {
 		name string
@@ -434,7 +437,46 @@ func TestNewETAMessageReplyMarkup(t *testing.T) {
 			name: "when not inline",
 			args: args{
 				busStopCode: "96049",
-				inline:      false,
+				options:     ETAMessageOptions{Alerts: true, LiveETAs: true},
+			},
+			want: telegram.InlineKeyboardMarkup{
+				InlineKeyboard: [][]telegram.InlineKeyboardButton{
+					{
+						{
+							Text:         "Refresh",
+							CallbackData: `{"t":"refresh","b":"96049"}`,
+						},
+						{
+							Text:         "Live",
+							CallbackData: `{"t":"live","b":"96049"}`,
+						},
+						{
+							Text:         "Resend",
+							CallbackData: `{"t":"resend","b":"96049"}`,
+						},
+						{
+							Text:         "⭐",
+							CallbackData: `{"t":"togf","a":"96049"}`,
+						},
+						{
+							Text:         "🔔",
+							CallbackData: `{"t":"alert","b":"96049"}`,
+						},
+					},
+					{
+						{
+							Text:         "Show incoming bus details",
+							CallbackData: `{"t":"refresh","b":"96049","f":"f"}`,
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "when alerts are not available",
+			args: args{
+				busStopCode: "96049",
+				options:     ETAMessageOptions{LiveETAs: true},
 			},
 			want: telegram.InlineKeyboardMarkup{
 				InlineKeyboard: [][]telegram.InlineKeyboardButton{
@@ -443,6 +485,10 @@ func TestNewETAMessageReplyMarkup(t *testing.T) {
 							Text:         "Refresh",
 							CallbackData: `{"t":"refresh","b":"96049"}`,
 						},
+						{
+							Text:         "Live",
+							CallbackData: `{"t":"live","b":"96049"}`,
+						},
 						{
 							Text:         "Resend",
 							CallbackData: `{"t":"resend","b":"96049"}`,
@@ -465,8 +511,7 @@ func TestNewETAMessageReplyMarkup(t *testing.T) {
 			name: "when not inline, with formatter",
 			args: args{
 				busStopCode: "96049",
-				formatter:   FormatterSummary,
-				inline:      false,
+				options:     ETAMessageOptions{Formatter: FormatterSummary, Alerts: true, LiveETAs: true},
 			},
 			want: telegram.InlineKeyboardMarkup{
 				InlineKeyboard: [][]telegram.InlineKeyboardButton{
@@ -475,6 +520,10 @@ func TestNewETAMessageReplyMarkup(t *testing.T) {
 							Text:         "Refresh",
 							CallbackData: `{"t":"refresh","b":"96049","f":"s"}`,
 						},
+						{
+							Text:         "Live",
+							CallbackData: `{"t":"live","b":"96049","f":"s"}`,
+						},
 						{
 							Text:         "Resend",
 							CallbackData: `{"t":"resend","b":"96049","f":"s"}`,
@@ -483,6 +532,10 @@ func TestNewETAMessageReplyMarkup(t *testing.T) {
 							Text:         "⭐",
 							CallbackData: `{"t":"togf","a":"96049"}`,
 						},
+						{
+							Text:         "🔔",
+							CallbackData: `{"t":"alert","b":"96049"}`,
+						},
 					},
 					{
 						{
@@ -497,8 +550,7 @@ func TestNewETAMessageReplyMarkup(t *testing.T) {
 			name: "when not inline, with features formatter",
 			args: args{
 				busStopCode: "96049",
-				formatter:   FormatterFeatures,
-				inline:      false,
+				options:     ETAMessageOptions{Formatter: FormatterFeatures, Alerts: true, LiveETAs: true},
 			},
 			want: telegram.InlineKeyboardMarkup{
 				InlineKeyboard: [][]telegram.InlineKeyboardButton{
@@ -507,6 +559,10 @@ func TestNewETAMessageReplyMarkup(t *testing.T) {
 							Text:         "Refresh",
 							CallbackData: `{"t":"refresh","b":"96049","f":"f"}`,
 						},
+						{
+							Text:         "Live",
+							CallbackData: `{"t":"live","b":"96049","f":"f"}`,
+						},
 						{
 							Text:         "Resend",
 							CallbackData: `{"t":"resend","b":"96049","f":"f"}`,
@@ -515,6 +571,10 @@ func TestNewETAMessageReplyMarkup(t *testing.T) {
 							Text:         "⭐",
 							CallbackData: `{"t":"togf","a":"96049"}`,
 						},
+						{
+							Text:         "🔔",
+							CallbackData: `{"t":"alert","b":"96049"}`,
+						},
 					},
 					{
 						{
@@ -529,7 +589,34 @@ func TestNewETAMessageReplyMarkup(t *testing.T) {
 			name: "when inline",
 			args: args{
 				busStopCode: "96049",
-				inline:      true,
+				options:     ETAMessageOptions{Inline: true, LiveETAs: true},
+			},
+			want: telegram.InlineKeyboardMarkup{
+				InlineKeyboard: [][]telegram.InlineKeyboardButton{
+					{
+						{
+							Text:         "Refresh",
+							CallbackData: `{"t":"refresh","b":"96049"}`,
+						},
+						{
+							Text:         "Live",
+							CallbackData: `{"t":"live","b":"96049"}`,
+						},
+					},
+					{
+						{
+							Text:         "Show incoming bus details",
+							CallbackData: `{"t":"refresh","b":"96049","f":"f"}`,
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "when live etas are not available",
+			args: args{
+				busStopCode: "96049",
+				options:     ETAMessageOptions{Inline: true},
 			},
 			want: telegram.InlineKeyboardMarkup{
 				InlineKeyboard: [][]telegram.InlineKeyboardButton{
@@ -552,8 +639,7 @@ func TestNewETAMessageReplyMarkup(t *testing.T) {
 			name: "when inline, with formatter",
 			args: args{
 				busStopCode: "96049",
-				formatter:   FormatterFeatures,
-				inline:      true,
+				options:     ETAMessageOptions{Formatter: FormatterFeatures, Inline: true, LiveETAs: true},
 			},
 			want: telegram.InlineKeyboardMarkup{
 				InlineKeyboard: [][]telegram.InlineKeyboardButton{
@@ -562,6 +648,10 @@ func TestNewETAMessageReplyMarkup(t *testing.T) {
 							Text:         "Refresh",
 							CallbackData: `{"t":"refresh","b":"96049","f":"f"}`,
 						},
+						{
+							Text:         "Live",
+							CallbackData: `{"t":"live","b":"96049","f":"f"}`,
+						},
 					},
 					{
 						{
@@ -576,8 +666,7 @@ func TestNewETAMessageReplyMarkup(t *testing.T) {
 			name: "when inline, with features formatter",
 			args: args{
 				busStopCode: "96049",
-				formatter:   FormatterSummary,
-				inline:      true,
+				options:     ETAMessageOptions{Formatter: FormatterSummary, Inline: true, LiveETAs: true},
 			},
 			want: telegram.InlineKeyboardMarkup{
 				InlineKeyboard: [][]telegram.InlineKeyboardButton{
@@ -586,6 +675,10 @@ func TestNewETAMessageReplyMarkup(t *testing.T) {
 							Text:         "Refresh",
 							CallbackData: `{"t":"refresh","b":"96049","f":"s"}`,
 						},
+						{
+							Text:         "Live",
+							CallbackData: `{"t":"live","b":"96049","f":"s"}`,
+						},
 					},
 					{
 						{
@@ -599,7 +692,7 @@ func TestNewETAMessageReplyMarkup(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			actual := NewETAMessageReplyMarkup(tt.args.busStopCode, tt.args.serviceNos, tt.args.formatter, tt.args.inline)
+			actual := NewETAMessageReplyMarkup(tt.args.busStopCode, tt.args.serviceNos, tt.args.options)
 			assert.Equal(t, tt.want, actual)
 		})
 	}
@@ -649,6 +742,48 @@ func TestNewETA(t *testing.T) {
 		expected := "An error occurred while fetching ETAs (request ID: )"
 		assert.Equal(t, expected, actual.Error)
 	})
+	t.Run("contains outage message when circuit breaker is open", func(t *testing.T) {
+		getter := mockBusStopRepository{}
+		etaService := mockETAService{
+			Error: &CircuitOpenError{},
+		}
+		actual := NewETA(context.Background(), getter, etaService, ETARequest{})
+		assert.Equal(t, DataMallOutageMessage, actual.Error)
+	})
+	t.Run("contains last known services when datamall is down", func(t *testing.T) {
+		getter := mockBusStopRepository{}
+		fetchedAt := time.Date(2019, 1, 1, 8, 0, 0, 0, sgt)
+		etaService := mockETAService{
+			BusArrival: newArrival(fetchedAt, "96049"),
+			Error: &StaleArrivalError{
+				Err:       &datamall.Error{StatusCode: 503},
+				FetchedAt: fetchedAt,
+			},
+		}
+		actual := NewETA(context.Background(), getter, etaService, ETARequest{
+			Time: fetchedAt.Add(time.Minute),
+		})
+		assert.Equal(t, fetchedAt, actual.StaleAsOf)
+		assert.Equal(t, "LTA DataMall could be down at the moment (status code 503)", actual.Error)
+		assert.Equal(t, projectArrival(newArrival(fetchedAt, "96049"), fetchedAt.Add(time.Minute)).Services, actual.Services)
+	})
+	t.Run("contains appropriate error when last known services have all arrived", func(t *testing.T) {
+		getter := mockBusStopRepository{}
+		fetchedAt := time.Date(2019, 1, 1, 8, 0, 0, 0, sgt)
+		etaService := mockETAService{
+			BusArrival: newArrival(fetchedAt, "96049"),
+			Error: &StaleArrivalError{
+				Err:       &datamall.Error{StatusCode: 503},
+				FetchedAt: fetchedAt,
+			},
+		}
+		actual := NewETA(context.Background(), getter, etaService, ETARequest{
+			Time: fetchedAt.Add(time.Hour),
+		})
+		assert.True(t, actual.StaleAsOf.IsZero())
+		assert.Equal(t, "LTA DataMall could be down at the moment (status code 503)", actual.Error)
+		assert.Empty(t, actual.Services)
+	})
 	t.Run("doesn't filter services when services is empty", func(t *testing.T) {
 		getter := mockBusStopRepository{}
 		arrival := newArrival(time.Time{}, "")
@@ -675,30 +810,148 @@ func TestNewETA(t *testing.T) {
 	})
 }
 
-type mockFormatter struct{}
-
-func (f mockFormatter) GetFormatter(ctx context.Context, userID int) Formatter {
-	return f
-}
-
-func (f mockFormatter) Format(eta ETA) (string, error) {
-	var output string
-	for _, svc := range eta.Services {
-		output += " " + svc.ServiceNo
+func TestETAMessageFactory_Message(t *testing.T) {
+	request := ETARequest{
+		UserID: 1,
+		Code:   "96049",
+	}
+	eta := NewETA(context.Background(), mockBusStopRepository{}, mockDatamall{}, request)
+	format := func(f Formatter) string {
+		text, err := f.Format(eta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return text
+	}
+	testCases := []struct {
+		Name        string
+		Preferences Preferences
+		Options     ETAMessageOptions
+		Expected    ETAMessage
+	}{
+		{
+			Name: "default preferences",
+			Expected: ETAMessage{
+				Text:        format(summaryFormatter),
+				ParseMode:   "markdown",
+				ReplyMarkup: NewETAMessageReplyMarkup("96049", nil, ETAMessageOptions{}),
+			},
+		},
+		{
+			Name:        "preferred formatter and time display",
+			Preferences: Preferences{Formatter: FormatterFeatures, TimeDisplay: TimeDisplayClock},
+			Expected: ETAMessage{
+				Text:        format(featuresClockFormatter),
+				ParseMode:   "markdown",
+				ReplyMarkup: NewETAMessageReplyMarkup("96049", nil, ETAMessageOptions{Formatter: FormatterFeatures}),
+			},
+		},
+		{
+			Name:        "formatter overrides preferred formatter",
+			Preferences: Preferences{Formatter: FormatterFeatures},
+			Options:     ETAMessageOptions{Formatter: FormatterSummary},
+			Expected: ETAMessage{
+				Text:        format(summaryFormatter),
+				ParseMode:   "markdown",
+				ReplyMarkup: NewETAMessageReplyMarkup("96049", nil, ETAMessageOptions{Formatter: FormatterSummary}),
+			},
+		},
+		{
+			Name:    "live inline message",
+			Options: ETAMessageOptions{Inline: true, Live: true},
+			Expected: ETAMessage{
+				Text:        format(summaryFormatter),
+				ParseMode:   "markdown",
+				ReplyMarkup: NewETAMessageReplyMarkup("96049", nil, ETAMessageOptions{Inline: true, Live: true}),
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			f := NewETAMessageFactory(mockBusStopRepository{}, mockDatamall{}, mockPreferenceRepository{1: tc.Preferences})
+			actual, err := f.Message(context.Background(), request, tc.Options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, tc.Expected, actual)
+		})
 	}
-	return "services:" + output, nil
 }
 
 func TestETAMessageFactory_Text(t *testing.T) {
-	f := ETAMessageFactory{
-		busStopGetter:    mockBusStopRepository{},
-		etaService:       mockDatamall{},
-		formatterFactory: mockFormatter{},
-	}
+	f := NewETAMessageFactory(mockBusStopRepository{}, mockDatamall{}, nil)
 	actual, err := f.Text(context.Background(), ETARequest{})
 	if err != nil {
 		t.Fatal(err)
 	}
-	expected := "services: 2 24"
+	expected, err := summaryFormatter.Format(NewETA(context.Background(), mockBusStopRepository{}, mockDatamall{}, ETARequest{}))
+	if err != nil {
+		t.Fatal(err)
+	}
 	assert.Equal(t, expected, actual)
+	assert.Contains(t, actual, "| 24   |")
+}
+
+func TestETAMessageFactory_ETAs(t *testing.T) {
+	now := time.Date(2018, 1, 1, 8, 0, 0, 0, sgt)
+	busStops := busStopsByCode{
+		"96049": {BusStopCode: "96049", Description: "Opp Tropicana Condo"},
+	}
+	etaService := partialETAService{
+		Now: now,
+		Errors: map[string]error{
+			"81111": &datamall.Error{StatusCode: 503},
+		},
+	}
+	preferences := mockPreferenceRepository{1: {TimeDisplay: TimeDisplayClock}}
+	f := NewETAMessageFactory(busStops, etaService, preferences)
+	requests := []ETARequest{
+		{UserID: 1, Time: now, Code: "96049", Services: []string{"24"}},
+		{UserID: 1, Time: now, Code: "81111"},
+	}
+	etas, actualPreferences := f.ETAs(context.Background(), 1, requests)
+	assert.Equal(t, Preferences{TimeDisplay: TimeDisplayClock}, actualPreferences)
+	if !assert.Len(t, etas, 2) {
+		return
+	}
+	assert.Equal(t, "Opp Tropicana Condo", etas[0].BusStop.Description)
+	if assert.Len(t, etas[0].Services, 1) {
+		assert.Equal(t, "24", etas[0].Services[0].ServiceNo)
+	}
+	assert.Equal(t, "81111", etas[1].BusStop.BusStopCode)
+	assert.Equal(t, "LTA DataMall could be down at the moment (status code 503)", etas[1].Error)
+}
+
+// concurrencyTrackingETAService records the largest number of bus arrivals being retrieved at the same time.
+type concurrencyTrackingETAService struct {
+	mu      sync.Mutex
+	current int
+	max     int
+}
+
+func (s *concurrencyTrackingETAService) GetBusArrival(code string, serviceNo string) (datamall.BusArrival, error) {
+	s.mu.Lock()
+	s.current++
+	if s.current > s.max {
+		s.max = s.current
+	}
+	s.mu.Unlock()
+	time.Sleep(10 * time.Millisecond)
+	s.mu.Lock()
+	s.current--
+	s.mu.Unlock()
+	return datamall.BusArrival{}, nil
+}
+
+func TestETAMessageFactory_ETAsLimitsConcurrency(t *testing.T) {
+	etaService := new(concurrencyTrackingETAService)
+	f := NewETAMessageFactory(busStopsByCode{}, etaService, nil)
+	requests := make([]ETARequest, 4*maxConcurrentETARequests)
+	for i := range requests {
+		requests[i] = ETARequest{Code: fmt.Sprintf("%05d", i)}
+	}
+	etas, _ := f.ETAs(context.Background(), 1, requests)
+	assert.Len(t, etas, len(requests))
+	assert.True(t, etaService.max <= maxConcurrentETARequests, "%d etas were retrieved at the same time", etaService.max)
+	assert.True(t, etaService.max > 1, "etas were not retrieved concurrently")
 }
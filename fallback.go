@@ -0,0 +1,139 @@
+package busetabot
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/yi-jiayu/datamall/v3"
+)
+
+// DefaultStaleArrivalMaxAge is how old the last known bus arrivals for a bus stop can be before they are no longer
+// shown when DataMall is down.
+const DefaultStaleArrivalMaxAge = 30 * time.Minute
+
+// ArrivalStore stores the most recent successful bus arrival response for each bus stop.
+type ArrivalStore interface {
+	Put(key string, arrival datamall.BusArrival, fetchedAt time.Time)
+	Get(key string) (arrival datamall.BusArrival, fetchedAt time.Time, ok bool)
+}
+
+type storedArrival struct {
+	arrival   datamall.BusArrival
+	fetchedAt time.Time
+}
+
+// InMemoryArrivalStore is an ArrivalStore which keeps bus arrivals in memory. It is safe for concurrent use.
+type InMemoryArrivalStore struct {
+	mu       sync.RWMutex
+	arrivals map[string]storedArrival
+}
+
+// NewInMemoryArrivalStore returns an empty InMemoryArrivalStore.
+func NewInMemoryArrivalStore() *InMemoryArrivalStore {
+	return &InMemoryArrivalStore{
+		arrivals: make(map[string]storedArrival),
+	}
+}
+
+func (s *InMemoryArrivalStore) Put(key string, arrival datamall.BusArrival, fetchedAt time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.arrivals[key] = storedArrival{
+		arrival:   arrival,
+		fetchedAt: fetchedAt,
+	}
+}
+
+func (s *InMemoryArrivalStore) Get(key string) (arrival datamall.BusArrival, fetchedAt time.Time, ok bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	stored, ok := s.arrivals[key]
+	return stored.arrival, stored.fetchedAt, ok
+}
+
+// StaleArrivalError is returned by a FallbackETAService together with the last known bus arrivals for a bus stop when
+// DataMall returned an error or the circuit breaker around it is open.
+type StaleArrivalError struct {
+	// Err is either the *datamall.Error returned by DataMall or a *CircuitOpenError.
+	Err error
+
+	// FetchedAt is when the last known bus arrivals were retrieved.
+	FetchedAt time.Time
+}
+
+func (e *StaleArrivalError) Error() string {
+	return fmt.Sprintf("serving bus arrivals from %s: %v", e.FetchedAt, e.Err)
+}
+
+// FallbackETAService is an ETAService which remembers the last successful response for each bus stop and returns it
+// along with a *StaleArrivalError when DataMall returns an error or the circuit breaker around it is open.
+type FallbackETAService struct {
+	service ETAService
+	store   ArrivalStore
+	maxAge  time.Duration
+	nowFunc func() time.Time
+}
+
+// NewFallbackETAService returns a FallbackETAService which gets bus arrivals from service and remembers them in store.
+func NewFallbackETAService(service ETAService, store ArrivalStore) *FallbackETAService {
+	return &FallbackETAService{
+		service: service,
+		store:   store,
+		maxAge:  DefaultStaleArrivalMaxAge,
+		nowFunc: time.Now,
+	}
+}
+
+func (s *FallbackETAService) GetBusArrival(busStopCode string, serviceNo string) (datamall.BusArrival, error) {
+	key := busStopCode + " " + serviceNo
+	arrival, err := s.service.GetBusArrival(busStopCode, serviceNo)
+	if err == nil {
+		s.store.Put(key, arrival, s.nowFunc())
+		return arrival, nil
+	}
+	switch err.(type) {
+	case *datamall.Error, *CircuitOpenError:
+	default:
+		return arrival, err
+	}
+	stale, fetchedAt, ok := s.store.Get(key)
+	if !ok || s.nowFunc().Sub(fetchedAt) > s.maxAge {
+		return arrival, err
+	}
+	return stale, &StaleArrivalError{
+		Err:       err,
+		FetchedAt: fetchedAt,
+	}
+}
+
+// projectArrival removes buses which should already have arrived by now from arrival, moving later buses up so that
+// the next bus to arrive is always NextBus. Services with no buses left are removed.
+func projectArrival(arrival datamall.BusArrival, now time.Time) datamall.BusArrival {
+	projected := arrival
+	projected.Services = nil
+	for _, service := range arrival.Services {
+		var buses []datamall.ArrivingBus
+		for _, bus := range []datamall.ArrivingBus{service.NextBus, service.NextBus2, service.NextBus3} {
+			if !bus.EstimatedArrival.IsZero() && bus.EstimatedArrival.After(now) {
+				buses = append(buses, bus)
+			}
+		}
+		if len(buses) == 0 {
+			continue
+		}
+		service.NextBus, service.NextBus2, service.NextBus3 = datamall.ArrivingBus{}, datamall.ArrivingBus{}, datamall.ArrivingBus{}
+		for i, bus := range buses {
+			switch i {
+			case 0:
+				service.NextBus = bus
+			case 1:
+				service.NextBus2 = bus
+			case 2:
+				service.NextBus3 = bus
+			}
+		}
+		projected.Services = append(projected.Services, service)
+	}
+	return projected
+}
@@ -0,0 +1,92 @@
+package busetabot
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yi-jiayu/datamall/v3"
+)
+
+func TestFallbackETAService_GetBusArrival(t *testing.T) {
+	now := time.Date(2019, 1, 1, 8, 0, 0, 0, sgt)
+	arrival := newArrival(now, "96049")
+	dmErr := &datamall.Error{StatusCode: 503}
+	t.Run("returns and remembers arrivals when datamall is up", func(t *testing.T) {
+		store := NewInMemoryArrivalStore()
+		service := NewFallbackETAService(mockETAService{BusArrival: arrival}, store)
+		service.nowFunc = func() time.Time { return now }
+		actual, err := service.GetBusArrival("96049", "")
+		assert.NoError(t, err)
+		assert.Equal(t, arrival, actual)
+		stored, fetchedAt, ok := store.Get("96049 ")
+		assert.True(t, ok)
+		assert.Equal(t, arrival, stored)
+		assert.Equal(t, now, fetchedAt)
+	})
+	t.Run("returns last known arrivals when datamall is down", func(t *testing.T) {
+		store := NewInMemoryArrivalStore()
+		store.Put("96049 ", arrival, now)
+		service := NewFallbackETAService(mockETAService{Error: dmErr}, store)
+		service.nowFunc = func() time.Time { return now.Add(5 * time.Minute) }
+		actual, err := service.GetBusArrival("96049", "")
+		assert.Equal(t, &StaleArrivalError{Err: dmErr, FetchedAt: now}, err)
+		assert.Equal(t, arrival, actual)
+	})
+	t.Run("returns error when last known arrivals are too old", func(t *testing.T) {
+		store := NewInMemoryArrivalStore()
+		store.Put("96049 ", arrival, now)
+		service := NewFallbackETAService(mockETAService{Error: dmErr}, store)
+		service.nowFunc = func() time.Time { return now.Add(DefaultStaleArrivalMaxAge + time.Minute) }
+		_, err := service.GetBusArrival("96049", "")
+		assert.Equal(t, dmErr, err)
+	})
+	t.Run("returns error when there are no last known arrivals", func(t *testing.T) {
+		service := NewFallbackETAService(mockETAService{Error: dmErr}, NewInMemoryArrivalStore())
+		_, err := service.GetBusArrival("96049", "")
+		assert.Equal(t, dmErr, err)
+	})
+	t.Run("returns last known arrivals when circuit breaker is open", func(t *testing.T) {
+		store := NewInMemoryArrivalStore()
+		store.Put("96049 ", arrival, now)
+		openErr := &CircuitOpenError{OpenedAt: now}
+		service := NewFallbackETAService(mockETAService{Error: openErr}, store)
+		service.nowFunc = func() time.Time { return now }
+		actual, err := service.GetBusArrival("96049", "")
+		assert.Equal(t, &StaleArrivalError{Err: openErr, FetchedAt: now}, err)
+		assert.Equal(t, arrival, actual)
+	})
+	t.Run("does not fall back for other errors", func(t *testing.T) {
+		store := NewInMemoryArrivalStore()
+		store.Put("96049 ", arrival, now)
+		otherErr := errors.New("some other error")
+		service := NewFallbackETAService(mockETAService{Error: otherErr}, store)
+		service.nowFunc = func() time.Time { return now }
+		_, err := service.GetBusArrival("96049", "")
+		assert.Equal(t, otherErr, err)
+	})
+}
+
+func Test_projectArrival(t *testing.T) {
+	now := time.Date(2019, 1, 1, 8, 0, 0, 0, sgt)
+	bus := func(minutes int) datamall.ArrivingBus {
+		return datamall.ArrivingBus{EstimatedArrival: now.Add(time.Duration(minutes) * time.Minute)}
+	}
+	arrival := datamall.BusArrival{
+		BusStopCode: "96049",
+		Services: []datamall.Service{
+			{ServiceNo: "2", NextBus: bus(-1), NextBus2: bus(3), NextBus3: bus(10)},
+			{ServiceNo: "5", NextBus: bus(-5)},
+			{ServiceNo: "24", NextBus: bus(1), NextBus2: bus(8)},
+		},
+	}
+	expected := datamall.BusArrival{
+		BusStopCode: "96049",
+		Services: []datamall.Service{
+			{ServiceNo: "2", NextBus: bus(3), NextBus2: bus(10)},
+			{ServiceNo: "24", NextBus: bus(1), NextBus2: bus(8)},
+		},
+	}
+	assert.Equal(t, expected, projectArrival(arrival, now))
+}
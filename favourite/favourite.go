@@ -0,0 +1,42 @@
+// Package favourite defines the eta queries users save as favourites. It is separate from package busetabot so that
+// the generated mocks and the user repository conformance tests can refer to favourites without an import cycle.
+package favourite
+
+// Favourite is an eta query saved by a user.
+type Favourite struct {
+	// Query is the eta query, such as "96049 2 24".
+	Query string
+
+	// Label is a name chosen by the user which is shown instead of the query, such as "Home → Work", or empty.
+	Label string
+}
+
+// Name returns the label of f, or its query if it has no label.
+func (f Favourite) Name() string {
+	if f.Label != "" {
+		return f.Label
+	}
+	return f.Query
+}
+
+// FromQueries returns unlabelled favourites for queries.
+func FromQueries(queries ...string) []Favourite {
+	if queries == nil {
+		return nil
+	}
+	favourites := make([]Favourite, len(queries))
+	for i, query := range queries {
+		favourites[i] = Favourite{Query: query}
+	}
+	return favourites
+}
+
+// Index returns the position of the favourite with query in favourites, or -1 if there is none.
+func Index(favourites []Favourite, query string) int {
+	for i, f := range favourites {
+		if f.Query == query {
+			return i
+		}
+	}
+	return -1
+}
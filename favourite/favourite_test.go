@@ -0,0 +1,25 @@
+package favourite
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFavourite_Name(t *testing.T) {
+	assert.Equal(t, "96049 2 24", Favourite{Query: "96049 2 24"}.Name())
+	assert.Equal(t, "Home → Work", Favourite{Query: "96049 2 24", Label: "Home → Work"}.Name())
+}
+
+func TestFromQueries(t *testing.T) {
+	assert.Nil(t, FromQueries())
+	assert.Equal(t, []Favourite{{Query: "96049"}, {Query: "81111 2"}}, FromQueries("96049", "81111 2"))
+}
+
+func TestIndex(t *testing.T) {
+	favourites := []Favourite{{Query: "96049"}, {Query: "81111 2", Label: "Work"}}
+	assert.Equal(t, 0, Index(favourites, "96049"))
+	assert.Equal(t, 1, Index(favourites, "81111 2"))
+	assert.Equal(t, -1, Index(favourites, "Work"))
+	assert.Equal(t, -1, Index(nil, "96049"))
+}
@@ -0,0 +1,316 @@
+package busetabot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"github.com/yi-jiayu/telegram-bot-api"
+
+	"github.com/yi-jiayu/bus-eta-bot/v4/favourite"
+	"github.com/yi-jiayu/bus-eta-bot/v4/telegram"
+)
+
+// Favourite is an eta query saved by a user, with an optional label.
+type Favourite = favourite.Favourite
+
+// MaxFavouriteLabelLength is the maximum number of characters in a favourite's label.
+const MaxFavouriteLabelLength = 32
+
+// Actions on the buttons of the favourites management message.
+const (
+	manageFavouriteETAs   = "e"
+	manageFavouriteUp     = "u"
+	manageFavouriteDown   = "d"
+	manageFavouriteRename = "r"
+	manageFavouriteDelete = "x"
+)
+
+const (
+	manageFavouritesIntro = "Tap a favourite to get etas for it, or use the buttons below it to move, rename or delete it."
+	noFavouritesText      = "You haven't set any favourites yet!"
+
+	// renameFavouritePrompt asks for a new label for a favourite. Replies to it are recognised by matching
+	// renameFavouritePromptRegexp against the text of the message being replied to.
+	renameFavouritePrompt = "Send me a new name for %s, or send - to remove its name."
+)
+
+var renameFavouritePromptRegexp = regexp.MustCompile(`^Send me a new name for (.+), or send - to remove its name\.$`)
+
+// newManageFavouriteButton returns a button on the favourites management message which performs action on the
+// favourite at position i. A hash of the query of the favourite is included so that a button on an outdated message
+// does not act on a different favourite.
+func newManageFavouriteButton(text, action string, i int, fav Favourite) telegram.InlineKeyboardButton {
+	data := CallbackData{
+		Type:   "favm",
+		Argstr: fmt.Sprintf("%s %d %s", action, i, favouriteHash(fav)),
+	}
+	JSON, _ := json.Marshal(data)
+	return telegram.InlineKeyboardButton{
+		Text:         text,
+		CallbackData: string(JSON),
+	}
+}
+
+// favouriteHash returns a short hash of the query of fav which fits in callback data.
+func favouriteHash(fav Favourite) string {
+	h := fnv.New32a()
+	h.Write([]byte(fav.Query))
+	return fmt.Sprintf("%08x", h.Sum32())
+}
+
+// newManageFavouritesMarkup returns the buttons for managing favourites. Each favourite has a button showing its name
+// which sends etas for it, followed by a row of buttons to move, rename and delete it. The last button sends the etas
+// for all of them.
+func newManageFavouritesMarkup(favourites []Favourite) telegram.InlineKeyboardMarkup {
+	var keyboard [][]telegram.InlineKeyboardButton
+	for i, fav := range favourites {
+		keyboard = append(keyboard, []telegram.InlineKeyboardButton{
+			newManageFavouriteButton(fmt.Sprintf("%d. %s", i+1, fav.Name()), manageFavouriteETAs, i, fav),
+		})
+		var row []telegram.InlineKeyboardButton
+		if i > 0 {
+			row = append(row, newManageFavouriteButton("⬆️", manageFavouriteUp, i, fav))
+		}
+		if i < len(favourites)-1 {
+			row = append(row, newManageFavouriteButton("⬇️", manageFavouriteDown, i, fav))
+		}
+		row = append(row,
+			newManageFavouriteButton("✏️ Rename", manageFavouriteRename, i, fav),
+			newManageFavouriteButton("🗑 Delete", manageFavouriteDelete, i, fav))
+		keyboard = append(keyboard, row)
+	}
+	if len(favourites) > 0 {
+		keyboard = append(keyboard, []telegram.InlineKeyboardButton{
+			newDashboardButton("📋 Dashboard", true),
+		})
+	}
+	return telegram.InlineKeyboardMarkup{
+		InlineKeyboard: keyboard,
+	}
+}
+
+// manageFavouritesText returns the text of the favourites management message.
+func manageFavouritesText(favourites []Favourite) string {
+	if len(favourites) == 0 {
+		return noFavouritesText
+	}
+	return manageFavouritesIntro
+}
+
+// sendManageFavouritesMessage sends a message for managing a user's favourites.
+func sendManageFavouritesMessage(ctx context.Context, bot *BusEtaBot, message *tgbotapi.Message, responses chan<- Response) {
+	go bot.LogEvent(ctx, message.From, CategoryCommand, ActionManageFavouritesCommand, message.Chat.Type)
+
+	favourites, err := bot.Users.GetUserFavourites(ctx, message.From.ID)
+	if err != nil {
+		responses <- notOk(err)
+		return
+	}
+	resp := telegram.SendMessageRequest{
+		ChatID: message.Chat.ID,
+		Text:   manageFavouritesText(favourites),
+	}
+	if len(favourites) > 0 {
+		resp.ReplyMarkup = newManageFavouritesMarkup(favourites)
+	}
+	responses <- ok(resp)
+}
+
+// ManageFavouritesCallbackHandler handles the buttons on the favourites management message.
+func ManageFavouritesCallbackHandler(ctx context.Context, bot *BusEtaBot, cbq *tgbotapi.CallbackQuery, responses chan<- Response) {
+	defer close(responses)
+
+	var data CallbackData
+	err := json.Unmarshal([]byte(cbq.Data), &data)
+	if err != nil {
+		responses <- notOk(errors.Wrap(err, "error unmarshalling callback data"))
+		return
+	}
+	fields := strings.Fields(data.Argstr)
+	if len(fields) != 3 {
+		responses <- notOk(errors.Errorf("invalid manage favourites callback argument %q", data.Argstr))
+		return
+	}
+	action, hash := fields[0], fields[2]
+	i, err := strconv.Atoi(fields[1])
+	if err != nil {
+		responses <- notOk(errors.Wrapf(err, "invalid favourite position %q", fields[1]))
+		return
+	}
+	go bot.LogEvent(ctx, cbq.From, CategoryCallback, ActionManageFavouritesCallback, action)
+
+	favourites, err := bot.Users.GetUserFavourites(ctx, cbq.From.ID)
+	if err != nil {
+		responses <- notOk(err)
+		return
+	}
+	if i < 0 || i >= len(favourites) || favouriteHash(favourites[i]) != hash {
+		editManageFavouritesMessage(cbq, favourites, "Your favourites have changed, here they are again.", responses)
+		return
+	}
+	fav := favourites[i]
+
+	var answer string
+	switch action {
+	case manageFavouriteETAs:
+		code, services, err := InferEtaQuery(fav.Query)
+		if err != nil {
+			responses <- notOk(errors.Wrapf(err, "invalid favourite %q", fav.Query))
+			return
+		}
+		sendETAMessage(ctx, bot, cbq, code, services, responses)
+		return
+	case manageFavouriteRename:
+		responses <- ok(telegram.SendMessageRequest{
+			ChatID:      cbq.Message.Chat.ID,
+			Text:        fmt.Sprintf(renameFavouritePrompt, fav.Query),
+			ReplyMarkup: telegram.NewForceReply(true),
+		})
+		responses <- ok(telegram.AnswerCallbackQueryRequest{
+			CallbackQueryID: cbq.ID,
+		})
+		return
+	case manageFavouriteUp, manageFavouriteDown:
+		j := i - 1
+		answer = fmt.Sprintf("Moved %s up.", fav.Name())
+		if action == manageFavouriteDown {
+			j = i + 1
+			answer = fmt.Sprintf("Moved %s down.", fav.Name())
+		}
+		if j < 0 || j >= len(favourites) {
+			editManageFavouritesMessage(cbq, favourites, "", responses)
+			return
+		}
+		favourites[i], favourites[j] = favourites[j], favourites[i]
+	case manageFavouriteDelete:
+		favourites = append(favourites[:i], favourites[i+1:]...)
+		answer = fmt.Sprintf("Deleted %s.", fav.Name())
+	default:
+		responses <- notOk(errors.Errorf("invalid manage favourites action %q", action))
+		return
+	}
+	err = bot.Users.SetUserFavourites(ctx, cbq.From.ID, favourites)
+	if err != nil {
+		responses <- notOk(errors.Wrap(err, "error updating user favourites"))
+		return
+	}
+	editManageFavouritesMessage(cbq, favourites, answer+" Send /favourites to update your keyboard.", responses)
+}
+
+// editManageFavouritesMessage updates the favourites management message a callback query came from and answers the
+// callback query.
+func editManageFavouritesMessage(cbq *tgbotapi.CallbackQuery, favourites []Favourite, answer string, responses chan<- Response) {
+	responses <- ok(telegram.EditMessageTextRequest{
+		ChatID:      cbq.Message.Chat.ID,
+		MessageID:   cbq.Message.MessageID,
+		Text:        manageFavouritesText(favourites),
+		ReplyMarkup: newManageFavouritesMarkup(favourites),
+	})
+	responses <- ok(telegram.AnswerCallbackQueryRequest{
+		CallbackQueryID: cbq.ID,
+		Text:            answer,
+	})
+}
+
+// renamedFavouriteQuery returns the query of the favourite being renamed if message is a reply to a rename prompt.
+func renamedFavouriteQuery(message *tgbotapi.Message) (string, bool) {
+	if message.ReplyToMessage == nil {
+		return "", false
+	}
+	m := renameFavouritePromptRegexp.FindStringSubmatch(message.ReplyToMessage.Text)
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
+
+// validateFavouriteLabel returns a message explaining why label cannot be used as the label of the favourite at
+// position i, or an empty string if it can.
+func validateFavouriteLabel(favourites []Favourite, i int, label string) string {
+	if utf8.RuneCountInString(label) > MaxFavouriteLabelLength {
+		return fmt.Sprintf("Oops, a name can be at most %d characters long.", MaxFavouriteLabelLength)
+	}
+	if strings.ContainsAny(label, "\n\r") {
+		return "Oops, a name must fit on one line."
+	}
+	// labels are sent back as text messages from the favourites keyboard, so they must not look like eta queries
+	if _, _, err := InferEtaQuery(label); err == nil {
+		return "Oops, a name can't start with a bus stop code."
+	}
+	for j, fav := range favourites {
+		if j != i && fav.Name() == label {
+			return "Oops, you already have a favourite with that name."
+		}
+	}
+	return ""
+}
+
+// renameFavourite handles a reply to a rename prompt by saving the reply as the label of the favourite with query.
+func renameFavourite(ctx context.Context, bot *BusEtaBot, message *tgbotapi.Message, query string) error {
+	go bot.LogEvent(ctx, message.From, CategoryMessage, ActionRenameFavouriteMessage, message.Chat.Type)
+
+	reply := telegram.SendMessageRequest{
+		ChatID: message.Chat.ID,
+	}
+	favourites, err := bot.Users.GetUserFavourites(ctx, message.From.ID)
+	if err != nil {
+		return err
+	}
+	label := strings.TrimSpace(message.Text)
+	if label == "-" {
+		label = ""
+	}
+	i := favourite.Index(favourites, query)
+	var invalid string
+	if i >= 0 {
+		invalid = validateFavouriteLabel(favourites, i, label)
+	}
+	switch {
+	case i < 0:
+		reply.Text = fmt.Sprintf("Oops, %s is no longer one of your favourites.", query)
+	case invalid != "":
+		reply.Text = invalid
+	default:
+		favourites[i].Label = label
+		err = bot.Users.SetUserFavourites(ctx, message.From.ID, favourites)
+		if err != nil {
+			return errors.Wrap(err, "error updating user favourites")
+		}
+		reply.Text = fmt.Sprintf("Renamed %s to %s!", query, label)
+		if label == "" {
+			reply.Text = fmt.Sprintf("Removed the name of %s.", query)
+		}
+		reply.ReplyMarkup = newShowFavouritesMarkup(favourites)
+	}
+	err = bot.TelegramService.Do(reply)
+	if err != nil {
+		return errors.Wrap(err, "error sending message")
+	}
+	return nil
+}
+
+// labelledFavouriteQuery returns the query of the user's favourite labelled text, which is what the favourites
+// keyboard sends when one of its buttons is tapped.
+func labelledFavouriteQuery(ctx context.Context, bot *BusEtaBot, userID int, text string) (string, bool) {
+	if bot.Users == nil {
+		return "", false
+	}
+	favourites, err := bot.Users.GetUserFavourites(ctx, userID)
+	if err != nil {
+		logError(ctx, err)
+		return "", false
+	}
+	for _, fav := range favourites {
+		if fav.Label != "" && fav.Label == text {
+			return fav.Query, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,347 @@
+package busetabot
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/kr/pretty"
+	"github.com/stretchr/testify/assert"
+	"github.com/yi-jiayu/telegram-bot-api"
+
+	"github.com/yi-jiayu/bus-eta-bot/v4/mocks"
+	"github.com/yi-jiayu/bus-eta-bot/v4/telegram"
+)
+
+func TestNewManageFavouritesMarkup(t *testing.T) {
+	favourites := []Favourite{{Query: "96049 2 24", Label: "Home"}, {Query: "81111"}}
+	expected := telegram.InlineKeyboardMarkup{
+		InlineKeyboard: [][]telegram.InlineKeyboardButton{
+			{
+				{Text: "1. Home", CallbackData: `{"t":"favm","a":"e 0 fe66b4cf"}`},
+			},
+			{
+				{Text: "⬇️", CallbackData: `{"t":"favm","a":"d 0 fe66b4cf"}`},
+				{Text: "✏️ Rename", CallbackData: `{"t":"favm","a":"r 0 fe66b4cf"}`},
+				{Text: "🗑 Delete", CallbackData: `{"t":"favm","a":"x 0 fe66b4cf"}`},
+			},
+			{
+				{Text: "2. 81111", CallbackData: `{"t":"favm","a":"e 1 3464c9db"}`},
+			},
+			{
+				{Text: "⬆️", CallbackData: `{"t":"favm","a":"u 1 3464c9db"}`},
+				{Text: "✏️ Rename", CallbackData: `{"t":"favm","a":"r 1 3464c9db"}`},
+				{Text: "🗑 Delete", CallbackData: `{"t":"favm","a":"x 1 3464c9db"}`},
+			},
+			{
+				{Text: "📋 Dashboard", CallbackData: `{"t":"dash","a":"new"}`},
+			},
+		},
+	}
+	assert.Equal(t, expected, newManageFavouritesMarkup(favourites))
+}
+
+func TestManageFavouritesCallbackHandler(t *testing.T) {
+	const userID = 1
+	home := Favourite{Query: "96049 2 24", Label: "Home"}
+	work := Favourite{Query: "81111"}
+	sameStop := Favourite{Query: "96049"}
+	type testCase struct {
+		Name               string
+		Data               string
+		Favourites         []Favourite
+		ExpectedFavourites []Favourite
+		Expected           []Response
+	}
+	testCases := []testCase{
+		{
+			Name:               "moves a favourite up",
+			Data:               `{"t":"favm","a":"u 1 3464c9db"}`,
+			Favourites:         []Favourite{home, work},
+			ExpectedFavourites: []Favourite{work, home},
+			Expected: []Response{
+				ok(telegram.EditMessageTextRequest{
+					ChatID:      1,
+					MessageID:   1,
+					Text:        manageFavouritesIntro,
+					ReplyMarkup: newManageFavouritesMarkup([]Favourite{work, home}),
+				}),
+				ok(telegram.AnswerCallbackQueryRequest{
+					CallbackQueryID: "1",
+					Text:            "Moved 81111 up. Send /favourites to update your keyboard.",
+				}),
+			},
+		},
+		{
+			Name:               "moves a favourite down",
+			Data:               `{"t":"favm","a":"d 0 fe66b4cf"}`,
+			Favourites:         []Favourite{home, work},
+			ExpectedFavourites: []Favourite{work, home},
+			Expected: []Response{
+				ok(telegram.EditMessageTextRequest{
+					ChatID:      1,
+					MessageID:   1,
+					Text:        manageFavouritesIntro,
+					ReplyMarkup: newManageFavouritesMarkup([]Favourite{work, home}),
+				}),
+				ok(telegram.AnswerCallbackQueryRequest{
+					CallbackQueryID: "1",
+					Text:            "Moved Home down. Send /favourites to update your keyboard.",
+				}),
+			},
+		},
+		{
+			Name:               "deletes a favourite",
+			Data:               `{"t":"favm","a":"x 0 fe66b4cf"}`,
+			Favourites:         []Favourite{home, work},
+			ExpectedFavourites: []Favourite{work},
+			Expected: []Response{
+				ok(telegram.EditMessageTextRequest{
+					ChatID:      1,
+					MessageID:   1,
+					Text:        manageFavouritesIntro,
+					ReplyMarkup: newManageFavouritesMarkup([]Favourite{work}),
+				}),
+				ok(telegram.AnswerCallbackQueryRequest{
+					CallbackQueryID: "1",
+					Text:            "Deleted Home. Send /favourites to update your keyboard.",
+				}),
+			},
+		},
+		{
+			Name:               "deletes the last favourite",
+			Data:               `{"t":"favm","a":"x 0 3464c9db"}`,
+			Favourites:         []Favourite{work},
+			ExpectedFavourites: []Favourite{},
+			Expected: []Response{
+				ok(telegram.EditMessageTextRequest{
+					ChatID:      1,
+					MessageID:   1,
+					Text:        "You haven't set any favourites yet!",
+					ReplyMarkup: telegram.InlineKeyboardMarkup{},
+				}),
+				ok(telegram.AnswerCallbackQueryRequest{
+					CallbackQueryID: "1",
+					Text:            "Deleted 81111. Send /favourites to update your keyboard.",
+				}),
+			},
+		},
+		{
+			Name:       "asks for a new name",
+			Data:       `{"t":"favm","a":"r 0 fe66b4cf"}`,
+			Favourites: []Favourite{home, work},
+			Expected: []Response{
+				ok(telegram.SendMessageRequest{
+					ChatID:      1,
+					Text:        "Send me a new name for 96049 2 24, or send - to remove its name.",
+					ReplyMarkup: telegram.NewForceReply(true),
+				}),
+				ok(telegram.AnswerCallbackQueryRequest{
+					CallbackQueryID: "1",
+				}),
+			},
+		},
+		{
+			Name:       "shows the current favourites when the message is outdated",
+			Data:       `{"t":"favm","a":"x 1 f4bece44"}`,
+			Favourites: []Favourite{home, work},
+			Expected: []Response{
+				ok(telegram.EditMessageTextRequest{
+					ChatID:      1,
+					MessageID:   1,
+					Text:        manageFavouritesIntro,
+					ReplyMarkup: newManageFavouritesMarkup([]Favourite{home, work}),
+				}),
+				ok(telegram.AnswerCallbackQueryRequest{
+					CallbackQueryID: "1",
+					Text:            "Your favourites have changed, here they are again.",
+				}),
+			},
+		},
+		{
+			Name:       "shows the current favourites when another favourite at the same bus stop has taken its place",
+			Data:       `{"t":"favm","a":"x 0 fe66b4cf"}`,
+			Favourites: []Favourite{sameStop, home},
+			Expected: []Response{
+				ok(telegram.EditMessageTextRequest{
+					ChatID:      1,
+					MessageID:   1,
+					Text:        manageFavouritesIntro,
+					ReplyMarkup: newManageFavouritesMarkup([]Favourite{sameStop, home}),
+				}),
+				ok(telegram.AnswerCallbackQueryRequest{
+					CallbackQueryID: "1",
+					Text:            "Your favourites have changed, here they are again.",
+				}),
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			m := mocks.NewMockUserRepository(ctrl)
+			m.EXPECT().GetUserFavourites(gomock.Any(), userID).Return(append([]Favourite(nil), tc.Favourites...), nil)
+			if tc.ExpectedFavourites != nil {
+				m.EXPECT().SetUserFavourites(gomock.Any(), userID, tc.ExpectedFavourites).Return(nil)
+			}
+			bot := &BusEtaBot{
+				Users: m,
+			}
+			responses := make(chan Response, ResponseBufferSize)
+			go ManageFavouritesCallbackHandler(context.TODO(), bot, newCallbackQueryFromMessage(tc.Data), responses)
+			actual, err := collectResponsesWithTimeout(responses, 5*time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !assert.Equal(t, tc.Expected, actual) {
+				pretty.Println(actual)
+			}
+		})
+	}
+}
+
+func TestTextHandler_RenameFavourite(t *testing.T) {
+	const userID = 1
+	newReply := func(query, text string) *tgbotapi.Message {
+		message := MockMessageWithText(text)
+		message.ReplyToMessage = MockMessageWithText("Send me a new name for " + query + ", or send - to remove its name.")
+		return message
+	}
+	home := Favourite{Query: "96049 2 24", Label: "Home"}
+	work := Favourite{Query: "81111"}
+	type testCase struct {
+		Name               string
+		Message            *tgbotapi.Message
+		Favourites         []Favourite
+		ExpectedFavourites []Favourite
+		Expected           []telegram.Request
+	}
+	testCases := []testCase{
+		{
+			Name:               "renames a favourite",
+			Message:            newReply("81111", " Work "),
+			Favourites:         []Favourite{home, work},
+			ExpectedFavourites: []Favourite{home, {Query: "81111", Label: "Work"}},
+			Expected: []telegram.Request{
+				telegram.SendMessageRequest{
+					ChatID:      1,
+					Text:        "Renamed 81111 to Work!",
+					ReplyMarkup: newShowFavouritesMarkup([]Favourite{home, {Query: "81111", Label: "Work"}}),
+				},
+			},
+		},
+		{
+			Name:               "removes the name of a favourite",
+			Message:            newReply("96049 2 24", "-"),
+			Favourites:         []Favourite{home, work},
+			ExpectedFavourites: []Favourite{{Query: "96049 2 24"}, work},
+			Expected: []telegram.Request{
+				telegram.SendMessageRequest{
+					ChatID:      1,
+					Text:        "Removed the name of 96049 2 24.",
+					ReplyMarkup: newShowFavouritesMarkup([]Favourite{{Query: "96049 2 24"}, work}),
+				},
+			},
+		},
+		{
+			Name:       "rejects a name which looks like an eta query",
+			Message:    newReply("81111", "12345 Work"),
+			Favourites: []Favourite{home, work},
+			Expected: []telegram.Request{
+				telegram.SendMessageRequest{
+					ChatID: 1,
+					Text:   "Oops, a name can't start with a bus stop code.",
+				},
+			},
+		},
+		{
+			Name:       "rejects a name used by another favourite",
+			Message:    newReply("81111", "Home"),
+			Favourites: []Favourite{home, work},
+			Expected: []telegram.Request{
+				telegram.SendMessageRequest{
+					ChatID: 1,
+					Text:   "Oops, you already have a favourite with that name.",
+				},
+			},
+		},
+		{
+			Name:       "rejects a name which is too long",
+			Message:    newReply("81111", "This name is much too long to fit on a button"),
+			Favourites: []Favourite{home, work},
+			Expected: []telegram.Request{
+				telegram.SendMessageRequest{
+					ChatID: 1,
+					Text:   "Oops, a name can be at most 32 characters long.",
+				},
+			},
+		},
+		{
+			Name:       "when the favourite has been deleted",
+			Message:    newReply("17179", "Gym"),
+			Favourites: []Favourite{home, work},
+			Expected: []telegram.Request{
+				telegram.SendMessageRequest{
+					ChatID: 1,
+					Text:   "Oops, 17179 is no longer one of your favourites.",
+				},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			m := mocks.NewMockUserRepository(ctrl)
+			m.EXPECT().GetUserFavourites(gomock.Any(), userID).Return(append([]Favourite(nil), tc.Favourites...), nil)
+			if tc.ExpectedFavourites != nil {
+				m.EXPECT().SetUserFavourites(gomock.Any(), userID, tc.ExpectedFavourites).Return(nil)
+			}
+			tg := &mockTelegramService{}
+			bot := &BusEtaBot{
+				Users:           m,
+				TelegramService: tg,
+			}
+			err := TextHandler(context.Background(), bot, tc.Message)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if actual := tg.Requests; !assert.Equal(t, tc.Expected, actual) {
+				pretty.Println(actual)
+			}
+		})
+	}
+}
+
+func TestTextHandler_FavouriteLabel(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	m := mocks.NewMockUserRepository(ctrl)
+	m.EXPECT().GetUserFavourites(gomock.Any(), 1).Return([]Favourite{{Query: "96049", Label: "Home"}}, nil)
+	tg := &mockTelegramService{}
+	bot := &BusEtaBot{
+		Datamall: mockDatamall{},
+		BusStops: mockBusStopRepository{
+			BusStop: &BusStop{
+				BusStopCode: "96049",
+				RoadName:    "Upp Changi Rd East",
+				Description: "Opp Tropicana Condo",
+			},
+		},
+		NowFunc: func() time.Time {
+			return time.Time{}
+		},
+		Users:           m,
+		TelegramService: tg,
+	}
+	err := TextHandler(context.Background(), bot, MockMessageWithText("Home"))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !assert.Len(t, tg.Requests, 1) {
+		return
+	}
+	assert.Contains(t, tg.Requests[0].(telegram.SendMessageRequest).Text, "*Opp Tropicana Condo (96049)*")
+}
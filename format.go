@@ -2,6 +2,7 @@ package busetabot
 
 import (
 	"bytes"
+	"fmt"
 	"regexp"
 	"sort"
 	"strconv"
@@ -21,17 +22,28 @@ var trimTrailingLettersRegexp = regexp.MustCompile("[^0-9]$")
 
 var (
 	funcMap = map[string]interface{}{
-		"join":          strings.Join,
-		"until":         minutesUntil,
-		"arrivingBuses": arrivingBuses,
-		"sortByArrival": sortByArrival,
-		"sortByService": sortByService,
-		"inSGT":         inSGT,
-		"otherServices": otherServices,
-		"take":          take,
+		"join":           strings.Join,
+		"until":          minutesUntil,
+		"arrivingBuses":  arrivingBuses,
+		"sortByArrival":  sortByArrival,
+		"sortByService":  sortByService,
+		"inSGT":          inSGT,
+		"clockInSGT":     clockInSGT,
+		"arrivalClock":   arrivalClock,
+		"otherServices":  otherServices,
+		"take":           take,
+		"lastBuses":      lastBuses,
+		"escapeMarkdown": escapeMarkdown,
 	}
 )
 
+var markdownReplacer = strings.NewReplacer("_", `\_`, "*", `\*`, "`", "\\`", "[", `\[`)
+
+// escapeMarkdown escapes the characters in s which would otherwise start an entity in a markdown message.
+func escapeMarkdown(s string) string {
+	return markdownReplacer.Replace(s)
+}
+
 var (
 	featuresFormatter = TemplateFormatter{
 		template: template.Must(template.New("message.tmpl").
@@ -51,6 +63,24 @@ var (
 				"templates/partials/footer.tmpl",
 				"templates/partials/services_count.tmpl")),
 	}
+	featuresClockFormatter = TemplateFormatter{
+		template: template.Must(template.New("message.tmpl").
+			Funcs(funcMap).
+			ParseFiles("templates/message.tmpl",
+				"templates/partials/header.tmpl",
+				"templates/partials/features_clock.tmpl",
+				"templates/partials/services_count.tmpl",
+				"templates/partials/footer.tmpl")),
+	}
+	summaryClockFormatter = TemplateFormatter{
+		template: template.Must(template.New("message.tmpl").
+			Funcs(funcMap).
+			ParseFiles("templates/message.tmpl",
+				"templates/partials/header.tmpl",
+				"templates/partials/summary_clock.tmpl",
+				"templates/partials/footer.tmpl",
+				"templates/partials/services_count.tmpl")),
+	}
 )
 
 var (
@@ -58,6 +88,13 @@ var (
 		FormatterSummary:  summaryFormatter,
 		FormatterFeatures: featuresFormatter,
 	}
+
+	// ClockFormatters contains the same formatters as Formatters, but showing the time each bus will arrive instead
+	// of the number of minutes until it arrives.
+	ClockFormatters = map[string]Formatter{
+		FormatterSummary:  summaryClockFormatter,
+		FormatterFeatures: featuresClockFormatter,
+	}
 )
 
 type ArrivingBus struct {
@@ -98,6 +135,14 @@ func minutesUntil(now time.Time, then time.Time) string {
 	return strconv.Itoa(int(then.Sub(now).Minutes()))
 }
 
+// arrivalClock returns the time of day in Singapore when a bus arrives at t.
+func arrivalClock(t time.Time) string {
+	if t.IsZero() {
+		return "?"
+	}
+	return clockInSGT(t)
+}
+
 func arrivingBuses(services []datamall.Service) []ArrivingBus {
 	var buses []ArrivingBus
 	for _, service := range services {
@@ -157,6 +202,35 @@ func inSGT(t time.Time) string {
 	return t.In(sgt).Format("Mon, 02 Jan 06 15:04 MST")
 }
 
+func clockInSGT(t time.Time) string {
+	return t.In(sgt).Format("15:04")
+}
+
+// lastBuses returns messages such as "Last bus 96 left at 23:41, first bus at 05:45." for each service at a bus stop
+// which has stopped running for the day at now. Only services in serviceNos are included unless it is empty.
+func lastBuses(stop BusStop, serviceNos []string, now time.Time) []string {
+	var messages []string
+	for _, serviceNo := range stop.Services {
+		if len(serviceNos) > 0 && !contains(serviceNos, serviceNo) {
+			continue
+		}
+		timings, ok := stop.Timings[serviceNo]
+		if !ok {
+			continue
+		}
+		lastBus, firstBus, ok := timings.ended(now)
+		if !ok {
+			continue
+		}
+		message := fmt.Sprintf("Last bus %s left at %s", serviceNo, clockInSGT(lastBus))
+		if !firstBus.IsZero() {
+			message += fmt.Sprintf(", first bus at %s", clockInSGT(firstBus))
+		}
+		messages = append(messages, message+".")
+	}
+	return messages
+}
+
 func otherServices(stop BusStop, services []datamall.Service) []string {
 	var others []string
 	contains := func(serviceNo string, services []datamall.Service) bool {
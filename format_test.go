@@ -156,6 +156,25 @@ func TestSummaryFormatter(t *testing.T) {
 			ETA:      etaErrorNotNil,
 			Expected: "*Opp Tropicana Condo (96049)*\nUpp Changi Rd East\nError fetching ETAs from LTA DataMall!\n\n_Last updated on Sun, 24 Nov 74 00:00 SGT_",
 		},
+		{
+			Name: "services have stopped running",
+			ETA: ETA{
+				BusStop: BusStop{
+					BusStopCode: "96049",
+					RoadName:    "Upp Changi Rd East",
+					Description: "Opp Tropicana Condo",
+					Services:    []string{"2", "24", "5"},
+					Timings: map[string]BusTimings{
+						"2":  {WeekdayFirstBus: "0530", WeekdayLastBus: "2341", SaturdayFirstBus: "0530", SaturdayLastBus: "2341", SundayFirstBus: "0600", SundayLastBus: "2341"},
+						"24": {WeekdayFirstBus: "0545", WeekdayLastBus: "0005", SaturdayFirstBus: "0545", SaturdayLastBus: "0005", SundayFirstBus: "0545", SundayLastBus: "0005"},
+						"5":  {WeekdayFirstBus: "0530", WeekdayLastBus: "2300", SaturdayFirstBus: "0530", SaturdayLastBus: "2300", SundayFirstBus: "0530", SundayLastBus: "2300"},
+					},
+				},
+				Now:        baseTime,
+				ServiceNos: []string{"2", "24"},
+			},
+			Expected: "*Opp Tropicana Condo (96049)*\nUpp Changi Rd East\nNo ETAs available.\nLast bus 2 left at 23:41, first bus at 06:00.\n\n_Last updated on Sun, 24 Nov 74 00:00 SGT_",
+		},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.Name, func(t *testing.T) {
@@ -206,6 +225,36 @@ func TestFeaturesFormatter(t *testing.T) {
 	}
 }
 
+func TestClockFormatters(t *testing.T) {
+	testCases := []struct {
+		Name      string
+		Formatter string
+		Expected  string
+	}{
+		{
+			Name:      "summary",
+			Formatter: FormatterSummary,
+			Expected:  "*Opp Tropicana Condo (96049)*\nUpp Changi Rd East\n```\n| Svc  |  Nxt  |  2nd  |  3rd  |\n|------|-------|-------|-------|\n| 2    | 23:58 | 00:10 | 00:36 |\n| 24   | 00:00 | 00:03 | 00:06 |\n```\nShowing 2 out of 2 services for this bus stop.\n\n_Last updated on Sun, 24 Nov 74 00:00 SGT_",
+		},
+		{
+			Name:      "features",
+			Formatter: FormatterFeatures,
+			Expected:  "*Opp Tropicana Condo (96049)*\nUpp Changi Rd East\n```\nSvc     Eta  Sea  Typ  Fea\n---     ---  ---  ---  ---\n2     23:58  SDA   DD     \n24    00:00  SEA   SD     \n24    00:03  SDA   DD  WAB\n24    00:06  LSD   BD     \n2     00:10  SDA   DD     \n2     00:36  LSD   BD  WAB\n```\nShowing 2 out of 2 services for this bus stop.\n\n_Last updated on Sun, 24 Nov 74 00:00 SGT_",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			actual, err := ClockFormatters[tc.Formatter].Format(etaServicesPopulated)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !assert.Equal(t, tc.Expected, actual) {
+				pretty.Println(actual)
+			}
+		})
+	}
+}
+
 func TestMessageTemplate(t *testing.T) {
 	type testCase struct {
 		Name     string
@@ -385,6 +434,15 @@ func TestFooterTemplate(t *testing.T) {
 			ETA:      etaServicesIsEmpty,
 			Expected: "\n_Last updated on Sun, 24 Nov 74 00:00 SGT_",
 		},
+		{
+			Name: "services are stale",
+			ETA: ETA{
+				Now:       baseTime,
+				Error:     "LTA DataMall could be down at the moment (status code 503)",
+				StaleAsOf: baseTime.Add(-5 * time.Minute),
+			},
+			Expected: "\n_Stale as of 23:55. LTA DataMall could be down at the moment (status code 503)._\n_Last updated on Sun, 24 Nov 74 00:00 SGT_",
+		},
 	}
 	formatter := TemplateFormatter{
 		template: template.Must(template.New("footer_test.tmpl").
@@ -1,8 +1,13 @@
 package busetabot
 
+import "math"
+
 const (
 	EquatorialLatitude  = 110574.0
 	EquatorialLongitude = 111320.0
+
+	// EarthRadius is the mean radius of the earth in metres.
+	EarthRadius = 6371008.8
 )
 
 // EuclideanDistanceAtEquator returns the approximate squared distance between two points near the equator.
@@ -11,3 +16,13 @@ func SquaredEuclideanDistanceAtEquator(lat0, lon0, lat1, lon1 float64) float64 {
 	dLon := EquatorialLongitude * (lon0 - lon1)
 	return dLat*dLat + dLon*dLon
 }
+
+// HaversineDistance returns the great-circle distance in metres between two points.
+func HaversineDistance(lat0, lon0, lat1, lon1 float64) float64 {
+	phi0 := lat0 * math.Pi / 180
+	phi1 := lat1 * math.Pi / 180
+	dPhi := (lat1 - lat0) * math.Pi / 180
+	dLambda := (lon1 - lon0) * math.Pi / 180
+	a := math.Sin(dPhi/2)*math.Sin(dPhi/2) + math.Cos(phi0)*math.Cos(phi1)*math.Sin(dLambda/2)*math.Sin(dLambda/2)
+	return 2 * EarthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
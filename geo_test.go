@@ -26,3 +26,38 @@ func TestSquaredEuclideanDistanceAtEquator(t *testing.T) {
 		})
 	}
 }
+
+func TestHaversineDistance(t *testing.T) {
+	testCases := []struct {
+		Name                   string
+		Lat0, Lon0, Lat1, Lon1 float64
+		Expected               float64
+	}{
+		{
+			Name: "same point",
+			Lat0: 1.383764, Lon0: 103.7583, Lat1: 1.383764, Lon1: 103.7583,
+			Expected: 0,
+		},
+		{
+			Name: "one degree of latitude",
+			Lat0: 1, Lon0: 103, Lat1: 2, Lon1: 103,
+			Expected: 111195,
+		},
+		{
+			Name: "one degree of longitude away from the equator",
+			Lat0: 60, Lon0: 103, Lat1: 60, Lon1: 104,
+			Expected: 55597,
+		},
+		{
+			Name: "across singapore",
+			Lat0: 1.383764, Lon0: 103.7583, Lat1: 1.29684825487647, Lon1: 103.85253591654006,
+			Expected: 14253,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			actual := HaversineDistance(tc.Lat0, tc.Lon0, tc.Lat1, tc.Lon1)
+			assert.InDelta(t, tc.Expected, actual, 1)
+		})
+	}
+}
@@ -16,6 +16,14 @@ const NearbyBusStopsRadius = 1000.0
 
 const InlineQueryResultsLimit = 50
 
+// Inline query results for nearby bus stops, favourites and recently used bus stops have one of these suffixes in their
+// IDs, so that choosing them can be told apart from choosing a search result.
+const (
+	inlineResultNearby    = "geo"
+	inlineResultFavourite = "fav"
+	inlineResultRecent    = "recent"
+)
+
 type StreetViewProvider interface {
 	GetPhotoURLByLocation(lat, lon float64, width, height int) (string, error)
 }
@@ -34,11 +42,64 @@ func GetNearbyInlineQueryResults(ctx context.Context, streetView StreetViewProvi
 	return results, nil
 }
 
-// InlineQueryHandler handles inline queries
+// GetPersonalInlineQueryResults returns inline query results for a user's favourites followed by the bus stops they
+// recently requested etas for. Favourites and recently used bus stops which are not available are left out.
+func GetPersonalInlineQueryResults(ctx context.Context, bot *BusEtaBot, userID int) ([]telegram.InlineQueryResult, error) {
+	var results []telegram.InlineQueryResult
+	// bus stops which are already shown as a favourite without any services are not repeated as recently used
+	shown := make(map[string]bool)
+	if bot.Users != nil {
+		favourites, err := bot.Users.GetUserFavourites(ctx, userID)
+		if err != nil {
+			logError(ctx, err)
+		}
+		for _, fav := range favourites {
+			code, services, err := InferEtaQuery(fav.Query)
+			if err != nil {
+				continue
+			}
+			stop := bot.BusStops.Get(code)
+			if stop == nil {
+				continue
+			}
+			result, err := buildInlineQueryResultFavourite(bot.StreetView, *stop, fav, services)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, result)
+			if len(services) == 0 {
+				shown[code] = true
+			}
+		}
+	}
+	for _, code := range bot.recentBusStops(ctx, userID) {
+		if shown[code] {
+			continue
+		}
+		stop := bot.BusStops.Get(code)
+		if stop == nil {
+			continue
+		}
+		result, err := buildInlineQueryResult(bot.StreetView, *stop)
+		if err != nil {
+			return nil, err
+		}
+		result.ID = code + " " + inlineResultRecent
+		results = append(results, result)
+		shown[code] = true
+	}
+	if len(results) > InlineQueryResultsLimit {
+		results = results[:InlineQueryResultsLimit]
+	}
+	return results, nil
+}
+
+// InlineQueryHandler handles inline queries. An empty query returns the bus stops near the user's location if it is
+// available, otherwise the user's favourites and recently used bus stops.
 func InlineQueryHandler(ctx context.Context, bot *BusEtaBot, ilq *tgbotapi.InlineQuery) error {
 	query := ilq.Query
 	var err error
-	var showingNearby bool
+	var showingNearby, showingPersonal bool
 	results := make([]telegram.InlineQueryResult, 0)
 	if query == "" && ilq.Location != nil {
 		showingNearby = true
@@ -47,8 +108,18 @@ func InlineQueryHandler(ctx context.Context, bot *BusEtaBot, ilq *tgbotapi.Inlin
 		if err != nil {
 			return err
 		}
-	} else {
-		showingNearby = false
+	}
+	if query == "" && ilq.Location == nil {
+		personal, err := GetPersonalInlineQueryResults(ctx, bot, ilq.From.ID)
+		if err != nil {
+			return err
+		}
+		if len(personal) > 0 {
+			showingPersonal = true
+			results = personal
+		}
+	}
+	if !showingNearby && !showingPersonal {
 		busStops := bot.BusStops.Search(ctx, query, InlineQueryResultsLimit)
 		for _, bs := range busStops {
 			result, err := buildInlineQueryResult(bot.StreetView, bs)
@@ -68,10 +139,14 @@ func InlineQueryHandler(ctx context.Context, bot *BusEtaBot, ilq *tgbotapi.Inlin
 		InlineQueryID: ilq.ID,
 		Results:       results,
 		CacheTime:     cacheTime,
+		IsPersonal:    showingPersonal,
 	}
-	if showingNearby {
+	switch {
+	case showingNearby:
 		go bot.LogEvent(ctx, ilq.From, CategoryInlineQuery, ActionNewNearbyInlineQuery, "")
-	} else {
+	case showingPersonal:
+		go bot.LogEvent(ctx, ilq.From, CategoryInlineQuery, ActionNewPersonalInlineQuery, "")
+	default:
 		go bot.LogEvent(ctx, ilq.From, CategoryInlineQuery, ActionNewInlineQuery, "")
 	}
 	err = bot.TelegramService.Do(answer)
@@ -93,7 +168,7 @@ func buildInlineQueryResult(streetView StreetViewProvider, bs BusStop) (telegram
 			}
 		}
 	}
-	markup := NewETAMessageReplyMarkup(bs.BusStopCode, nil, "", true)
+	markup := NewETAMessageReplyMarkup(bs.BusStopCode, nil, ETAMessageOptions{Inline: true})
 	result := telegram.InlineQueryResultArticle{
 		ID:          bs.BusStopCode,
 		Title:       fmt.Sprintf("%s (%s)", bs.Description, bs.BusStopCode),
@@ -113,37 +188,86 @@ func buildInlineQueryResultGeo(streetView StreetViewProvider, stop NearbyBusStop
 	if err != nil {
 		return telegram.InlineQueryResultArticle{}, err
 	}
-	result.ID = stop.BusStopCode + " geo"
+	result.ID = stop.BusStopCode + " " + inlineResultNearby
 	result.Description = fmt.Sprintf("%.0f m away", stop.Distance)
 	return result, nil
 }
 
+// buildInlineQueryResultFavourite returns an inline query result for one of a user's favourites at stop, which only
+// shows etas for services if it is not empty.
+func buildInlineQueryResultFavourite(streetView StreetViewProvider, stop BusStop, fav Favourite, services []string) (telegram.InlineQueryResultArticle, error) {
+	result, err := buildInlineQueryResult(streetView, stop)
+	if err != nil {
+		return telegram.InlineQueryResultArticle{}, err
+	}
+	result.ID = fav.Query + " " + inlineResultFavourite
+	var description []string
+	if fav.Label != "" {
+		result.Title = "⭐ " + fav.Label
+		description = append(description, fmt.Sprintf("%s (%s)", stop.Description, stop.BusStopCode))
+	} else {
+		result.Title = "⭐ " + result.Title
+	}
+	if len(services) > 0 {
+		description = append(description, "Services "+strings.Join(services, ", "))
+	}
+	if len(description) > 0 {
+		result.Description = strings.Join(description, " · ")
+	}
+	result.ReplyMarkup = NewETAMessageReplyMarkup(stop.BusStopCode, services, ETAMessageOptions{Inline: true})
+	return result, nil
+}
+
+// parseInlineResultID returns the eta query of an inline query result and the suffix of its ID showing what kind of
+// result it was, if any.
+func parseInlineResultID(ID string) (code string, services []string, kind string) {
+	tokens := strings.Fields(ID)
+	if len(tokens) == 0 {
+		return "", nil, ""
+	}
+	if n := len(tokens); n > 1 {
+		switch tokens[n-1] {
+		case inlineResultNearby, inlineResultFavourite, inlineResultRecent:
+			kind = tokens[n-1]
+			tokens = tokens[:n-1]
+		}
+	}
+	return tokens[0], tokens[1:], kind
+}
+
 // ChosenInlineResultHandler handles a chosen inline result
 func ChosenInlineResultHandler(ctx context.Context, bot *BusEtaBot, cir *tgbotapi.ChosenInlineResult) error {
-	tokens := strings.Split(cir.ResultID, " ")
-	busStopID := tokens[0]
+	busStopID, services, kind := parseInlineResultID(cir.ResultID)
+	if len(services) == 0 {
+		services = nil
+	}
 
-	eta := NewETA(ctx, bot.BusStops, bot.Datamall, ETARequest{
+	options := bot.etaMessageOptions()
+	options.Inline = true
+	message, err := bot.etaMessages().Message(ctx, ETARequest{
 		UserID:   cir.From.ID,
 		Time:     bot.NowFunc(),
 		Code:     busStopID,
-		Services: nil,
-	})
-	text, err := summaryFormatter.Format(eta)
+		Services: services,
+	}, options)
 	if err != nil {
 		return err
 	}
-	markup := NewETAMessageReplyMarkup(busStopID, nil, "", true)
 	reply := telegram.EditMessageTextRequest{
 		InlineMessageID: cir.InlineMessageID,
-		Text:            text,
-		ParseMode:       "markdown",
-		ReplyMarkup:     markup,
+		Text:            message.Text,
+		ParseMode:       message.ParseMode,
+		ReplyMarkup:     message.ReplyMarkup,
 	}
 
-	if len(tokens) > 1 && tokens[1] == "geo" {
+	switch kind {
+	case inlineResultNearby:
 		go bot.LogEvent(ctx, cir.From, CategoryChosenInlineResult, ActionChosenNearbyInlineResult, "")
-	} else {
+	case inlineResultFavourite:
+		go bot.LogEvent(ctx, cir.From, CategoryChosenInlineResult, ActionChosenFavouriteInlineResult, "")
+	case inlineResultRecent:
+		go bot.LogEvent(ctx, cir.From, CategoryChosenInlineResult, ActionChosenRecentInlineResult, "")
+	default:
 		go bot.LogEvent(ctx, cir.From, CategoryChosenInlineResult, ActionChosenInlineResult, "")
 	}
 
@@ -151,5 +275,6 @@ func ChosenInlineResultHandler(ctx context.Context, bot *BusEtaBot, cir *tgbotap
 	if err != nil {
 		return errors.Wrap(err, "error updating inline query after chosen inline result")
 	}
+	bot.rememberBusStop(ctx, cir.From.ID, busStopID)
 	return nil
 }
@@ -5,10 +5,12 @@ import (
 	"testing"
 	"time"
 
+	"github.com/golang/mock/gomock"
 	"github.com/kr/pretty"
 	"github.com/stretchr/testify/assert"
 	"github.com/yi-jiayu/telegram-bot-api"
 
+	"github.com/yi-jiayu/bus-eta-bot/v4/mocks"
 	"github.com/yi-jiayu/bus-eta-bot/v4/telegram"
 )
 
@@ -27,6 +29,21 @@ func (s *mockTelegramService) Do(request telegram.Request) error {
 	return nil
 }
 
+// mockRecentBusStopRepository is a RecentBusStopRepository which returns Codes and records the bus stops added to it.
+type mockRecentBusStopRepository struct {
+	Codes []string
+	Added []string
+}
+
+func (r *mockRecentBusStopRepository) AddRecentBusStop(ctx context.Context, userID int, code string, t time.Time) error {
+	r.Added = append(r.Added, code)
+	return nil
+}
+
+func (r *mockRecentBusStopRepository) GetRecentBusStops(ctx context.Context, userID int) ([]string, error) {
+	return r.Codes, nil
+}
+
 func TestInlineQueryHandler(t *testing.T) {
 	busStops := []BusStop{
 		{
@@ -152,7 +169,7 @@ func TestInlineQueryHandler(t *testing.T) {
 						telegram.InlineQueryResultArticle{
 							ID:                  "96041 geo",
 							Title:               "Bef Tropicana Condo (96041)",
-							Description:         "55 m away",
+							Description:         "56 m away",
 							ThumbURL:            "https://maps.googleapis.com/maps/api/streetview?key=API_KEY&location=1.340415%2C103.961279&size=100x100",
 							InputMessageContent: telegram.InputTextMessageContent{MessageText: "*Bef Tropicana Condo (96041)*\nUpp Changi Rd East\n`Fetching etas...`", ParseMode: "markdown"},
 							ReplyMarkup: telegram.InlineKeyboardMarkup{
@@ -272,6 +289,114 @@ func TestInlineQueryHandler(t *testing.T) {
 	}
 }
 
+func TestInlineQueryHandler_Personal(t *testing.T) {
+	busStops := NewInMemoryBusStopRepository([]BusStop{
+		{BusStopCode: "96041", RoadName: "Upp Changi Rd East", Description: "Bef Tropicana Condo"},
+		{BusStopCode: "96049", RoadName: "Upp Changi Rd East", Description: "Opp Tropicana Condo"},
+	}, nil)
+	newResult := func(ID, title, description, code string, services []string) telegram.InlineQueryResultArticle {
+		stop := busStops.Get(code)
+		return telegram.InlineQueryResultArticle{
+			ID:          ID,
+			Title:       title,
+			Description: description,
+			InputMessageContent: telegram.InputTextMessageContent{
+				MessageText: "*" + stop.Description + " (" + code + ")*\n" + stop.RoadName + "\n`Fetching etas...`",
+				ParseMode:   "markdown",
+			},
+			ReplyMarkup: NewETAMessageReplyMarkup(code, services, ETAMessageOptions{Inline: true}),
+		}
+	}
+	type testCase struct {
+		Name       string
+		Favourites []Favourite
+		Recent     []string
+		Expected   telegram.AnswerInlineQueryRequest
+	}
+	testCases := []testCase{
+		{
+			Name:       "shows favourites followed by recently used bus stops",
+			Favourites: []Favourite{{Query: "96049 24", Label: "Home"}, {Query: "96041"}, {Query: "12345"}},
+			Recent:     []string{"96041", "96049", "99999"},
+			Expected: telegram.AnswerInlineQueryRequest{
+				InlineQueryID: "1",
+				Results: []telegram.InlineQueryResult{
+					newResult("96049 24 fav", "⭐ Home", "Opp Tropicana Condo (96049) · Services 24", "96049", []string{"24"}),
+					newResult("96041 fav", "⭐ Bef Tropicana Condo (96041)", "Upp Changi Rd East", "96041", nil),
+					newResult("96049 recent", "Opp Tropicana Condo (96049)", "Upp Changi Rd East", "96049", nil),
+				},
+				IsPersonal: true,
+			},
+		},
+		{
+			Name:   "shows recently used bus stops without favourites",
+			Recent: []string{"96049"},
+			Expected: telegram.AnswerInlineQueryRequest{
+				InlineQueryID: "1",
+				Results: []telegram.InlineQueryResult{
+					newResult("96049 recent", "Opp Tropicana Condo (96049)", "Upp Changi Rd East", "96049", nil),
+				},
+				IsPersonal: true,
+			},
+		},
+		{
+			Name: "falls back to all bus stops without favourites or recently used bus stops",
+			Expected: telegram.AnswerInlineQueryRequest{
+				InlineQueryID: "1",
+				Results: []telegram.InlineQueryResult{
+					newResult("96041", "Bef Tropicana Condo (96041)", "Upp Changi Rd East", "96041", nil),
+					newResult("96049", "Opp Tropicana Condo (96049)", "Upp Changi Rd East", "96049", nil),
+				},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			users := mocks.NewMockUserRepository(ctrl)
+			users.EXPECT().GetUserFavourites(gomock.Any(), 1).Return(tc.Favourites, nil)
+			tg := &mockTelegramService{}
+			bot := &BusEtaBot{
+				BusStops:        busStops,
+				Users:           users,
+				RecentBusStops:  &mockRecentBusStopRepository{Codes: tc.Recent},
+				TelegramService: tg,
+			}
+			ilq := MockInlineQuery()
+			err := InlineQueryHandler(context.Background(), bot, &ilq)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !assert.Equal(t, []telegram.Request{tc.Expected}, tg.Requests) {
+				pretty.Println(tg.Requests)
+			}
+		})
+	}
+}
+
+func TestParseInlineResultID(t *testing.T) {
+	testCases := []struct {
+		ID       string
+		Code     string
+		Services []string
+		Kind     string
+	}{
+		{ID: "96049", Code: "96049", Services: []string{}},
+		{ID: "96049 geo", Code: "96049", Services: []string{}, Kind: "geo"},
+		{ID: "96049 recent", Code: "96049", Services: []string{}, Kind: "recent"},
+		{ID: "96049 2 24 fav", Code: "96049", Services: []string{"2", "24"}, Kind: "fav"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.ID, func(t *testing.T) {
+			code, services, kind := parseInlineResultID(tc.ID)
+			assert.Equal(t, tc.Code, code)
+			assert.Equal(t, tc.Services, services)
+			assert.Equal(t, tc.Kind, kind)
+		})
+	}
+}
+
 func TestChosenInlineResultHandler(t *testing.T) {
 	busStops := mockBusStopRepository{
 		BusStop: &BusStop{
@@ -312,6 +437,33 @@ func TestChosenInlineResultHandler(t *testing.T) {
 				},
 			},
 		},
+		{
+			Name:     "Favourite chosen inline result",
+			ResultID: "96049 24 fav",
+			Expected: []telegram.Request{
+				telegram.EditMessageTextRequest{
+					InlineMessageID: "ID",
+					Text:            "*Opp Tropicana Condo (96049)*\nUpp Changi Rd East\n```\n| Svc  | Nxt | 2nd | 3rd |\n|------|-----|-----|-----|\n| 24   |   1 |   3 |   6 |\n```\n\n\n_Last updated on Mon, 01 Jan 01 08:00 SGT_",
+					ParseMode:       "markdown",
+					ReplyMarkup: telegram.InlineKeyboardMarkup{
+						InlineKeyboard: [][]telegram.InlineKeyboardButton{
+							{
+								{
+									Text:         "Refresh",
+									CallbackData: "{\"t\":\"refresh\",\"b\":\"96049\",\"s\":[\"24\"]}",
+								},
+							},
+							{
+								{
+									Text:         "Show incoming bus details",
+									CallbackData: "{\"t\":\"refresh\",\"b\":\"96049\",\"s\":[\"24\"],\"f\":\"f\"}",
+								},
+							},
+						},
+					},
+				},
+			},
+		},
 		{
 			Name:     "Nearby chosen inline result",
 			ResultID: "96049 geo",
@@ -351,6 +503,7 @@ func TestChosenInlineResultHandler(t *testing.T) {
 				},
 			}
 			tg := new(mockTelegramService)
+			recent := new(mockRecentBusStopRepository)
 			bot := &BusEtaBot{
 				Datamall: mockDatamall{},
 				BusStops: busStops,
@@ -358,6 +511,7 @@ func TestChosenInlineResultHandler(t *testing.T) {
 					return time.Time{}
 				},
 				TelegramService: tg,
+				RecentBusStops:  recent,
 			}
 			err := ChosenInlineResultHandler(context.Background(), bot, &cir)
 			if err != nil {
@@ -366,10 +520,36 @@ func TestChosenInlineResultHandler(t *testing.T) {
 			if !assert.Equal(t, tc.Expected, tg.Requests) {
 				pretty.Println(tg.Requests)
 			}
+			assert.Equal(t, []string{"96049"}, recent.Added)
 		})
 	}
 }
 
+func TestChosenInlineResultHandler_SendFails(t *testing.T) {
+	tg := &recordingTelegramService{
+		Requests: make(chan telegram.Request, 1),
+		Error:    telegram.Error{Description: "Bad Request: message to edit not found"},
+	}
+	recent := new(mockRecentBusStopRepository)
+	bot := &BusEtaBot{
+		Datamall: mockDatamall{},
+		BusStops: mockBusStopRepository{},
+		NowFunc: func() time.Time {
+			return time.Time{}
+		},
+		TelegramService: tg,
+		RecentBusStops:  recent,
+	}
+	cir := tgbotapi.ChosenInlineResult{
+		InlineMessageID: "ID",
+		ResultID:        "96049",
+		From:            &tgbotapi.User{ID: 1},
+	}
+	err := ChosenInlineResultHandler(context.Background(), bot, &cir)
+	assert.Error(t, err)
+	assert.Empty(t, recent.Added, "the bus stop should only be remembered after the etas are sent")
+}
+
 func TestGetNearbyInlineQueryResults(t *testing.T) {
 	streetView := mockStreetView("URL")
 	busStops := NewInMemoryBusStopRepository([]BusStop{
@@ -415,7 +595,7 @@ func TestGetNearbyInlineQueryResults(t *testing.T) {
 		telegram.InlineQueryResultArticle{
 			ID:                  "96041 geo",
 			Title:               "Bef Tropicana Condo (96041)",
-			Description:         "55 m away",
+			Description:         "56 m away",
 			ThumbURL:            "URL",
 			InputMessageContent: telegram.InputTextMessageContent{MessageText: "*Bef Tropicana Condo (96041)*\nUpp Changi Rd East\n`Fetching etas...`", ParseMode: "markdown"},
 			ReplyMarkup: telegram.InlineKeyboardMarkup{
@@ -0,0 +1,286 @@
+package busetabot
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/yi-jiayu/bus-eta-bot/v4/telegram"
+)
+
+// Default live eta parameters
+const (
+	DefaultLiveInterval           = 30 * time.Second
+	DefaultLiveDuration           = 10 * time.Minute
+	DefaultMaxLiveSessionsPerChat = 2
+	DefaultMaxLiveSessions        = 100
+)
+
+var (
+	errLiveChatLimit = errors.New("too many live eta messages in chat")
+	errLiveLimit     = errors.New("too many live eta messages")
+)
+
+// liveMessage identifies an eta message which is being kept up to date, either in a chat or sent inline.
+type liveMessage struct {
+	ChatID          int64
+	MessageID       int
+	InlineMessageID string
+}
+
+func (m liveMessage) key() string {
+	if m.InlineMessageID != "" {
+		return m.InlineMessageID
+	}
+	return strconv.FormatInt(m.ChatID, 10) + ":" + strconv.Itoa(m.MessageID)
+}
+
+type liveSession struct {
+	message   liveMessage
+	owner     int64
+	req       ETARequest
+	formatter string
+	stop      chan struct{}
+}
+
+// LiveETAManager keeps eta messages up to date by editing them at regular intervals for a limited time. A live
+// message stops updating when it expires, when it is stopped by a user, or when Telegram reports that it can no
+// longer be edited. It is safe for concurrent use.
+type LiveETAManager struct {
+	// Interval is how often live messages are updated.
+	Interval time.Duration
+
+	// Duration is how long a message stays live for.
+	Duration time.Duration
+
+	// MaxPerChat is the maximum number of live messages in a single chat, or sent inline by a single user.
+	MaxPerChat int
+
+	// Max is the maximum number of live messages overall.
+	Max int
+
+	mu       sync.Mutex
+	sessions map[string]*liveSession
+	perOwner map[int64]int
+	wg       sync.WaitGroup
+}
+
+// NewLiveETAManager returns a LiveETAManager with the default parameters.
+func NewLiveETAManager() *LiveETAManager {
+	return &LiveETAManager{
+		Interval:   DefaultLiveInterval,
+		Duration:   DefaultLiveDuration,
+		MaxPerChat: DefaultMaxLiveSessionsPerChat,
+		Max:        DefaultMaxLiveSessions,
+		sessions:   make(map[string]*liveSession),
+		perOwner:   make(map[int64]int),
+	}
+}
+
+// IsLive reports whether message is currently being kept up to date.
+func (m *LiveETAManager) IsLive(message liveMessage) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.sessions[message.key()]
+	return ok
+}
+
+// start begins updating message in the background. owner is the chat the message is in, or the user who sent it for
+// inline messages, and is used to enforce MaxPerChat. Starting a message which is already live does nothing.
+func (m *LiveETAManager) start(ctx context.Context, bot *BusEtaBot, message liveMessage, owner int64, req ETARequest, formatter string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := message.key()
+	if _, ok := m.sessions[key]; ok {
+		return nil
+	}
+	if len(m.sessions) >= m.Max {
+		return errLiveLimit
+	}
+	if m.perOwner[owner] >= m.MaxPerChat {
+		return errLiveChatLimit
+	}
+	s := &liveSession{
+		message:   message,
+		owner:     owner,
+		req:       req,
+		formatter: formatter,
+		stop:      make(chan struct{}),
+	}
+	m.sessions[key] = s
+	m.perOwner[owner]++
+	m.wg.Add(1)
+	go m.run(ctx, bot, s)
+	return nil
+}
+
+// stop stops updating message, reporting whether it was live.
+func (m *LiveETAManager) stop(message liveMessage) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[message.key()]
+	if !ok {
+		return false
+	}
+	m.remove(s)
+	close(s.stop)
+	return true
+}
+
+// StopAll stops all live messages and waits for them to be updated for the last time.
+func (m *LiveETAManager) StopAll() {
+	m.mu.Lock()
+	for _, s := range m.sessions {
+		m.remove(s)
+		close(s.stop)
+	}
+	m.mu.Unlock()
+	m.wg.Wait()
+}
+
+// remove must be called with m.mu held.
+func (m *LiveETAManager) remove(s *liveSession) {
+	delete(m.sessions, s.message.key())
+	m.perOwner[s.owner]--
+	if m.perOwner[s.owner] <= 0 {
+		delete(m.perOwner, s.owner)
+	}
+}
+
+func (m *LiveETAManager) run(ctx context.Context, bot *BusEtaBot, s *liveSession) {
+	defer m.wg.Done()
+	ticker := time.NewTicker(m.Interval)
+	defer ticker.Stop()
+	expiry := time.NewTimer(m.Duration)
+	defer expiry.Stop()
+	// retry is set while waiting to update the message again after being rate limited
+	var retry <-chan time.Time
+	for {
+		select {
+		case <-s.stop:
+			m.update(ctx, bot, s, false)
+			return
+		case <-expiry.C:
+			m.mu.Lock()
+			if m.sessions[s.message.key()] == s {
+				m.remove(s)
+			}
+			m.mu.Unlock()
+			m.update(ctx, bot, s, false)
+			return
+		case <-ticker.C:
+			if retry != nil {
+				continue
+			}
+		case <-retry:
+			retry = nil
+		}
+		err := m.update(ctx, bot, s, true)
+		if d := retryAfter(err); d > 0 {
+			retry = time.After(d)
+			continue
+		}
+		if isPermanentEditError(err) {
+			m.mu.Lock()
+			if m.sessions[s.message.key()] == s {
+				m.remove(s)
+			}
+			m.mu.Unlock()
+			return
+		}
+	}
+}
+
+func (m *LiveETAManager) update(ctx context.Context, bot *BusEtaBot, s *liveSession, live bool) error {
+	req := s.req
+	req.Time = bot.NowFunc()
+	edit, err := newETAMessageEditRequest(ctx, bot, s.message, req, s.formatter, live)
+	if err != nil {
+		bot.logger().Errorf(ctx, "error formatting live etas: %+v", err)
+		return err
+	}
+	err = bot.TelegramService.Do(edit)
+	if err != nil && !isNotModifiedError(err) {
+		bot.logger().Warningf(ctx, "error updating live etas for message %s: %+v", s.message.key(), err)
+		return err
+	}
+	return nil
+}
+
+// isNotModifiedError reports whether err is Telegram refusing to edit a message because its contents did not change.
+func isNotModifiedError(err error) bool {
+	tgErr, ok := err.(telegram.Error)
+	return ok && strings.Contains(tgErr.Description, "message is not modified")
+}
+
+// permanentEditErrors contains parts of the descriptions of Telegram errors which mean that a message can no longer be
+// edited.
+var permanentEditErrors = []string{
+	"message to edit not found",
+	"message can't be edited",
+	"bot was blocked",
+	"chat not found",
+}
+
+// isPermanentEditError reports whether err means that a message can no longer be edited, for example because it was
+// deleted or the bot was blocked.
+func isPermanentEditError(err error) bool {
+	tgErr, ok := err.(telegram.Error)
+	if !ok {
+		return false
+	}
+	for _, description := range permanentEditErrors {
+		if strings.Contains(tgErr.Description, description) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryAfter returns how long to wait before editing a message again if err means that the bot was rate limited, or 0.
+func retryAfter(err error) time.Duration {
+	tgErr, ok := err.(telegram.Error)
+	if !ok {
+		return 0
+	}
+	return time.Duration(tgErr.RetryAfter) * time.Second
+}
+
+// newETAMessageEditRequest returns a request to update message with the latest etas.
+func newETAMessageEditRequest(ctx context.Context, bot *BusEtaBot, message liveMessage, req ETARequest, formatter string, live bool) (telegram.EditMessageTextRequest, error) {
+	options := bot.etaMessageOptions()
+	options.Formatter = formatter
+	options.Inline = message.InlineMessageID != ""
+	options.Live = live
+	eta, err := bot.etaMessages().Message(ctx, req, options)
+	if err != nil {
+		return telegram.EditMessageTextRequest{}, err
+	}
+	return telegram.EditMessageTextRequest{
+		ChatID:          message.ChatID,
+		MessageID:       message.MessageID,
+		InlineMessageID: message.InlineMessageID,
+		Text:            eta.Text,
+		ParseMode:       eta.ParseMode,
+		ReplyMarkup:     eta.ReplyMarkup,
+	}, nil
+}
+
+func liveDescription(m *LiveETAManager) string {
+	return fmt.Sprintf("ETAs will update every %s for the next %s.", humanDuration(m.Interval), humanDuration(m.Duration))
+}
+
+func humanDuration(d time.Duration) string {
+	if d >= time.Minute && d%time.Minute == 0 {
+		if d == time.Minute {
+			return "minute"
+		}
+		return fmt.Sprintf("%d minutes", d/time.Minute)
+	}
+	return fmt.Sprintf("%d seconds", d/time.Second)
+}
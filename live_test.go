@@ -0,0 +1,302 @@
+package busetabot
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kr/pretty"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/yi-jiayu/bus-eta-bot/v4/telegram"
+)
+
+// recordingTelegramService sends each request it receives on Requests and returns Error.
+type recordingTelegramService struct {
+	Requests chan telegram.Request
+	Error    error
+}
+
+func (s *recordingTelegramService) Do(request telegram.Request) error {
+	s.Requests <- request
+	return s.Error
+}
+
+func receiveRequest(t *testing.T, requests <-chan telegram.Request) telegram.EditMessageTextRequest {
+	select {
+	case r := <-requests:
+		edit, ok := r.(telegram.EditMessageTextRequest)
+		if !ok {
+			t.Fatalf("expected EditMessageTextRequest, got %T", r)
+		}
+		return edit
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for request")
+	}
+	return telegram.EditMessageTextRequest{}
+}
+
+func newLiveTestBot(tg TelegramService) (*BusEtaBot, *LiveETAManager) {
+	live := NewLiveETAManager()
+	live.Interval = 10 * time.Millisecond
+	live.Duration = time.Minute
+	bot := &BusEtaBot{
+		Datamall:        mockETAService{},
+		BusStops:        mockBusStopRepository{},
+		TelegramService: tg,
+		LiveETAs:        live,
+		NowFunc:         time.Now,
+	}
+	return bot, live
+}
+
+func TestLiveETAManager(t *testing.T) {
+	tg := &recordingTelegramService{Requests: make(chan telegram.Request, 10)}
+	bot, live := newLiveTestBot(tg)
+	message := liveMessage{ChatID: 1, MessageID: 2}
+	req := ETARequest{Code: "96049", Services: []string{"2"}}
+
+	err := live.start(context.Background(), bot, message, 1, req, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, live.IsLive(message))
+
+	edit := receiveRequest(t, tg.Requests)
+	assert.Equal(t, int64(1), edit.ChatID)
+	assert.Equal(t, 2, edit.MessageID)
+	assert.Equal(t, NewETAMessageReplyMarkup("96049", []string{"2"}, ETAMessageOptions{Live: true}), edit.ReplyMarkup)
+
+	assert.True(t, live.stop(message))
+	assert.False(t, live.IsLive(message))
+	live.StopAll()
+	// the last update removes the Stop button
+	var last telegram.EditMessageTextRequest
+	for len(tg.Requests) > 0 {
+		last = receiveRequest(t, tg.Requests)
+	}
+	assert.Equal(t, NewETAMessageReplyMarkup("96049", []string{"2"}, ETAMessageOptions{LiveETAs: true}), last.ReplyMarkup)
+}
+
+func TestLiveETAManager_Limits(t *testing.T) {
+	tg := &recordingTelegramService{Requests: make(chan telegram.Request, 100)}
+	bot, live := newLiveTestBot(tg)
+	live.Interval = time.Hour
+	live.MaxPerChat = 1
+	live.Max = 2
+	defer live.StopAll()
+	ctx := context.Background()
+
+	assert.NoError(t, live.start(ctx, bot, liveMessage{ChatID: 1, MessageID: 1}, 1, ETARequest{}, ""))
+	assert.NoError(t, live.start(ctx, bot, liveMessage{ChatID: 1, MessageID: 1}, 1, ETARequest{}, ""), "starting a live message twice should do nothing")
+	assert.Equal(t, errLiveChatLimit, live.start(ctx, bot, liveMessage{ChatID: 1, MessageID: 2}, 1, ETARequest{}, ""))
+	assert.NoError(t, live.start(ctx, bot, liveMessage{InlineMessageID: "a"}, 2, ETARequest{}, ""))
+	assert.Equal(t, errLiveLimit, live.start(ctx, bot, liveMessage{ChatID: 3, MessageID: 1}, 3, ETARequest{}, ""))
+
+	live.stop(liveMessage{ChatID: 1, MessageID: 1})
+	assert.NoError(t, live.start(ctx, bot, liveMessage{ChatID: 1, MessageID: 2}, 1, ETARequest{}, ""), "stopping a live message should free up its slot")
+}
+
+func TestLiveETAManager_StopsWhenMessageCannotBeEdited(t *testing.T) {
+	for _, description := range []string{
+		"Bad Request: message to edit not found",
+		"Bad Request: message can't be edited",
+		"Forbidden: bot was blocked by the user",
+		"Bad Request: chat not found",
+	} {
+		t.Run(description, func(t *testing.T) {
+			tg := &recordingTelegramService{
+				Requests: make(chan telegram.Request, 10),
+				Error:    telegram.Error{Description: description},
+			}
+			bot, live := newLiveTestBot(tg)
+			message := liveMessage{ChatID: 1, MessageID: 2}
+
+			err := live.start(context.Background(), bot, message, 1, ETARequest{Code: "96049"}, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			receiveRequest(t, tg.Requests)
+			live.wg.Wait()
+			assert.False(t, live.IsLive(message))
+		})
+	}
+}
+
+func TestLiveETAManager_KeepsGoingAfterTransientErrors(t *testing.T) {
+	for _, err := range []error{
+		telegram.Error{Description: "Bad Request: message is not modified"},
+		telegram.Error{Description: "Internal Server Error"},
+		errors.New("connection reset by peer"),
+	} {
+		t.Run(err.Error(), func(t *testing.T) {
+			tg := &recordingTelegramService{
+				Requests: make(chan telegram.Request, 10),
+				Error:    err,
+			}
+			bot, live := newLiveTestBot(tg)
+			message := liveMessage{ChatID: 1, MessageID: 2}
+
+			err := live.start(context.Background(), bot, message, 1, ETARequest{Code: "96049"}, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			receiveRequest(t, tg.Requests)
+			receiveRequest(t, tg.Requests)
+			assert.True(t, live.IsLive(message))
+			live.stop(message)
+			live.StopAll()
+		})
+	}
+}
+
+// rateLimitedTelegramService rate limits the first request it receives and sends each request it receives on
+// Requests.
+type rateLimitedTelegramService struct {
+	Requests   chan telegram.Request
+	RetryAfter int
+
+	mu      sync.Mutex
+	limited bool
+}
+
+func (s *rateLimitedTelegramService) Do(request telegram.Request) error {
+	s.Requests <- request
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.limited {
+		return nil
+	}
+	s.limited = true
+	return telegram.Error{Description: "Too Many Requests: retry after 1", RetryAfter: s.RetryAfter}
+}
+
+func TestLiveETAManager_BacksOffWhenRateLimited(t *testing.T) {
+	tg := &rateLimitedTelegramService{
+		Requests:   make(chan telegram.Request, 10),
+		RetryAfter: 1,
+	}
+	bot, live := newLiveTestBot(tg)
+	message := liveMessage{ChatID: 1, MessageID: 2}
+
+	err := live.start(context.Background(), bot, message, 1, ETARequest{Code: "96049"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiveRequest(t, tg.Requests)
+	limited := time.Now()
+	select {
+	case <-tg.Requests:
+	case <-time.After(3 * time.Second):
+		t.Fatal("timed out waiting for request")
+	}
+	assert.True(t, time.Since(limited) >= 900*time.Millisecond, "should wait before editing the message again")
+	assert.True(t, live.IsLive(message))
+	live.stop(message)
+	live.StopAll()
+}
+
+func TestLiveETAManager_Expiry(t *testing.T) {
+	tg := &recordingTelegramService{Requests: make(chan telegram.Request, 10)}
+	bot, live := newLiveTestBot(tg)
+	live.Interval = time.Hour
+	live.Duration = 10 * time.Millisecond
+	message := liveMessage{InlineMessageID: "a"}
+
+	err := live.start(context.Background(), bot, message, 1, ETARequest{Code: "96049"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	edit := receiveRequest(t, tg.Requests)
+	live.wg.Wait()
+	assert.False(t, live.IsLive(message))
+	assert.Equal(t, "a", edit.InlineMessageID)
+	assert.Equal(t, NewETAMessageReplyMarkup("96049", nil, ETAMessageOptions{Inline: true, LiveETAs: true}), edit.ReplyMarkup)
+}
+
+func TestLiveCallbackHandler(t *testing.T) {
+	tg := &recordingTelegramService{Requests: make(chan telegram.Request, 10)}
+	bot, live := newLiveTestBot(tg)
+	live.Interval = DefaultLiveInterval
+	live.Duration = DefaultLiveDuration
+	live.MaxPerChat = 1
+	defer live.StopAll()
+
+	responses := make(chan Response, ResponseBufferSize)
+	LiveCallbackHandler(context.Background(), bot, newCallbackQueryFromMessage(`{"t":"live","b":"96049"}`), responses)
+	actual, err := collectResponsesWithTimeout(responses, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(t, actual, 2) {
+		edit := actual[0].Request.(telegram.EditMessageTextRequest)
+		assert.Equal(t, NewETAMessageReplyMarkup("96049", nil, ETAMessageOptions{Live: true}), edit.ReplyMarkup)
+		assert.Equal(t, ok(telegram.AnswerCallbackQueryRequest{
+			CallbackQueryID: "1",
+			Text:            "ETAs will update every 30 seconds for the next 10 minutes.",
+		}), actual[1])
+	}
+	assert.True(t, live.IsLive(liveMessage{ChatID: 1, MessageID: 1}))
+
+	cbq := newCallbackQueryFromMessage(`{"t":"live","b":"96049"}`)
+	cbq.Message.MessageID = 2
+	responses = make(chan Response, ResponseBufferSize)
+	LiveCallbackHandler(context.Background(), bot, cbq, responses)
+	actual, err = collectResponsesWithTimeout(responses, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Response{
+		ok(telegram.AnswerCallbackQueryRequest{
+			CallbackQueryID: "1",
+			Text:            "Oops, there can only be 1 live eta messages in a chat at a time.",
+		}),
+	}
+	if !assert.Equal(t, expected, actual) {
+		pretty.Println(actual)
+	}
+}
+
+func TestStopLiveCallbackHandler(t *testing.T) {
+	t.Run("when message is live", func(t *testing.T) {
+		tg := &recordingTelegramService{Requests: make(chan telegram.Request, 10)}
+		bot, live := newLiveTestBot(tg)
+		live.Interval = time.Hour
+		message := liveMessage{ChatID: 1, MessageID: 1}
+		err := live.start(context.Background(), bot, message, 1, ETARequest{Code: "96049"}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		responses := make(chan Response, ResponseBufferSize)
+		StopLiveCallbackHandler(context.Background(), bot, newCallbackQueryFromMessage(`{"t":"live_stop","b":"96049"}`), responses)
+		actual, err := collectResponsesWithTimeout(responses, 100*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []Response{
+			ok(telegram.AnswerCallbackQueryRequest{CallbackQueryID: "1", Text: "Live etas stopped."}),
+		}
+		assert.Equal(t, expected, actual)
+		edit := receiveRequest(t, tg.Requests)
+		assert.Equal(t, NewETAMessageReplyMarkup("96049", nil, ETAMessageOptions{LiveETAs: true}), edit.ReplyMarkup)
+		assert.False(t, live.IsLive(message))
+	})
+	t.Run("when message is no longer live", func(t *testing.T) {
+		bot, _ := newLiveTestBot(nil)
+
+		responses := make(chan Response, ResponseBufferSize)
+		StopLiveCallbackHandler(context.Background(), bot, newCallbackQueryFromMessage(`{"t":"live_stop","b":"96049"}`), responses)
+		actual, err := collectResponsesWithTimeout(responses, 100*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if assert.Len(t, actual, 2) {
+			edit := actual[0].Request.(telegram.EditMessageTextRequest)
+			assert.Equal(t, NewETAMessageReplyMarkup("96049", nil, ETAMessageOptions{LiveETAs: true}), edit.ReplyMarkup)
+			assert.Equal(t, ok(telegram.AnswerCallbackQueryRequest{CallbackQueryID: "1", Text: "Live etas stopped."}), actual[1])
+		}
+	})
+}
@@ -0,0 +1,181 @@
+package busetabot
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/yi-jiayu/telegram-bot-api"
+
+	"github.com/yi-jiayu/bus-eta-bot/v4/telegram"
+)
+
+// Default live location parameters
+const (
+	DefaultLiveLocationRefreshInterval = time.Minute
+	DefaultLiveLocationTimeout         = 30 * time.Minute
+)
+
+// liveLocationKey identifies a live location shared in a chat.
+type liveLocationKey struct {
+	ChatID    int64
+	MessageID int
+}
+
+type liveLocationSession struct {
+	// messageID is the message showing the nearest bus stop, or 0 if it has not been sent yet.
+	messageID int
+	nearest   string
+	updatedAt time.Time
+	busy      bool
+}
+
+// LiveLocationTracker keeps track of the message showing the nearest bus stop to each live location shared with the
+// bot. As a live location moves, the message is edited whenever the nearest bus stop changes, and otherwise at most
+// once every RefreshInterval. It is safe for concurrent use.
+type LiveLocationTracker struct {
+	// RefreshInterval is the minimum time between edits when the nearest bus stop has not changed.
+	RefreshInterval time.Duration
+
+	// Timeout is how long a live location is remembered after its message was last updated. A live location which
+	// moves again after it has been forgotten gets a new message.
+	Timeout time.Duration
+
+	mu       sync.Mutex
+	sessions map[liveLocationKey]*liveLocationSession
+}
+
+// NewLiveLocationTracker returns a LiveLocationTracker with the default parameters.
+func NewLiveLocationTracker() *LiveLocationTracker {
+	return &LiveLocationTracker{
+		RefreshInterval: DefaultLiveLocationRefreshInterval,
+		Timeout:         DefaultLiveLocationTimeout,
+		sessions:        make(map[liveLocationKey]*liveLocationSession),
+	}
+}
+
+// begin decides whether the message for a live location needs to be sent or edited now that nearest is the nearest
+// bus stop. If it does, messageID is the message to edit, or 0 if a new message should be sent, and finish must be
+// called afterwards.
+func (t *LiveLocationTracker) begin(key liveLocationKey, nearest string, now time.Time) (messageID int, update bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for k, s := range t.sessions {
+		if !s.busy && now.Sub(s.updatedAt) > t.Timeout {
+			delete(t.sessions, k)
+		}
+	}
+	s, ok := t.sessions[key]
+	if !ok {
+		s = &liveLocationSession{}
+		t.sessions[key] = s
+	} else if s.busy || s.nearest == nearest && now.Sub(s.updatedAt) < t.RefreshInterval {
+		return 0, false
+	}
+	s.busy = true
+	return s.messageID, true
+}
+
+// finish records the outcome of an update started by begin. The session is left unchanged if the update failed, so
+// that the next move tries again.
+func (t *LiveLocationTracker) finish(key liveLocationKey, messageID int, nearest string, now time.Time, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.sessions[key]
+	if !ok {
+		return
+	}
+	s.busy = false
+	if err != nil {
+		if s.messageID == 0 {
+			delete(t.sessions, key)
+		}
+		return
+	}
+	s.messageID = messageID
+	s.nearest = nearest
+	s.updatedAt = now
+}
+
+// Len returns the number of live locations being tracked.
+func (t *LiveLocationTracker) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.sessions)
+}
+
+// liveLocationText returns the text of the message for a live location, showing the etas at the nearest bus stop.
+func liveLocationText(ctx context.Context, bot *BusEtaBot, preferences Preferences, userID int, nearby []NearbyBusStop) (string, error) {
+	if len(nearby) == 0 {
+		return fmt.Sprintf("Oops, I couldn't find any bus stops within %.0f m of your location.", preferences.nearbyRadius()), nil
+	}
+	nearest := nearby[0]
+	text, err := bot.etaMessages().Text(ctx, ETARequest{
+		UserID: userID,
+		Time:   bot.NowFunc(),
+		Code:   nearest.BusStopCode,
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Nearest bus stop to your live location, %.0f m away:\n\n%s", nearest.Distance, text), nil
+}
+
+// EditedLocationHandler handles updates to live locations. It keeps a single message in the chat showing the etas at
+// the bus stop nearest to the live location.
+func EditedLocationHandler(ctx context.Context, bot *BusEtaBot, message *tgbotapi.Message) error {
+	if bot.LiveLocations == nil {
+		return nil
+	}
+	location := message.Location
+	preferences := bot.userPreferences(ctx, message.From.ID)
+	nearby := bot.BusStops.Nearby(ctx, location.Latitude, location.Longitude, preferences.nearbyRadius(), 1)
+	var nearest string
+	if len(nearby) > 0 {
+		nearest = nearby[0].BusStopCode
+	}
+	key := liveLocationKey{
+		ChatID:    message.Chat.ID,
+		MessageID: message.MessageID,
+	}
+	now := bot.NowFunc()
+	messageID, update := bot.LiveLocations.begin(key, nearest, now)
+	if !update {
+		return nil
+	}
+	var err error
+	defer func() {
+		bot.LiveLocations.finish(key, messageID, nearest, now, err)
+	}()
+
+	text, err := liveLocationText(ctx, bot, preferences, message.From.ID, nearby)
+	if err != nil {
+		return err
+	}
+	if messageID != 0 {
+		go bot.LogEvent(ctx, message.From, CategoryMessage, ActionLiveLocationUpdate, message.Chat.Type)
+		err = bot.TelegramService.Do(telegram.EditMessageTextRequest{
+			ChatID:    message.Chat.ID,
+			MessageID: messageID,
+			Text:      text,
+			ParseMode: "markdown",
+		})
+		if err != nil {
+			return errors.Wrap(err, "error editing live location message")
+		}
+		return nil
+	}
+
+	go bot.LogEvent(ctx, message.From, CategoryMessage, ActionLiveLocationMessage, message.Chat.Type)
+	reply := tgbotapi.NewMessage(message.Chat.ID, text)
+	reply.ParseMode = "markdown"
+	reply.ReplyToMessageID = message.MessageID
+	sent, err := bot.Telegram.Send(reply)
+	if err != nil {
+		return errors.Wrap(err, "error sending live location message")
+	}
+	messageID = sent.MessageID
+	return nil
+}
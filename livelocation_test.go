@@ -0,0 +1,128 @@
+package busetabot
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/yi-jiayu/telegram-bot-api"
+
+	"github.com/yi-jiayu/bus-eta-bot/v4/telegram"
+)
+
+func TestLiveLocationTracker(t *testing.T) {
+	tracker := NewLiveLocationTracker()
+	key := liveLocationKey{ChatID: 1, MessageID: 1}
+	now := time.Date(2018, 1, 1, 8, 0, 0, 0, sgt)
+
+	messageID, update := tracker.begin(key, "96049", now)
+	assert.True(t, update, "a new live location should be sent")
+	assert.Equal(t, 0, messageID)
+	_, update = tracker.begin(key, "96041", now)
+	assert.False(t, update, "a live location should not be updated while it is being sent")
+	tracker.finish(key, 42, "96049", now, nil)
+
+	_, update = tracker.begin(key, "96049", now.Add(10*time.Second))
+	assert.False(t, update, "updates should be throttled while the nearest bus stop does not change")
+
+	messageID, update = tracker.begin(key, "96041", now.Add(10*time.Second))
+	assert.True(t, update, "a change in the nearest bus stop should be updated immediately")
+	assert.Equal(t, 42, messageID)
+	tracker.finish(key, 42, "96041", now.Add(10*time.Second), nil)
+
+	messageID, update = tracker.begin(key, "96041", now.Add(10*time.Second+DefaultLiveLocationRefreshInterval))
+	assert.True(t, update, "etas should be refreshed after the refresh interval")
+	assert.Equal(t, 42, messageID)
+	tracker.finish(key, 42, "96041", now.Add(10*time.Second+DefaultLiveLocationRefreshInterval), errors.New("edit failed"))
+
+	messageID, update = tracker.begin(key, "96041", now.Add(11*time.Second+DefaultLiveLocationRefreshInterval))
+	assert.True(t, update, "failed updates should be retried")
+	assert.Equal(t, 42, messageID)
+	tracker.finish(key, 42, "96041", now.Add(11*time.Second+DefaultLiveLocationRefreshInterval), nil)
+
+	other := liveLocationKey{ChatID: 2, MessageID: 1}
+	messageID, update = tracker.begin(other, "96049", now.Add(time.Hour))
+	assert.True(t, update)
+	assert.Equal(t, 0, messageID)
+	assert.Equal(t, 1, tracker.Len(), "live locations should be forgotten after the timeout")
+}
+
+func TestEditedLocationHandler(t *testing.T) {
+	var sent int32
+	tgAPI := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&sent, 1)
+		w.Write([]byte(`{"ok":true,"result":{"message_id":42,"chat":{"id":1}}}`))
+	}))
+	defer tgAPI.Close()
+
+	now := time.Date(2018, 1, 1, 8, 0, 0, 0, sgt)
+	tg := &mockTelegramService{}
+	bot := &BusEtaBot{
+		Telegram: &tgbotapi.BotAPI{
+			APIEndpoint: tgAPI.URL + "/bot%s/%s",
+			Client:      http.DefaultClient,
+		},
+		TelegramService: tg,
+		BusStops: NewInMemoryBusStopRepository([]BusStop{
+			{BusStopCode: "96041", Description: "Bef Tropicana Condo", Latitude: 1.34041450268626, Longitude: 103.96127892061004},
+			{BusStopCode: "96049", Description: "Opp Tropicana Condo", Latitude: 1.33995375346513, Longitude: 103.96079768187379},
+		}, nil),
+		Datamall:      mockETAService{},
+		LiveLocations: NewLiveLocationTracker(),
+		NowFunc:       func() time.Time { return now },
+	}
+	move := func(lat, lon float64) {
+		message := MockMessageWithType("private")
+		message.Location = &tgbotapi.Location{Latitude: lat, Longitude: lon}
+		err := EditedLocationHandler(context.Background(), bot, &message)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	move(1.3404, 103.9612)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&sent), "the first update should send a new message")
+	assert.Empty(t, tg.Requests)
+
+	move(1.3403, 103.9611)
+	assert.Empty(t, tg.Requests, "moving without changing the nearest bus stop should not edit the message")
+
+	move(1.3399, 103.9608)
+	if assert.Len(t, tg.Requests, 1, "changing the nearest bus stop should edit the message") {
+		edit := tg.Requests[0].(telegram.EditMessageTextRequest)
+		assert.Equal(t, 42, edit.MessageID)
+		assert.True(t, strings.HasPrefix(edit.Text, "Nearest bus stop to your live location, 6 m away:\n\n*Opp Tropicana Condo (96049)*"), edit.Text)
+	}
+
+	now = now.Add(DefaultLiveLocationRefreshInterval)
+	move(1.3399, 103.9608)
+	assert.Len(t, tg.Requests, 2, "etas should be refreshed after the refresh interval")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&sent))
+}
+
+func TestBusEtaBot_HandleUpdate_EditedLocation(t *testing.T) {
+	var handled *tgbotapi.Message
+	bot := BusEtaBot{
+		Handlers: Handlers{
+			EditedLocationHandler: func(ctx context.Context, bot *BusEtaBot, message *tgbotapi.Message) error {
+				handled = message
+				return nil
+			},
+		},
+	}
+
+	text := MockMessageWithText("edited")
+	bot.HandleUpdate(context.Background(), &tgbotapi.Update{EditedMessage: text})
+	assert.Nil(t, handled, "edited text messages should be ignored")
+
+	location := MockMessage()
+	location.Location = &tgbotapi.Location{Latitude: 1.34, Longitude: 103.96}
+	bot.HandleUpdate(context.Background(), &tgbotapi.Update{EditedMessage: &location})
+	assert.Equal(t, &location, handled)
+}
@@ -0,0 +1,179 @@
+package busetabot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/yi-jiayu/telegram-bot-api"
+)
+
+// Log severities
+const (
+	SeverityInfo    = "INFO"
+	SeverityWarning = "WARNING"
+	SeverityError   = "ERROR"
+)
+
+var defaultLogger Logger = NewStdLogger(log.New(os.Stderr, "", log.LstdFlags))
+
+type loggerKey struct{}
+type requestIDProviderKey struct{}
+type updateIDKey struct{}
+
+// Logger logs messages about the handling of an update.
+type Logger interface {
+	Infof(ctx context.Context, format string, args ...interface{})
+	Warningf(ctx context.Context, format string, args ...interface{})
+	Errorf(ctx context.Context, format string, args ...interface{})
+}
+
+// RequestIDProvider returns an identifier for the request being handled which can be shown to users when something
+// goes wrong.
+type RequestIDProvider interface {
+	RequestID(ctx context.Context) string
+}
+
+// StdLogger logs messages using a logger from the standard library.
+type StdLogger struct {
+	logger *log.Logger
+}
+
+// NewStdLogger returns a StdLogger which writes to logger.
+func NewStdLogger(logger *log.Logger) StdLogger {
+	return StdLogger{
+		logger: logger,
+	}
+}
+
+func (l StdLogger) logf(ctx context.Context, severity, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	if id := requestID(ctx); id != "" {
+		l.logger.Printf("%s: [%s] %s", severity, id, message)
+	} else {
+		l.logger.Printf("%s: %s", severity, message)
+	}
+}
+
+func (l StdLogger) Infof(ctx context.Context, format string, args ...interface{}) {
+	l.logf(ctx, SeverityInfo, format, args...)
+}
+
+func (l StdLogger) Warningf(ctx context.Context, format string, args ...interface{}) {
+	l.logf(ctx, SeverityWarning, format, args...)
+}
+
+func (l StdLogger) Errorf(ctx context.Context, format string, args ...interface{}) {
+	l.logf(ctx, SeverityError, format, args...)
+}
+
+// JSONLogEntry is a single entry written by a JSONLogger.
+type JSONLogEntry struct {
+	Time      time.Time `json:"time"`
+	Severity  string    `json:"severity"`
+	Message   string    `json:"message"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+// JSONLogger writes structured log entries as newline-delimited JSON.
+type JSONLogger struct {
+	mu      sync.Mutex
+	w       io.Writer
+	nowFunc func() time.Time
+}
+
+// NewJSONLogger returns a JSONLogger which writes to w.
+func NewJSONLogger(w io.Writer) *JSONLogger {
+	return &JSONLogger{
+		w:       w,
+		nowFunc: time.Now,
+	}
+}
+
+func (l *JSONLogger) logf(ctx context.Context, severity, format string, args ...interface{}) {
+	entry := JSONLogEntry{
+		Time:      l.nowFunc(),
+		Severity:  severity,
+		Message:   fmt.Sprintf(format, args...),
+		RequestID: requestID(ctx),
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	json.NewEncoder(l.w).Encode(entry)
+}
+
+func (l *JSONLogger) Infof(ctx context.Context, format string, args ...interface{}) {
+	l.logf(ctx, SeverityInfo, format, args...)
+}
+
+func (l *JSONLogger) Warningf(ctx context.Context, format string, args ...interface{}) {
+	l.logf(ctx, SeverityWarning, format, args...)
+}
+
+func (l *JSONLogger) Errorf(ctx context.Context, format string, args ...interface{}) {
+	l.logf(ctx, SeverityError, format, args...)
+}
+
+// UpdateIDRequestIDProvider uses the ID of the Telegram update being handled as the request ID.
+type UpdateIDRequestIDProvider struct{}
+
+func (UpdateIDRequestIDProvider) RequestID(ctx context.Context) string {
+	if id, ok := ctx.Value(updateIDKey{}).(int); ok {
+		return strconv.Itoa(id)
+	}
+	return ""
+}
+
+// withLogging returns a copy of ctx carrying the bot's logger and request ID provider as well as the ID of the update
+// being handled, so that they are available to code which does not have access to the bot.
+func (bot *BusEtaBot) withLogging(ctx context.Context, update *tgbotapi.Update) context.Context {
+	ctx = context.WithValue(ctx, updateIDKey{}, update.UpdateID)
+	if bot.Logger != nil {
+		ctx = context.WithValue(ctx, loggerKey{}, bot.Logger)
+	}
+	if bot.RequestIDs != nil {
+		ctx = context.WithValue(ctx, requestIDProviderKey{}, bot.RequestIDs)
+	}
+	return ctx
+}
+
+// logger returns the bot's logger, or the default logger if none was set.
+func (bot *BusEtaBot) logger() Logger {
+	if bot.Logger != nil {
+		return bot.Logger
+	}
+	return defaultLogger
+}
+
+func loggerFromContext(ctx context.Context) Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(Logger); ok {
+		return logger
+	}
+	return defaultLogger
+}
+
+// requestID returns the ID of the request being handled in ctx, or an empty string if there is no request ID provider.
+func requestID(ctx context.Context) string {
+	if provider, ok := ctx.Value(requestIDProviderKey{}).(RequestIDProvider); ok {
+		return provider.RequestID(ctx)
+	}
+	return ""
+}
+
+func logInfof(ctx context.Context, format string, args ...interface{}) {
+	loggerFromContext(ctx).Infof(ctx, format, args...)
+}
+
+func logWarningf(ctx context.Context, format string, args ...interface{}) {
+	loggerFromContext(ctx).Warningf(ctx, format, args...)
+}
+
+func logErrorf(ctx context.Context, format string, args ...interface{}) {
+	loggerFromContext(ctx).Errorf(ctx, format, args...)
+}
@@ -0,0 +1,95 @@
+package busetabot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yi-jiayu/telegram-bot-api"
+)
+
+type mockRequestIDProvider string
+
+func (p mockRequestIDProvider) RequestID(ctx context.Context) string {
+	return string(p)
+}
+
+func TestStdLogger(t *testing.T) {
+	t.Run("without request ID", func(t *testing.T) {
+		b := new(bytes.Buffer)
+		logger := NewStdLogger(log.New(b, "", 0))
+		logger.Warningf(context.Background(), "something %s", "happened")
+		assert.Equal(t, "WARNING: something happened\n", b.String())
+	})
+	t.Run("with request ID", func(t *testing.T) {
+		b := new(bytes.Buffer)
+		logger := NewStdLogger(log.New(b, "", 0))
+		ctx := context.WithValue(context.Background(), requestIDProviderKey{}, mockRequestIDProvider("abc"))
+		logger.Errorf(ctx, "something %s", "broke")
+		assert.Equal(t, "ERROR: [abc] something broke\n", b.String())
+	})
+}
+
+func TestJSONLogger(t *testing.T) {
+	b := new(bytes.Buffer)
+	logger := NewJSONLogger(b)
+	logger.nowFunc = func() time.Time {
+		return time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	ctx := context.WithValue(context.Background(), requestIDProviderKey{}, mockRequestIDProvider("abc"))
+	logger.Infof(ctx, "handled %d updates", 2)
+	logger.Errorf(context.Background(), "oops")
+
+	var entries []JSONLogEntry
+	decoder := json.NewDecoder(b)
+	for decoder.More() {
+		var entry JSONLogEntry
+		err := decoder.Decode(&entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	expected := []JSONLogEntry{
+		{
+			Time:      time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC),
+			Severity:  SeverityInfo,
+			Message:   "handled 2 updates",
+			RequestID: "abc",
+		},
+		{
+			Time:     time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC),
+			Severity: SeverityError,
+			Message:  "oops",
+		},
+	}
+	assert.Equal(t, expected, entries)
+}
+
+func TestBusEtaBot_HandleUpdate_Logging(t *testing.T) {
+	b := new(bytes.Buffer)
+	var etaError string
+	bot := &BusEtaBot{
+		Handlers: Handlers{
+			TextHandler: func(ctx context.Context, bot *BusEtaBot, message *tgbotapi.Message) error {
+				eta := NewETA(ctx, mockBusStopRepository{}, mockETAService{Error: errors.New("some other error")}, ETARequest{})
+				etaError = eta.Error
+				return nil
+			},
+		},
+		Logger:     NewStdLogger(log.New(b, "", 0)),
+		RequestIDs: UpdateIDRequestIDProvider{},
+	}
+	update := tgbotapi.Update{
+		UpdateID: 123,
+		Message:  MockMessageWithText("96049"),
+	}
+	bot.HandleUpdate(context.Background(), &update)
+	assert.Equal(t, "An error occurred while fetching ETAs (request ID: 123)", etaError)
+	assert.Equal(t, "ERROR: [123] some other error\n", b.String())
+}
@@ -9,8 +9,6 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/yi-jiayu/telegram-bot-api"
-	"google.golang.org/appengine"
-	"google.golang.org/appengine/log"
 
 	"github.com/yi-jiayu/bus-eta-bot/v4/telegram"
 )
@@ -24,11 +22,21 @@ func TextHandler(ctx context.Context, bot *BusEtaBot, message *tgbotapi.Message)
 		return nil
 	}
 
+	if query, ok := renamedFavouriteQuery(message); ok && bot.Users != nil {
+		return renameFavourite(ctx, bot, message, query)
+	}
+
 	chatID := message.Chat.ID
 	// a message is a continuation if it was a reply to a message asking for a bus stop code
 	continuation := message.ReplyToMessage != nil && message.ReplyToMessage.Text == "Alright, send me a bus stop code to get etas for."
 
 	busStopID, serviceNos, err := InferEtaQuery(message.Text)
+	if err != nil && !continuation {
+		// buttons on the favourites keyboard send the label of a favourite if it has one
+		if query, ok := labelledFavouriteQuery(ctx, bot, message.From.ID, message.Text); ok {
+			busStopID, serviceNos, err = InferEtaQuery(query)
+		}
+	}
 	if err != nil {
 		// if it wasn't a continuation, we ignore the message
 		if !continuation {
@@ -46,24 +54,21 @@ func TextHandler(ctx context.Context, bot *BusEtaBot, message *tgbotapi.Message)
 		}
 		return nil
 	}
-	eta := NewETA(ctx, bot.BusStops, bot.Datamall, ETARequest{
+	eta, err := bot.etaMessages().Message(ctx, ETARequest{
 		UserID:   message.From.ID,
 		Time:     bot.NowFunc(),
 		Code:     busStopID,
 		Services: serviceNos,
-	})
-	text, err := summaryFormatter.Format(eta)
+	}, bot.etaMessageOptions())
 	if err != nil {
 		return err
 	}
-	markup := NewETAMessageReplyMarkup(busStopID, serviceNos, "", false)
 	req := telegram.SendMessageRequest{
 		ChatID:      chatID,
-		Text:        text,
-		ParseMode:   "markdown",
-		ReplyMarkup: markup,
+		Text:        eta.Text,
+		ParseMode:   eta.ParseMode,
+		ReplyMarkup: eta.ReplyMarkup,
 	}
-
 	if continuation {
 		go bot.LogEvent(ctx, message.From, CategoryMessage, ActionContinuedTextMessage, message.Chat.Type)
 	} else {
@@ -74,15 +79,21 @@ func TextHandler(ctx context.Context, bot *BusEtaBot, message *tgbotapi.Message)
 	if err != nil {
 		return errors.Wrap(err, "error sending message")
 	}
+	bot.rememberBusStop(ctx, message.From.ID, busStopID)
 	return nil
 }
 
 // LocationHandler handles messages contain a location
 func LocationHandler(ctx context.Context, bot *BusEtaBot, message *tgbotapi.Message) error {
+	preferences := bot.userPreferences(ctx, message.From.ID)
+	if preferences.NearbyMode == NearbyModeCompact {
+		return sendCompactNearbyMessage(ctx, bot, preferences, message)
+	}
+
 	chatID := message.Chat.ID
 	location := message.Location
 
-	nearby := bot.BusStops.Nearby(ctx, location.Latitude, location.Longitude, 500, 5)
+	nearby := bot.BusStops.Nearby(ctx, location.Latitude, location.Longitude, preferences.nearbyRadius(), preferences.nearbyLimit())
 	if len(nearby) > 0 {
 		go bot.LogEvent(ctx, message.From, CategoryMessage, ActionLocationMessage, message.Chat.Type)
 
@@ -90,6 +101,17 @@ func LocationHandler(ctx context.Context, bot *BusEtaBot, message *tgbotapi.Mess
 		if !message.Chat.IsPrivate() {
 			reply.ReplyToMessageID = message.MessageID
 		}
+		compact := NewNearbyModeButton(NearbyModeCompact, location.Latitude, location.Longitude)
+		reply.ReplyMarkup = tgbotapi.InlineKeyboardMarkup{
+			InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{
+				{
+					tgbotapi.InlineKeyboardButton{
+						Text:         compact.Text,
+						CallbackData: &compact.CallbackData,
+					},
+				},
+			},
+		}
 		_, err := bot.Telegram.Send(reply)
 		if err != nil {
 			return err
@@ -125,7 +147,7 @@ func LocationHandler(ctx context.Context, bot *BusEtaBot, message *tgbotapi.Mess
 
 			_, err = bot.Telegram.Send(reply)
 			if err != nil {
-				log.Errorf(ctx, "%+v", err)
+				logError(ctx, err)
 			}
 		}
 
@@ -134,20 +156,20 @@ func LocationHandler(ctx context.Context, bot *BusEtaBot, message *tgbotapi.Mess
 
 	go bot.LogEvent(ctx, message.From, CategoryMessage, ActionLocationMessage, message.Chat.Type)
 
-	reply := tgbotapi.NewMessage(chatID, "Oops, I couldn't find any bus stops within 500 m of your location.")
+	reply := tgbotapi.NewMessage(chatID, fmt.Sprintf("Oops, I couldn't find any bus stops within %.0f m of your location.", preferences.nearbyRadius()))
 	_, err := bot.Telegram.Send(reply)
 	return err
 }
 
 func messageErrorHandler(ctx context.Context, bot *BusEtaBot, message *tgbotapi.Message, err error) {
-	log.Errorf(ctx, "%+v", err)
+	logError(ctx, err)
 
-	text := fmt.Sprintf("Oh no! Something went wrong. \n\nRequest ID: `%s`", appengine.RequestID(ctx))
+	text := fmt.Sprintf("Oh no! Something went wrong. \n\nRequest ID: `%s`", requestID(ctx))
 	reply := tgbotapi.NewMessage(message.Chat.ID, text)
 	reply.ParseMode = "markdown"
 
 	_, err = bot.Telegram.Send(reply)
 	if err != nil {
-		log.Errorf(ctx, "%+v", err)
+		logError(ctx, err)
 	}
 }
@@ -70,7 +70,7 @@ func TestLocationHandler(t *testing.T) {
 
 	actual := reqs
 	expected := []Request{
-		{Path: "/bot/sendMessage", Body: "chat_id=1&disable_notification=false&disable_web_page_preview=false&text=Here+are+some+bus+stops+near+your+location%3A"},
+		{Path: "/bot/sendMessage", Body: "chat_id=1&disable_notification=false&disable_web_page_preview=false&reply_markup=%7B%22inline_keyboard%22%3A%5B%5B%7B%22text%22%3A%22Show+in+one+message%22%2C%22callback_data%22%3A%22%7B%5C%22t%5C%22%3A%5C%22nearby_mode%5C%22%2C%5C%22a%5C%22%3A%5C%22compact+1.340415+103.961279%5C%22%7D%22%7D%5D%5D%7D&text=Here+are+some+bus+stops+near+your+location%3A"},
 		{Path: "/bot/sendVenue", Body: "address=0+m+away&chat_id=1&disable_notification=false&latitude=1.340415&longitude=103.961279&reply_markup=%7B%22inline_keyboard%22%3A%5B%5B%7B%22text%22%3A%22Get+etas%22%2C%22callback_data%22%3A%22%7B%5C%22t%5C%22%3A%5C%22new_eta%5C%22%2C%5C%22b%5C%22%3A%5C%2296041%5C%22%7D%22%7D%5D%5D%7D&title=Bef+Tropicana+Condo+%2896041%29"},
 		{Path: "/bot/sendVenue", Body: "address=74+m+away&chat_id=1&disable_notification=false&latitude=1.339954&longitude=103.960798&reply_markup=%7B%22inline_keyboard%22%3A%5B%5B%7B%22text%22%3A%22Get+etas%22%2C%22callback_data%22%3A%22%7B%5C%22t%5C%22%3A%5C%22new_eta%5C%22%2C%5C%22b%5C%22%3A%5C%2296049%5C%22%7D%22%7D%5D%5D%7D&title=Opp+Tropicana+Condo+%2896049%29"},
 	}
@@ -7,6 +7,7 @@ package mocks
 import (
 	context "context"
 	gomock "github.com/golang/mock/gomock"
+	favourite "github.com/yi-jiayu/bus-eta-bot/v4/favourite"
 	reflect "reflect"
 	time "time"
 )
@@ -35,10 +36,10 @@ func (m *MockUserRepository) EXPECT() *MockUserRepositoryMockRecorder {
 }
 
 // GetUserFavourites mocks base method
-func (m *MockUserRepository) GetUserFavourites(arg0 context.Context, arg1 int) ([]string, error) {
+func (m *MockUserRepository) GetUserFavourites(arg0 context.Context, arg1 int) ([]favourite.Favourite, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "GetUserFavourites", arg0, arg1)
-	ret0, _ := ret[0].([]string)
+	ret0, _ := ret[0].([]favourite.Favourite)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
@@ -50,7 +51,7 @@ func (mr *MockUserRepositoryMockRecorder) GetUserFavourites(arg0, arg1 interface
 }
 
 // SetUserFavourites mocks base method
-func (m *MockUserRepository) SetUserFavourites(arg0 context.Context, arg1 int, arg2 []string) error {
+func (m *MockUserRepository) SetUserFavourites(arg0 context.Context, arg1 int, arg2 []favourite.Favourite) error {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "SetUserFavourites", arg0, arg1, arg2)
 	ret0, _ := ret[0].(error)
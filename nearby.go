@@ -0,0 +1,288 @@
+package busetabot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/yi-jiayu/telegram-bot-api"
+
+	"github.com/yi-jiayu/bus-eta-bot/v4/telegram"
+)
+
+const (
+	// LocationNearbyRadius is the search range in metres for bus stops near a location sent in a message.
+	LocationNearbyRadius = 500.0
+
+	// LocationNearbyLimit is the maximum number of bus stops shown for a location sent in a message.
+	LocationNearbyLimit = 5
+
+	// WalkingSpeed is the walking speed in metres per minute used to estimate walking times to nearby bus stops.
+	WalkingSpeed = 80.0
+)
+
+var nearbyTemplate = template.Must(template.New("nearby.tmpl").
+	Funcs(funcMap).
+	Funcs(map[string]interface{}{"walkingMinutes": walkingMinutes}).
+	ParseFiles("templates/nearby.tmpl"))
+
+// NearbyETA contains the etas at a bus stop near a location.
+type NearbyETA struct {
+	ETA
+	Distance float64
+}
+
+// NearbyETAs contains the etas at the bus stops near a location, for showing them in one message.
+type NearbyETAs struct {
+	Now   time.Time
+	Stops []NearbyETA
+
+	// ClockTimes is whether to show the time each bus arrives instead of the number of minutes until it arrives.
+	ClockTimes bool
+}
+
+// walkingMinutes returns the number of minutes needed to walk distance metres, rounded up.
+func walkingMinutes(distance float64) int {
+	minutes := int(math.Ceil(distance / WalkingSpeed))
+	if minutes < 1 {
+		return 1
+	}
+	return minutes
+}
+
+// NewNearbyETAs gets the etas at each of the nearby bus stops concurrently, to be shown the way userID prefers.
+func NewNearbyETAs(ctx context.Context, factory ETAMessageFactory, userID int, nearby []NearbyBusStop, now time.Time) NearbyETAs {
+	requests := make([]ETARequest, len(nearby))
+	for i := range nearby {
+		requests[i] = ETARequest{
+			UserID: userID,
+			Time:   now,
+			Code:   nearby[i].BusStopCode,
+		}
+	}
+	etas, preferences := factory.ETAs(ctx, userID, requests)
+	stops := make([]NearbyETA, len(nearby))
+	for i := range nearby {
+		etas[i].BusStop = nearby[i].BusStop
+		stops[i] = NearbyETA{
+			ETA:      etas[i],
+			Distance: nearby[i].Distance,
+		}
+	}
+	return NearbyETAs{
+		Now:        now,
+		Stops:      stops,
+		ClockTimes: preferences.clockTimes(),
+	}
+}
+
+// Format returns the text of a compact nearby bus stops message.
+func (n NearbyETAs) Format() (string, error) {
+	b := new(bytes.Buffer)
+	err := nearbyTemplate.Execute(b, n)
+	if err != nil {
+		return "", errors.Wrap(err, "error formatting nearby etas")
+	}
+	return b.String(), nil
+}
+
+// formatLocation formats a location for callback data.
+func formatLocation(lat, lon float64) string {
+	return fmt.Sprintf("%.6f %.6f", lat, lon)
+}
+
+// parseLocation parses a location formatted by formatLocation.
+func parseLocation(s string) (lat, lon float64, err error) {
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return 0, 0, errors.Errorf("invalid location %q", s)
+	}
+	lat, err = strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "invalid latitude %q", fields[0])
+	}
+	lon, err = strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "invalid longitude %q", fields[1])
+	}
+	return lat, lon, nil
+}
+
+// NewNearbyModeButton returns a button which switches to showing nearby bus stops in mode, starting with the bus stops
+// near a location.
+func NewNearbyModeButton(mode string, lat, lon float64) telegram.InlineKeyboardButton {
+	text := "Show in one message"
+	if mode == NearbyModeVenues {
+		text = "Show separately"
+	}
+	data := CallbackData{
+		Type:   "nearby_mode",
+		Argstr: mode + " " + formatLocation(lat, lon),
+	}
+	JSON, _ := json.Marshal(data)
+	return telegram.InlineKeyboardButton{
+		Text:         text,
+		CallbackData: string(JSON),
+	}
+}
+
+// newCompactNearbyMarkup returns the reply markup for a compact nearby bus stops message.
+func newCompactNearbyMarkup(lat, lon float64) telegram.InlineKeyboardMarkup {
+	data := CallbackData{
+		Type:   "nearby",
+		Argstr: formatLocation(lat, lon),
+	}
+	JSON, _ := json.Marshal(data)
+	return telegram.InlineKeyboardMarkup{
+		InlineKeyboard: [][]telegram.InlineKeyboardButton{
+			{
+				{Text: "Refresh", CallbackData: string(JSON)},
+				NewNearbyModeButton(NearbyModeVenues, lat, lon),
+			},
+		},
+	}
+}
+
+// newCompactNearbyMessage returns the text and markup of a compact nearby bus stops message. found is false if there
+// are no bus stops near the location.
+func newCompactNearbyMessage(ctx context.Context, bot *BusEtaBot, userID int, preferences Preferences, lat, lon float64) (text string, markup telegram.InlineKeyboardMarkup, found bool, err error) {
+	nearby := bot.BusStops.Nearby(ctx, lat, lon, preferences.nearbyRadius(), preferences.nearbyLimit())
+	if len(nearby) == 0 {
+		return "", telegram.InlineKeyboardMarkup{}, false, nil
+	}
+	etas := NewNearbyETAs(ctx, bot.etaMessages(), userID, nearby, bot.NowFunc())
+	text, err = etas.Format()
+	if err != nil {
+		return "", telegram.InlineKeyboardMarkup{}, false, err
+	}
+	return text, newCompactNearbyMarkup(lat, lon), true, nil
+}
+
+// sendCompactNearbyMessage replies to a location with one message listing the nearby bus stops and their etas.
+func sendCompactNearbyMessage(ctx context.Context, bot *BusEtaBot, preferences Preferences, message *tgbotapi.Message) error {
+	location := message.Location
+	text, markup, found, err := newCompactNearbyMessage(ctx, bot, message.From.ID, preferences, location.Latitude, location.Longitude)
+	if err != nil {
+		return err
+	}
+	go bot.LogEvent(ctx, message.From, CategoryMessage, ActionCompactLocationMessage, message.Chat.Type)
+	req := telegram.SendMessageRequest{
+		ChatID: message.Chat.ID,
+		Text:   fmt.Sprintf("Oops, I couldn't find any bus stops within %.0f m of your location.", preferences.nearbyRadius()),
+	}
+	if found {
+		req.Text = text
+		req.ParseMode = "markdown"
+		req.ReplyMarkup = markup
+	}
+	if !message.Chat.IsPrivate() {
+		req.ReplyToMessageID = message.MessageID
+	}
+	err = bot.TelegramService.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "error sending message")
+	}
+	return nil
+}
+
+// editCompactNearbyMessage replaces the message a callback query came from with a compact nearby bus stops message.
+func editCompactNearbyMessage(ctx context.Context, bot *BusEtaBot, cbq *tgbotapi.CallbackQuery, preferences Preferences, lat, lon float64, answer string, responses chan<- Response) {
+	text, markup, found, err := newCompactNearbyMessage(ctx, bot, cbq.From.ID, preferences, lat, lon)
+	if err != nil {
+		responses <- notOk(err)
+		return
+	}
+	if !found {
+		text = fmt.Sprintf("Oops, I couldn't find any bus stops within %.0f m of your location.", preferences.nearbyRadius())
+	}
+	message := callbackQueryMessage(cbq)
+	responses <- ok(telegram.EditMessageTextRequest{
+		ChatID:          message.ChatID,
+		MessageID:       message.MessageID,
+		InlineMessageID: message.InlineMessageID,
+		Text:            text,
+		ParseMode:       "markdown",
+		ReplyMarkup:     markup,
+	})
+	responses <- ok(telegram.AnswerCallbackQueryRequest{
+		CallbackQueryID: cbq.ID,
+		Text:            answer,
+	})
+}
+
+// NearbyCallbackHandler handles the Refresh button on a compact nearby bus stops message.
+func NearbyCallbackHandler(ctx context.Context, bot *BusEtaBot, cbq *tgbotapi.CallbackQuery, responses chan<- Response) {
+	defer close(responses)
+
+	var data CallbackData
+	err := json.Unmarshal([]byte(cbq.Data), &data)
+	if err != nil {
+		responses <- notOk(errors.Wrap(err, "error unmarshalling callback data"))
+		return
+	}
+	lat, lon, err := parseLocation(data.Argstr)
+	if err != nil {
+		responses <- notOk(err)
+		return
+	}
+	go bot.LogEvent(ctx, cbq.From, CategoryCallback, ActionNearbyCallback, "")
+	editCompactNearbyMessage(ctx, bot, cbq, bot.userPreferences(ctx, cbq.From.ID), lat, lon, "ETAs updated!", responses)
+}
+
+// NearbyModeCallbackHandler saves how a user wants nearby bus stops to be shown. Switching to compact mode also
+// replaces the message the callback query came from with a compact nearby bus stops message.
+func NearbyModeCallbackHandler(ctx context.Context, bot *BusEtaBot, cbq *tgbotapi.CallbackQuery, responses chan<- Response) {
+	defer close(responses)
+
+	var data CallbackData
+	err := json.Unmarshal([]byte(cbq.Data), &data)
+	if err != nil {
+		responses <- notOk(errors.Wrap(err, "error unmarshalling callback data"))
+		return
+	}
+	var mode, location string
+	if i := strings.Index(data.Argstr, " "); i >= 0 {
+		mode, location = data.Argstr[:i], data.Argstr[i+1:]
+	}
+	if mode != NearbyModeVenues && mode != NearbyModeCompact {
+		responses <- notOk(errors.Errorf("invalid nearby mode %q", mode))
+		return
+	}
+	lat, lon, err := parseLocation(location)
+	if err != nil {
+		responses <- notOk(err)
+		return
+	}
+	go bot.LogEvent(ctx, cbq.From, CategoryCallback, ActionNearbyModeCallback, mode)
+
+	answer := "Nearby bus stops will be shown in one message from now on."
+	if mode == NearbyModeVenues {
+		answer = "Nearby bus stops will be sent as separate messages from now on."
+	}
+	preferences := bot.userPreferences(ctx, cbq.From.ID)
+	if bot.Preferences == nil {
+		answer = "Oops, your preferences cannot be saved at the moment."
+	} else {
+		preferences.NearbyMode = mode
+		err = bot.Preferences.SetUserPreferences(ctx, cbq.From.ID, preferences)
+		if err != nil {
+			responses <- notOk(err)
+			return
+		}
+	}
+	if mode == NearbyModeCompact {
+		editCompactNearbyMessage(ctx, bot, cbq, preferences, lat, lon, answer, responses)
+		return
+	}
+	responses <- ok(telegram.AnswerCallbackQueryRequest{
+		CallbackQueryID: cbq.ID,
+		Text:            answer,
+	})
+}
@@ -0,0 +1,293 @@
+package busetabot
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kr/pretty"
+	"github.com/stretchr/testify/assert"
+	"github.com/yi-jiayu/datamall/v3"
+	"github.com/yi-jiayu/telegram-bot-api"
+
+	"github.com/yi-jiayu/bus-eta-bot/v4/telegram"
+)
+
+type mockPreferenceRepository map[int]Preferences
+
+func (r mockPreferenceRepository) GetUserPreferences(ctx context.Context, userID int) (Preferences, error) {
+	return r[userID], nil
+}
+
+func (r mockPreferenceRepository) SetUserPreferences(ctx context.Context, userID int, preferences Preferences) error {
+	r[userID] = preferences
+	return nil
+}
+
+func newNearbyTestBot(now time.Time) *BusEtaBot {
+	return &BusEtaBot{
+		BusStops: &mockBusStopRepository{
+			NearbyBusStops: []BusStop{
+				{BusStopCode: "96049", Description: "Opp Tropicana Condo", Latitude: 1.33995375346513, Longitude: 103.96079768187379},
+				{BusStopCode: "96041", Description: "Bef Tropicana Condo", Latitude: 1.34041450268626, Longitude: 103.96127892061004},
+			},
+		},
+		Datamall: mockETAService{
+			BusArrival: datamall.BusArrival{
+				Services: []datamall.Service{
+					{ServiceNo: "24", NextBus: datamall.ArrivingBus{EstimatedArrival: now.Add(5 * time.Minute)}},
+					{ServiceNo: "2", NextBus: datamall.ArrivingBus{EstimatedArrival: now.Add(90 * time.Second)}},
+				},
+			},
+		},
+		NowFunc:     func() time.Time { return now },
+		Preferences: mockPreferenceRepository{1: {NearbyMode: NearbyModeCompact}},
+	}
+}
+
+const expectedCompactNearbyText = "Bus stops near your location:\n\n" +
+	"*Opp Tropicana Condo (96049)*\n" +
+	"23 m away, 1 min walk\n" +
+	"`2: 1  24: 5`\n\n" +
+	"*Bef Tropicana Condo (96041)*\n" +
+	"55 m away, 1 min walk\n" +
+	"`2: 1  24: 5`\n\n" +
+	"_Minutes until the next bus, as of Mon, 01 Jan 18 08:00 SGT_"
+
+var expectedCompactNearbyMarkup = telegram.InlineKeyboardMarkup{
+	InlineKeyboard: [][]telegram.InlineKeyboardButton{
+		{
+			{Text: "Refresh", CallbackData: `{"t":"nearby","a":"1.340000 103.961000"}`},
+			{Text: "Show separately", CallbackData: `{"t":"nearby_mode","a":"venues 1.340000 103.961000"}`},
+		},
+	},
+}
+
+func Test_walkingMinutes(t *testing.T) {
+	assert.Equal(t, 1, walkingMinutes(0))
+	assert.Equal(t, 1, walkingMinutes(80))
+	assert.Equal(t, 2, walkingMinutes(81))
+	assert.Equal(t, 7, walkingMinutes(500))
+}
+
+func TestNearbyETAs_Format(t *testing.T) {
+	now := time.Date(2018, 1, 1, 8, 0, 0, 0, sgt)
+	etas := NearbyETAs{
+		Now: now,
+		Stops: []NearbyETA{
+			{
+				ETA: ETA{
+					BusStop: BusStop{BusStopCode: "96049", Description: "Opp Tropicana Condo"},
+					Now:     now,
+					Services: []datamall.Service{
+						{ServiceNo: "24", NextBus: datamall.ArrivingBus{EstimatedArrival: now.Add(5 * time.Minute)}},
+						{ServiceNo: "2"},
+					},
+				},
+				Distance: 23.4,
+			},
+			{
+				ETA: ETA{
+					BusStop: BusStop{BusStopCode: "96041", Description: "Bef Tropicana Condo"},
+					Now:     now,
+					Error:   "Oops, couldn't get etas.",
+				},
+				Distance: 160,
+			},
+			{
+				ETA: ETA{
+					BusStop: BusStop{BusStopCode: "96031", Description: "Opp Changi_Village*"},
+					Now:     now,
+				},
+				Distance: 480,
+			},
+		},
+	}
+	actual, err := etas.Format()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "Bus stops near your location:\n\n" +
+		"*Opp Tropicana Condo (96049)*\n" +
+		"23 m away, 1 min walk\n" +
+		"`2: ?  24: 5`\n\n" +
+		"*Bef Tropicana Condo (96041)*\n" +
+		"160 m away, 2 min walk\n" +
+		"Oops, couldn't get etas.\n\n" +
+		"*Opp Changi\\_Village\\* (96031)*\n" +
+		"480 m away, 6 min walk\n" +
+		"No ETAs available.\n\n" +
+		"_Minutes until the next bus, as of Mon, 01 Jan 18 08:00 SGT_"
+	assert.Equal(t, expected, actual)
+}
+
+func TestNearbyETAs_FormatStale(t *testing.T) {
+	now := time.Date(2018, 1, 1, 8, 0, 0, 0, sgt)
+	etaService := mockETAService{
+		BusArrival: datamall.BusArrival{
+			Services: []datamall.Service{
+				{ServiceNo: "24", NextBus: datamall.ArrivingBus{EstimatedArrival: now.Add(5 * time.Minute)}},
+			},
+		},
+		Error: &StaleArrivalError{Err: errors.New("DataMall is down"), FetchedAt: now.Add(-10 * time.Minute)},
+	}
+	nearby := []NearbyBusStop{
+		{BusStop: BusStop{BusStopCode: "96049", Description: "Opp Tropicana Condo"}, Distance: 23.4},
+	}
+	etas := NewNearbyETAs(context.Background(), NewETAMessageFactory(&mockBusStopRepository{}, etaService, nil), 1, nearby, now)
+	actual, err := etas.Format()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "Bus stops near your location:\n\n" +
+		"*Opp Tropicana Condo (96049)*\n" +
+		"23 m away, 1 min walk\n" +
+		"`24: 5`\n" +
+		"_Stale as of 07:50._\n\n" +
+		"_Minutes until the next bus, as of Mon, 01 Jan 18 08:00 SGT_"
+	assert.Equal(t, expected, actual)
+}
+
+func TestLocationHandler_Compact(t *testing.T) {
+	now := time.Date(2018, 1, 1, 8, 0, 0, 0, sgt)
+	bot := newNearbyTestBot(now)
+	tg := &mockTelegramService{}
+	bot.TelegramService = tg
+	message := MockMessage()
+	message.Location = &tgbotapi.Location{Latitude: 1.34, Longitude: 103.961}
+
+	err := LocationHandler(context.Background(), bot, &message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []telegram.Request{
+		telegram.SendMessageRequest{
+			ChatID:      1,
+			Text:        expectedCompactNearbyText,
+			ParseMode:   "markdown",
+			ReplyMarkup: expectedCompactNearbyMarkup,
+		},
+	}
+	if !assert.Equal(t, expected, tg.Requests) {
+		pretty.Println(tg.Requests)
+	}
+}
+
+func TestLocationHandler_CompactWithPreferences(t *testing.T) {
+	now := time.Date(2018, 1, 1, 8, 0, 0, 0, sgt)
+	bot := newNearbyTestBot(now)
+	bot.Preferences = mockPreferenceRepository{1: {NearbyMode: NearbyModeCompact, NearbyLimit: 1, TimeDisplay: TimeDisplayClock}}
+	tg := &mockTelegramService{}
+	bot.TelegramService = tg
+	message := MockMessage()
+	message.Location = &tgbotapi.Location{Latitude: 1.34, Longitude: 103.961}
+
+	err := LocationHandler(context.Background(), bot, &message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []telegram.Request{
+		telegram.SendMessageRequest{
+			ChatID: 1,
+			Text: "Bus stops near your location:\n\n" +
+				"*Opp Tropicana Condo (96049)*\n" +
+				"23 m away, 1 min walk\n" +
+				"`2: 08:01  24: 08:05`\n\n" +
+				"_Arrival time of the next bus, as of Mon, 01 Jan 18 08:00 SGT_",
+			ParseMode:   "markdown",
+			ReplyMarkup: expectedCompactNearbyMarkup,
+		},
+	}
+	if !assert.Equal(t, expected, tg.Requests) {
+		pretty.Println(tg.Requests)
+	}
+}
+
+func TestNearbyCallbackHandler(t *testing.T) {
+	now := time.Date(2018, 1, 1, 8, 0, 0, 0, sgt)
+	bot := newNearbyTestBot(now)
+	responses := make(chan Response, ResponseBufferSize)
+
+	NearbyCallbackHandler(context.Background(), bot, newCallbackQueryFromMessage(`{"t":"nearby","a":"1.340000 103.961000"}`), responses)
+
+	actual, err := collectResponsesWithTimeout(responses, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Response{
+		ok(telegram.EditMessageTextRequest{
+			ChatID:      1,
+			MessageID:   1,
+			Text:        expectedCompactNearbyText,
+			ParseMode:   "markdown",
+			ReplyMarkup: expectedCompactNearbyMarkup,
+		}),
+		ok(telegram.AnswerCallbackQueryRequest{CallbackQueryID: "1", Text: "ETAs updated!"}),
+	}
+	if !assert.Equal(t, expected, actual) {
+		pretty.Println(actual)
+	}
+}
+
+func TestNearbyModeCallbackHandler(t *testing.T) {
+	now := time.Date(2018, 1, 1, 8, 0, 0, 0, sgt)
+	t.Run("switching to compact mode", func(t *testing.T) {
+		bot := newNearbyTestBot(now)
+		preferences := mockPreferenceRepository{}
+		bot.Preferences = preferences
+		responses := make(chan Response, ResponseBufferSize)
+
+		NearbyModeCallbackHandler(context.Background(), bot, newCallbackQueryFromMessage(`{"t":"nearby_mode","a":"compact 1.340000 103.961000"}`), responses)
+
+		actual, err := collectResponsesWithTimeout(responses, 100*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []Response{
+			ok(telegram.EditMessageTextRequest{
+				ChatID:      1,
+				MessageID:   1,
+				Text:        expectedCompactNearbyText,
+				ParseMode:   "markdown",
+				ReplyMarkup: expectedCompactNearbyMarkup,
+			}),
+			ok(telegram.AnswerCallbackQueryRequest{CallbackQueryID: "1", Text: "Nearby bus stops will be shown in one message from now on."}),
+		}
+		if !assert.Equal(t, expected, actual) {
+			pretty.Println(actual)
+		}
+		assert.Equal(t, NearbyModeCompact, preferences[1].NearbyMode)
+	})
+	t.Run("switching to venues mode", func(t *testing.T) {
+		bot := newNearbyTestBot(now)
+		preferences := bot.Preferences.(mockPreferenceRepository)
+		responses := make(chan Response, ResponseBufferSize)
+
+		NearbyModeCallbackHandler(context.Background(), bot, newCallbackQueryFromMessage(`{"t":"nearby_mode","a":"venues 1.340000 103.961000"}`), responses)
+
+		actual, err := collectResponsesWithTimeout(responses, 100*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []Response{
+			ok(telegram.AnswerCallbackQueryRequest{CallbackQueryID: "1", Text: "Nearby bus stops will be sent as separate messages from now on."}),
+		}
+		assert.Equal(t, expected, actual)
+		assert.Equal(t, NearbyModeVenues, preferences[1].NearbyMode)
+	})
+	t.Run("with an invalid mode", func(t *testing.T) {
+		bot := newNearbyTestBot(now)
+		responses := make(chan Response, ResponseBufferSize)
+
+		NearbyModeCallbackHandler(context.Background(), bot, newCallbackQueryFromMessage(`{"t":"nearby_mode","a":"grid 1.340000 103.961000"}`), responses)
+
+		actual, err := collectResponsesWithTimeout(responses, 100*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if assert.Len(t, actual, 1) {
+			assert.EqualError(t, actual[0].Error, `invalid nearby mode "grid"`)
+		}
+	})
+}
@@ -0,0 +1,303 @@
+package busetabot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/yi-jiayu/datamall/v3"
+
+	"github.com/yi-jiayu/bus-eta-bot/v4/telegram"
+)
+
+// Journey planner parameters
+const (
+	// MaxTransferWalk is the furthest in metres that a journey may walk between two bus stops to transfer.
+	MaxTransferWalk = 200
+
+	// MaxDirectJourneys is the maximum number of direct journeys suggested.
+	MaxDirectJourneys = 5
+
+	// MaxTransferJourneys is the maximum number of journeys with a transfer suggested.
+	MaxTransferJourneys = 3
+
+	// MinutesPerStop is the estimated time taken to travel between two consecutive bus stops.
+	MinutesPerStop = 2
+)
+
+var errPlanUsage = errors.New("invalid plan arguments")
+
+// JourneyLeg is a ride on a single bus service between two bus stops.
+type JourneyLeg struct {
+	ServiceNo string
+	Direction int
+	From      string
+	To        string
+	Stops     int
+}
+
+// Journey is a way of getting from one bus stop to another with at most one transfer.
+type Journey struct {
+	Legs []JourneyLeg
+
+	// Wait is the number of minutes until the next bus for the first leg arrives, or -1 if it is not known.
+	Wait int
+}
+
+// Stops returns the total number of stops travelled on a journey.
+func (j Journey) Stops() int {
+	stops := 0
+	for _, leg := range j.Legs {
+		stops += leg.Stops
+	}
+	return stops
+}
+
+// Walk reports whether a journey involves walking between two bus stops to transfer.
+func (j Journey) Walk() bool {
+	return len(j.Legs) == 2 && j.Legs[0].To != j.Legs[1].From
+}
+
+// estimatedMinutes returns the estimated travel time for a journey including the wait for the first bus.
+func (j Journey) estimatedMinutes() int {
+	minutes := j.Stops() * MinutesPerStop
+	if j.Wait > 0 {
+		minutes += j.Wait
+	}
+	return minutes
+}
+
+// ParsePlanArgs parses the arguments to the /plan command, which are the bus stop codes to travel from and to.
+func ParsePlanArgs(args string) (from, to string, err error) {
+	fields := strings.Fields(args)
+	if len(fields) != 2 || !busStopRegex.MatchString(fields[0]) || !busStopRegex.MatchString(fields[1]) {
+		return "", "", errPlanUsage
+	}
+	return fields[0], fields[1], nil
+}
+
+type routeKey struct {
+	ServiceNo string
+	Direction int
+}
+
+// PlanJourneys finds direct journeys between two bus stops, as well as journeys with a single transfer using services
+// which do not go there directly. Transfers can involve walking up to MaxTransferWalk metres to another bus stop. Only
+// the shortest journey for each service or pair of services is returned, and journeys are not ranked.
+func PlanJourneys(ctx context.Context, busStops BusStopRepository, routes RouteRepository, from, to string) (direct, transfers []Journey) {
+	toIndexes := make(map[routeKey][]int)
+	for _, q := range routes.RoutesThrough(to) {
+		key := routeKey{q.ServiceNo, q.Direction}
+		toIndexes[key] = append(toIndexes[key], q.Index)
+	}
+	// nextIndex returns the number of stops from index to the next time a route reaches the destination
+	nextIndex := func(key routeKey, index int) (int, bool) {
+		for _, i := range toIndexes[key] {
+			if i > index {
+				return i - index, true
+			}
+		}
+		return 0, false
+	}
+
+	fromPositions := routes.RoutesThrough(from)
+	directServices := make(map[string]Journey)
+	for _, p := range fromPositions {
+		stops, ok := nextIndex(routeKey{p.ServiceNo, p.Direction}, p.Index)
+		if !ok {
+			continue
+		}
+		if j, ok := directServices[p.ServiceNo]; ok && j.Stops() <= stops {
+			continue
+		}
+		directServices[p.ServiceNo] = Journey{
+			Legs: []JourneyLeg{{ServiceNo: p.ServiceNo, Direction: p.Direction, From: from, To: to, Stops: stops}},
+			Wait: -1,
+		}
+	}
+	for _, j := range directServices {
+		direct = append(direct, j)
+	}
+
+	transferStops := make(map[string][]string)
+	nearby := func(code string) []string {
+		if codes, ok := transferStops[code]; ok {
+			return codes
+		}
+		codes := []string{code}
+		if stop := busStops.Get(code); stop != nil {
+			for _, n := range busStops.Nearby(ctx, stop.Latitude, stop.Longitude, MaxTransferWalk, 0) {
+				if n.BusStopCode != code {
+					codes = append(codes, n.BusStopCode)
+				}
+			}
+		}
+		transferStops[code] = codes
+		return codes
+	}
+
+	transferServices := make(map[[2]string]Journey)
+	for _, p := range fromPositions {
+		if _, ok := directServices[p.ServiceNo]; ok {
+			continue
+		}
+		first := route(routes, p.ServiceNo, p.Direction)
+		if first == nil {
+			continue
+		}
+		for i := p.Index + 1; i < len(first.Stops); i++ {
+			alight := first.Stops[i].BusStopCode
+			for _, board := range nearby(alight) {
+				for _, r := range routes.RoutesThrough(board) {
+					if _, ok := directServices[r.ServiceNo]; ok || r.ServiceNo == p.ServiceNo {
+						continue
+					}
+					stops, ok := nextIndex(routeKey{r.ServiceNo, r.Direction}, r.Index)
+					if !ok {
+						continue
+					}
+					j := Journey{
+						Legs: []JourneyLeg{
+							{ServiceNo: p.ServiceNo, Direction: p.Direction, From: from, To: alight, Stops: i - p.Index},
+							{ServiceNo: r.ServiceNo, Direction: r.Direction, From: board, To: to, Stops: stops},
+						},
+						Wait: -1,
+					}
+					key := [2]string{p.ServiceNo, r.ServiceNo}
+					if best, ok := transferServices[key]; ok {
+						if best.Stops() < j.Stops() || best.Stops() == j.Stops() && (!best.Walk() || j.Walk()) {
+							continue
+						}
+					}
+					transferServices[key] = j
+				}
+			}
+		}
+	}
+	for _, j := range transferServices {
+		transfers = append(transfers, j)
+	}
+	return
+}
+
+// rankJourneys sets the wait for the first bus of each journey from arrivals at the starting bus stop and sorts
+// journeys by their estimated travel time. Journeys without an upcoming bus are ranked last.
+func rankJourneys(journeys []Journey, arrival datamall.BusArrival, now time.Time) {
+	waits := make(map[string]int)
+	for _, service := range arrival.Services {
+		if t := service.NextBus.EstimatedArrival; !t.IsZero() {
+			wait := int(t.Sub(now) / time.Minute)
+			if wait < 0 {
+				wait = 0
+			}
+			waits[service.ServiceNo] = wait
+		}
+	}
+	for i := range journeys {
+		if wait, ok := waits[journeys[i].Legs[0].ServiceNo]; ok {
+			journeys[i].Wait = wait
+		}
+	}
+	sort.Slice(journeys, func(i, j int) bool {
+		a, b := journeys[i], journeys[j]
+		if (a.Wait < 0) != (b.Wait < 0) {
+			return a.Wait >= 0
+		}
+		if a.estimatedMinutes() != b.estimatedMinutes() {
+			return a.estimatedMinutes() < b.estimatedMinutes()
+		}
+		if a.Stops() != b.Stops() {
+			return a.Stops() < b.Stops()
+		}
+		return journeyName(a) < journeyName(b)
+	})
+}
+
+func journeyName(j Journey) string {
+	var services []string
+	for _, leg := range j.Legs {
+		services = append(services, leg.ServiceNo)
+	}
+	return strings.Join(services, " ")
+}
+
+func pluralStops(n int) string {
+	if n == 1 {
+		return "1 stop"
+	}
+	return fmt.Sprintf("%d stops", n)
+}
+
+// describeJourney returns a description of a journey such as "Take 2 for 5 stops to Blk 1 (12345), walk to Blk 2
+// (12346), then take 96 for 8 stops. Next bus in 3 min."
+func describeJourney(busStops BusStopGetter, j Journey) string {
+	var b strings.Builder
+	first := j.Legs[0]
+	fmt.Fprintf(&b, "Take %s for %s", first.ServiceNo, pluralStops(first.Stops))
+	if len(j.Legs) == 2 {
+		second := j.Legs[1]
+		fmt.Fprintf(&b, " to %s", describeBusStop(busStops, first.To))
+		if j.Walk() {
+			fmt.Fprintf(&b, ", walk to %s", describeBusStop(busStops, second.From))
+		}
+		fmt.Fprintf(&b, ", then take %s for %s", second.ServiceNo, pluralStops(second.Stops))
+	}
+	b.WriteString(".")
+	if j.Wait >= 0 {
+		fmt.Fprintf(&b, " Next bus in %d min.", j.Wait)
+	}
+	return b.String()
+}
+
+// newPlanMessage returns the text and reply markup for a message listing journeys between two bus stops. The reply
+// markup contains a button to get etas at the starting bus stop for the first service of each journey, leaving out the
+// services of the last journeys if they do not all fit in the button's callback data.
+func newPlanMessage(busStops BusStopGetter, from, to string, direct, transfers []Journey) (string, telegram.ReplyMarkup) {
+	if len(direct) == 0 && len(transfers) == 0 {
+		return fmt.Sprintf("Sorry, I couldn't find a way to get from %s to %s with at most one transfer.",
+			describeBusStop(busStops, from), describeBusStop(busStops, to)), nil
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Journeys from %s to %s:\n", describeBusStop(busStops, from), describeBusStop(busStops, to))
+	var services []string
+	n := 0
+	for _, section := range []struct {
+		heading  string
+		journeys []Journey
+	}{
+		{"Direct", direct},
+		{"With one transfer", transfers},
+	} {
+		if len(section.journeys) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\n%s\n", section.heading)
+		for _, j := range section.journeys {
+			n++
+			fmt.Fprintf(&b, "%d. %s\n", n, describeJourney(busStops, j))
+			if !contains(services, j.Legs[0].ServiceNo) {
+				services = append(services, j.Legs[0].ServiceNo)
+			}
+		}
+	}
+	data := CallbackData{
+		Type:       "new_eta",
+		BusStopID:  from,
+		ServiceNos: services,
+	}
+	JSON, _ := json.Marshal(data)
+	for len(JSON) > maxCallbackDataLength && len(data.ServiceNos) > 1 {
+		data.ServiceNos = data.ServiceNos[:len(data.ServiceNos)-1]
+		JSON, _ = json.Marshal(data)
+	}
+	markup := telegram.InlineKeyboardMarkup{
+		InlineKeyboard: [][]telegram.InlineKeyboardButton{
+			{{Text: "Show etas at " + from, CallbackData: string(JSON)}},
+		},
+	}
+	return strings.TrimSuffix(b.String(), "\n"), markup
+}
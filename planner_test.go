@@ -0,0 +1,243 @@
+package busetabot
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kr/pretty"
+	"github.com/stretchr/testify/assert"
+	"github.com/yi-jiayu/datamall/v3"
+
+	"github.com/yi-jiayu/bus-eta-bot/v4/telegram"
+)
+
+// newPlannerTestNetwork returns a network where 10 and 20 go directly from 10001 to 10009, 30 connects to 50 at 10003
+// and to 40 by walking from 10003 to 10004.
+func newPlannerTestNetwork() (*InMemoryBusStopRepository, *InMemoryRouteRepository) {
+	busStops := NewInMemoryBusStopRepository([]BusStop{
+		{BusStopCode: "10001", Description: "Start", Latitude: 1.35, Longitude: 103.70},
+		{BusStopCode: "10002", Description: "Middle", Latitude: 1.33, Longitude: 103.75},
+		{BusStopCode: "10003", Description: "Junction", Latitude: 1.300, Longitude: 103.80},
+		{BusStopCode: "10004", Description: "Opp Junction", Latitude: 1.301, Longitude: 103.80},
+		{BusStopCode: "10005", Description: "Detour 1", Latitude: 1.28, Longitude: 103.82},
+		{BusStopCode: "10006", Description: "Detour 2", Latitude: 1.27, Longitude: 103.84},
+		{BusStopCode: "10009", Description: "End", Latitude: 1.25, Longitude: 103.85},
+	}, nil)
+	routes := NewInMemoryRouteRepository([]Route{
+		newTestRoute("10", 1, "10001", "10002", "10009"),
+		newTestRoute("20", 1, "10001", "10002", "10003", "10004", "10009"),
+		newTestRoute("20", 2, "10009", "10004", "10003", "10002", "10001"),
+		newTestRoute("30", 1, "10001", "10003"),
+		newTestRoute("40", 1, "10004", "10009"),
+		newTestRoute("50", 1, "10003", "10005", "10006", "10009"),
+	})
+	return busStops, routes
+}
+
+func TestParsePlanArgs(t *testing.T) {
+	from, to, err := ParsePlanArgs(" 96049  17179 ")
+	assert.NoError(t, err)
+	assert.Equal(t, "96049", from)
+	assert.Equal(t, "17179", to)
+
+	for _, args := range []string{"", "96049", "96049 17179 2", "96049 clementi"} {
+		_, _, err := ParsePlanArgs(args)
+		assert.Equal(t, errPlanUsage, err, args)
+	}
+}
+
+func TestPlanJourneys(t *testing.T) {
+	busStops, routes := newPlannerTestNetwork()
+	direct, transfers := PlanJourneys(context.Background(), busStops, routes, "10001", "10009")
+	rankJourneys(direct, datamall.BusArrival{}, time.Time{})
+	rankJourneys(transfers, datamall.BusArrival{}, time.Time{})
+
+	expectedDirect := []Journey{
+		{Legs: []JourneyLeg{{ServiceNo: "10", Direction: 1, From: "10001", To: "10009", Stops: 2}}, Wait: -1},
+		{Legs: []JourneyLeg{{ServiceNo: "20", Direction: 1, From: "10001", To: "10009", Stops: 4}}, Wait: -1},
+	}
+	if !assert.Equal(t, expectedDirect, direct) {
+		pretty.Println(direct)
+	}
+	expectedTransfers := []Journey{
+		{
+			Legs: []JourneyLeg{
+				{ServiceNo: "30", Direction: 1, From: "10001", To: "10003", Stops: 1},
+				{ServiceNo: "40", Direction: 1, From: "10004", To: "10009", Stops: 1},
+			},
+			Wait: -1,
+		},
+		{
+			Legs: []JourneyLeg{
+				{ServiceNo: "30", Direction: 1, From: "10001", To: "10003", Stops: 1},
+				{ServiceNo: "50", Direction: 1, From: "10003", To: "10009", Stops: 3},
+			},
+			Wait: -1,
+		},
+	}
+	if !assert.Equal(t, expectedTransfers, transfers) {
+		pretty.Println(transfers)
+	}
+	assert.True(t, transfers[0].Walk())
+	assert.False(t, transfers[1].Walk())
+}
+
+func TestRankJourneys(t *testing.T) {
+	now := time.Date(2018, 1, 1, 8, 0, 0, 0, sgt)
+	journeys := []Journey{
+		{Legs: []JourneyLeg{{ServiceNo: "10", Stops: 2}}, Wait: -1},
+		{Legs: []JourneyLeg{{ServiceNo: "20", Stops: 4}}, Wait: -1},
+		{Legs: []JourneyLeg{{ServiceNo: "30", Stops: 1}}, Wait: -1},
+	}
+	arrival := datamall.BusArrival{
+		Services: []datamall.Service{
+			{ServiceNo: "10", NextBus: datamall.ArrivingBus{EstimatedArrival: now.Add(10 * time.Minute)}},
+			{ServiceNo: "20", NextBus: datamall.ArrivingBus{EstimatedArrival: now.Add(time.Minute)}},
+		},
+	}
+	rankJourneys(journeys, arrival, now)
+	expected := []Journey{
+		{Legs: []JourneyLeg{{ServiceNo: "20", Stops: 4}}, Wait: 1},
+		{Legs: []JourneyLeg{{ServiceNo: "10", Stops: 2}}, Wait: 10},
+		{Legs: []JourneyLeg{{ServiceNo: "30", Stops: 1}}, Wait: -1},
+	}
+	assert.Equal(t, expected, journeys)
+}
+
+func TestNewPlanMessage_ManyServices(t *testing.T) {
+	var direct []Journey
+	for _, serviceNo := range []string{"10", "20", "30", "40", "50", "60", "70", "80", "90"} {
+		direct = append(direct, Journey{
+			Legs: []JourneyLeg{{ServiceNo: serviceNo, From: "10001", To: "10009", Stops: 1}},
+			Wait: -1,
+		})
+	}
+	_, markup := newPlanMessage(busStopsByCode{}, "10001", "10009", direct, nil)
+	data := markup.(telegram.InlineKeyboardMarkup).InlineKeyboard[0][0].CallbackData
+	assert.True(t, len(data) <= maxCallbackDataLength, "callback data is %d bytes long", len(data))
+	assert.Equal(t, `{"t":"new_eta","b":"10001","s":["10","20","30","40","50","60"]}`, data)
+}
+
+func TestPlanCmdHandler(t *testing.T) {
+	busStops, routes := newPlannerTestNetwork()
+	now := time.Date(2018, 1, 1, 8, 0, 0, 0, sgt)
+	arrival := datamall.BusArrival{
+		Services: []datamall.Service{
+			{ServiceNo: "20", NextBus: datamall.ArrivingBus{EstimatedArrival: now.Add(time.Minute)}},
+			{ServiceNo: "30", NextBus: datamall.ArrivingBus{EstimatedArrival: now.Add(3 * time.Minute)}},
+		},
+	}
+	testCases := []struct {
+		Name     string
+		Text     string
+		Datamall ETAService
+		Routes   RouteRepository
+		Expected telegram.SendMessageRequest
+	}{
+		{
+			Name:     "with journeys",
+			Text:     "/plan 10001 10009",
+			Datamall: mockETAService{BusArrival: arrival},
+			Routes:   routes,
+			Expected: telegram.SendMessageRequest{
+				ChatID: 1,
+				Text: "Journeys from Start (10001) to End (10009):\n\n" +
+					"Direct\n" +
+					"1. Take 20 for 4 stops. Next bus in 1 min.\n" +
+					"2. Take 10 for 2 stops.\n\n" +
+					"With one transfer\n" +
+					"3. Take 30 for 1 stop to Junction (10003), walk to Opp Junction (10004), then take 40 for 1 stop. Next bus in 3 min.\n" +
+					"4. Take 30 for 1 stop to Junction (10003), then take 50 for 3 stops. Next bus in 3 min.",
+				ReplyMarkup: telegram.InlineKeyboardMarkup{
+					InlineKeyboard: [][]telegram.InlineKeyboardButton{
+						{{Text: "Show etas at 10001", CallbackData: `{"t":"new_eta","b":"10001","s":["20","10","30"]}`}},
+					},
+				},
+			},
+		},
+		{
+			Name:     "when etas are not available",
+			Text:     "/plan 10003 10009",
+			Datamall: mockETAService{Error: errors.New("datamall is down")},
+			Routes:   routes,
+			Expected: telegram.SendMessageRequest{
+				ChatID: 1,
+				Text:   "Journeys from Junction (10003) to End (10009):\n\nDirect\n1. Take 20 for 2 stops.\n2. Take 50 for 3 stops.",
+				ReplyMarkup: telegram.InlineKeyboardMarkup{
+					InlineKeyboard: [][]telegram.InlineKeyboardButton{
+						{{Text: "Show etas at 10003", CallbackData: `{"t":"new_eta","b":"10003","s":["20","50"]}`}},
+					},
+				},
+			},
+		},
+		{
+			Name:   "without a journey",
+			Text:   "/plan 10006 10005",
+			Routes: routes,
+			Expected: telegram.SendMessageRequest{
+				ChatID: 1,
+				Text:   "Sorry, I couldn't find a way to get from Detour 2 (10006) to Detour 1 (10005) with at most one transfer.",
+			},
+		},
+		{
+			Name:   "with an unknown bus stop",
+			Text:   "/plan 10001 99999",
+			Routes: routes,
+			Expected: telegram.SendMessageRequest{
+				ChatID: 1,
+				Text:   "Oops, I couldn't find bus stop 99999.",
+			},
+		},
+		{
+			Name:   "with the same bus stop",
+			Text:   "/plan 10001 10001",
+			Routes: routes,
+			Expected: telegram.SendMessageRequest{
+				ChatID: 1,
+				Text:   "Oops, you're already there!",
+			},
+		},
+		{
+			Name:   "with invalid arguments",
+			Text:   "/plan 10001",
+			Routes: routes,
+			Expected: telegram.SendMessageRequest{
+				ChatID:    1,
+				Text:      "Send `/plan <from> <to>` with two bus stop codes, for example `/plan 96049 17179`, to find buses between them.",
+				ParseMode: "markdown",
+			},
+		},
+		{
+			Name: "when routes are not available",
+			Text: "/plan 10001 10009",
+			Expected: telegram.SendMessageRequest{
+				ChatID: 1,
+				Text:   "Oops, journey planning is not available at the moment.",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			bot := &BusEtaBot{
+				Datamall: tc.Datamall,
+				BusStops: busStops,
+				Routes:   tc.Routes,
+				NowFunc:  func() time.Time { return now },
+			}
+			responses := make(chan Response, ResponseBufferSize)
+
+			PlanCmdHandler(context.Background(), bot, MockMessageWithText(tc.Text), responses)
+
+			actual, err := collectResponsesWithTimeout(responses, 100*time.Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := []Response{ok(tc.Expected)}
+			if !assert.Equal(t, expected, actual) {
+				pretty.Println(actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package busetabot
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/yi-jiayu/telegram-bot-api"
+)
+
+// Default long polling parameters.
+const (
+	DefaultPollTimeout       = 60
+	DefaultPollWorkers       = 4
+	DefaultPollRetryInterval = 5 * time.Second
+)
+
+// UpdateSource provides updates using the getUpdates method of the Telegram Bot API.
+type UpdateSource interface {
+	GetUpdates(config tgbotapi.UpdateConfig) ([]tgbotapi.Update, error)
+}
+
+// Poller retrieves updates from the Telegram Bot API using long polling and hands them to a pool of workers which
+// pass them to a BusEtaBot for handling.
+type Poller struct {
+	Source UpdateSource
+	Bot    *BusEtaBot
+
+	// Workers is the number of updates which can be handled concurrently.
+	Workers int
+
+	// Timeout is the long polling timeout in seconds.
+	Timeout int
+
+	// RetryInterval is how long to wait before polling again after getUpdates returns an error.
+	RetryInterval time.Duration
+
+	// Offset is the identifier of the next update to request. It is advanced after each batch of updates is received
+	// so that Telegram does not redeliver them.
+	Offset int
+
+	// NewContext returns the context to handle an update with. If nil, context.Background is used.
+	NewContext func() context.Context
+}
+
+// NewPoller returns a Poller for bot which retrieves updates from source with the default parameters.
+func NewPoller(source UpdateSource, bot *BusEtaBot) *Poller {
+	return &Poller{
+		Source:        source,
+		Bot:           bot,
+		Workers:       DefaultPollWorkers,
+		Timeout:       DefaultPollTimeout,
+		RetryInterval: DefaultPollRetryInterval,
+	}
+}
+
+type pollResult struct {
+	updates []tgbotapi.Update
+	err     error
+}
+
+// Run polls for updates until ctx is cancelled. Once ctx is cancelled, Run stops polling, acknowledges the updates
+// which have already been received so that Telegram does not redeliver them on the next run, and returns after they
+// have all been handled.
+func (p *Poller) Run(ctx context.Context) error {
+	workers := p.Workers
+	if workers <= 0 {
+		workers = DefaultPollWorkers
+	}
+	queue := make(chan tgbotapi.Update, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for update := range queue {
+				p.handle(update)
+			}
+		}()
+	}
+	defer wg.Wait()
+	defer close(queue)
+	defer p.acknowledge(ctx)
+
+	for {
+		updates, err := p.poll(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			p.Bot.logger().Errorf(ctx, "error getting updates: %+v", err)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(p.RetryInterval):
+			}
+			continue
+		}
+		for _, update := range updates {
+			if update.UpdateID >= p.Offset {
+				p.Offset = update.UpdateID + 1
+			}
+			queue <- update
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+// poll makes a single getUpdates request, returning early with the context's error if ctx is cancelled first. Updates
+// returned by a request which was abandoned this way are not acknowledged and will be redelivered on the next run.
+func (p *Poller) poll(ctx context.Context) ([]tgbotapi.Update, error) {
+	config := tgbotapi.UpdateConfig{
+		Offset:  p.Offset,
+		Timeout: p.Timeout,
+	}
+	results := make(chan pollResult, 1)
+	go func() {
+		updates, err := p.Source.GetUpdates(config)
+		results <- pollResult{updates: updates, err: err}
+	}()
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case r := <-results:
+		return r.updates, r.err
+	}
+}
+
+// acknowledge confirms the updates received so far by making one last getUpdates request with the next offset. It does
+// not wait for new updates, and any updates it returns are left to be delivered again on the next run.
+func (p *Poller) acknowledge(ctx context.Context) {
+	if p.Offset == 0 {
+		return
+	}
+	_, err := p.Source.GetUpdates(tgbotapi.UpdateConfig{
+		Offset:  p.Offset,
+		Timeout: 0,
+	})
+	if err != nil {
+		p.Bot.logger().Errorf(ctx, "error acknowledging updates: %+v", err)
+	}
+}
+
+func (p *Poller) handle(update tgbotapi.Update) {
+	ctx := context.Background()
+	if p.NewContext != nil {
+		ctx = p.NewContext()
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			p.Bot.logger().Errorf(ctx, "recovered from panic while handling update %d: %v", update.UpdateID, r)
+		}
+	}()
+	p.Bot.HandleUpdate(ctx, &update)
+}
@@ -0,0 +1,167 @@
+package busetabot
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yi-jiayu/telegram-bot-api"
+)
+
+type mockUpdateSource struct {
+	sync.Mutex
+	Batches [][]tgbotapi.Update
+	Errors  []error
+	Configs []tgbotapi.UpdateConfig
+	Done    chan struct{}
+}
+
+func (s *mockUpdateSource) GetUpdates(config tgbotapi.UpdateConfig) ([]tgbotapi.Update, error) {
+	s.Lock()
+	s.Configs = append(s.Configs, config)
+	if len(s.Errors) > 0 {
+		err := s.Errors[0]
+		s.Errors = s.Errors[1:]
+		s.Unlock()
+		return nil, err
+	}
+	if len(s.Batches) > 0 {
+		batch := s.Batches[0]
+		s.Batches = s.Batches[1:]
+		s.Unlock()
+		return batch, nil
+	}
+	s.Unlock()
+	if config.Timeout == 0 {
+		// a short poll returns immediately
+		return nil, nil
+	}
+	close(s.Done)
+	// block like a long poll which never receives any updates
+	select {}
+}
+
+func newTextUpdate(id int, text string) tgbotapi.Update {
+	return tgbotapi.Update{
+		UpdateID: id,
+		Message:  MockMessageWithText(text),
+	}
+}
+
+func TestPoller_Run(t *testing.T) {
+	var mu sync.Mutex
+	var handled []string
+	bot := &BusEtaBot{
+		Handlers: Handlers{
+			TextHandler: func(ctx context.Context, bot *BusEtaBot, message *tgbotapi.Message) error {
+				mu.Lock()
+				handled = append(handled, message.Text)
+				mu.Unlock()
+				return nil
+			},
+		},
+	}
+	source := &mockUpdateSource{
+		Batches: [][]tgbotapi.Update{
+			{newTextUpdate(10, "96049"), newTextUpdate(11, "81111")},
+			{newTextUpdate(12, "17179")},
+		},
+		Errors: []error{errors.New("network error")},
+		Done:   make(chan struct{}),
+	}
+	poller := NewPoller(source, bot)
+	poller.Workers = 2
+	poller.Timeout = 30
+	poller.RetryInterval = time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	finished := make(chan error)
+	go func() {
+		finished <- poller.Run(ctx)
+	}()
+	select {
+	case <-source.Done:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for updates to be polled")
+	}
+	cancel()
+	select {
+	case err := <-finished:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for poller to stop")
+	}
+
+	assert.ElementsMatch(t, []string{"96049", "81111", "17179"}, handled)
+	assert.Equal(t, 13, poller.Offset)
+	expectedConfigs := []tgbotapi.UpdateConfig{
+		{Offset: 0, Timeout: 30},
+		{Offset: 0, Timeout: 30},
+		{Offset: 12, Timeout: 30},
+		{Offset: 13, Timeout: 30},
+		{Offset: 13, Timeout: 0},
+	}
+	source.Lock()
+	defer source.Unlock()
+	assert.Equal(t, expectedConfigs, source.Configs, "the last update should be acknowledged before stopping")
+}
+
+func TestPoller_Run_RecoversFromPanics(t *testing.T) {
+	var mu sync.Mutex
+	var handled []string
+	bot := &BusEtaBot{
+		Handlers: Handlers{
+			TextHandler: func(ctx context.Context, bot *BusEtaBot, message *tgbotapi.Message) error {
+				if message.Text == "panic" {
+					panic("handler panicked")
+				}
+				mu.Lock()
+				handled = append(handled, message.Text)
+				mu.Unlock()
+				return nil
+			},
+		},
+	}
+	source := &mockUpdateSource{
+		Batches: [][]tgbotapi.Update{
+			{newTextUpdate(1, "panic"), newTextUpdate(2, "96049")},
+		},
+		Done: make(chan struct{}),
+	}
+	poller := NewPoller(source, bot)
+	poller.Workers = 1
+
+	ctx, cancel := context.WithCancel(context.Background())
+	finished := make(chan error)
+	go func() {
+		finished <- poller.Run(ctx)
+	}()
+	<-source.Done
+	cancel()
+	<-finished
+
+	assert.Equal(t, []string{"96049"}, handled)
+}
+
+func TestPoller_Run_WithoutUpdates(t *testing.T) {
+	source := &mockUpdateSource{
+		Done: make(chan struct{}),
+	}
+	poller := NewPoller(source, &BusEtaBot{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	finished := make(chan error)
+	go func() {
+		finished <- poller.Run(ctx)
+	}()
+	<-source.Done
+	cancel()
+	<-finished
+
+	source.Lock()
+	defer source.Unlock()
+	assert.Len(t, source.Configs, 1, "there should be nothing to acknowledge")
+}
@@ -0,0 +1,141 @@
+package busetabot
+
+import (
+	"context"
+)
+
+// Ways of showing the bus stops near a location.
+const (
+	// NearbyModeVenues sends each nearby bus stop as a separate venue message with a button to get its etas.
+	NearbyModeVenues = "venues"
+
+	// NearbyModeCompact lists the nearby bus stops and the next bus for each of their services in one message.
+	NearbyModeCompact = "compact"
+)
+
+// Ways of showing when buses will arrive.
+const (
+	// TimeDisplayRelative shows the number of minutes until each bus arrives.
+	TimeDisplayRelative = "relative"
+
+	// TimeDisplayClock shows the time of day when each bus arrives.
+	TimeDisplayClock = "clock"
+)
+
+// LanguageEnglish is the default language, and currently the only one the bot speaks.
+const LanguageEnglish = "en"
+
+// Languages contains the names of the languages which can be chosen, by language code.
+var Languages = map[string]string{
+	LanguageEnglish: "English",
+}
+
+// Preferences contains a user's saved settings. The zero value contains the default settings.
+type Preferences struct {
+	// NearbyMode is how bus stops near a location are shown, either NearbyModeVenues or NearbyModeCompact. Venues are
+	// shown if it is empty.
+	NearbyMode string
+
+	// Formatter is the name of the formatter used for eta messages. The summary formatter is used if it is empty.
+	Formatter string
+
+	// TimeDisplay is how arrival times are shown, either TimeDisplayRelative or TimeDisplayClock. Relative times are
+	// shown if it is empty.
+	TimeDisplay string
+
+	// NearbyRadius is the search range in metres for bus stops near a location, or 0 for LocationNearbyRadius.
+	NearbyRadius int
+
+	// NearbyLimit is the maximum number of bus stops shown for a location, or 0 for LocationNearbyLimit.
+	NearbyLimit int
+
+	// Language is the code of the language the bot replies in. English is used if it is empty.
+	Language string
+}
+
+// formatter returns the formatter called name, or the user's preferred formatter if name is empty, showing arrival
+// times the way the user prefers.
+func (p Preferences) formatter(name string) Formatter {
+	if name == "" {
+		name = p.Formatter
+	}
+	formatters := Formatters
+	if p.clockTimes() {
+		formatters = ClockFormatters
+	}
+	f, ok := formatters[name]
+	if !ok {
+		return formatters[FormatterSummary]
+	}
+	return f
+}
+
+// clockTimes returns whether to show the time each bus arrives instead of the number of minutes until it arrives.
+func (p Preferences) clockTimes() bool {
+	return p.TimeDisplay == TimeDisplayClock
+}
+
+// nearbyRadius returns the search range in metres for bus stops near a location.
+func (p Preferences) nearbyRadius() float64 {
+	if p.NearbyRadius <= 0 {
+		return LocationNearbyRadius
+	}
+	return float64(p.NearbyRadius)
+}
+
+// nearbyLimit returns the maximum number of bus stops shown for a location.
+func (p Preferences) nearbyLimit() int {
+	if p.NearbyLimit <= 0 {
+		return LocationNearbyLimit
+	}
+	return p.NearbyLimit
+}
+
+// language returns the code of the language the bot replies in.
+func (p Preferences) language() string {
+	if _, ok := Languages[p.Language]; !ok {
+		return LanguageEnglish
+	}
+	return p.Language
+}
+
+// PreferenceRepository stores user preferences.
+type PreferenceRepository interface {
+	// GetUserPreferences returns a user's preferences, or the default preferences if they have never been saved.
+	GetUserPreferences(ctx context.Context, userID int) (Preferences, error)
+	SetUserPreferences(ctx context.Context, userID int, preferences Preferences) error
+}
+
+// getUserPreferences returns a user's preferences from repository, or the default preferences if they are not
+// available.
+func getUserPreferences(ctx context.Context, repository PreferenceRepository, userID int) Preferences {
+	if repository == nil {
+		return Preferences{}
+	}
+	preferences, err := repository.GetUserPreferences(ctx, userID)
+	if err != nil {
+		logError(ctx, err)
+		return Preferences{}
+	}
+	return preferences
+}
+
+// userPreferences returns a user's preferences, or the default preferences if they are not available.
+func (bot *BusEtaBot) userPreferences(ctx context.Context, userID int) Preferences {
+	return getUserPreferences(ctx, bot.Preferences, userID)
+}
+
+// etaMessages returns an ETAMessageFactory for rendering eta messages with the bot's bus stops, eta service and user
+// preferences.
+func (bot *BusEtaBot) etaMessages() ETAMessageFactory {
+	return NewETAMessageFactory(bot.BusStops, bot.Datamall, bot.Preferences)
+}
+
+// etaMessageOptions returns the options for an eta message sent by the bot, which only has buttons for the features
+// the bot has been set up with.
+func (bot *BusEtaBot) etaMessageOptions() ETAMessageOptions {
+	return ETAMessageOptions{
+		Alerts:   bot.Alerts != nil,
+		LiveETAs: bot.LiveETAs != nil,
+	}
+}
@@ -0,0 +1,44 @@
+package busetabot
+
+import (
+	"context"
+	"time"
+)
+
+// MaxRecentBusStops is the number of recently used bus stops remembered for each user.
+const MaxRecentBusStops = 10
+
+// RecentBusStopRepository stores the bus stops each user has recently requested etas for.
+type RecentBusStopRepository interface {
+	// AddRecentBusStop records that a user requested etas for the bus stop with code at t. Only the most recent
+	// MaxRecentBusStops bus stops are kept for each user.
+	AddRecentBusStop(ctx context.Context, userID int, code string, t time.Time) error
+
+	// GetRecentBusStops returns the codes of the bus stops a user requested etas for most recently, most recent first.
+	GetRecentBusStops(ctx context.Context, userID int) ([]string, error)
+}
+
+// rememberBusStop records that a user requested etas for the bus stop with code. Failing to do so only means that the
+// bus stop is missing from the user's recent bus stops, so errors are logged instead of returned.
+func (bot *BusEtaBot) rememberBusStop(ctx context.Context, userID int, code string) {
+	if bot.RecentBusStops == nil {
+		return
+	}
+	err := bot.RecentBusStops.AddRecentBusStop(ctx, userID, code, bot.NowFunc())
+	if err != nil {
+		logError(ctx, err)
+	}
+}
+
+// recentBusStops returns a user's recently used bus stops, or nil if they are not available.
+func (bot *BusEtaBot) recentBusStops(ctx context.Context, userID int) []string {
+	if bot.RecentBusStops == nil {
+		return nil
+	}
+	codes, err := bot.RecentBusStops.GetRecentBusStops(ctx, userID)
+	if err != nil {
+		logError(ctx, err)
+		return nil
+	}
+	return codes
+}
@@ -0,0 +1,310 @@
+package busetabot
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/yi-jiayu/bus-eta-bot/v4/telegram"
+)
+
+// RouteStopsPerPage is the number of stops shown at a time in a route message.
+const RouteStopsPerPage = 10
+
+// BusTimings contains the first and last bus times at a bus stop as reported by DataMall, in the form "0630". A time
+// of "-" means that there is no service on that day.
+type BusTimings struct {
+	WeekdayFirstBus  string `json:"wd_first_bus"`
+	WeekdayLastBus   string `json:"wd_last_bus"`
+	SaturdayFirstBus string `json:"sat_first_bus"`
+	SaturdayLastBus  string `json:"sat_last_bus"`
+	SundayFirstBus   string `json:"sun_first_bus"`
+	SundayLastBus    string `json:"sun_last_bus"`
+}
+
+// on returns the first and last bus times on a day of the week.
+func (t BusTimings) on(day time.Weekday) (first, last string) {
+	switch day {
+	case time.Saturday:
+		return t.SaturdayFirstBus, t.SaturdayLastBus
+	case time.Sunday:
+		return t.SundayFirstBus, t.SundayLastBus
+	}
+	return t.WeekdayFirstBus, t.WeekdayLastBus
+}
+
+// serviceHours returns when the first and last buses leave on the service day starting on day. Last buses which
+// leave after midnight are on the following day.
+func (t BusTimings) serviceHours(day time.Time) (first, last time.Time, ok bool) {
+	f, l := t.on(day.Weekday())
+	first, ok1 := parseBusTime(day, f)
+	last, ok2 := parseBusTime(day, l)
+	if !ok1 || !ok2 {
+		return time.Time{}, time.Time{}, false
+	}
+	if last.Before(first) {
+		last = last.AddDate(0, 0, 1)
+	}
+	return first, last, true
+}
+
+// ended reports whether the last bus has left and the first bus of the next service day has not at now, as well as
+// when the last bus left and when the next first bus will leave, which is the zero time if there is no service.
+func (t BusTimings) ended(now time.Time) (lastBus, firstBus time.Time, ok bool) {
+	now = now.In(sgt)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, sgt)
+	for _, day := range []time.Time{today.AddDate(0, 0, -1), today} {
+		first, last, ok := t.serviceHours(day)
+		if !ok {
+			continue
+		}
+		if !now.Before(first) && !now.After(last) {
+			return time.Time{}, time.Time{}, false
+		}
+		if last.Before(now) && last.After(lastBus) {
+			lastBus = last
+		}
+	}
+	if lastBus.IsZero() {
+		return time.Time{}, time.Time{}, false
+	}
+	for _, day := range []time.Time{today, today.AddDate(0, 0, 1)} {
+		if first, _, ok := t.serviceHours(day); ok && first.After(now) {
+			firstBus = first
+			break
+		}
+	}
+	return lastBus, firstBus, true
+}
+
+// parseBusTime returns the time on day given by a DataMall bus time such as "0630".
+func parseBusTime(day time.Time, t string) (time.Time, bool) {
+	if len(t) != 4 {
+		return time.Time{}, false
+	}
+	hour, err := strconv.Atoi(t[:2])
+	if err != nil {
+		return time.Time{}, false
+	}
+	minute, err := strconv.Atoi(t[2:])
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, day.Location()), true
+}
+
+// RouteStop is a single stop along a bus route.
+type RouteStop struct {
+	BusTimings
+	BusStopCode string `json:"code"`
+
+	// Distance is how far along the route this stop is in kilometres.
+	Distance float64 `json:"distance"`
+}
+
+// Route is the sequence of stops served by a bus service in one direction.
+type Route struct {
+	ServiceNo string      `json:"service_no"`
+	Operator  string      `json:"operator"`
+	Direction int         `json:"direction"`
+	Stops     []RouteStop `json:"stops"`
+}
+
+// RoutePosition is the position of a bus stop along a route.
+type RoutePosition struct {
+	ServiceNo string
+	Direction int
+
+	// Index is the index of the bus stop in the route's stops.
+	Index int
+}
+
+// RouteRepository provides bus route information.
+type RouteRepository interface {
+	// Routes returns the routes for a bus service ordered by direction, or nil if the service does not exist.
+	Routes(serviceNo string) []Route
+
+	// RoutesThrough returns the positions of a bus stop along every route which serves it.
+	RoutesThrough(busStopCode string) []RoutePosition
+}
+
+// InMemoryRouteRepository is a RouteRepository which keeps all bus routes in memory.
+type InMemoryRouteRepository struct {
+	routes    map[string][]Route
+	positions map[string][]RoutePosition
+}
+
+// NewInMemoryRouteRepository returns an InMemoryRouteRepository containing routes.
+func NewInMemoryRouteRepository(routes []Route) *InMemoryRouteRepository {
+	m := make(map[string][]Route)
+	for _, route := range routes {
+		key := strings.ToUpper(route.ServiceNo)
+		m[key] = append(m[key], route)
+	}
+	positions := make(map[string][]RoutePosition)
+	for _, routes := range m {
+		sort.Slice(routes, func(i, j int) bool {
+			return routes[i].Direction < routes[j].Direction
+		})
+		for _, route := range routes {
+			for i, stop := range route.Stops {
+				positions[stop.BusStopCode] = append(positions[stop.BusStopCode], RoutePosition{
+					ServiceNo: route.ServiceNo,
+					Direction: route.Direction,
+					Index:     i,
+				})
+			}
+		}
+	}
+	for _, ps := range positions {
+		sort.Slice(ps, func(i, j int) bool {
+			if ps[i].ServiceNo != ps[j].ServiceNo {
+				return ps[i].ServiceNo < ps[j].ServiceNo
+			}
+			if ps[i].Direction != ps[j].Direction {
+				return ps[i].Direction < ps[j].Direction
+			}
+			return ps[i].Index < ps[j].Index
+		})
+	}
+	return &InMemoryRouteRepository{
+		routes:    m,
+		positions: positions,
+	}
+}
+
+// NewInMemoryRouteRepositoryFromFile returns an InMemoryRouteRepository containing the routes in a JSON file.
+func NewInMemoryRouteRepositoryFromFile(path string) (*InMemoryRouteRepository, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "error opening bus routes JSON file")
+	}
+	defer f.Close()
+	var routes []Route
+	err = json.NewDecoder(f).Decode(&routes)
+	if err != nil {
+		return nil, errors.Wrap(err, "error decoding bus routes JSON file")
+	}
+	return NewInMemoryRouteRepository(routes), nil
+}
+
+// Routes returns the routes for serviceNo, ignoring case.
+func (r *InMemoryRouteRepository) Routes(serviceNo string) []Route {
+	return r.routes[strings.ToUpper(serviceNo)]
+}
+
+// RoutesThrough returns the positions of busStopCode along every route which serves it.
+func (r *InMemoryRouteRepository) RoutesThrough(busStopCode string) []RoutePosition {
+	return r.positions[busStopCode]
+}
+
+// route returns the route for serviceNo in direction, or nil if it does not exist.
+func route(routes RouteRepository, serviceNo string, direction int) *Route {
+	for _, r := range routes.Routes(serviceNo) {
+		if r.Direction == direction {
+			return &r
+		}
+	}
+	return nil
+}
+
+// formatBusTime formats a DataMall bus time such as "0630" as "06:30".
+func formatBusTime(t string) string {
+	if len(t) != 4 {
+		return t
+	}
+	return t[:2] + ":" + t[2:]
+}
+
+// describeRouteStop returns the description of a bus stop along a route, or just its code if it could not be found.
+func describeRouteStop(busStops BusStopGetter, code string) string {
+	if busStops != nil {
+		if stop := busStops.Get(code); stop != nil && stop.Description != "" {
+			return stop.Description
+		}
+	}
+	return code
+}
+
+func newRouteButton(serviceNo string, direction, page int, text string) telegram.InlineKeyboardButton {
+	data := CallbackData{
+		Type:       "route",
+		ServiceNos: []string{serviceNo},
+		Argstr:     fmt.Sprintf("%d %d", direction, page),
+	}
+	JSON, _ := json.Marshal(data)
+	return telegram.InlineKeyboardButton{
+		Text:         text,
+		CallbackData: string(JSON),
+	}
+}
+
+// newRouteMessage returns the text and reply markup for a page of the stops along one of the routes for a service.
+// Tapping a stop sends its etas for that service.
+func newRouteMessage(busStops BusStopGetter, routes []Route, direction, page int) (string, telegram.InlineKeyboardMarkup) {
+	route := routes[0]
+	for _, r := range routes {
+		if r.Direction == direction {
+			route = r
+		}
+	}
+	pages := (len(route.Stops) + RouteStopsPerPage - 1) / RouteStopsPerPage
+	if page < 0 || page >= pages {
+		page = 0
+	}
+	start := page * RouteStopsPerPage
+	end := start + RouteStopsPerPage
+	if end > len(route.Stops) {
+		end = len(route.Stops)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Service %s", route.ServiceNo)
+	if len(route.Stops) > 0 {
+		last := route.Stops[len(route.Stops)-1]
+		fmt.Fprintf(&b, " towards %s", describeRouteStop(busStops, last.BusStopCode))
+		first := route.Stops[0]
+		fmt.Fprintf(&b, "\nWeekdays: first bus %s, last bus %s", formatBusTime(first.WeekdayFirstBus), formatBusTime(first.WeekdayLastBus))
+	}
+	fmt.Fprintf(&b, "\nStops %d to %d of %d:", start+1, end, len(route.Stops))
+
+	var keyboard [][]telegram.InlineKeyboardButton
+	for i, stop := range route.Stops[start:end] {
+		JSON, _ := json.Marshal(CallbackData{
+			Type:       "new_eta",
+			BusStopID:  stop.BusStopCode,
+			ServiceNos: []string{route.ServiceNo},
+		})
+		keyboard = append(keyboard, []telegram.InlineKeyboardButton{
+			{
+				Text:         fmt.Sprintf("%d. %s (%s)", start+i+1, describeRouteStop(busStops, stop.BusStopCode), stop.BusStopCode),
+				CallbackData: string(JSON),
+			},
+		})
+	}
+	var nav []telegram.InlineKeyboardButton
+	if page > 0 {
+		nav = append(nav, newRouteButton(route.ServiceNo, route.Direction, page-1, "◀ Prev"))
+	}
+	if page < pages-1 {
+		nav = append(nav, newRouteButton(route.ServiceNo, route.Direction, page+1, "Next ▶"))
+	}
+	for _, r := range routes {
+		if r.Direction != route.Direction {
+			nav = append(nav, newRouteButton(r.ServiceNo, r.Direction, 0, "Other direction"))
+			break
+		}
+	}
+	if len(nav) > 0 {
+		keyboard = append(keyboard, nav)
+	}
+	return b.String(), telegram.InlineKeyboardMarkup{
+		InlineKeyboard: keyboard,
+	}
+}
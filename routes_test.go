@@ -0,0 +1,278 @@
+package busetabot
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kr/pretty"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/yi-jiayu/bus-eta-bot/v4/telegram"
+)
+
+func newTestRoute(serviceNo string, direction int, codes ...string) Route {
+	route := Route{ServiceNo: serviceNo, Operator: "SBST", Direction: direction}
+	for i, code := range codes {
+		route.Stops = append(route.Stops, RouteStop{
+			BusTimings:  BusTimings{WeekdayFirstBus: "0600", WeekdayLastBus: "2330"},
+			BusStopCode: code,
+			Distance:    float64(i),
+		})
+	}
+	return route
+}
+
+func TestNewInMemoryRouteRepositoryFromFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "routes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "bus_routes.json")
+	err = ioutil.WriteFile(path, []byte(`[
+  {"service_no": "96", "operator": "SBST", "direction": 2, "stops": [{"code": "17009", "distance": 0, "wd_first_bus": "0610", "wd_last_bus": "2340", "sat_first_bus": "0610", "sat_last_bus": "2340", "sun_first_bus": "-", "sun_last_bus": "-"}]},
+  {"service_no": "96", "operator": "SBST", "direction": 1, "stops": [{"code": "28009", "distance": 0, "wd_first_bus": "0600", "wd_last_bus": "2330", "sat_first_bus": "0600", "sat_last_bus": "2330", "sun_first_bus": "-", "sun_last_bus": "-"}]},
+  {"service_no": "96b", "operator": "SBST", "direction": 1, "stops": []}
+]`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routes, err := NewInMemoryRouteRepositoryFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual := routes.Routes("96")
+	if assert.Len(t, actual, 2) {
+		assert.Equal(t, 1, actual[0].Direction)
+		assert.Equal(t, 2, actual[1].Direction)
+		assert.Equal(t, RouteStop{
+			BusTimings: BusTimings{
+				WeekdayFirstBus:  "0600",
+				WeekdayLastBus:   "2330",
+				SaturdayFirstBus: "0600",
+				SaturdayLastBus:  "2330",
+				SundayFirstBus:   "-",
+				SundayLastBus:    "-",
+			},
+			BusStopCode: "28009",
+		}, actual[0].Stops[0])
+	}
+	assert.Len(t, routes.Routes("96B"), 1, "service numbers should be case-insensitive")
+	assert.Nil(t, routes.Routes("999"))
+}
+
+func TestNewRouteMessage(t *testing.T) {
+	var codes []string
+	for i := 1; i <= 12; i++ {
+		codes = append(codes, fmt.Sprintf("%05d", i))
+	}
+	routes := []Route{
+		newTestRoute("96", 1, codes...),
+		newTestRoute("96", 2, "00012", "00001"),
+	}
+	busStops := NewInMemoryBusStopRepository([]BusStop{
+		{BusStopCode: "00012", Description: "Clementi Int"},
+	}, nil)
+
+	t.Run("first page", func(t *testing.T) {
+		text, markup := newRouteMessage(busStops, routes, 1, 0)
+		assert.Equal(t, "Service 96 towards Clementi Int\nWeekdays: first bus 06:00, last bus 23:30\nStops 1 to 10 of 12:", text)
+		if assert.Len(t, markup.InlineKeyboard, 11) {
+			assert.Equal(t, []telegram.InlineKeyboardButton{
+				{Text: "1. 00001 (00001)", CallbackData: `{"t":"new_eta","b":"00001","s":["96"]}`},
+			}, markup.InlineKeyboard[0])
+			assert.Equal(t, []telegram.InlineKeyboardButton{
+				{Text: "Next ▶", CallbackData: `{"t":"route","s":["96"],"a":"1 1"}`},
+				{Text: "Other direction", CallbackData: `{"t":"route","s":["96"],"a":"2 0"}`},
+			}, markup.InlineKeyboard[10])
+		}
+	})
+	t.Run("last page", func(t *testing.T) {
+		text, markup := newRouteMessage(busStops, routes, 1, 1)
+		assert.Equal(t, "Service 96 towards Clementi Int\nWeekdays: first bus 06:00, last bus 23:30\nStops 11 to 12 of 12:", text)
+		expected := telegram.InlineKeyboardMarkup{
+			InlineKeyboard: [][]telegram.InlineKeyboardButton{
+				{{Text: "11. 00011 (00011)", CallbackData: `{"t":"new_eta","b":"00011","s":["96"]}`}},
+				{{Text: "12. Clementi Int (00012)", CallbackData: `{"t":"new_eta","b":"00012","s":["96"]}`}},
+				{
+					{Text: "◀ Prev", CallbackData: `{"t":"route","s":["96"],"a":"1 0"}`},
+					{Text: "Other direction", CallbackData: `{"t":"route","s":["96"],"a":"2 0"}`},
+				},
+			},
+		}
+		if !assert.Equal(t, expected, markup) {
+			pretty.Println(markup)
+		}
+	})
+	t.Run("out of range page and direction", func(t *testing.T) {
+		text, _ := newRouteMessage(busStops, routes, 3, 5)
+		assert.Equal(t, "Service 96 towards Clementi Int\nWeekdays: first bus 06:00, last bus 23:30\nStops 1 to 10 of 12:", text)
+	})
+}
+
+func TestRouteCmdHandler(t *testing.T) {
+	routes := NewInMemoryRouteRepository([]Route{newTestRoute("96", 1, "00001", "00002")})
+	testCases := []struct {
+		Name     string
+		Text     string
+		Routes   RouteRepository
+		Expected telegram.SendMessageRequest
+	}{
+		{
+			Name:   "when service exists",
+			Text:   "/route 96",
+			Routes: routes,
+			Expected: telegram.SendMessageRequest{
+				ChatID: 1,
+				Text:   "Service 96 towards 00002\nWeekdays: first bus 06:00, last bus 23:30\nStops 1 to 2 of 2:",
+				ReplyMarkup: telegram.InlineKeyboardMarkup{
+					InlineKeyboard: [][]telegram.InlineKeyboardButton{
+						{{Text: "1. 00001 (00001)", CallbackData: `{"t":"new_eta","b":"00001","s":["96"]}`}},
+						{{Text: "2. 00002 (00002)", CallbackData: `{"t":"new_eta","b":"00002","s":["96"]}`}},
+					},
+				},
+			},
+		},
+		{
+			Name:   "when service does not exist",
+			Text:   "/route 999",
+			Routes: routes,
+			Expected: telegram.SendMessageRequest{
+				ChatID: 1,
+				Text:   "Oops, I couldn't find bus service 999.",
+			},
+		},
+		{
+			Name:   "without a service",
+			Text:   "/route",
+			Routes: routes,
+			Expected: telegram.SendMessageRequest{
+				ChatID:    1,
+				Text:      "Send `/route <service>`, for example `/route 96`, to see the stops along a bus route.",
+				ParseMode: "markdown",
+			},
+		},
+		{
+			Name: "when routes are not available",
+			Text: "/route 96",
+			Expected: telegram.SendMessageRequest{
+				ChatID: 1,
+				Text:   "Oops, bus routes are not available at the moment.",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			bot := &BusEtaBot{Routes: tc.Routes}
+			responses := make(chan Response, ResponseBufferSize)
+
+			RouteCmdHandler(context.Background(), bot, MockMessageWithText(tc.Text), responses)
+
+			actual, err := collectResponsesWithTimeout(responses, 100*time.Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := []Response{ok(tc.Expected)}
+			if !assert.Equal(t, expected, actual) {
+				pretty.Println(actual)
+			}
+		})
+	}
+}
+
+func TestRouteCallbackHandler(t *testing.T) {
+	bot := &BusEtaBot{
+		Routes: NewInMemoryRouteRepository([]Route{
+			newTestRoute("96", 1, "00001", "00002"),
+			newTestRoute("96", 2, "00002", "00001"),
+		}),
+	}
+	responses := make(chan Response, ResponseBufferSize)
+
+	RouteCallbackHandler(context.Background(), bot, newCallbackQueryFromMessage(`{"t":"route","s":["96"],"a":"2 0"}`), responses)
+
+	actual, err := collectResponsesWithTimeout(responses, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Response{
+		ok(telegram.EditMessageTextRequest{
+			ChatID:    1,
+			MessageID: 1,
+			Text:      "Service 96 towards 00001\nWeekdays: first bus 06:00, last bus 23:30\nStops 1 to 2 of 2:",
+			ReplyMarkup: telegram.InlineKeyboardMarkup{
+				InlineKeyboard: [][]telegram.InlineKeyboardButton{
+					{{Text: "1. 00002 (00002)", CallbackData: `{"t":"new_eta","b":"00002","s":["96"]}`}},
+					{{Text: "2. 00001 (00001)", CallbackData: `{"t":"new_eta","b":"00001","s":["96"]}`}},
+					{{Text: "Other direction", CallbackData: `{"t":"route","s":["96"],"a":"1 0"}`}},
+				},
+			},
+		}),
+		ok(telegram.AnswerCallbackQueryRequest{CallbackQueryID: "1"}),
+	}
+	if !assert.Equal(t, expected, actual) {
+		pretty.Println(actual)
+	}
+}
+
+func TestBusTimings_ended(t *testing.T) {
+	timings := BusTimings{
+		WeekdayFirstBus:  "0530",
+		WeekdayLastBus:   "2341",
+		SaturdayFirstBus: "0530",
+		SaturdayLastBus:  "0010",
+		SundayFirstBus:   "-",
+		SundayLastBus:    "-",
+	}
+	testCases := []struct {
+		Name     string
+		Now      time.Time
+		Ended    bool
+		LastBus  time.Time
+		FirstBus time.Time
+	}{
+		{
+			Name: "during service hours",
+			Now:  time.Date(2018, 1, 1, 12, 0, 0, 0, sgt),
+		},
+		{
+			Name:     "after the last bus",
+			Now:      time.Date(2018, 1, 1, 23, 50, 0, 0, sgt),
+			Ended:    true,
+			LastBus:  time.Date(2018, 1, 1, 23, 41, 0, 0, sgt),
+			FirstBus: time.Date(2018, 1, 2, 5, 30, 0, 0, sgt),
+		},
+		{
+			Name: "before a last bus after midnight",
+			Now:  time.Date(2018, 1, 7, 0, 5, 0, 0, sgt),
+		},
+		{
+			Name:     "after a last bus after midnight",
+			Now:      time.Date(2018, 1, 7, 0, 20, 0, 0, sgt),
+			Ended:    true,
+			LastBus:  time.Date(2018, 1, 7, 0, 10, 0, 0, sgt),
+			FirstBus: time.Date(2018, 1, 8, 5, 30, 0, 0, sgt),
+		},
+		{
+			Name:     "on a day without service",
+			Now:      time.Date(2018, 1, 7, 12, 0, 0, 0, time.UTC),
+			Ended:    true,
+			LastBus:  time.Date(2018, 1, 7, 0, 10, 0, 0, sgt),
+			FirstBus: time.Date(2018, 1, 8, 5, 30, 0, 0, sgt),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			lastBus, firstBus, ended := timings.ended(tc.Now)
+			assert.Equal(t, tc.Ended, ended)
+			assert.True(t, tc.LastBus.Equal(lastBus), "expected last bus at %v, got %v", tc.LastBus, lastBus)
+			assert.True(t, tc.FirstBus.Equal(firstBus), "expected first bus at %v, got %v", tc.FirstBus, firstBus)
+		})
+	}
+}
@@ -0,0 +1,261 @@
+package busetabot
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/yi-jiayu/bus-eta-bot/v4/telegram"
+)
+
+// Schedule limits and defaults
+const (
+	MaxSchedulesPerUser       = 5
+	DefaultScheduleCheckEvery = 20 * time.Second
+)
+
+var (
+	scheduleTimeRegex = regexp.MustCompile(`^([01]?\d|2[0-3]):([0-5]\d)$`)
+
+	errScheduleUsage = errors.New("invalid schedule arguments")
+)
+
+// Days is a set of days of the week.
+type Days uint8
+
+// Common sets of days
+const (
+	Weekdays Days = 1<<time.Monday | 1<<time.Tuesday | 1<<time.Wednesday | 1<<time.Thursday | 1<<time.Friday
+	Weekends Days = 1<<time.Saturday | 1<<time.Sunday
+	Everyday      = Weekdays | Weekends
+)
+
+var dayNames = map[string]Days{
+	"weekdays": Weekdays,
+	"weekends": Weekends,
+	"daily":    Everyday,
+	"everyday": Everyday,
+	"sun":      1 << time.Sunday,
+	"mon":      1 << time.Monday,
+	"tue":      1 << time.Tuesday,
+	"wed":      1 << time.Wednesday,
+	"thu":      1 << time.Thursday,
+	"fri":      1 << time.Friday,
+	"sat":      1 << time.Saturday,
+}
+
+// ParseDays parses a comma-separated list of days, such as "weekdays" or "mon,wed,fri".
+func ParseDays(s string) (Days, error) {
+	var days Days
+	for _, name := range strings.Split(strings.ToLower(s), ",") {
+		d, ok := dayNames[strings.TrimSpace(name)]
+		if !ok {
+			return 0, errors.Errorf("unknown day: %s", name)
+		}
+		days |= d
+	}
+	return days, nil
+}
+
+// Has reports whether day is one of d.
+func (d Days) Has(day time.Weekday) bool {
+	return d&(1<<day) != 0
+}
+
+func (d Days) String() string {
+	switch d {
+	case Everyday:
+		return "every day"
+	case Weekdays:
+		return "weekdays"
+	case Weekends:
+		return "weekends"
+	}
+	var names []string
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if d.Has(day) {
+			names = append(names, day.String()[:3])
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// Schedule is a recurring request for etas to be sent to a chat at a certain time of day in Singapore time.
+type Schedule struct {
+	ID          int64
+	UserID      int
+	ChatID      int64
+	BusStopCode string
+	ServiceNos  []string
+	Hour        int
+	Minute      int
+	Days        Days
+}
+
+// Query returns the eta query for a schedule, such as "96049 2 24".
+func (s Schedule) Query() string {
+	return strings.Join(append([]string{s.BusStopCode}, s.ServiceNos...), " ")
+}
+
+func (s Schedule) String() string {
+	return fmt.Sprintf("%s at %02d:%02d on %s", s.Query(), s.Hour, s.Minute, s.Days)
+}
+
+// on returns the time a schedule is due on the same day as t in Singapore time.
+func (s Schedule) on(t time.Time) time.Time {
+	t = t.In(sgt)
+	return time.Date(t.Year(), t.Month(), t.Day(), s.Hour, s.Minute, 0, 0, sgt)
+}
+
+// ScheduleRepository stores recurring eta schedules.
+type ScheduleRepository interface {
+	AddSchedule(ctx context.Context, schedule Schedule) (ID int64, err error)
+	GetSchedules(ctx context.Context) ([]Schedule, error)
+	GetUserSchedules(ctx context.Context, userID int) ([]Schedule, error)
+	DeleteSchedule(ctx context.Context, ID int64) error
+}
+
+// ParseScheduleArgs parses the arguments to the /schedule command, which are an eta query followed by a time in
+// Singapore time and optionally the days to send etas on, such as "96049 2 24 08:10 weekdays". Schedules are for
+// weekdays by default.
+func ParseScheduleArgs(args string) (code string, services []string, hour, minute int, days Days, err error) {
+	fields := strings.Fields(args)
+	at := -1
+	for i, field := range fields {
+		if scheduleTimeRegex.MatchString(field) {
+			at = i
+			break
+		}
+	}
+	if at < 1 || len(fields)-at > 2 {
+		return "", nil, 0, 0, 0, errScheduleUsage
+	}
+	code, services, err = InferEtaQuery(strings.Join(fields[:at], " "))
+	if err != nil {
+		return "", nil, 0, 0, 0, errScheduleUsage
+	}
+	m := scheduleTimeRegex.FindStringSubmatch(fields[at])
+	hour, _ = strconv.Atoi(m[1])
+	minute, _ = strconv.Atoi(m[2])
+	days = Weekdays
+	if at+1 < len(fields) {
+		days, err = ParseDays(fields[at+1])
+		if err != nil {
+			return "", nil, 0, 0, 0, errScheduleUsage
+		}
+	}
+	return code, services, hour, minute, days, nil
+}
+
+func newDeleteScheduleButton(n int, schedule Schedule) telegram.InlineKeyboardButton {
+	return telegram.InlineKeyboardButton{
+		Text:         fmt.Sprintf("Delete %d. %s", n, schedule.Query()),
+		CallbackData: fmt.Sprintf(`{"t":"schedule_delete","a":"%d"}`, schedule.ID),
+	}
+}
+
+// newSchedulesMessage returns a message listing a user's schedules with buttons to delete them.
+func newSchedulesMessage(chatID int64, schedules []Schedule) telegram.SendMessageRequest {
+	if len(schedules) == 0 {
+		return telegram.SendMessageRequest{
+			ChatID: chatID,
+			Text:   "You don't have any scheduled etas. Send /schedule to set one up.",
+		}
+	}
+	lines := []string{"Your scheduled etas:"}
+	var keyboard [][]telegram.InlineKeyboardButton
+	for i, schedule := range schedules {
+		lines = append(lines, fmt.Sprintf("%d. %s", i+1, schedule))
+		keyboard = append(keyboard, []telegram.InlineKeyboardButton{newDeleteScheduleButton(i+1, schedule)})
+	}
+	return telegram.SendMessageRequest{
+		ChatID: chatID,
+		Text:   strings.Join(lines, "\n"),
+		ReplyMarkup: telegram.InlineKeyboardMarkup{
+			InlineKeyboard: keyboard,
+		},
+	}
+}
+
+// ScheduleRunner sends etas for schedules when they are due.
+type ScheduleRunner struct {
+	Bot      *BusEtaBot
+	Interval time.Duration
+
+	// last is when schedules were last checked.
+	last time.Time
+}
+
+// Run checks for due schedules every Interval until ctx is cancelled. Schedules which were due before Run was called
+// are not sent.
+func (r *ScheduleRunner) Run(ctx context.Context) {
+	interval := r.Interval
+	if interval <= 0 {
+		interval = DefaultScheduleCheckEvery
+	}
+	r.last = r.Bot.NowFunc()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.CheckSchedules(ctx)
+		}
+	}
+}
+
+// CheckSchedules sends etas for every schedule which became due since the last check.
+func (r *ScheduleRunner) CheckSchedules(ctx context.Context) {
+	bot := r.Bot
+	now := bot.NowFunc()
+	last := r.last
+	if last.IsZero() {
+		last = now
+	}
+	schedules, err := bot.Schedules.GetSchedules(ctx)
+	if err != nil {
+		bot.logger().Errorf(ctx, "error getting schedules: %+v", err)
+		return
+	}
+	r.last = now
+	for _, schedule := range schedules {
+		// the last check may have been on the previous day
+		for _, due := range []time.Time{schedule.on(last), schedule.on(now)} {
+			if due.After(last) && !due.After(now) && schedule.Days.Has(due.Weekday()) {
+				r.send(ctx, schedule)
+				break
+			}
+		}
+	}
+}
+
+func (r *ScheduleRunner) send(ctx context.Context, schedule Schedule) {
+	bot := r.Bot
+	eta, err := bot.etaMessages().Message(ctx, ETARequest{
+		UserID:   schedule.UserID,
+		Time:     bot.NowFunc(),
+		Code:     schedule.BusStopCode,
+		Services: schedule.ServiceNos,
+	}, bot.etaMessageOptions())
+	if err != nil {
+		bot.logger().Errorf(ctx, "error formatting etas for schedule %d: %+v", schedule.ID, err)
+		return
+	}
+	req := telegram.SendMessageRequest{
+		ChatID:      schedule.ChatID,
+		Text:        eta.Text,
+		ParseMode:   eta.ParseMode,
+		ReplyMarkup: eta.ReplyMarkup,
+	}
+	err = bot.TelegramService.Do(req)
+	if err != nil {
+		bot.logger().Errorf(ctx, "error sending etas for schedule %d: %+v", schedule.ID, err)
+	}
+}
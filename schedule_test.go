@@ -0,0 +1,380 @@
+package busetabot
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kr/pretty"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/yi-jiayu/bus-eta-bot/v4/telegram"
+)
+
+type mockScheduleRepository struct {
+	Schedules []Schedule
+	nextID    int64
+}
+
+func (r *mockScheduleRepository) AddSchedule(ctx context.Context, schedule Schedule) (ID int64, err error) {
+	r.nextID++
+	schedule.ID = r.nextID
+	r.Schedules = append(r.Schedules, schedule)
+	return schedule.ID, nil
+}
+
+func (r *mockScheduleRepository) GetSchedules(ctx context.Context) ([]Schedule, error) {
+	return append([]Schedule(nil), r.Schedules...), nil
+}
+
+func (r *mockScheduleRepository) GetUserSchedules(ctx context.Context, userID int) (schedules []Schedule, err error) {
+	for _, schedule := range r.Schedules {
+		if schedule.UserID == userID {
+			schedules = append(schedules, schedule)
+		}
+	}
+	return schedules, nil
+}
+
+func (r *mockScheduleRepository) DeleteSchedule(ctx context.Context, ID int64) error {
+	for i, schedule := range r.Schedules {
+		if schedule.ID == ID {
+			r.Schedules = append(r.Schedules[:i], r.Schedules[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func TestParseScheduleArgs(t *testing.T) {
+	testCases := []struct {
+		Args     string
+		Code     string
+		Services []string
+		Hour     int
+		Minute   int
+		Days     Days
+		Err      error
+	}{
+		{Args: "96049 2 24 08:10", Code: "96049", Services: []string{"2", "24"}, Hour: 8, Minute: 10, Days: Weekdays},
+		{Args: "96049 8:10 daily", Code: "96049", Services: []string{}, Hour: 8, Minute: 10, Days: Everyday},
+		{Args: "96049 2 18:30 mon,fri", Code: "96049", Services: []string{"2"}, Hour: 18, Minute: 30, Days: 1<<time.Monday | 1<<time.Friday},
+		{Args: "96049 2", Err: errScheduleUsage},
+		{Args: "08:10 96049", Err: errScheduleUsage},
+		{Args: "96049 24:00", Err: errScheduleUsage},
+		{Args: "96049 08:10 someday", Err: errScheduleUsage},
+		{Args: "96049 08:10 weekdays extra", Err: errScheduleUsage},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Args, func(t *testing.T) {
+			code, services, hour, minute, days, err := ParseScheduleArgs(tc.Args)
+			assert.Equal(t, tc.Err, err)
+			assert.Equal(t, tc.Code, code)
+			assert.Equal(t, tc.Services, services)
+			assert.Equal(t, tc.Hour, hour)
+			assert.Equal(t, tc.Minute, minute)
+			assert.Equal(t, tc.Days, days)
+		})
+	}
+}
+
+func TestDays_String(t *testing.T) {
+	assert.Equal(t, "weekdays", Weekdays.String())
+	assert.Equal(t, "weekends", Weekends.String())
+	assert.Equal(t, "every day", Everyday.String())
+	assert.Equal(t, "Mon, Wed, Fri", Days(1<<time.Monday|1<<time.Wednesday|1<<time.Friday).String())
+}
+
+func TestScheduleRunner_CheckSchedules(t *testing.T) {
+	// 08:10 in Singapore is 00:10 UTC
+	monday := time.Date(2019, 1, 7, 0, 10, 0, 0, time.UTC)
+	saturday := time.Date(2019, 1, 12, 0, 10, 0, 0, time.UTC)
+	schedule := Schedule{ID: 1, UserID: 1, ChatID: 1, BusStopCode: "96049", ServiceNos: []string{"2"}, Hour: 8, Minute: 10, Days: Weekdays}
+	testCases := []struct {
+		Name     string
+		Last     time.Time
+		Now      time.Time
+		Schedule Schedule
+		Sent     bool
+	}{
+		{
+			Name:     "sends etas when a schedule becomes due",
+			Last:     monday.Add(-10 * time.Second),
+			Now:      monday.Add(10 * time.Second),
+			Schedule: schedule,
+			Sent:     true,
+		},
+		{
+			Name:     "does not send etas before a schedule is due",
+			Last:     monday.Add(-30 * time.Second),
+			Now:      monday.Add(-10 * time.Second),
+			Schedule: schedule,
+		},
+		{
+			Name:     "does not send etas again after a schedule was due",
+			Last:     monday.Add(10 * time.Second),
+			Now:      monday.Add(30 * time.Second),
+			Schedule: schedule,
+		},
+		{
+			Name:     "does not send etas on other days",
+			Last:     saturday.Add(-10 * time.Second),
+			Now:      saturday.Add(10 * time.Second),
+			Schedule: schedule,
+		},
+		{
+			Name: "does not send etas for a schedule due before the last check on the previous day",
+			Last: time.Date(2019, 1, 7, 23, 59, 50, 0, sgt),
+			Now:  time.Date(2019, 1, 8, 0, 0, 10, 0, sgt),
+			Schedule: Schedule{
+				ID: 1, UserID: 1, ChatID: 1, BusStopCode: "96049", Hour: 23, Minute: 59, Days: Weekdays,
+			},
+		},
+		{
+			Name: "sends etas for a schedule due at midnight",
+			Last: time.Date(2019, 1, 7, 23, 59, 50, 0, sgt),
+			Now:  time.Date(2019, 1, 8, 0, 0, 10, 0, sgt),
+			Schedule: Schedule{
+				ID: 1, UserID: 1, ChatID: 1, BusStopCode: "96049", Hour: 0, Minute: 0, Days: Weekdays,
+			},
+			Sent: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			tg := &mockTelegramService{}
+			now := tc.Now
+			bot := &BusEtaBot{
+				Datamall:        mockETAService{BusArrival: newArrival(now, "96049")},
+				BusStops:        mockBusStopRepository{},
+				Schedules:       &mockScheduleRepository{Schedules: []Schedule{tc.Schedule}},
+				TelegramService: tg,
+				NowFunc: func() time.Time {
+					return now
+				},
+			}
+			runner := ScheduleRunner{
+				Bot:  bot,
+				last: tc.Last,
+			}
+
+			runner.CheckSchedules(context.Background())
+
+			if !tc.Sent {
+				assert.Empty(t, tg.Requests)
+				return
+			}
+			eta := NewETA(context.Background(), bot.BusStops, bot.Datamall, ETARequest{
+				UserID:   1,
+				Time:     now,
+				Code:     tc.Schedule.BusStopCode,
+				Services: tc.Schedule.ServiceNos,
+			})
+			text, err := summaryFormatter.Format(eta)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := []telegram.Request{
+				telegram.SendMessageRequest{
+					ChatID:      1,
+					Text:        text,
+					ParseMode:   "markdown",
+					ReplyMarkup: NewETAMessageReplyMarkup(tc.Schedule.BusStopCode, tc.Schedule.ServiceNos, ETAMessageOptions{}),
+				},
+			}
+			if !assert.Equal(t, expected, tg.Requests) {
+				pretty.Println(tg.Requests)
+			}
+			assert.Equal(t, now, runner.last)
+		})
+	}
+}
+
+func TestScheduleCmdHandler(t *testing.T) {
+	existing := Schedule{ID: 1, UserID: 1, ChatID: 1, BusStopCode: "81111", Hour: 18, Minute: 0, Days: Everyday}
+	testCases := []struct {
+		Name      string
+		Text      string
+		Schedules []Schedule
+		Expected  []Response
+	}{
+		{
+			Name: "creates a schedule",
+			Text: "/schedule 96049 2 24 08:10 weekdays",
+			Expected: []Response{
+				ok(telegram.SendMessageRequest{
+					ChatID: 1,
+					Text:   "Alright, I'll send you etas for 96049 2 24 at 08:10 on weekdays.",
+				}),
+			},
+		},
+		{
+			Name:      "enforces the per-user limit",
+			Text:      "/schedule 96049 08:10",
+			Schedules: []Schedule{existing, existing, existing, existing, existing},
+			Expected: []Response{
+				ok(telegram.SendMessageRequest{
+					ChatID: 1,
+					Text:   "Oops, you can only have up to 5 scheduled etas. Send /schedules to see and delete them.",
+				}),
+			},
+		},
+		{
+			Name: "rejects invalid arguments",
+			Text: "/schedule 96049 2",
+			Expected: []Response{
+				ok(telegram.SendMessageRequest{
+					ChatID:    1,
+					Text:      "Oops, I didn't understand that. " + scheduleUsage,
+					ParseMode: "markdown",
+				}),
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			bot := &BusEtaBot{
+				Schedules: &mockScheduleRepository{Schedules: tc.Schedules},
+			}
+			responses := make(chan Response, ResponseBufferSize)
+
+			ScheduleCmdHandler(context.Background(), bot, MockMessageWithText(tc.Text), responses)
+
+			actual, err := collectResponsesWithTimeout(responses, 100*time.Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !assert.Equal(t, tc.Expected, actual) {
+				pretty.Println(actual)
+			}
+		})
+	}
+}
+
+func TestSchedulesCmdHandler(t *testing.T) {
+	bot := &BusEtaBot{
+		Schedules: &mockScheduleRepository{
+			Schedules: []Schedule{
+				{ID: 3, UserID: 1, ChatID: 1, BusStopCode: "96049", ServiceNos: []string{"2", "24"}, Hour: 8, Minute: 10, Days: Weekdays},
+				{ID: 4, UserID: 2, ChatID: 2, BusStopCode: "81111", Hour: 18, Minute: 0, Days: Everyday},
+			},
+		},
+	}
+	responses := make(chan Response, ResponseBufferSize)
+
+	SchedulesCmdHandler(context.Background(), bot, MockMessageWithText("/schedules"), responses)
+
+	actual, err := collectResponsesWithTimeout(responses, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Response{
+		ok(telegram.SendMessageRequest{
+			ChatID: 1,
+			Text:   "Your scheduled etas:\n1. 96049 2 24 at 08:10 on weekdays",
+			ReplyMarkup: telegram.InlineKeyboardMarkup{
+				InlineKeyboard: [][]telegram.InlineKeyboardButton{
+					{{Text: "Delete 1. 96049 2 24", CallbackData: `{"t":"schedule_delete","a":"3"}`}},
+				},
+			},
+		}),
+	}
+	if !assert.Equal(t, expected, actual) {
+		pretty.Println(actual)
+	}
+}
+
+func TestUnscheduleCmdHandler(t *testing.T) {
+	schedules := &mockScheduleRepository{
+		Schedules: []Schedule{
+			{ID: 3, UserID: 1, ChatID: 1, BusStopCode: "96049", Hour: 8, Minute: 10, Days: Weekdays},
+			{ID: 4, UserID: 1, ChatID: 1, BusStopCode: "81111", Hour: 18, Minute: 0, Days: Everyday},
+		},
+	}
+	bot := &BusEtaBot{
+		Schedules: schedules,
+	}
+	responses := make(chan Response, ResponseBufferSize)
+
+	UnscheduleCmdHandler(context.Background(), bot, MockMessageWithText("/unschedule 2"), responses)
+
+	actual, err := collectResponsesWithTimeout(responses, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Response{
+		ok(telegram.SendMessageRequest{
+			ChatID: 1,
+			Text:   "Deleted scheduled etas for 81111 at 18:00 on every day.",
+		}),
+	}
+	if !assert.Equal(t, expected, actual) {
+		pretty.Println(actual)
+	}
+	assert.Len(t, schedules.Schedules, 1)
+	assert.Equal(t, int64(3), schedules.Schedules[0].ID)
+}
+
+func TestDeleteScheduleCallbackHandler(t *testing.T) {
+	schedules := &mockScheduleRepository{
+		Schedules: []Schedule{
+			{ID: 3, UserID: 1, ChatID: 1, BusStopCode: "96049", Hour: 8, Minute: 10, Days: Weekdays},
+			{ID: 4, UserID: 2, ChatID: 2, BusStopCode: "81111", Hour: 18, Minute: 0, Days: Everyday},
+		},
+	}
+	bot := &BusEtaBot{
+		Schedules: schedules,
+	}
+	testCases := []struct {
+		Name     string
+		Data     string
+		Expected []Response
+	}{
+		{
+			Name: "does not delete another user's schedule",
+			Data: `{"t":"schedule_delete","a":"4"}`,
+			Expected: []Response{
+				ok(telegram.EditMessageTextRequest{
+					ChatID:    1,
+					MessageID: 1,
+					Text:      "Your scheduled etas:\n1. 96049 at 08:10 on weekdays",
+					ReplyMarkup: telegram.InlineKeyboardMarkup{
+						InlineKeyboard: [][]telegram.InlineKeyboardButton{
+							{{Text: "Delete 1. 96049", CallbackData: `{"t":"schedule_delete","a":"3"}`}},
+						},
+					},
+				}),
+				ok(telegram.AnswerCallbackQueryRequest{CallbackQueryID: "1", Text: "That scheduled eta has already been deleted."}),
+			},
+		},
+		{
+			Name: "deletes the user's own schedule",
+			Data: `{"t":"schedule_delete","a":"3"}`,
+			Expected: []Response{
+				ok(telegram.EditMessageTextRequest{
+					ChatID:    1,
+					MessageID: 1,
+					Text:      "You don't have any scheduled etas. Send /schedule to set one up.",
+				}),
+				ok(telegram.AnswerCallbackQueryRequest{CallbackQueryID: "1", Text: "Deleted scheduled etas for 96049 at 08:10 on weekdays."}),
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			responses := make(chan Response, ResponseBufferSize)
+
+			DeleteScheduleCallbackHandler(context.Background(), bot, newCallbackQueryFromMessage(tc.Data), responses)
+
+			actual, err := collectResponsesWithTimeout(responses, 100*time.Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !assert.Equal(t, tc.Expected, actual) {
+				pretty.Println(actual)
+			}
+		})
+	}
+	assert.Len(t, schedules.Schedules, 1)
+	assert.Equal(t, int64(4), schedules.Schedules[0].ID)
+}
@@ -1,19 +0,0 @@
-#!/usr/bin/env python3
-
-import json
-import sqlite3
-from collections import OrderedDict
-
-with open('bus_stops.json') as f:
-    # in Python 3.7 and above, dictionaries are ordered by default
-    # but this will explicitly preserve the key order
-    bus_stops = json.load(f, object_pairs_hook=OrderedDict)
-
-conn = sqlite3.connect('datamall.sqlite')
-c = conn.cursor()
-for bus_stop in bus_stops:
-    c.execute('insert into bus_stops values (?, ?, ?, ?, ?)', tuple(bus_stop.values()))
-conn.commit()
-print('Inserted', len(bus_stops), 'rows into bus_stops')
-
-conn.close()
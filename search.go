@@ -0,0 +1,226 @@
+package busetabot
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Bus stop search
+//
+// Queries and bus stop descriptions and road names are split into lowercase tokens on anything other than letters,
+// digits and apostrophes, so "Tampines Stn/Int" becomes "tampines", "stn" and "int". Each query token becomes a search
+// term, and aliases in queries add their synonyms to the term they start at as alternatives.
+//
+// Each alternative of each search term is matched against the tokens in the description and road name of every bus
+// stop, as well as its bus stop code. A match has a quality of:
+//
+//   - 3 if the tokens are equal
+//   - 2 if the bus stop token starts with the query token, which must be at least minSearchPrefixLength characters
+//   - 1 if the tokens are within a small edit distance of each other (see maxSearchEditDistance)
+//
+// The best quality of each search term in each field is multiplied by the weight of the field, which is 2 for
+// descriptions and bus stop codes and 1 for road names, so an exact match in a description scores 6 while an exact
+// match in a road name scores 3. A bus stop's score is the sum over all search terms and fields. Bus stops are ranked
+// by score, and then by bus stop code.
+
+const (
+	// minSearchPrefixLength is the shortest query token which is matched against the start of longer tokens.
+	minSearchPrefixLength = 2
+
+	// minFuzzySearchLength is the shortest query token which can match tokens with a different spelling.
+	minFuzzySearchLength = 4
+
+	// longFuzzySearchLength is the shortest query token which can be two edits away from the tokens it matches.
+	longFuzzySearchLength = 8
+)
+
+type searchField int
+
+const (
+	searchFieldDescription searchField = iota
+	searchFieldRoadName
+	searchFieldCode
+)
+
+var searchFieldWeights = [...]int{
+	searchFieldDescription: 2,
+	searchFieldRoadName:    1,
+	searchFieldCode:        2,
+}
+
+// searchPosting records that a token appears in a field of the bus stop at an index.
+type searchPosting struct {
+	stop  int
+	field searchField
+}
+
+// searchIndex is an inverted index from the tokens in bus stop descriptions, road names and codes to the bus stops
+// they appear in.
+type searchIndex struct {
+	postings map[string][]searchPosting
+	// terms contains every token in postings in sorted order, for finding tokens by prefix.
+	terms []string
+}
+
+type searchHit struct {
+	stop  int
+	score int
+}
+
+// searchTokens splits s into lowercase tokens.
+func searchTokens(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	})
+}
+
+func newSearchIndex(busStops []BusStop) searchIndex {
+	postings := make(map[string][]searchPosting)
+	add := func(token string, p searchPosting) {
+		ps := postings[token]
+		if n := len(ps); n > 0 && ps[n-1] == p {
+			return
+		}
+		postings[token] = append(ps, p)
+	}
+	for i, bs := range busStops {
+		for _, token := range searchTokens(bs.Description) {
+			add(token, searchPosting{stop: i, field: searchFieldDescription})
+		}
+		for _, token := range searchTokens(bs.RoadName) {
+			add(token, searchPosting{stop: i, field: searchFieldRoadName})
+		}
+		add(bs.BusStopCode, searchPosting{stop: i, field: searchFieldCode})
+	}
+	terms := make([]string, 0, len(postings))
+	for term := range postings {
+		terms = append(terms, term)
+	}
+	sort.Strings(terms)
+	return searchIndex{
+		postings: postings,
+		terms:    terms,
+	}
+}
+
+// matches returns the quality of every term matching token.
+func (idx searchIndex) matches(token string) map[string]int {
+	matches := make(map[string]int)
+	if _, ok := idx.postings[token]; ok {
+		matches[token] = 3
+	}
+	if len(token) >= minSearchPrefixLength {
+		for i := sort.SearchStrings(idx.terms, token); i < len(idx.terms) && strings.HasPrefix(idx.terms[i], token); i++ {
+			if idx.terms[i] != token {
+				matches[idx.terms[i]] = 2
+			}
+		}
+	}
+	// misspelt numbers are more likely to be a different bus stop code than a typo
+	if max := maxSearchEditDistance(token); max > 0 && !isDigits(token) {
+		for _, term := range idx.terms {
+			if _, ok := matches[term]; ok {
+				continue
+			}
+			if withinEditDistance(token, term, max) {
+				matches[term] = 1
+			}
+		}
+	}
+	return matches
+}
+
+// search returns the bus stops matching any of terms, ranked by score. Each term is a list of alternative tokens, of
+// which the best match counts.
+func (idx searchIndex) search(busStops []BusStop, terms [][]string) []searchHit {
+	scores := make(map[int]int)
+	for _, alternatives := range terms {
+		best := make(map[searchPosting]int)
+		for _, token := range alternatives {
+			for term, quality := range idx.matches(token) {
+				for _, p := range idx.postings[term] {
+					if quality > best[p] {
+						best[p] = quality
+					}
+				}
+			}
+		}
+		for p, quality := range best {
+			scores[p.stop] += quality * searchFieldWeights[p.field]
+		}
+	}
+	hits := make([]searchHit, 0, len(scores))
+	for stop, score := range scores {
+		hits = append(hits, searchHit{stop: stop, score: score})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].score == hits[j].score {
+			return busStops[hits[i].stop].BusStopCode < busStops[hits[j].stop].BusStopCode
+		}
+		return hits[i].score > hits[j].score
+	})
+	return hits
+}
+
+// maxSearchEditDistance returns how many edits a token can be away from the tokens it matches.
+func maxSearchEditDistance(token string) int {
+	switch n := len(token); {
+	case n >= longFuzzySearchLength:
+		return 2
+	case n >= minFuzzySearchLength:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// withinEditDistance reports whether the Levenshtein distance between a and b is at most max.
+func withinEditDistance(a, b string, max int) bool {
+	if d := len(a) - len(b); d > max || -d > max {
+		return false
+	}
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		rowMin := curr[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if curr[j] < rowMin {
+				rowMin = curr[j]
+			}
+		}
+		if rowMin > max {
+			return false
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)] <= max
+}
+
+func minInt(first int, rest ...int) int {
+	min := first
+	for _, n := range rest {
+		if n < min {
+			min = n
+		}
+	}
+	return min
+}
@@ -0,0 +1,34 @@
+package busetabot
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_searchTokens(t *testing.T) {
+	assert.Equal(t, []string{"opp", "tampines", "stn", "int"}, searchTokens("Opp Tampines Stn/Int"))
+	assert.Equal(t, []string{"st", "joseph's", "ch"}, searchTokens("St. Joseph's Ch"))
+	assert.Equal(t, []string{"c'wealth", "ave", "west"}, searchTokens("C'wealth Ave West"))
+	assert.Empty(t, searchTokens(" / "))
+}
+
+func Test_withinEditDistance(t *testing.T) {
+	testCases := []struct {
+		A, B     string
+		Max      int
+		Expected bool
+	}{
+		{"clementi", "clementi", 0, true},
+		{"clementii", "clementi", 1, true},
+		{"clemnti", "clementi", 1, true},
+		{"clemetni", "clementi", 1, false},
+		{"clemetni", "clementi", 2, true},
+		{"bedok", "bedok nth", 2, false},
+		{"", "ab", 2, true},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.Expected, withinEditDistance(tc.A, tc.B, tc.Max), "%s %s %d", tc.A, tc.B, tc.Max)
+		assert.Equal(t, tc.Expected, withinEditDistance(tc.B, tc.A, tc.Max), "%s %s %d", tc.B, tc.A, tc.Max)
+	}
+}
@@ -0,0 +1,276 @@
+package busetabot
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/yi-jiayu/telegram-bot-api"
+
+	"github.com/yi-jiayu/bus-eta-bot/v4/telegram"
+)
+
+// setting is a user preference which can be changed from the /settings menu. Tapping a setting's button changes it to
+// the next of its values.
+type setting struct {
+	// key identifies the setting in callback data.
+	key    string
+	label  string
+	values []string
+	get    func(p Preferences) string
+	set    func(p *Preferences, value string)
+
+	// describe returns the label shown for one of the setting's values.
+	describe func(value string) string
+}
+
+var settings = []setting{
+	{
+		key:    "formatter",
+		label:  "ETA format",
+		values: []string{FormatterSummary, FormatterFeatures},
+		get: func(p Preferences) string {
+			if _, ok := Formatters[p.Formatter]; !ok {
+				return FormatterSummary
+			}
+			return p.Formatter
+		},
+		set: func(p *Preferences, value string) {
+			// the summary formatter is stored as the default so that eta messages keep their existing callback data
+			if value == FormatterSummary {
+				value = ""
+			}
+			p.Formatter = value
+		},
+		describe: func(value string) string {
+			if value == FormatterFeatures {
+				return "Details"
+			}
+			return "Summary"
+		},
+	},
+	{
+		key:    "time",
+		label:  "Times",
+		values: []string{TimeDisplayRelative, TimeDisplayClock},
+		get: func(p Preferences) string {
+			if p.TimeDisplay == TimeDisplayClock {
+				return TimeDisplayClock
+			}
+			return TimeDisplayRelative
+		},
+		set: func(p *Preferences, value string) {
+			p.TimeDisplay = value
+		},
+		describe: func(value string) string {
+			if value == TimeDisplayClock {
+				return "Arrival time"
+			}
+			return "Minutes until arrival"
+		},
+	},
+	{
+		key:    "nearby_mode",
+		label:  "Nearby bus stops",
+		values: []string{NearbyModeVenues, NearbyModeCompact},
+		get: func(p Preferences) string {
+			if p.NearbyMode == NearbyModeCompact {
+				return NearbyModeCompact
+			}
+			return NearbyModeVenues
+		},
+		set: func(p *Preferences, value string) {
+			p.NearbyMode = value
+		},
+		describe: func(value string) string {
+			if value == NearbyModeCompact {
+				return "One message"
+			}
+			return "Separate messages"
+		},
+	},
+	{
+		key:    "radius",
+		label:  "Nearby radius",
+		values: []string{"250", "500", "1000"},
+		get: func(p Preferences) string {
+			return strconv.Itoa(int(p.nearbyRadius()))
+		},
+		set: func(p *Preferences, value string) {
+			p.NearbyRadius, _ = strconv.Atoi(value)
+		},
+		describe: func(value string) string {
+			return value + " m"
+		},
+	},
+	{
+		key:    "limit",
+		label:  "Nearby results",
+		values: []string{"3", "5", "10"},
+		get: func(p Preferences) string {
+			return strconv.Itoa(p.nearbyLimit())
+		},
+		set: func(p *Preferences, value string) {
+			p.NearbyLimit, _ = strconv.Atoi(value)
+		},
+		describe: func(value string) string {
+			return value
+		},
+	},
+	{
+		key:    "language",
+		label:  "Language",
+		values: languageCodes(),
+		get: func(p Preferences) string {
+			return p.language()
+		},
+		set: func(p *Preferences, value string) {
+			p.Language = value
+		},
+		describe: func(value string) string {
+			return Languages[value]
+		},
+	},
+}
+
+// languageCodes returns the codes of the languages which can be chosen, with English first.
+func languageCodes() []string {
+	var others []string
+	for code := range Languages {
+		if code != LanguageEnglish {
+			others = append(others, code)
+		}
+	}
+	sort.Strings(others)
+	return append([]string{LanguageEnglish}, others...)
+}
+
+// next returns the value after the current value of s in p, wrapping around to the first value.
+func (s setting) next(p Preferences) string {
+	current := s.get(p)
+	for i, value := range s.values {
+		if value == current {
+			return s.values[(i+1)%len(s.values)]
+		}
+	}
+	return s.values[0]
+}
+
+// findSetting returns the setting identified by key.
+func findSetting(key string) (setting, bool) {
+	for _, s := range settings {
+		if s.key == key {
+			return s, true
+		}
+	}
+	return setting{}, false
+}
+
+const settingsText = "*Settings*\n\nTap a setting to change it."
+
+// newSettingsMarkup returns the /settings menu for a user with preferences p. Each button sets its setting to the
+// next value, so that tapping a stale menu still results in the value it showed being changed.
+func newSettingsMarkup(p Preferences) telegram.InlineKeyboardMarkup {
+	var keyboard [][]telegram.InlineKeyboardButton
+	for _, s := range settings {
+		data := CallbackData{
+			Type:   "settings",
+			Argstr: s.key + " " + s.next(p),
+		}
+		JSON, _ := json.Marshal(data)
+		keyboard = append(keyboard, []telegram.InlineKeyboardButton{
+			{
+				Text:         s.label + ": " + s.describe(s.get(p)),
+				CallbackData: string(JSON),
+			},
+		})
+	}
+	return telegram.InlineKeyboardMarkup{
+		InlineKeyboard: keyboard,
+	}
+}
+
+// SettingsCmdHandler handles the /settings command, which shows a menu for changing a user's preferences.
+func SettingsCmdHandler(ctx context.Context, bot *BusEtaBot, message *tgbotapi.Message, responses chan<- Response) {
+	defer close(responses)
+	go bot.LogEvent(ctx, message.From, CategoryCommand, ActionSettingsCommand, message.Chat.Type)
+
+	chatID := message.Chat.ID
+	if bot.Preferences == nil {
+		responses <- ok(telegram.SendMessageRequest{
+			ChatID: chatID,
+			Text:   "Oops, settings are not available at the moment.",
+		})
+		return
+	}
+	preferences, err := bot.Preferences.GetUserPreferences(ctx, message.From.ID)
+	if err != nil {
+		responses <- notOk(err)
+		return
+	}
+	responses <- ok(telegram.SendMessageRequest{
+		ChatID:      chatID,
+		Text:        settingsText,
+		ParseMode:   "markdown",
+		ReplyMarkup: newSettingsMarkup(preferences),
+	})
+}
+
+// SettingsCallbackHandler handles the buttons on the /settings menu. It saves the new value of a setting and updates
+// the menu in place.
+func SettingsCallbackHandler(ctx context.Context, bot *BusEtaBot, cbq *tgbotapi.CallbackQuery, responses chan<- Response) {
+	defer close(responses)
+
+	var data CallbackData
+	err := json.Unmarshal([]byte(cbq.Data), &data)
+	if err != nil {
+		responses <- notOk(errors.Wrap(err, "error unmarshalling callback data"))
+		return
+	}
+	fields := strings.Fields(data.Argstr)
+	if len(fields) != 2 {
+		responses <- notOk(errors.Errorf("invalid settings callback argument %q", data.Argstr))
+		return
+	}
+	s, found := findSetting(fields[0])
+	if !found || !contains(s.values, fields[1]) {
+		responses <- notOk(errors.Errorf("invalid setting %q", data.Argstr))
+		return
+	}
+	go bot.LogEvent(ctx, cbq.From, CategoryCallback, ActionSettingsCallback, s.key)
+
+	if bot.Preferences == nil {
+		responses <- ok(telegram.AnswerCallbackQueryRequest{
+			CallbackQueryID: cbq.ID,
+			Text:            "Oops, your preferences cannot be saved at the moment.",
+		})
+		return
+	}
+	preferences, err := bot.Preferences.GetUserPreferences(ctx, cbq.From.ID)
+	if err != nil {
+		responses <- notOk(err)
+		return
+	}
+	s.set(&preferences, fields[1])
+	err = bot.Preferences.SetUserPreferences(ctx, cbq.From.ID, preferences)
+	if err != nil {
+		responses <- notOk(err)
+		return
+	}
+	message := callbackQueryMessage(cbq)
+	responses <- ok(telegram.EditMessageTextRequest{
+		ChatID:          message.ChatID,
+		MessageID:       message.MessageID,
+		InlineMessageID: message.InlineMessageID,
+		Text:            settingsText,
+		ParseMode:       "markdown",
+		ReplyMarkup:     newSettingsMarkup(preferences),
+	})
+	responses <- ok(telegram.AnswerCallbackQueryRequest{
+		CallbackQueryID: cbq.ID,
+		Text:            s.label + " set to " + s.describe(fields[1]) + ".",
+	})
+}
@@ -0,0 +1,220 @@
+package busetabot
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kr/pretty"
+	"github.com/stretchr/testify/assert"
+	"github.com/yi-jiayu/datamall/v3"
+
+	"github.com/yi-jiayu/bus-eta-bot/v4/telegram"
+)
+
+var expectedDefaultSettingsMarkup = telegram.InlineKeyboardMarkup{
+	InlineKeyboard: [][]telegram.InlineKeyboardButton{
+		{{Text: "ETA format: Summary", CallbackData: `{"t":"settings","a":"formatter f"}`}},
+		{{Text: "Times: Minutes until arrival", CallbackData: `{"t":"settings","a":"time clock"}`}},
+		{{Text: "Nearby bus stops: Separate messages", CallbackData: `{"t":"settings","a":"nearby_mode compact"}`}},
+		{{Text: "Nearby radius: 500 m", CallbackData: `{"t":"settings","a":"radius 1000"}`}},
+		{{Text: "Nearby results: 5", CallbackData: `{"t":"settings","a":"limit 10"}`}},
+		{{Text: "Language: English", CallbackData: `{"t":"settings","a":"language en"}`}},
+	},
+}
+
+func TestPreferences_formatter(t *testing.T) {
+	assert.Equal(t, summaryFormatter, Preferences{}.formatter(""))
+	assert.Equal(t, featuresFormatter, Preferences{}.formatter(FormatterFeatures))
+	assert.Equal(t, featuresFormatter, Preferences{Formatter: FormatterFeatures}.formatter(""))
+	assert.Equal(t, summaryFormatter, Preferences{Formatter: FormatterFeatures}.formatter(FormatterSummary), "an explicit formatter should override the preferred one")
+	assert.Equal(t, summaryClockFormatter, Preferences{TimeDisplay: TimeDisplayClock}.formatter(""))
+	assert.Equal(t, summaryFormatter, Preferences{Formatter: "unknown"}.formatter(""))
+}
+
+func TestSettingsCmdHandler(t *testing.T) {
+	t.Run("shows the settings menu", func(t *testing.T) {
+		bot := &BusEtaBot{
+			Preferences: mockPreferenceRepository{},
+		}
+		responses := make(chan Response, ResponseBufferSize)
+
+		SettingsCmdHandler(context.Background(), bot, MockMessageWithText("/settings"), responses)
+
+		actual, err := collectResponsesWithTimeout(responses, 100*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []Response{
+			ok(telegram.SendMessageRequest{
+				ChatID:      1,
+				Text:        settingsText,
+				ParseMode:   "markdown",
+				ReplyMarkup: expectedDefaultSettingsMarkup,
+			}),
+		}
+		if !assert.Equal(t, expected, actual) {
+			pretty.Println(actual)
+		}
+	})
+	t.Run("without a preference repository", func(t *testing.T) {
+		bot := &BusEtaBot{}
+		responses := make(chan Response, ResponseBufferSize)
+
+		SettingsCmdHandler(context.Background(), bot, MockMessageWithText("/settings"), responses)
+
+		actual, err := collectResponsesWithTimeout(responses, 100*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []Response{
+			ok(telegram.SendMessageRequest{
+				ChatID: 1,
+				Text:   "Oops, settings are not available at the moment.",
+			}),
+		}
+		assert.Equal(t, expected, actual)
+	})
+}
+
+func TestSettingsCallbackHandler(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		Data     string
+		Before   Preferences
+		After    Preferences
+		Answer   string
+		Button   int
+		Expected string
+	}{
+		{
+			Name:     "changes the eta format",
+			Data:     `{"t":"settings","a":"formatter f"}`,
+			After:    Preferences{Formatter: FormatterFeatures},
+			Answer:   "ETA format set to Details.",
+			Button:   0,
+			Expected: "ETA format: Details",
+		},
+		{
+			Name:     "stores the summary format as the default",
+			Data:     `{"t":"settings","a":"formatter s"}`,
+			Before:   Preferences{Formatter: FormatterFeatures},
+			After:    Preferences{},
+			Answer:   "ETA format set to Summary.",
+			Button:   0,
+			Expected: "ETA format: Summary",
+		},
+		{
+			Name:     "changes the time display",
+			Data:     `{"t":"settings","a":"time clock"}`,
+			After:    Preferences{TimeDisplay: TimeDisplayClock},
+			Answer:   "Times set to Arrival time.",
+			Button:   1,
+			Expected: "Times: Arrival time",
+		},
+		{
+			Name:     "changes the nearby radius and keeps other preferences",
+			Data:     `{"t":"settings","a":"radius 1000"}`,
+			Before:   Preferences{NearbyMode: NearbyModeCompact},
+			After:    Preferences{NearbyMode: NearbyModeCompact, NearbyRadius: 1000},
+			Answer:   "Nearby radius set to 1000 m.",
+			Button:   3,
+			Expected: "Nearby radius: 1000 m",
+		},
+		{
+			Name:     "changes the number of nearby results",
+			Data:     `{"t":"settings","a":"limit 3"}`,
+			After:    Preferences{NearbyLimit: 3},
+			Answer:   "Nearby results set to 3.",
+			Button:   4,
+			Expected: "Nearby results: 3",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			preferences := mockPreferenceRepository{1: tc.Before}
+			bot := &BusEtaBot{
+				Preferences: preferences,
+			}
+			responses := make(chan Response, ResponseBufferSize)
+
+			SettingsCallbackHandler(context.Background(), bot, newCallbackQueryFromMessage(tc.Data), responses)
+
+			actual, err := collectResponsesWithTimeout(responses, 100*time.Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, tc.After, preferences[1])
+			expected := []Response{
+				ok(telegram.EditMessageTextRequest{
+					ChatID:      1,
+					MessageID:   1,
+					Text:        settingsText,
+					ParseMode:   "markdown",
+					ReplyMarkup: newSettingsMarkup(tc.After),
+				}),
+				ok(telegram.AnswerCallbackQueryRequest{CallbackQueryID: "1", Text: tc.Answer}),
+			}
+			if !assert.Equal(t, expected, actual) {
+				pretty.Println(actual)
+			}
+			assert.Equal(t, tc.Expected, newSettingsMarkup(tc.After).InlineKeyboard[tc.Button][0].Text)
+		})
+	}
+	t.Run("rejects invalid values", func(t *testing.T) {
+		preferences := mockPreferenceRepository{}
+		bot := &BusEtaBot{
+			Preferences: preferences,
+		}
+		for _, data := range []string{
+			`{"t":"settings","a":"radius 123"}`,
+			`{"t":"settings","a":"colour blue"}`,
+			`{"t":"settings","a":"radius"}`,
+		} {
+			responses := make(chan Response, ResponseBufferSize)
+
+			SettingsCallbackHandler(context.Background(), bot, newCallbackQueryFromMessage(data), responses)
+
+			actual, err := collectResponsesWithTimeout(responses, 100*time.Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if assert.Len(t, actual, 1) {
+				assert.Error(t, actual[0].Error, data)
+			}
+		}
+		assert.Empty(t, preferences)
+	})
+}
+
+func TestEtaHandler_Preferences(t *testing.T) {
+	now := time.Date(2018, 1, 1, 8, 0, 0, 0, sgt)
+	bot := &BusEtaBot{
+		BusStops: mockBusStopRepository{},
+		Datamall: mockETAService{
+			BusArrival: datamall.BusArrival{
+				Services: []datamall.Service{
+					{ServiceNo: "2", NextBus: datamall.ArrivingBus{EstimatedArrival: now.Add(5 * time.Minute)}},
+				},
+			},
+		},
+		NowFunc: func() time.Time {
+			return now
+		},
+		Preferences: mockPreferenceRepository{1: {Formatter: FormatterFeatures, TimeDisplay: TimeDisplayClock}},
+	}
+	responses := make(chan Response, ResponseBufferSize)
+
+	EtaHandler(context.Background(), bot, MockMessageWithText("/eta 96049"), responses)
+
+	actual, err := collectResponsesWithTimeout(responses, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !assert.Len(t, actual, 1) {
+		return
+	}
+	req := actual[0].Request.(telegram.SendMessageRequest)
+	assert.Contains(t, req.Text, "2     08:05")
+	assert.Equal(t, NewETAMessageReplyMarkup("96049", nil, ETAMessageOptions{Formatter: FormatterFeatures}), req.ReplyMarkup)
+}
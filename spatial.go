@@ -0,0 +1,65 @@
+package busetabot
+
+import "math"
+
+// spatialGridCellSize is the width and height of the cells in a spatialGrid in degrees, which is about 550 m in
+// Singapore. Nearby queries are usually for 500 m or 1000 m, so they only need to check a handful of cells.
+const spatialGridCellSize = 0.005
+
+// metresPerDegree is the length of one degree of latitude, or of longitude at the equator.
+const metresPerDegree = EarthRadius * math.Pi / 180
+
+type spatialGridCell struct {
+	row, col int
+}
+
+// spatialGrid buckets bus stops into cells of equal latitude and longitude, so that finding the bus stops near a point
+// only requires checking the bus stops in the cells around it.
+type spatialGrid struct {
+	cells map[spatialGridCell][]int
+}
+
+func newSpatialGrid(busStops []BusStop) spatialGrid {
+	cells := make(map[spatialGridCell][]int)
+	for i, bs := range busStops {
+		cell := spatialGridCellOf(bs.Latitude, bs.Longitude)
+		cells[cell] = append(cells[cell], i)
+	}
+	return spatialGrid{cells: cells}
+}
+
+func spatialGridCellOf(lat, lon float64) spatialGridCell {
+	return spatialGridCell{
+		row: int(math.Floor(lat / spatialGridCellSize)),
+		col: int(math.Floor(lon / spatialGridCellSize)),
+	}
+}
+
+// candidates calls f with the index of every bus stop which could be within radius metres of a point. Callers still
+// need to check the actual distance of each bus stop.
+func (g spatialGrid) candidates(lat, lon, radius float64, f func(i int)) {
+	dLat := radius / metresPerDegree
+	// longitudes get closer together away from the equator, so more columns need to be checked
+	dLon := 360.0
+	if cos := math.Cos((math.Abs(lat) + dLat) * math.Pi / 180); cos > 0 {
+		dLon = math.Min(dLon, radius/(metresPerDegree*cos))
+	}
+	min := spatialGridCellOf(lat-dLat, lon-dLon)
+	max := spatialGridCellOf(lat+dLat, lon+dLon)
+	// for very large radii, it is faster to check every bus stop than every cell
+	if float64(max.row-min.row+1)*float64(max.col-min.col+1) > float64(len(g.cells)) {
+		for _, stops := range g.cells {
+			for _, i := range stops {
+				f(i)
+			}
+		}
+		return
+	}
+	for row := min.row; row <= max.row; row++ {
+		for col := min.col; col <= max.col; col++ {
+			for _, i := range g.cells[spatialGridCell{row: row, col: col}] {
+				f(i)
+			}
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package busetabot
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// nearbyByScan finds nearby bus stops by checking every bus stop, for comparison with the spatial grid.
+func nearbyByScan(busStops []BusStop, lat, lon, radius float64, limit int) (nearby []NearbyBusStop) {
+	for _, bs := range busStops {
+		d := HaversineDistance(lat, lon, bs.Latitude, bs.Longitude)
+		if d <= radius {
+			nearby = append(nearby, NearbyBusStop{BusStop: bs, Distance: d})
+		}
+	}
+	sort.Slice(nearby, func(i, j int) bool {
+		if nearby[i].Distance == nearby[j].Distance {
+			return nearby[i].BusStopCode < nearby[j].BusStopCode
+		}
+		return nearby[i].Distance < nearby[j].Distance
+	})
+	if limit <= 0 || limit > len(nearby) {
+		limit = len(nearby)
+	}
+	return nearby[:limit]
+}
+
+// randomPointsInSingapore returns n random points around Singapore which are the same on every run.
+func randomPointsInSingapore(n int) [][2]float64 {
+	r := rand.New(rand.NewSource(1))
+	points := make([][2]float64, n)
+	for i := range points {
+		points[i] = [2]float64{1.24 + r.Float64()*0.23, 103.62 + r.Float64()*0.42}
+	}
+	return points
+}
+
+func TestInMemoryBusStopRepository_NearbyMatchesScan(t *testing.T) {
+	busStops, _, err := loadBusStopsFile("data/bus_stops.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := NewInMemoryBusStopRepository(busStops, nil)
+	for _, radius := range []float64{50, 500, 1000, 5000, 50000} {
+		for _, p := range randomPointsInSingapore(100) {
+			expected := nearbyByScan(busStops, p[0], p[1], radius, 0)
+			actual := repo.Nearby(context.Background(), p[0], p[1], radius, 0)
+			if !assert.Equal(t, expected, actual, "%f, %f within %f m", p[0], p[1], radius) {
+				return
+			}
+		}
+	}
+}
+
+func BenchmarkInMemoryBusStopRepository_Nearby(b *testing.B) {
+	busStops, _, err := loadBusStopsFile("data/bus_stops.json")
+	if err != nil {
+		b.Fatal(err)
+	}
+	repo := NewInMemoryBusStopRepository(busStops, nil)
+	points := randomPointsInSingapore(1000)
+	for _, bc := range []struct {
+		Name   string
+		Radius float64
+		Limit  int
+	}{
+		{Name: "LocationHandler", Radius: 500, Limit: 5},
+		{Name: "GetNearbyInlineQueryResults", Radius: NearbyBusStopsRadius, Limit: InlineQueryResultsLimit},
+	} {
+		b.Run(bc.Name+"/grid", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				p := points[i%len(points)]
+				repo.Nearby(context.Background(), p[0], p[1], bc.Radius, bc.Limit)
+			}
+		})
+		b.Run(bc.Name+"/scan", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				p := points[i%len(points)]
+				nearbyByScan(busStops, p[0], p[1], bc.Radius, bc.Limit)
+			}
+		})
+	}
+}
@@ -0,0 +1,377 @@
+package busetabot
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+// sqliteMigrations contains the statements needed to bring a database up to each schema version. The schema version of
+// a database is stored in its user_version pragma, and migration i takes a database from version i to version i+1.
+// Existing migrations must never be modified; changes to the schema must be made by appending a new migration.
+var sqliteMigrations = []string{
+	`CREATE TABLE users (
+		id             INTEGER PRIMARY KEY,
+		last_seen_time TIMESTAMP NOT NULL
+	);
+	CREATE TABLE favourites (
+		user_id  INTEGER NOT NULL,
+		position INTEGER NOT NULL,
+		query    TEXT    NOT NULL,
+		PRIMARY KEY (user_id, position)
+	);`,
+	`CREATE TABLE alerts (
+		id            INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id       INTEGER   NOT NULL,
+		chat_id       INTEGER   NOT NULL,
+		bus_stop_code TEXT      NOT NULL,
+		service_no    TEXT      NOT NULL,
+		minutes       INTEGER   NOT NULL,
+		created_at    TIMESTAMP NOT NULL,
+		expires_at    TIMESTAMP NOT NULL
+	);
+	CREATE INDEX alerts_user_id ON alerts (user_id);`,
+	`CREATE TABLE schedules (
+		id            INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id       INTEGER NOT NULL,
+		chat_id       INTEGER NOT NULL,
+		bus_stop_code TEXT    NOT NULL,
+		service_nos   TEXT    NOT NULL,
+		hour          INTEGER NOT NULL,
+		minute        INTEGER NOT NULL,
+		days          INTEGER NOT NULL
+	);
+	CREATE INDEX schedules_user_id ON schedules (user_id);`,
+	`CREATE TABLE preferences (
+		user_id     INTEGER PRIMARY KEY,
+		nearby_mode TEXT NOT NULL
+	);`,
+	`ALTER TABLE preferences ADD COLUMN formatter TEXT NOT NULL DEFAULT '';
+	ALTER TABLE preferences ADD COLUMN time_display TEXT NOT NULL DEFAULT '';
+	ALTER TABLE preferences ADD COLUMN nearby_radius INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE preferences ADD COLUMN nearby_limit INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE preferences ADD COLUMN language TEXT NOT NULL DEFAULT '';`,
+	`ALTER TABLE favourites ADD COLUMN label TEXT NOT NULL DEFAULT '';`,
+	`CREATE TABLE recent_bus_stops (
+		user_id       INTEGER   NOT NULL,
+		bus_stop_code TEXT      NOT NULL,
+		used_at       TIMESTAMP NOT NULL,
+		PRIMARY KEY (user_id, bus_stop_code)
+	);`,
+}
+
+// SQLiteUserRepository is a UserRepository which stores users in a SQLite database, for running the bot on a single
+// machine without Cloud Datastore.
+type SQLiteUserRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteUserRepository opens the SQLite database at path, creating it if it does not exist, and migrates it to the
+// latest schema version.
+func NewSQLiteUserRepository(path string) (*SQLiteUserRepository, error) {
+	db, err := sql.Open("sqlite3", path+"?_busy_timeout=5000&_foreign_keys=1")
+	if err != nil {
+		return nil, errors.Wrap(err, "error opening sqlite database")
+	}
+	// SQLite only supports a single writer at a time
+	db.SetMaxOpenConns(1)
+	err = migrateSQLite(db, sqliteMigrations)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteUserRepository{
+		db: db,
+	}, nil
+}
+
+// migrateSQLite applies any of migrations which have not yet been applied to db.
+func migrateSQLite(db *sql.DB, migrations []string) error {
+	var version int
+	err := db.QueryRow("PRAGMA user_version").Scan(&version)
+	if err != nil {
+		return errors.Wrap(err, "error getting schema version")
+	}
+	if version > len(migrations) {
+		return errors.Errorf("database schema version %d is newer than the latest known version %d", version, len(migrations))
+	}
+	for ; version < len(migrations); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return errors.Wrap(err, "error beginning migration")
+		}
+		_, err = tx.Exec(migrations[version])
+		if err != nil {
+			tx.Rollback()
+			return errors.Wrapf(err, "error migrating to schema version %d", version+1)
+		}
+		// pragma statements do not support placeholders
+		_, err = tx.Exec("PRAGMA user_version = " + strconv.Itoa(version+1))
+		if err != nil {
+			tx.Rollback()
+			return errors.Wrap(err, "error updating schema version")
+		}
+		err = tx.Commit()
+		if err != nil {
+			return errors.Wrapf(err, "error committing migration to schema version %d", version+1)
+		}
+	}
+	return nil
+}
+
+// Close closes the underlying database.
+func (r *SQLiteUserRepository) Close() error {
+	return r.db.Close()
+}
+
+func (r *SQLiteUserRepository) UpdateUserLastSeenTime(ctx context.Context, userID int, t time.Time) error {
+	_, err := r.db.ExecContext(ctx, `INSERT INTO users (id, last_seen_time) VALUES (?, ?)
+		ON CONFLICT (id) DO UPDATE SET last_seen_time = excluded.last_seen_time`, userID, t.UTC())
+	if err != nil {
+		return errors.Wrap(err, "error updating user last seen time")
+	}
+	return nil
+}
+
+// GetUserLastSeenTime returns the last time a user was seen, or the zero time if the user has never been seen.
+func (r *SQLiteUserRepository) GetUserLastSeenTime(ctx context.Context, userID int) (time.Time, error) {
+	var t time.Time
+	err := r.db.QueryRowContext(ctx, "SELECT last_seen_time FROM users WHERE id = ?", userID).Scan(&t)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return time.Time{}, nil
+		}
+		return time.Time{}, errors.Wrap(err, "error getting user last seen time")
+	}
+	return t, nil
+}
+
+func (r *SQLiteUserRepository) GetUserFavourites(ctx context.Context, userID int) (favourites []Favourite, err error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT query, label FROM favourites WHERE user_id = ? ORDER BY position", userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting user favourites")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var f Favourite
+		err = rows.Scan(&f.Query, &f.Label)
+		if err != nil {
+			return nil, errors.Wrap(err, "error scanning user favourite")
+		}
+		favourites = append(favourites, f)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting user favourites")
+	}
+	return favourites, nil
+}
+
+func (r *SQLiteUserRepository) SetUserFavourites(ctx context.Context, userID int, favourites []Favourite) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "error beginning transaction")
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM favourites WHERE user_id = ?", userID)
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "error deleting user favourites")
+	}
+	for i, f := range favourites {
+		_, err = tx.ExecContext(ctx, "INSERT INTO favourites (user_id, position, query, label) VALUES (?, ?, ?, ?)", userID, i, f.Query, f.Label)
+		if err != nil {
+			tx.Rollback()
+			return errors.Wrap(err, "error inserting user favourite")
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "error updating user favourites in transaction")
+	}
+	return nil
+}
+
+func (r *SQLiteUserRepository) GetUserPreferences(ctx context.Context, userID int) (Preferences, error) {
+	var preferences Preferences
+	err := r.db.QueryRowContext(ctx, `SELECT nearby_mode, formatter, time_display, nearby_radius, nearby_limit, language
+		FROM preferences WHERE user_id = ?`, userID).Scan(&preferences.NearbyMode, &preferences.Formatter,
+		&preferences.TimeDisplay, &preferences.NearbyRadius, &preferences.NearbyLimit, &preferences.Language)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return Preferences{}, nil
+		}
+		return Preferences{}, errors.Wrap(err, "error getting user preferences")
+	}
+	return preferences, nil
+}
+
+func (r *SQLiteUserRepository) SetUserPreferences(ctx context.Context, userID int, preferences Preferences) error {
+	_, err := r.db.ExecContext(ctx, `INSERT INTO preferences (user_id, nearby_mode, formatter, time_display, nearby_radius, nearby_limit, language)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET nearby_mode = excluded.nearby_mode, formatter = excluded.formatter,
+			time_display = excluded.time_display, nearby_radius = excluded.nearby_radius,
+			nearby_limit = excluded.nearby_limit, language = excluded.language`,
+		userID, preferences.NearbyMode, preferences.Formatter, preferences.TimeDisplay, preferences.NearbyRadius,
+		preferences.NearbyLimit, preferences.Language)
+	if err != nil {
+		return errors.Wrap(err, "error updating user preferences")
+	}
+	return nil
+}
+
+func (r *SQLiteUserRepository) AddRecentBusStop(ctx context.Context, userID int, code string, t time.Time) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "error beginning transaction")
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO recent_bus_stops (user_id, bus_stop_code, used_at) VALUES (?, ?, ?)
+		ON CONFLICT (user_id, bus_stop_code) DO UPDATE SET used_at = excluded.used_at`, userID, code, t.UTC())
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "error inserting recent bus stop")
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM recent_bus_stops WHERE user_id = ? AND bus_stop_code NOT IN (
+		SELECT bus_stop_code FROM recent_bus_stops WHERE user_id = ? ORDER BY used_at DESC LIMIT ?)`,
+		userID, userID, MaxRecentBusStops)
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "error deleting old recent bus stops")
+	}
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "error updating recent bus stops in transaction")
+	}
+	return nil
+}
+
+func (r *SQLiteUserRepository) GetRecentBusStops(ctx context.Context, userID int) (codes []string, err error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT bus_stop_code FROM recent_bus_stops WHERE user_id = ? ORDER BY used_at DESC", userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting recent bus stops")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var code string
+		err = rows.Scan(&code)
+		if err != nil {
+			return nil, errors.Wrap(err, "error scanning recent bus stop")
+		}
+		codes = append(codes, code)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting recent bus stops")
+	}
+	return codes, nil
+}
+
+func (r *SQLiteUserRepository) AddAlert(ctx context.Context, alert Alert) (ID int64, err error) {
+	res, err := r.db.ExecContext(ctx, `INSERT INTO alerts (user_id, chat_id, bus_stop_code, service_no, minutes, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`, alert.UserID, alert.ChatID, alert.BusStopCode, alert.ServiceNo, alert.Minutes, alert.CreatedAt.UTC(), alert.ExpiresAt.UTC())
+	if err != nil {
+		return 0, errors.Wrap(err, "error inserting alert")
+	}
+	ID, err = res.LastInsertId()
+	if err != nil {
+		return 0, errors.Wrap(err, "error getting alert ID")
+	}
+	return ID, nil
+}
+
+func (r *SQLiteUserRepository) GetAlerts(ctx context.Context) ([]Alert, error) {
+	return r.queryAlerts(ctx, "ORDER BY id")
+}
+
+func (r *SQLiteUserRepository) GetUserAlerts(ctx context.Context, userID int) ([]Alert, error) {
+	return r.queryAlerts(ctx, "WHERE user_id = ? ORDER BY id", userID)
+}
+
+func (r *SQLiteUserRepository) DeleteAlert(ctx context.Context, ID int64) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM alerts WHERE id = ?", ID)
+	if err != nil {
+		return errors.Wrap(err, "error deleting alert")
+	}
+	return nil
+}
+
+func (r *SQLiteUserRepository) queryAlerts(ctx context.Context, clause string, args ...interface{}) (alerts []Alert, err error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT id, user_id, chat_id, bus_stop_code, service_no, minutes, created_at, expires_at
+		FROM alerts `+clause, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting alerts")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var alert Alert
+		err = rows.Scan(&alert.ID, &alert.UserID, &alert.ChatID, &alert.BusStopCode, &alert.ServiceNo, &alert.Minutes, &alert.CreatedAt, &alert.ExpiresAt)
+		if err != nil {
+			return nil, errors.Wrap(err, "error scanning alert")
+		}
+		alerts = append(alerts, alert)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting alerts")
+	}
+	return alerts, nil
+}
+
+func (r *SQLiteUserRepository) AddSchedule(ctx context.Context, schedule Schedule) (ID int64, err error) {
+	res, err := r.db.ExecContext(ctx, `INSERT INTO schedules (user_id, chat_id, bus_stop_code, service_nos, hour, minute, days)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`, schedule.UserID, schedule.ChatID, schedule.BusStopCode, strings.Join(schedule.ServiceNos, " "), schedule.Hour, schedule.Minute, schedule.Days)
+	if err != nil {
+		return 0, errors.Wrap(err, "error inserting schedule")
+	}
+	ID, err = res.LastInsertId()
+	if err != nil {
+		return 0, errors.Wrap(err, "error getting schedule ID")
+	}
+	return ID, nil
+}
+
+func (r *SQLiteUserRepository) GetSchedules(ctx context.Context) ([]Schedule, error) {
+	return r.querySchedules(ctx, "ORDER BY id")
+}
+
+func (r *SQLiteUserRepository) GetUserSchedules(ctx context.Context, userID int) ([]Schedule, error) {
+	return r.querySchedules(ctx, "WHERE user_id = ? ORDER BY id", userID)
+}
+
+func (r *SQLiteUserRepository) DeleteSchedule(ctx context.Context, ID int64) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM schedules WHERE id = ?", ID)
+	if err != nil {
+		return errors.Wrap(err, "error deleting schedule")
+	}
+	return nil
+}
+
+func (r *SQLiteUserRepository) querySchedules(ctx context.Context, clause string, args ...interface{}) (schedules []Schedule, err error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT id, user_id, chat_id, bus_stop_code, service_nos, hour, minute, days
+		FROM schedules `+clause, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting schedules")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var schedule Schedule
+		var serviceNos string
+		err = rows.Scan(&schedule.ID, &schedule.UserID, &schedule.ChatID, &schedule.BusStopCode, &serviceNos, &schedule.Hour, &schedule.Minute, &schedule.Days)
+		if err != nil {
+			return nil, errors.Wrap(err, "error scanning schedule")
+		}
+		if serviceNos != "" {
+			schedule.ServiceNos = strings.Fields(serviceNos)
+		}
+		schedules = append(schedules, schedule)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting schedules")
+	}
+	return schedules, nil
+}
@@ -0,0 +1,267 @@
+package busetabot
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/yi-jiayu/bus-eta-bot/v4/usertest"
+)
+
+func newTestSQLiteUserRepository(t *testing.T) (*SQLiteUserRepository, func()) {
+	dir, err := ioutil.TempDir("", "bus-eta-bot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := NewSQLiteUserRepository(filepath.Join(dir, "users.sqlite"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("%+v", err)
+	}
+	return repo, func() {
+		repo.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestNewSQLiteUserRepository(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bus-eta-bot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "users.sqlite")
+	t.Run("migrates a new database to the latest version", func(t *testing.T) {
+		repo, err := NewSQLiteUserRepository(path)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		defer repo.Close()
+		var version int
+		err = repo.db.QueryRow("PRAGMA user_version").Scan(&version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, len(sqliteMigrations), version)
+	})
+	t.Run("keeps existing data when reopening a database", func(t *testing.T) {
+		repo, err := NewSQLiteUserRepository(path)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		err = repo.SetUserFavourites(context.Background(), 1, []Favourite{{Query: "96049", Label: "Home"}})
+		repo.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		repo, err = NewSQLiteUserRepository(path)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		defer repo.Close()
+		actual, err := repo.GetUserFavourites(context.Background(), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, []Favourite{{Query: "96049", Label: "Home"}}, actual)
+	})
+	t.Run("refuses to open a database from a newer version", func(t *testing.T) {
+		db, err := sql.Open("sqlite3", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.Exec("PRAGMA user_version = 1000")
+		db.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = NewSQLiteUserRepository(path)
+		assert.Error(t, err)
+	})
+}
+
+func TestSQLiteUserRepository_Conformance(t *testing.T) {
+	users, done := newTestSQLiteUserRepository(t)
+	defer done()
+	usertest.RunConformance(t, context.Background(), users)
+}
+
+func TestSQLiteUserRepository_Alerts(t *testing.T) {
+	repo, done := newTestSQLiteUserRepository(t)
+	defer done()
+	ctx := context.Background()
+	createdAt := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	first := Alert{UserID: 1, ChatID: 10, BusStopCode: "96049", ServiceNo: "96", Minutes: 5, CreatedAt: createdAt, ExpiresAt: createdAt.Add(AlertDuration)}
+	second := Alert{UserID: 2, ChatID: 20, BusStopCode: "81111", ServiceNo: "2", Minutes: 10, CreatedAt: createdAt, ExpiresAt: createdAt.Add(AlertDuration)}
+	var err error
+	first.ID, err = repo.AddAlert(ctx, first)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	second.ID, err = repo.AddAlert(ctx, second)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	assert.NotEqual(t, first.ID, second.ID)
+
+	alerts, err := repo.GetAlerts(ctx)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	assert.Equal(t, []Alert{first, second}, alerts)
+
+	alerts, err = repo.GetUserAlerts(ctx, 2)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	assert.Equal(t, []Alert{second}, alerts)
+
+	err = repo.DeleteAlert(ctx, first.ID)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	alerts, err = repo.GetAlerts(ctx)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	assert.Equal(t, []Alert{second}, alerts)
+}
+
+func TestSQLiteUserRepository_Schedules(t *testing.T) {
+	repo, done := newTestSQLiteUserRepository(t)
+	defer done()
+	ctx := context.Background()
+	first := Schedule{UserID: 1, ChatID: 10, BusStopCode: "96049", ServiceNos: []string{"2", "24"}, Hour: 8, Minute: 10, Days: Weekdays}
+	second := Schedule{UserID: 2, ChatID: 20, BusStopCode: "81111", Hour: 18, Minute: 0, Days: Everyday}
+	var err error
+	first.ID, err = repo.AddSchedule(ctx, first)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	second.ID, err = repo.AddSchedule(ctx, second)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	schedules, err := repo.GetSchedules(ctx)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	assert.Equal(t, []Schedule{first, second}, schedules)
+
+	schedules, err = repo.GetUserSchedules(ctx, 1)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	assert.Equal(t, []Schedule{first}, schedules)
+
+	err = repo.DeleteSchedule(ctx, first.ID)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	schedules, err = repo.GetSchedules(ctx)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	assert.Equal(t, []Schedule{second}, schedules)
+}
+
+func TestSQLiteUserRepository_Preferences(t *testing.T) {
+	repo, done := newTestSQLiteUserRepository(t)
+	defer done()
+	ctx := context.Background()
+
+	preferences, err := repo.GetUserPreferences(ctx, 1)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	assert.Equal(t, Preferences{}, preferences, "users should start with the default preferences")
+
+	for _, mode := range []string{NearbyModeCompact, NearbyModeVenues} {
+		err = repo.SetUserPreferences(ctx, 1, Preferences{NearbyMode: mode})
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		preferences, err = repo.GetUserPreferences(ctx, 1)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		assert.Equal(t, Preferences{NearbyMode: mode}, preferences)
+	}
+
+	all := Preferences{
+		NearbyMode:   NearbyModeCompact,
+		Formatter:    FormatterFeatures,
+		TimeDisplay:  TimeDisplayClock,
+		NearbyRadius: 1000,
+		NearbyLimit:  10,
+		Language:     LanguageEnglish,
+	}
+	err = repo.SetUserPreferences(ctx, 1, all)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	preferences, err = repo.GetUserPreferences(ctx, 1)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	assert.Equal(t, all, preferences)
+
+	preferences, err = repo.GetUserPreferences(ctx, 2)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	assert.Equal(t, Preferences{}, preferences, "preferences should not be shared between users")
+}
+
+func TestSQLiteUserRepository_RecentBusStops(t *testing.T) {
+	repo, done := newTestSQLiteUserRepository(t)
+	defer done()
+	ctx := context.Background()
+
+	codes, err := repo.GetRecentBusStops(ctx, 1)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	assert.Empty(t, codes, "users should start without any recent bus stops")
+
+	start := time.Date(2019, 1, 1, 8, 0, 0, 0, time.UTC)
+	var expected []string
+	for i := 0; i < MaxRecentBusStops+2; i++ {
+		code := fmt.Sprintf("%05d", i)
+		err = repo.AddRecentBusStop(ctx, 1, code, start.Add(time.Duration(i)*time.Minute))
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		expected = append([]string{code}, expected...)
+	}
+	codes, err = repo.GetRecentBusStops(ctx, 1)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	assert.Equal(t, expected[:MaxRecentBusStops], codes, "only the most recent bus stops should be kept")
+
+	err = repo.AddRecentBusStop(ctx, 1, "00005", start.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	codes, err = repo.GetRecentBusStops(ctx, 1)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	assert.Len(t, codes, MaxRecentBusStops)
+	assert.Equal(t, "00005", codes[0], "using a bus stop again should move it to the front")
+
+	codes, err = repo.GetRecentBusStops(ctx, 2)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	assert.Empty(t, codes, "recent bus stops should not be shared between users")
+}
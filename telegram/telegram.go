@@ -6,12 +6,18 @@ import (
 	"github.com/go-telegram-bot-api/telegram-bot-api"
 )
 
+// MaxMessageLength is the maximum number of characters in the text of a message.
+const MaxMessageLength = 4096
+
 type Request interface {
 	doWith(c *client) (result interface{}, err error)
 }
 
 type Error struct {
 	Description string
+
+	// RetryAfter is the number of seconds to wait before repeating a request which was rate limited, or 0.
+	RetryAfter int
 }
 
 func (err Error) Error() string {
@@ -20,7 +26,7 @@ func (err Error) Error() string {
 
 func newError(err error) error {
 	if err, ok := err.(tgbotapi.Error); ok {
-		return Error{Description: err.Message}
+		return Error{Description: err.Message, RetryAfter: err.RetryAfter}
 	}
 	return err
 }
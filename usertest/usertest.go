@@ -0,0 +1,238 @@
+// Package usertest provides a conformance test suite for implementations of the Bus Eta Bot UserRepository
+// interface, so that every storage backend is checked against a single definition of correct behaviour.
+package usertest
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yi-jiayu/bus-eta-bot/v4/favourite"
+)
+
+// UserRepository mirrors busetabot.UserRepository. It is declared here so that the busetabot package can use this
+// package in its own tests without an import cycle.
+type UserRepository interface {
+	UpdateUserLastSeenTime(ctx context.Context, userID int, t time.Time) error
+	GetUserFavourites(ctx context.Context, userID int) (favourites []favourite.Favourite, err error)
+	SetUserFavourites(ctx context.Context, userID int, favourites []favourite.Favourite) error
+}
+
+// LastSeenTimeGetter is implemented by repositories which can report when a user was last seen. If the repository
+// under test implements it, RunConformance also checks that last seen times can be read back.
+type LastSeenTimeGetter interface {
+	GetUserLastSeenTime(ctx context.Context, userID int) (time.Time, error)
+}
+
+// ConcurrentWriters is the number of concurrent SetUserFavourites calls made by the concurrency tests.
+const ConcurrentWriters = 10
+
+// userIDs are distinct for each test so that the tests can share a single repository.
+const (
+	userIDLastSeen = 1000001 + iota
+	userIDLastSeenKeepsFavourites
+	userIDMissing
+	userIDOrdering
+	userIDReplace
+	userIDEmpty
+	userIDLabels
+	userIDConcurrentSameUser
+	userIDConcurrentUsers
+)
+
+// RunConformance runs the conformance test suite against repo using ctx for every call. The suite only uses user IDs
+// above 1000000, which must not have any existing data.
+func RunConformance(t *testing.T, ctx context.Context, repo UserRepository) {
+	t.Run("UpdateUserLastSeenTime", func(t *testing.T) {
+		testUpdateUserLastSeenTime(t, ctx, repo)
+	})
+	t.Run("UpdateUserLastSeenTime does not modify favourites", func(t *testing.T) {
+		testUpdateUserLastSeenTimeKeepsFavourites(t, ctx, repo)
+	})
+	t.Run("GetUserFavourites for missing user", func(t *testing.T) {
+		testGetUserFavouritesMissingUser(t, ctx, repo)
+	})
+	t.Run("favourites keep their order", func(t *testing.T) {
+		testFavouritesOrdering(t, ctx, repo)
+	})
+	t.Run("SetUserFavourites replaces existing favourites", func(t *testing.T) {
+		testSetUserFavouritesReplaces(t, ctx, repo)
+	})
+	t.Run("SetUserFavourites with no favourites", func(t *testing.T) {
+		testSetUserFavouritesEmpty(t, ctx, repo)
+	})
+	t.Run("favourites keep their labels", func(t *testing.T) {
+		testFavouritesLabels(t, ctx, repo)
+	})
+	t.Run("concurrent SetUserFavourites for the same user", func(t *testing.T) {
+		testConcurrentSetUserFavouritesSameUser(t, ctx, repo)
+	})
+	t.Run("concurrent SetUserFavourites for different users", func(t *testing.T) {
+		testConcurrentSetUserFavouritesDifferentUsers(t, ctx, repo)
+	})
+}
+
+func mustGetFavourites(t *testing.T, ctx context.Context, repo UserRepository, userID int) []favourite.Favourite {
+	t.Helper()
+	favourites, err := repo.GetUserFavourites(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetUserFavourites(%d) returned error: %+v", userID, err)
+	}
+	return favourites
+}
+
+func mustSetFavourites(t *testing.T, ctx context.Context, repo UserRepository, userID int, favourites []favourite.Favourite) {
+	t.Helper()
+	err := repo.SetUserFavourites(ctx, userID, favourites)
+	if err != nil {
+		t.Fatalf("SetUserFavourites(%d, %+v) returned error: %+v", userID, favourites, err)
+	}
+}
+
+func assertFavourites(t *testing.T, expected, actual []favourite.Favourite) {
+	t.Helper()
+	if len(expected) == 0 && len(actual) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected favourites %+v, got %+v", expected, actual)
+	}
+}
+
+func testUpdateUserLastSeenTime(t *testing.T, ctx context.Context, repo UserRepository) {
+	first := time.Date(2019, 1, 1, 8, 0, 0, 0, time.UTC)
+	second := first.Add(time.Hour)
+	for _, seen := range []time.Time{first, second} {
+		err := repo.UpdateUserLastSeenTime(ctx, userIDLastSeen, seen)
+		if err != nil {
+			t.Fatalf("UpdateUserLastSeenTime returned error: %+v", err)
+		}
+		if getter, ok := repo.(LastSeenTimeGetter); ok {
+			actual, err := getter.GetUserLastSeenTime(ctx, userIDLastSeen)
+			if err != nil {
+				t.Fatalf("GetUserLastSeenTime returned error: %+v", err)
+			}
+			if !actual.Equal(seen) {
+				t.Errorf("expected last seen time %s, got %s", seen, actual)
+			}
+		}
+	}
+	if getter, ok := repo.(LastSeenTimeGetter); ok {
+		actual, err := getter.GetUserLastSeenTime(ctx, userIDMissing)
+		if err != nil {
+			t.Fatalf("GetUserLastSeenTime for missing user returned error: %+v", err)
+		}
+		if !actual.IsZero() {
+			t.Errorf("expected zero last seen time for missing user, got %s", actual)
+		}
+	}
+}
+
+func testUpdateUserLastSeenTimeKeepsFavourites(t *testing.T, ctx context.Context, repo UserRepository) {
+	favourites := favourite.FromQueries("96049", "81111")
+	mustSetFavourites(t, ctx, repo, userIDLastSeenKeepsFavourites, favourites)
+	err := repo.UpdateUserLastSeenTime(ctx, userIDLastSeenKeepsFavourites, time.Now())
+	if err != nil {
+		t.Fatalf("UpdateUserLastSeenTime returned error: %+v", err)
+	}
+	assertFavourites(t, favourites, mustGetFavourites(t, ctx, repo, userIDLastSeenKeepsFavourites))
+}
+
+func testGetUserFavouritesMissingUser(t *testing.T, ctx context.Context, repo UserRepository) {
+	assertFavourites(t, nil, mustGetFavourites(t, ctx, repo, userIDMissing))
+}
+
+func testFavouritesOrdering(t *testing.T, ctx context.Context, repo UserRepository) {
+	favourites := favourite.FromQueries("96049 2 24", "17179", "81111", "01012 7")
+	mustSetFavourites(t, ctx, repo, userIDOrdering, favourites)
+	assertFavourites(t, favourites, mustGetFavourites(t, ctx, repo, userIDOrdering))
+
+	reordered := favourite.FromQueries("81111", "01012 7", "96049 2 24", "17179")
+	mustSetFavourites(t, ctx, repo, userIDOrdering, reordered)
+	assertFavourites(t, reordered, mustGetFavourites(t, ctx, repo, userIDOrdering))
+}
+
+func testSetUserFavouritesReplaces(t *testing.T, ctx context.Context, repo UserRepository) {
+	mustSetFavourites(t, ctx, repo, userIDReplace, favourite.FromQueries("96049", "81111", "17179"))
+	favourites := favourite.FromQueries("17179")
+	mustSetFavourites(t, ctx, repo, userIDReplace, favourites)
+	assertFavourites(t, favourites, mustGetFavourites(t, ctx, repo, userIDReplace))
+}
+
+func testSetUserFavouritesEmpty(t *testing.T, ctx context.Context, repo UserRepository) {
+	mustSetFavourites(t, ctx, repo, userIDEmpty, favourite.FromQueries("96049"))
+	mustSetFavourites(t, ctx, repo, userIDEmpty, []favourite.Favourite{})
+	assertFavourites(t, nil, mustGetFavourites(t, ctx, repo, userIDEmpty))
+	mustSetFavourites(t, ctx, repo, userIDEmpty, favourite.FromQueries("96049"))
+	mustSetFavourites(t, ctx, repo, userIDEmpty, nil)
+	assertFavourites(t, nil, mustGetFavourites(t, ctx, repo, userIDEmpty))
+}
+
+func testFavouritesLabels(t *testing.T, ctx context.Context, repo UserRepository) {
+	favourites := []favourite.Favourite{
+		{Query: "96049 2 24", Label: "Home → Work"},
+		{Query: "81111"},
+		{Query: "17179", Label: "Gym"},
+	}
+	mustSetFavourites(t, ctx, repo, userIDLabels, favourites)
+	assertFavourites(t, favourites, mustGetFavourites(t, ctx, repo, userIDLabels))
+
+	renamed := []favourite.Favourite{
+		{Query: "96049 2 24"},
+		{Query: "81111", Label: "Office"},
+		{Query: "17179", Label: "Gym"},
+	}
+	mustSetFavourites(t, ctx, repo, userIDLabels, renamed)
+	assertFavourites(t, renamed, mustGetFavourites(t, ctx, repo, userIDLabels))
+}
+
+// testConcurrentSetUserFavouritesSameUser checks that concurrent writes for a single user are not interleaved: each
+// call may fail, but the stored favourites must be exactly those from one successful call.
+func testConcurrentSetUserFavouritesSameUser(t *testing.T, ctx context.Context, repo UserRepository) {
+	candidates := make([][]favourite.Favourite, ConcurrentWriters)
+	succeeded := make([]bool, ConcurrentWriters)
+	for i := range candidates {
+		for j := 0; j <= i; j++ {
+			candidates[i] = append(candidates[i], favourite.Favourite{Query: fmt.Sprintf("%05d", i*100+j)})
+		}
+	}
+	var wg sync.WaitGroup
+	for i := range candidates {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			succeeded[i] = repo.SetUserFavourites(ctx, userIDConcurrentSameUser, candidates[i]) == nil
+		}(i)
+	}
+	wg.Wait()
+	actual := mustGetFavourites(t, ctx, repo, userIDConcurrentSameUser)
+	for i, candidate := range candidates {
+		if succeeded[i] && reflect.DeepEqual(candidate, actual) {
+			return
+		}
+	}
+	t.Errorf("favourites %+v do not match any successful call to SetUserFavourites", actual)
+}
+
+func testConcurrentSetUserFavouritesDifferentUsers(t *testing.T, ctx context.Context, repo UserRepository) {
+	errs := make([]error, ConcurrentWriters)
+	var wg sync.WaitGroup
+	for i := 0; i < ConcurrentWriters; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = repo.SetUserFavourites(ctx, userIDConcurrentUsers+i, favourite.FromQueries(fmt.Sprintf("%05d", i)))
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("SetUserFavourites for user %d returned error: %+v", userIDConcurrentUsers+i, err)
+			continue
+		}
+		assertFavourites(t, favourite.FromQueries(fmt.Sprintf("%05d", i)), mustGetFavourites(t, ctx, repo, userIDConcurrentUsers+i))
+	}
+}
@@ -20,14 +20,18 @@ import (
 	"google.golang.org/appengine/urlfetch"
 
 	"github.com/yi-jiayu/bus-eta-bot/v4"
+	botappengine "github.com/yi-jiayu/bus-eta-bot/v4/appengine"
 	"github.com/yi-jiayu/bus-eta-bot/v4/telegram"
 )
 
 var BotToken = os.Getenv("TELEGRAM_BOT_TOKEN")
 
 var (
-	busStopRepository busetabot.BusStopRepository
-	userRepository    busetabot.UserRepository
+	busStopRepository  busetabot.BusStopRepository
+	busRouteRepository busetabot.RouteRepository
+	userRepository     *botappengine.DatastoreUserRepository
+	arrivalStore       = busetabot.NewInMemoryArrivalStore()
+	datamallBreaker    = busetabot.NewCircuitBreaker(busetabot.DefaultBreakerThreshold, busetabot.DefaultBreakerCooldown)
 )
 
 func rootHandler(w http.ResponseWriter, r *http.Request) {
@@ -35,7 +39,7 @@ func rootHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func webhookHandler(w http.ResponseWriter, r *http.Request) {
-	ctx := busetabot.NewContext(r)
+	ctx := botappengine.NewContext(r)
 
 	bs, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -72,7 +76,7 @@ func webhookHandler(w http.ResponseWriter, r *http.Request) {
 		Client:      client,
 	}
 
-	dm := datamall.NewClient(os.Getenv("DATAMALL_ACCOUNT_KEY"), client)
+	dm := busetabot.NewFallbackETAService(datamallBreaker.Wrap(datamall.NewClient(os.Getenv("DATAMALL_ACCOUNT_KEY"), client)), arrivalStore)
 
 	mp := busetabot.NewMeasurementProtocolClientWithClient(os.Getenv("GA_TID"), client)
 
@@ -80,7 +84,12 @@ func webhookHandler(w http.ResponseWriter, r *http.Request) {
 
 	bot := busetabot.NewBot(busetabot.DefaultHandlers(), tg, dm, &sv, &mp)
 	bot.BusStops = busStopRepository
+	bot.Routes = busRouteRepository
 	bot.Users = userRepository
+	bot.Preferences = userRepository
+	bot.RecentBusStops = userRepository
+	bot.Logger = botappengine.Logger{}
+	bot.RequestIDs = botappengine.RequestIDProvider{}
 
 	telegramService, err := telegram.NewClient(BotToken, client)
 	if err != nil {
@@ -94,15 +103,25 @@ func webhookHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func init() {
-	var err error
-	busStopRepository, err = busetabot.NewInMemoryBusStopRepositoryFromFile("data/bus_stops.json", "")
+	busStops, err := busetabot.NewInMemoryBusStopRepositoryFromFile("data/bus_stops.json", "data/synonyms.json")
 	if err != nil {
 		fmt.Printf("%+v\n", err)
 		raven.CaptureErrorAndWait(err, nil)
 		os.Exit(1)
 	}
+	busStopRepository = busStops
 
-	userRepository = new(busetabot.DatastoreUserRepository)
+	// bus routes are optional
+	if _, err := os.Stat("data/bus_routes.json"); err == nil {
+		busRouteRepository, err = busetabot.NewInMemoryRouteRepositoryFromFile("data/bus_routes.json")
+		if err != nil {
+			fmt.Printf("%+v\n", err)
+			raven.CaptureErrorAndWait(err, nil)
+			os.Exit(1)
+		}
+	}
+
+	userRepository = new(botappengine.DatastoreUserRepository)
 
 	http.HandleFunc("/", rootHandler)
 